@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Supported access log formats.
+const (
+	accessLogCommon = "common"
+	accessLogJSON   = "json"
+)
+
+// accessLogger records each request to the HTTP server, since the endpoints expose data about the organisation.
+type accessLogger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+	// file is the log file, or nil when logging to stderr
+	file io.Closer
+}
+
+// accessLogEntry is a single request, as written in the JSON format.
+type accessLogEntry struct {
+	Time       string `json:"time"`
+	ClientIP   string `json:"client_ip"`
+	Principal  string `json:"principal"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Status     int    `json:"status"`
+	Bytes      int    `json:"bytes"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// openAccessLog opens the access log, which can be a file or "-" for stderr, so that it doesn't get mixed up with the
+// log messages on stdout.  Files are rotated with the same settings as the main log file.
+func openAccessLog(path string, format string) (*accessLogger, error) {
+	if path == "-" {
+		return &accessLogger{w: os.Stderr, format: format}, nil
+	}
+	file, err := openRotatingFile(path, logMaxSizeMB, logMaxAgeDays)
+	if err != nil {
+		return nil, err
+	}
+	return &accessLogger{w: file, format: format, file: file}, nil
+}
+
+// close closes the log file, if there is one.
+func (l *accessLogger) close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// statusRecorder captures the status and size of a response, for the access log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(p)
+	r.size += n
+	return n, err
+}
+
+// middleware logs each request once it has been handled.
+func (l *accessLogger) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		l.log(r, recorder, start)
+	})
+}
+
+func (l *accessLogger) log(r *http.Request, recorder *statusRecorder, start time.Time) {
+	// The client IP is taken from the connection, rather than X-Forwarded-For, which the client can set to anything
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+	// The server doesn't authenticate anyone, so there's never a principal to log.  A user name from the request
+	// itself would only be what the client claims, so it isn't trusted.
+	principal := "-"
+
+	var line []byte
+	if l.format == accessLogJSON {
+		entry := accessLogEntry{
+			Time:       formatTimestamp(start),
+			ClientIP:   clientIP,
+			Principal:  principal,
+			Method:     r.Method,
+			Path:       r.URL.RequestURI(),
+			Status:     recorder.status,
+			Bytes:      recorder.size,
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			LogMessage(warningLevel, "Failed to encode access log entry: "+err.Error())
+			return
+		}
+		line = append(data, '\n')
+	} else {
+		line = []byte(fmt.Sprintf("%s - %s [%s] %q %d %d\n", clientIP, principal, start.In(outputLocation).Format("02/Jan/2006:15:04:05 -0700"),
+			r.Method+" "+r.URL.RequestURI()+" "+r.Proto, recorder.status, recorder.size))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(line); err != nil {
+		LogMessage(warningLevel, "Failed to write access log: "+err.Error())
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// defaultMaxSessionDays is the longest plausible session, when report.max_session_days isn't set.  Mattermost's
+// session lengths are set in hours, and are rarely more than a few months.
+const defaultMaxSessionDays = 365
+
+// clockSkewAllowance is how far ahead of this machine's clock a session's times can be before they're treated as
+// being in the future, since the database server's clock is never exactly the same.
+const clockSkewAllowance = 5 * time.Minute
+
+// tallyExpiryAnomalies counts the desktop and mobile sessions with expiry times that don't make sense: those that
+// expire further ahead than any session length, those that expire before they were created, and those created or
+// last used in the future, which points at clock skew between the servers.  They're all counted as active, so they
+// inflate the report.  Nothing is returned if there aren't any.
+func tallyExpiryAnomalies(sessions []SessionRecord, now time.Time, maxDays int) []expiryCount {
+	counts := []expiryCount{{label: msgAnomalyFarFuture}, {label: msgAnomalyBackwards}, {label: msgAnomalyFromFuture}}
+	latest := now.AddDate(0, 0, maxDays).UnixMilli()
+	future := now.Add(clockSkewAllowance).UnixMilli()
+
+	found := false
+	for _, session := range sessions {
+		client, ok := countedAppClient(session)
+		if !ok {
+			continue
+		}
+
+		i := -1
+		switch {
+		case session.ExpiresAt > latest:
+			i = 0
+		case session.ExpiresAt != 0 && session.CreateAt != 0 && session.ExpiresAt < session.CreateAt:
+			i = 1
+		case session.CreateAt > future || session.LastActivityAt > future:
+			i = 2
+		}
+		if i < 0 {
+			continue
+		}
+
+		DebugPrint(fmt.Sprintf("Session %s has an implausible expiry time: created %s, last used %s, expires %s", session.ID,
+			formatMillis(session.CreateAt), formatMillis(session.LastActivityAt), formatMillis(session.ExpiresAt)))
+		found = true
+		if client.Kind == mmversions.Desktop {
+			counts[i].desktop++
+		} else {
+			counts[i].mobile++
+		}
+	}
+
+	if !found {
+		return nil
+	}
+	return counts
+}
+
+// warnExpiryAnomalies logs how many of the active clients have implausible expiry times, so that it's clear how far
+// they might be skewing the report.
+func warnExpiryAnomalies(anomalies []expiryCount, total int, maxDays int) {
+	if len(anomalies) == 0 {
+		return
+	}
+	reasons := []string{
+		fmt.Sprintf("expire in more than %d days", maxDays),
+		"expire before they were created",
+		"were created or used in the future",
+	}
+	skewed := 0
+	for i, count := range anomalies {
+		if n := count.desktop + count.mobile; n > 0 {
+			skewed += n
+			LogMessage(warningLevel, fmt.Sprintf("%d active sessions %s", n, reasons[i]))
+		}
+	}
+	LogMessage(warningLevel, fmt.Sprintf("%d of the %d active clients have implausible expiry times, from clock skew or a misconfigured session length, and may be inflating the counts.  Use -expiry for a breakdown, or -debug to list them",
+		skewed, total))
+}
+
+// printAnomalyTable writes the implausible expiry times found by tallyExpiryAnomalies.
+func printAnomalyTable(w io.Writer, anomalies []expiryCount, maxDays int) {
+	fmt.Fprintln(w, "\n"+tr(msgAnomaliesFound))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\n", tr(msgColumnAnomaly), tr(msgColumnDesktop), tr(msgColumnMobile))
+	for _, count := range anomalies {
+		label := tr(count.label)
+		if count.label == msgAnomalyFarFuture {
+			label = trf(count.label, maxDays)
+		}
+		fmt.Fprintf(tw, "  %s\t%d\t%d\n", label, count.desktop, count.mobile)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+var botTimeout = 30 * time.Second
+
+// botPageSize is the number of users asked for in each page of the Mattermost API's user list.
+const botPageSize = 200
+
+// mattermostBot sends direct messages through the Mattermost REST API, as a bot account with a personal access token.
+type mattermostBot struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newMattermostBot(config *Config) (*mattermostBot, error) {
+	if config.Bot.URL == "" || config.Bot.Token == "" {
+		return nil, errors.New("the bot needs the server's URL and a token, as bot.url and bot.token")
+	}
+	return &mattermostBot{url: strings.TrimSuffix(config.Bot.URL, "/"), token: config.Bot.Token, client: &http.Client{Timeout: botTimeout}}, nil
+}
+
+// call makes a request to the API, sending body and decoding the response into out, if they're not nil.
+func (b *mattermostBot) call(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.url+"/api/v4"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	DebugPrint(fmt.Sprintf("Calling the Mattermost API: %s %s", method, path))
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// Mattermost explains the error in the message field
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if json.Unmarshal(respBody, &apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(respBody))
+		}
+		return fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status, apiErr.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// apiUser is the part of a user returned by the API that the bot needs.
+type apiUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	IsBot    bool   `json:"is_bot"`
+}
+
+// me returns the bot's own user ID.
+func (b *mattermostBot) me(ctx context.Context) (string, error) {
+	var user apiUser
+	if err := b.call(ctx, http.MethodGet, "/users/me", nil, &user); err != nil {
+		return "", err
+	}
+	return user.ID, nil
+}
+
+// systemAdmins returns the IDs of the active system admins, not counting bots.
+func (b *mattermostBot) systemAdmins(ctx context.Context) ([]string, error) {
+	var ids []string
+	for page := 0; ; page++ {
+		var users []apiUser
+		path := fmt.Sprintf("/users?role=system_admin&active=true&per_page=%d&page=%d", botPageSize, page)
+		if err := b.call(ctx, http.MethodGet, path, nil, &users); err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			if !user.IsBot {
+				ids = append(ids, user.ID)
+			}
+		}
+		if len(users) < botPageSize {
+			return ids, nil
+		}
+	}
+}
+
+// usersByName returns the IDs of the named users.  Any that don't exist are logged and left out.
+func (b *mattermostBot) usersByName(ctx context.Context, usernames []string) ([]string, error) {
+	names := make([]string, len(usernames))
+	for i, name := range usernames {
+		names[i] = strings.TrimPrefix(name, "@")
+	}
+	var users []apiUser
+	if err := b.call(ctx, http.MethodPost, "/users/usernames", names, &users); err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool)
+	var ids []string
+	for _, user := range users {
+		found[strings.ToLower(user.Username)] = true
+		ids = append(ids, user.ID)
+	}
+	for _, name := range names {
+		if !found[strings.ToLower(name)] {
+			LogMessage(warningLevel, "No Mattermost user found with the username: "+name)
+		}
+	}
+	return ids, nil
+}
+
+// directMessage posts a message in the direct channel between the bot and a user, creating it if need be.
+func (b *mattermostBot) directMessage(ctx context.Context, botID, userID, message string) error {
+	var channel struct {
+		ID string `json:"id"`
+	}
+	if err := b.call(ctx, http.MethodPost, "/channels/direct", []string{botID, userID}, &channel); err != nil {
+		return err
+	}
+	post := map[string]string{"channel_id": channel.ID, "message": message}
+	return b.call(ctx, http.MethodPost, "/posts", post, nil)
+}
+
+// validBotURL checks that the server URL is an http(s) URL.
+func validBotURL(serverURL string) bool {
+	u, err := url.Parse(serverURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// adminSummary is the short summary of a run that's sent to the admins with '-notify-admins'.  The users below the
+// minimum versions are only counted when there's a minimum.
+func adminSummary(summary *mmversions.Summary, sessions []SessionRecord, minDesktop, minMobile string) string {
+	desktopTotal, mobileTotal := mmversions.Total(summary.Desktop), mmversions.Total(summary.Mobile)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "#### %s\n", tr(msgCardTitle))
+	fmt.Fprintf(&sb, "**%s:** %d\n", tr(msgCardDesktopClients), desktopTotal)
+	fmt.Fprintf(&sb, "**%s:** %d\n", tr(msgCardMobileClients), mobileTotal)
+	fmt.Fprintf(&sb, "**%s:** %d\n", tr(msgCardTotalClients), desktopTotal+mobileTotal)
+
+	for _, platform := range []struct {
+		kind    mmversions.ClientKind
+		label   string
+		minimum string
+	}{
+		{mmversions.Desktop, msgPlatformDesktop, minDesktop},
+		{mmversions.Mobile, msgPlatformMobile, minMobile},
+	} {
+		if platform.minimum == "" {
+			continue
+		}
+		users := make(map[string]bool)
+		for _, session := range sessions {
+			client, ok := countedAppClient(session)
+			if ok && client.Kind == platform.kind && mmversions.Less(client.Version, platform.minimum) {
+				users[session.UserID] = true
+			}
+		}
+		fmt.Fprintf(&sb, "**%s:** %d\n", trf(msgUsersBelowMinimum, tr(platform.label), platform.minimum), len(users))
+	}
+	fmt.Fprintf(&sb, "\n%s %s\n", tr(msgCardGenerated), formatTimestamp(time.Now()))
+	return sb.String()
+}
+
+// notifyAdmins sends the summary to each of the system admins, or those listed in bot.admins, as a direct message
+// from the bot.  It carries on past a failed message, so that one deactivated admin doesn't stop the rest.
+func notifyAdmins(ctx context.Context, config *Config, message string) error {
+	bot, err := newMattermostBot(config)
+	if err != nil {
+		return err
+	}
+	botID, err := bot.me(ctx)
+	if err != nil {
+		return err
+	}
+	var admins []string
+	if len(config.Bot.Admins) > 0 {
+		admins, err = bot.usersByName(ctx, config.Bot.Admins)
+	} else {
+		admins, err = bot.systemAdmins(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if len(admins) == 0 {
+		return errors.New("no admins found to notify")
+	}
+
+	failed := 0
+	for _, admin := range admins {
+		if err := bot.directMessage(ctx, botID, admin, message); err != nil {
+			LogMessage(warningLevel, fmt.Sprintf("Unable to message admin %s: %v", admin, err))
+			failed++
+		}
+	}
+	if failed == len(admins) {
+		return fmt.Errorf("none of the %d admins could be messaged", len(admins))
+	}
+	LogMessage(infoLevel, fmt.Sprintf("Summary sent to %d admins", len(admins)-failed))
+	return nil
+}
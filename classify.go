@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// setLegacyDesktopPatterns sets the patterns for recognising legacy desktop builds, going back to the defaults if
+// there aren't any, so that a reload that removes them from the config file takes effect.
+func setLegacyDesktopPatterns(patterns []string) error {
+	if len(patterns) == 0 {
+		patterns = mmversions.DefaultLegacyDesktopPatterns
+	}
+	return mmversions.SetLegacyDesktopPatterns(patterns)
+}
+
+// readPropsFile reads props JSON strings from a file, one per line, or from stdin if the name is "-".  Blank lines
+// and lines starting with '#' are skipped, so that a file of odd user agents can be annotated.
+func readPropsFile(filename string) ([]string, error) {
+	var in io.Reader = os.Stdin
+	if filename != "-" {
+		file, err := os.Open(filename)
+		if err != nil {
+			errMsg := fmt.Sprintf("Unable to open props file: %v", err)
+			LogMessage(errorLevel, errMsg)
+			return nil, err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	var props []string
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		props = append(props, line)
+	}
+	return props, scanner.Err()
+}
+
+// printClassification writes how a session with each of the props would be classified and counted, returning the
+// number that couldn't be parsed.  The other columns of the template, such as the device ID, are applied to every
+// session, since they also affect the classification.
+func printClassification(w io.Writer, props []string, template mmversions.Session) int {
+	invalid := 0
+	for i, p := range props {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, p)
+
+		session := template
+		session.Props = p
+		client, err := mmversions.Classify(session)
+		if err != nil {
+			fmt.Fprintf(w, "    error:   invalid props JSON: %v\n", err)
+			invalid++
+			continue
+		}
+
+		fmt.Fprintf(w, "    kind:    %s (rule: %s)\n", client.Kind, client.Rule)
+		fmt.Fprintf(w, "    browser: %q\n", client.Browser)
+		fmt.Fprintf(w, "    os:      %q\n", client.OS)
+		fmt.Fprintf(w, "    version: %q\n", client.Version)
+		if client.Build != "" {
+			fmt.Fprintf(w, "    build:   %q\n", client.Build)
+		}
+		placeholder := (client.Kind == mmversions.Desktop || client.Kind == mmversions.Mobile) && client.Version == mmversions.PlaceholderVersion
+		switch key := mmversions.Bucket(client); {
+		case key == "" && placeholder:
+			fmt.Fprintln(w, "    counted: no, because of the placeholder version")
+		case key == "":
+			fmt.Fprintln(w, "    counted: no, because there's no version")
+		case client.Legacy:
+			fmt.Fprintf(w, "    counted: as a legacy desktop build %s, apart from the releases\n", key)
+		case client.DevBuild:
+			fmt.Fprintf(w, "    counted: as a nightly/development desktop build %s, apart from the releases\n", key)
+		case placeholder:
+			fmt.Fprintf(w, "    counted: as %s %s, with a placeholder version warning\n", client.Kind, key)
+		default:
+			fmt.Fprintf(w, "    counted: as %s %s\n", client.Kind, key)
+		}
+	}
+	return invalid
+}
@@ -0,0 +1,1998 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+	"github.com/spf13/viper"
+)
+
+const appName = "mm-desktop-versions"
+
+// command is a single subcommand of the utility.  newFlags builds the command's flag set, and returns the function
+// that runs the command once the flags have been parsed.  Keeping the two together means the help text and shell
+// completions are always generated from the same definition as the command itself.
+type command struct {
+	name     string
+	summary  string
+	args     string
+	newFlags func() (*flag.FlagSet, func(ctx context.Context, args []string) error)
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "report", summary: "print a tally of the desktop and mobile app versions in use", newFlags: reportCommand},
+		{name: "lookup", summary: "write a CSV of users with desktop clients at or below a given version", newFlags: lookupCommand},
+		{name: "whois", summary: "list all of a user's active sessions, with their client, version, OS and device", args: "<username|email>", newFlags: whoisCommand},
+		{name: "notify", summary: "post the version tally to a Mattermost, Slack or Teams webhook", newFlags: notifyCommand},
+		{name: "digest", summary: "send what's changed since the last digest to the webhook and admins, e.g. weekly", newFlags: digestCommand},
+		{name: "serve", summary: "serve the version tally over HTTP for Prometheus and dashboards", newFlags: serveCommand},
+		{name: "service", summary: "install, remove, start or stop a Windows service that runs serve", args: "<install|uninstall|start|stop> [<serve flags>...]", newFlags: serviceCommand},
+		{name: "stale", summary: "tally the sessions that are still active but haven't been used for a number of days", newFlags: staleCommand},
+		{name: "plan", summary: "write a step-by-step plan, in markdown, for upgrading the desktop clients at or below a version", newFlags: planCommand},
+		{name: "mdm-export", summary: "write the mobile devices with outdated apps to a CSV file for importing into Intune or Jamf", newFlags: mdmExportCommand},
+		{name: "readiness", summary: "list the clients that would be below the minimum versions after a server upgrade", newFlags: readinessCommand},
+		{name: "forgotten", summary: "tally the long-lived sessions on old desktop versions, which are usually forgotten installs", newFlags: forgottenCommand},
+		{name: "snapshot", summary: "save the version tally to a JSON file for later comparison", newFlags: snapshotCommand},
+		{name: "diff", summary: "compare two snapshot files", args: "<before.json> <after.json>", newFlags: diffCommand},
+		{name: "compare", summary: "compare the versions in use across servers, side by side", args: "[<snapshot.json>...]", newFlags: compareCommand},
+		{name: "tenants", summary: "write a report bundle for each customer in the config file, with a compliance overview", newFlags: tenantsCommand},
+		{name: "tui", summary: "show an interactive, live-refreshing dashboard of the versions in use", newFlags: tuiCommand},
+		{name: "classify-test", summary: "show how sessions with the given props would be classified", args: "[<props JSON>...]", newFlags: classifyTestCommand},
+		{name: "init", summary: "interactively create a config file", newFlags: initCommand},
+		{name: "validate-config", summary: "check the config file for missing, invalid or unknown settings", newFlags: validateConfigCommand},
+		{name: "test-connection", summary: "check that the database can be reached and the required tables can be read", newFlags: testConnectionCommand},
+		{name: "schema", summary: "print the JSON schema for one of the JSON outputs, or list them", args: "[<name>]", newFlags: schemaCommand},
+		{name: "completion", summary: "print a shell completion script", args: "<bash|zsh|fish|powershell>", newFlags: completionCommand},
+	}
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// newFlagSet creates the flag set for a subcommand, with the flags that every command shares.
+func newFlagSet(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.BoolVar(&debugMode, "debug", false, "run the utility in debug mode for additional output")
+	fs.BoolVar(&debugMode, "v", false, "verbose output (the same as -debug)")
+	fs.BoolVar(&traceMode, "vv", false, "very verbose output, including details of individual sessions")
+	fs.BoolVar(&quietMode, "quiet", false, "only write errors and the final result")
+	fs.StringVar(&logFilePath, "log-file", "", "write log messages to this file instead of the console (errors are also written to stderr)")
+	fs.IntVar(&logMaxSizeMB, "log-max-size", logMaxSizeMB, "rotate the log file when it reaches this size, in megabytes")
+	fs.IntVar(&logMaxAgeDays, "log-max-age", logMaxAgeDays, "delete rotated log files older than this many days (0 keeps them forever)")
+	fs.BoolVar(&useSystemLog, "system-log", false, "write log messages to syslog, or the Windows Event Log on Windows (errors are also written to stderr)")
+	fs.Func("tz", "time `zone` for timestamps in the output, e.g. Europe/Berlin, or Local for this machine's time zone (default UTC)", setOutputLocation)
+	fs.StringVar(&syslogAddress, "syslog-addr", "", "send syslog messages to a remote server, e.g. udp://loghost:514, instead of the local daemon")
+	fs.BoolVar(&containerMode, "container", false, "run in a container: read settings only from "+envPrefix+"_ environment variables, write results to stdout and JSON log messages to stderr, and never write local files")
+	fs.Func("errors", "`format` for failures: text, or json to also write each failure to stderr as a JSON object (default text)", setErrorFormat)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s %s [flags] %s\n\n", appName, cmd.name, cmd.args)
+		fmt.Fprintf(out, "%s%s.\n\nFlags:\n", strings.ToUpper(cmd.summary[:1]), cmd.summary[1:])
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// usage prints the top-level help text.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s <command> [flags]\n\nCommands:\n", appName)
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(out, "\nRun '%s <command> -help' for the flags accepted by each command.\n\nGlobal flags:\n", appName)
+	flag.PrintDefaults()
+}
+
+// run parses the command line and runs the requested command, returning the process exit code.  Commands return a
+// classified error rather than an exit code, so that the exit codes are decided in one place (see errors.go).  The
+// command is stopped when parent is cancelled, e.g. by the Windows service manager, as well as on Ctrl-C or SIGTERM.
+func run(parent context.Context, args []string) int {
+	var showVersion bool
+	var showHelp bool
+	flag.BoolVar(&showVersion, "version", false, "show version infomration and exit")
+	flag.BoolVar(&showHelp, "help", false, "show help and exit")
+	flag.Usage = usage
+
+	// Before subcommands were introduced everything was driven by flags, so keep those invocations working
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		if isGlobalFlag(args) {
+			if err := flag.CommandLine.Parse(args); err != nil {
+				if errors.Is(err, flag.ErrHelp) {
+					return exitHelp
+				}
+				return exitCodes[usageFailure]
+			}
+			args = flag.Args()
+		}
+		if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+			args = legacyArgs(args)
+		}
+	}
+
+	if showVersion {
+		fmt.Printf("Version: %s\n", Version)
+		return exitVersion
+	}
+	if showHelp {
+		usage()
+		return exitHelp
+	}
+
+	if args[0] == "help" {
+		if len(args) > 1 {
+			if cmd := findCommand(args[1]); cmd != nil {
+				fs, _ := cmd.newFlags()
+				fs.Usage()
+				return exitHelp
+			}
+		}
+		usage()
+		return exitHelp
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		LogMessage(errorLevel, "Unknown command: "+args[0])
+		usage()
+		return exitCodes[usageFailure]
+	}
+
+	fs, runCmd := cmd.newFlags()
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitHelp
+		}
+		// The flag package has already printed the error, so it's only needed again as JSON
+		if errorFormat == "json" {
+			writeErrorReport(os.Stderr, cmd.name, usageError("%v", err))
+		}
+		return exitCodes[usageFailure]
+	}
+	if outfile := fs.Lookup("outfile"); outfile != nil {
+		reserveStdout(outfile.Value.String())
+	}
+	if quietMode && (debugMode || traceMode) {
+		return commandFailed(cmd.name, usageError("-quiet can't be combined with -debug, -v or -vv"))
+	}
+	if containerMode && (logFilePath != "" || useSystemLog) {
+		return commandFailed(cmd.name, usageError("-container logs to stderr, so it can't be combined with -log-file or -system-log"))
+	}
+
+	if logFilePath != "" {
+		logFile, err := openRotatingFile(logFilePath, logMaxSizeMB, logMaxAgeDays)
+		if err != nil {
+			return commandFailed(cmd.name, usageError("Unable to open log file: %v", err))
+		}
+		defer logFile.Close()
+		logFileLogger = log.New(logFile, "", log.Ldate|log.Ltime)
+	}
+
+	if useSystemLog {
+		sysLog, err := openSystemLogger(syslogAddress)
+		if err != nil {
+			return commandFailed(cmd.name, usageError("Unable to open system log: %v", err))
+		}
+		defer sysLog.Close()
+		systemLog = sysLog
+	}
+
+	// Stop cleanly on Ctrl-C or a service stop, abandoning any query or upload in progress.  A second signal kills
+	// the process as usual, in case something doesn't respond to the cancellation.
+	ctx, stop := signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	if err := runCmd(ctx, fs.Args()); err != nil {
+		if errors.Is(err, errConfigShown) {
+			return exitOK
+		}
+		if ctx.Err() != nil {
+			err = interruptedError(err)
+		}
+		return commandFailed(cmd.name, err)
+	}
+	return exitOK
+}
+
+// commandFailed reports a failure of a command, in JSON as well if '-errors json' was given, and returns its exit
+// code.
+func commandFailed(command string, err error) int {
+	LogMessage(errorLevel, err.Error())
+	if errorFormat == "json" {
+		writeErrorReport(os.Stderr, command, err)
+	}
+	return exitCode(err)
+}
+
+// isGlobalFlag reports whether the command line is just asking for the version or help.
+func isGlobalFlag(args []string) bool {
+	for _, arg := range args {
+		switch strings.TrimLeft(arg, "-") {
+		case "version", "help", "h":
+			return true
+		}
+	}
+	return false
+}
+
+// legacyArgs converts the original flag-only command line into the equivalent subcommand.
+func legacyArgs(args []string) []string {
+	var rest []string
+	cmd := "report"
+	for _, arg := range args {
+		switch arg {
+		case "-lookup", "--lookup", "-lookup=true", "--lookup=true":
+			cmd = "lookup"
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if len(args) > 0 {
+		LogMessage(warningLevel, "Running without a command is deprecated.  Please use: "+appName+" "+cmd+" [flags]")
+	}
+	return append([]string{cmd}, rest...)
+}
+
+// sourceOptions are the flags shared by every command that reads session data.
+type sourceOptions struct {
+	fs             *flag.FlagSet
+	configFile     string
+	profile        string
+	tenant         string
+	inputFile      string
+	inputUsersFile string
+	filter         string
+	os             string
+	showConfig     bool
+	aggregateOnly  bool
+	db             *dbOverrides
+	overrides      []configOverride
+	// expiredDays also includes the sessions that expired within this many days, for a view of the recent clients
+	// rather than only those active right now
+	expiredDays int
+	// lazyConnect opens the database without checking that it can be reached, for a server that reports that through
+	// its readiness check instead of failing to start
+	lazyConnect bool
+}
+
+// configOverride is a command-specific flag that overrides a config setting, when given on the command line.
+type configOverride struct {
+	flag  string
+	apply func(config *Config)
+}
+
+func addSourceFlags(fs *flag.FlagSet) *sourceOptions {
+	opts := &sourceOptions{fs: fs}
+	fs.StringVar(&opts.configFile, "config", "config.json", "path to config file")
+	fs.StringVar(&opts.profile, "profile", "", "[optional] use the named profile from the config file, e.g. prod or staging")
+	fs.StringVar(&opts.inputFile, "input", "", "[optional] analyse a support packet (.zip) or an exported Sessions table (.json or .csv) instead of connecting to a database")
+	fs.StringVar(&opts.inputUsersFile, "input-users", "", "[optional] exported Users table (.json or .csv) to use alongside a Sessions export")
+	opts.db = addDBFlags(fs)
+	fs.StringVar(&opts.filter, "filter", "", "[optional] only include sessions matching this `expression`, e.g. 'os == \"Windows\" && version < 5.5.0'")
+	fs.StringVar(&opts.os, "os", "", "[optional] only include sessions from these operating systems, as a comma-separated `list`, e.g. Windows,Darwin")
+	var active string
+	var activeDays int
+	fs.StringVar(&active, "active", "", "[optional] what makes a session active: expiry for unexpired sessions (the default), activity for those used in the last -active-days, or both")
+	fs.IntVar(&activeDays, "active-days", 0, "[optional] number of `days` since a session was last used for it to be active, with -active activity or both (default 30)")
+	opts.overrideSetting("active", func(config *Config) { config.Sessions.Active = active })
+	opts.overrideSetting("active-days", func(config *Config) { config.Sessions.ActiveDays = activeDays })
+	fs.BoolVar(&opts.aggregateOnly, "aggregate-only", false, "[optional] never read the Users table or output anything about individual users")
+	opts.overrideSetting("aggregate-only", func(config *Config) {
+		config.Privacy.AggregateOnly = config.Privacy.AggregateOnly || opts.aggregateOnly
+	})
+	fs.BoolVar(&opts.showConfig, "show-config", false, "[optional] print the effective configuration, after applying the profile, environment variables and flags, and exit")
+	return opts
+}
+
+// overrideSetting arranges for a flag to take the place of a config setting, if it's given on the command line.
+func (o *sourceOptions) overrideSetting(flag string, apply func(config *Config)) {
+	o.overrides = append(o.overrides, configOverride{flag: flag, apply: apply})
+}
+
+// dbOverrides are database settings given on the command line, which take the place of those in the config file
+// for a single run.  There's deliberately no flag for the password, since it would be visible in the process list.
+type dbOverrides struct {
+	dbType string
+	host   string
+	port   int
+	name   string
+	user   string
+}
+
+func addDBFlags(fs *flag.FlagSet) *dbOverrides {
+	o := &dbOverrides{}
+	fs.StringVar(&o.dbType, "db-type", "", "[optional] database type (postgresql or mysql), overriding the one in the config file")
+	fs.StringVar(&o.host, "db-host", "", "[optional] database host, overriding the one in the config file")
+	fs.IntVar(&o.port, "db-port", 0, "[optional] database port, overriding the one in the config file")
+	fs.StringVar(&o.name, "db-name", "", "[optional] database name, overriding the one in the config file")
+	fs.StringVar(&o.user, "db-user", "", "[optional] database user, overriding the one in the config file")
+	return o
+}
+
+// apply copies any settings given on the command line into the config.
+func (o *dbOverrides) apply(config *Config) {
+	if o.dbType != "" {
+		config.DB.Type = o.dbType
+	}
+	if o.host != "" {
+		config.DB.Host = o.host
+	}
+	if o.port != 0 {
+		config.DB.Port = o.port
+	}
+	if o.name != "" {
+		config.DB.Name = o.name
+	}
+	if o.user != "" {
+		config.DB.User = o.user
+	}
+}
+
+// addLanguageFlag adds the '-lang' flag to commands that produce a report for people to read.  When the command
+// reads a config file, the flag overrides the language set there.
+func addLanguageFlag(fs *flag.FlagSet, opts *sourceOptions) {
+	fs.Func("lang", "[optional] `language` for the report: "+supportedLanguages()+" (default en)", setReportLanguage)
+	if opts != nil {
+		opts.overrideSetting("lang", func(config *Config) { config.Output.Lang = reportLanguage })
+	}
+}
+
+// addOutputFileFlags adds the flags for what happens to an output file once it's been written: '-checksum' to write
+// its checksum, and '-upload' to upload it, overriding signing.checksums and upload.url from the config file.
+func addOutputFileFlags(fs *flag.FlagSet, opts *sourceOptions) {
+	var checksums bool
+	var destination string
+	fs.BoolVar(&checksums, "checksum", false, "[optional] also write a SHA-256 checksum of the output file, to <file>.sha256")
+	fs.StringVar(&destination, "upload", "", "[optional] also upload the output file to this `URL`, e.g. s3://bucket/prefix")
+	opts.overrideSetting("checksum", func(config *Config) { config.Signing.Checksums = checksums })
+	opts.overrideSetting("upload", func(config *Config) { config.Upload.URL = destination })
+}
+
+// checkOutputFile catches a bad upload URL or a missing signing key before the run, rather than after the output has
+// been written.
+func checkOutputFile(config *Config) error {
+	if containerMode && (config.Upload.URL != "" || config.Signing.Checksums || config.Signing.MinisignKey != "" || config.Signing.GPGKey != "") {
+		return usageError("-container writes its results to stdout rather than a file, so they can't be signed or uploaded")
+	}
+	if config.Upload.URL != "" {
+		if _, err := parseUploadURL(config.Upload.URL); err != nil {
+			return configError(err, "Invalid upload URL")
+		}
+	}
+	for _, keyFile := range []string{config.Signing.MinisignKey, config.Signing.GPGKey} {
+		if keyFile == "" {
+			continue
+		}
+		if _, err := os.Stat(keyFile); err != nil {
+			return configError(err, "Unable to read signing key")
+		}
+	}
+	return nil
+}
+
+// outputName describes where an output file is written, for log messages.
+func outputName(filename string) string {
+	if filename == "-" {
+		return "stdout"
+	}
+	return filename
+}
+
+// finishOutput writes the checksum and signatures for an output file, and then uploads them along with the file, as
+// set in the config.
+func finishOutput(ctx context.Context, config *Config, filename string) error {
+	signatures, err := signOutput(config, filename)
+	if err != nil {
+		return outputError(err, "Failed to sign %s", filename)
+	}
+	if len(signatures) > 0 {
+		LogMessage(infoLevel, "Checksum and signatures written to: "+strings.Join(signatures, ", "))
+	}
+	if err := uploadOutput(ctx, config, append([]string{filename}, signatures...)...); err != nil {
+		return outputError(err, "Failed to upload %s", filename)
+	}
+	return nil
+}
+
+// applyLanguage sets the report language from the effective config.
+func applyLanguage(config *Config) error {
+	if err := setReportLanguage(config.Output.Lang); err != nil {
+		return configError(err, "Invalid output.lang setting")
+	}
+	return nil
+}
+
+// splitList splits a comma-separated flag value, ignoring empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// isFlagSet reports whether a flag was given on the command line.
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// errConfigShown is returned by openSource once '-show-config' has printed the configuration, to stop the command
+// without it being treated as a failure.
+var errConfigShown = errors.New("configuration shown")
+
+// openSource loads the configuration and opens the database, or reads the input files when running offline.  The
+// config file isn't read when running offline, unless needConfig is set, or a config file or profile has been chosen.  Settings are taken from the command line first, then the environment, then the config file.
+// The returned close function must be called when the caller has finished with the source.
+func openSource(ctx context.Context, opts *sourceOptions, needConfig bool) (Store, *Config, func(), error) {
+	var filter sessionFilter
+	if opts.filter != "" {
+		var filterErr error
+		filter, filterErr = parseFilter(opts.filter)
+		if filterErr != nil {
+			return nil, nil, nil, usageError("Invalid filter: %v", filterErr)
+		}
+	}
+	if opts.os != "" {
+		osOnly, osErr := parseOSFilter(opts.os)
+		if osErr != nil {
+			return nil, nil, nil, usageError("Invalid -os: %v", osErr)
+		}
+		if filter != nil {
+			filter = andFilter{filter, osOnly}
+		} else {
+			filter = osOnly
+		}
+	}
+
+	configFile := opts.configFile
+	if opts.inputFile != "" && !needConfig && opts.profile == "" && !isFlagSet(opts.fs, "config") {
+		configFile = ""
+	}
+	if containerMode {
+		if opts.profile != "" || opts.tenant != "" || isFlagSet(opts.fs, "config") {
+			return nil, nil, nil, usageError("-container reads its settings from %s_ environment variables only, so there's no config file or profiles", envPrefix)
+		}
+		configFile = ""
+	}
+	config, cfgErr := loadConfig(configFile, opts.profile, opts.tenant)
+	if cfgErr != nil {
+		return nil, nil, nil, configError(cfgErr, "Failed to process config file")
+	}
+	opts.db.apply(config)
+	for _, override := range opts.overrides {
+		if isFlagSet(opts.fs, override.flag) {
+			override.apply(config)
+		}
+	}
+	if err := validateUserColumns(config.Lookup.UserColumns); err != nil {
+		return nil, nil, nil, configError(err, "Invalid lookup.user_columns setting")
+	}
+
+	active, activeErr := newActiveRule(config, opts.expiredDays)
+	if activeErr != nil {
+		return nil, nil, nil, configError(activeErr, "Invalid sessions.active setting")
+	}
+	where, whereErr := parseCustomWhere(config.Sessions.Where, config.Sessions.Params)
+	if whereErr != nil {
+		return nil, nil, nil, configError(whereErr, "Invalid sessions.where setting")
+	}
+	if err := setLegacyDesktopPatterns(config.Sessions.LegacyDesktopPatterns); err != nil {
+		return nil, nil, nil, configError(err, "Invalid sessions.legacy_desktop_patterns setting")
+	}
+
+	if opts.showConfig {
+		if err := printConfig(os.Stdout, config); err != nil {
+			return nil, nil, nil, outputError(err, "Unable to show the configuration")
+		}
+		return nil, nil, nil, errConfigShown
+	}
+
+	if opts.inputFile != "" {
+		LogMessage(infoLevel, "Running offline against: "+opts.inputFile)
+		usersFile := opts.inputUsersFile
+		if config.Privacy.AggregateOnly && usersFile != "" {
+			LogMessage(warningLevel, "Ignoring users file, since aggregate-only mode is enabled")
+			usersFile = ""
+		}
+		offline, inputErr := loadInput(opts.inputFile, usersFile)
+		if inputErr != nil {
+			return nil, nil, nil, inputError(inputErr, "Failed to read input file")
+		}
+		offline.active = active
+		if where != nil {
+			LogMessage(warningLevel, "Ignoring sessions.where, since it can only be applied to a database query")
+		}
+		return withPrivacy(withFilter(offline, filter), config), config, func() {}, nil
+	}
+
+	var db *sql.DB
+	var dbErr error
+	if opts.lazyConnect {
+		db, dbErr = openDatabase(config)
+	} else {
+		db, dbErr = connectDatabase(ctx, config)
+	}
+	if dbErr != nil {
+		return nil, nil, nil, connectionError(dbErr, "Failed to connect to database")
+	}
+	source, storeErr := newSQLStore(db, config)
+	if storeErr != nil {
+		db.Close()
+		return nil, nil, nil, configError(storeErr, "Unsupported database type")
+	}
+	source.active = active
+	source.where = where
+	return withPrivacy(withFilter(source, filter), config), config, func() { db.Close() }, nil
+}
+
+// writeDiagnostics writes the sessions that couldn't be counted to a CSV file, for '-diagnostics'.  The file is
+// written even if there aren't any, so that it's clear the check was made.
+func writeDiagnostics(ctx context.Context, filename string, problems []mmversions.Problem, config *Config) error {
+	if err := writeCSVFile(ctx, filename, diagnosticRows(problems), config); err != nil {
+		return err
+	}
+	LogMessage(infoLevel, fmt.Sprintf("%d sessions that couldn't be counted written to: %s", len(problems), filename))
+	return nil
+}
+
+// writeCSVFile writes rows, the first of which is the header, to a CSV file.
+func writeCSVFile(ctx context.Context, filename string, rows [][]string, config *Config) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return outputError(err, "Failed to create output file")
+	}
+	defer file.Close()
+	writer, err := newOutputWriter("csv", file, config)
+	if err != nil {
+		return outputError(err, "Failed to write %s", filename)
+	}
+	if err := writer.WriteUsers(ctx, rows); err != nil {
+		return outputError(err, "Failed to write %s", filename)
+	}
+	if err := file.Close(); err != nil {
+		return outputError(err, "Failed to write output file")
+	}
+	return nil
+}
+
+// checkThresholds makes sure that each compliance threshold is a percentage, and has minimum versions to compare the
+// clients with.
+func checkThresholds(config *Config) error {
+	settings := config.Report
+	for _, threshold := range []struct {
+		flag    string
+		value   float64
+		minimum bool
+	}{
+		{"desktop-threshold", settings.DesktopThreshold, settings.MinDesktopVersion != ""},
+		{"mobile-threshold", settings.MobileThreshold, settings.MinMobileVersion != ""},
+		{"web-threshold", settings.WebThreshold, len(settings.MinBrowserVersions) > 0},
+	} {
+		if threshold.value < 0 || threshold.value > 100 {
+			return usageError("-%s must be a percentage, between 0 and 100", threshold.flag)
+		}
+		if threshold.value > 0 && !threshold.minimum {
+			return configError(nil, "-%s needs minimum versions to compare the clients with", threshold.flag)
+		}
+	}
+	return nil
+}
+
+// withFilter wraps the source so that only sessions matching the filter are returned, if there is one.
+func withFilter(source Store, filter sessionFilter) Store {
+	if filter == nil {
+		return source
+	}
+	return &filteredSource{Store: source, filter: filter}
+}
+
+func reportCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("report"))
+	opts := addSourceFlags(fs)
+	var style reportStyle
+	var noColor bool
+	var format string
+	fs.StringVar(&format, "format", "text", "[optional] output `format`: text, or one of "+outputFormats())
+	fs.StringVar(&style.minDesktopVersion, "min-desktop-version", "", "[optional] highlight desktop versions older than this one as outdated")
+	fs.StringVar(&style.minMobileVersion, "min-mobile-version", "", "[optional] highlight mobile versions older than this one as outdated")
+	opts.overrideSetting("min-desktop-version", func(config *Config) { config.Report.MinDesktopVersion = style.minDesktopVersion })
+	opts.overrideSetting("min-mobile-version", func(config *Config) { config.Report.MinMobileVersion = style.minMobileVersion })
+	var desktopThreshold, mobileThreshold, webThreshold float64
+	fs.Float64Var(&desktopThreshold, "desktop-threshold", 0, "[optional] fail if fewer than this `percent` of desktop clients are at or above -min-desktop-version")
+	fs.Float64Var(&mobileThreshold, "mobile-threshold", 0, "[optional] fail if fewer than this `percent` of mobile clients are at or above -min-mobile-version")
+	fs.Float64Var(&webThreshold, "web-threshold", 0, "[optional] fail if fewer than this `percent` of browsers are at or above report.min_browser_versions")
+	opts.overrideSetting("desktop-threshold", func(config *Config) { config.Report.DesktopThreshold = desktopThreshold })
+	opts.overrideSetting("mobile-threshold", func(config *Config) { config.Report.MobileThreshold = mobileThreshold })
+	opts.overrideSetting("web-threshold", func(config *Config) { config.Report.WebThreshold = webThreshold })
+	fs.BoolVar(&noColor, "no-color", false, "[optional] don't colour the output, e.g. when writing to logs or in CI")
+	var showTeams bool
+	fs.BoolVar(&showTeams, "teams", false, "[optional] add a breakdown of the desktop and mobile versions by team")
+	var showAuth bool
+	fs.BoolVar(&showAuth, "auth", false, "[optional] add a breakdown of the desktop and mobile versions by how their users sign in, e.g. ldap, saml or email")
+	var showRoles bool
+	fs.BoolVar(&showRoles, "roles", false, "[optional] add a breakdown of the desktop and mobile versions by role: system admin, member or guest")
+	var showBlocked bool
+	fs.BoolVar(&showBlocked, "server-minimums", false, "[optional] add the sessions below the minimum app versions enforced by the Mattermost server, which are already blocked")
+	var showNodes bool
+	fs.BoolVar(&showNodes, "nodes", false, "[optional] add a breakdown of the desktop and mobile versions by app node or connection origin, from the session props in sessions.node_props")
+	var showPerUser bool
+	fs.BoolVar(&showPerUser, "sessions-per-user", false, "[optional] add a breakdown of the users by how many active desktop and mobile sessions they have")
+	var showShared bool
+	fs.BoolVar(&showShared, "shared-devices", false, "[optional] add the device IDs signed in to by more than one user, e.g. shared devices or cloned MDM enrolments")
+	fs.IntVar(&opts.expiredDays, "include-expired", 0, "[optional] also include the sessions that expired in the last number of `days`, for a view of the clients in use recently")
+	var showLocales bool
+	fs.BoolVar(&showLocales, "locales", false, "[optional] add a breakdown of the clients by their user's locale")
+	var showOSVersions bool
+	fs.BoolVar(&showOSVersions, "os-versions", false, "[optional] add a breakdown of the desktop app versions by OS version, e.g. Windows 10 and 11")
+	var showBuilds bool
+	var showAdoption bool
+	fs.BoolVar(&showAdoption, "adoption", false, "[optional] add the percentage of enabled users with an active desktop or mobile client")
+	var noLicenseHeader bool
+	fs.BoolVar(&noLicenseHeader, "no-license-header", false, "[optional] don't start the report with the server's edition and license")
+	var showLicense bool
+	fs.BoolVar(&showLicense, "license", false, "[optional] add the active clients and users as a percentage of the licensed seats")
+	var showExpiry bool
+	fs.BoolVar(&showExpiry, "expiry", false, "[optional] add a breakdown of the clients by how soon their sessions expire")
+	var sendAdminSummary bool
+	fs.BoolVar(&sendAdminSummary, "notify-admins", false, "[optional] send a summary to the system admins, or those in bot.admins, as a direct message from the bot in the config file")
+	var diagnosticsFile string
+	fs.StringVar(&diagnosticsFile, "diagnostics", "", "[optional] write the sessions that couldn't be counted, with their raw props, to this CSV `file`")
+	var sampleUnknown int
+	fs.IntVar(&sampleUnknown, "sample-unknown", 0, "[optional] write up to this `number` of anonymised examples of the client strings that couldn't be classified to -sample-file")
+	var sampleFile string
+	fs.StringVar(&sampleFile, "sample-file", defaultUnknownSampleFile, "[optional] CSV `file` for the examples from -sample-unknown")
+	fs.BoolVar(&showBuilds, "mobile-builds", false, "[optional] add a breakdown of the mobile app versions by build number")
+	addLanguageFlag(fs, opts)
+
+	return fs, func(ctx context.Context, args []string) error {
+		if opts.expiredDays < 0 {
+			return usageError("-include-expired can't be negative")
+		}
+		stdoutReserved = format != "text"
+		source, config, closeSource, err := openSource(ctx, opts, false)
+		if err != nil {
+			return err
+		}
+		defer closeSource()
+		if err := applyLanguage(config); err != nil {
+			return err
+		}
+		if opts.expiredDays > 0 {
+			LogMessage(infoLevel, fmt.Sprintf("Including the sessions that expired in the last %d days", opts.expiredDays))
+		}
+
+		style.minDesktopVersion = config.Report.MinDesktopVersion
+		style.minMobileVersion = config.Report.MinMobileVersion
+		if err := checkThresholds(config); err != nil {
+			return err
+		}
+		if containerMode && !isFlagSet(fs, "format") {
+			format = "ndjson"
+		}
+		var writer OutputWriter
+		if format != "text" {
+			if writer, err = newOutputWriter(format, os.Stdout, config); err != nil {
+				return usageError("Invalid -format: %v", err)
+			}
+			if showLocales || showTeams || showAuth || showRoles || showNodes || showBlocked || showPerUser || showShared || showOSVersions || showBuilds || showAdoption || showLicense || showExpiry {
+				return usageError("The breakdowns are only available with -format text")
+			}
+		}
+		if showLocales && config.Privacy.AggregateOnly {
+			return configError(errAggregateOnly, "The locale breakdown needs each user's locale")
+		}
+		if showTeams && config.Privacy.AggregateOnly {
+			return configError(errAggregateOnly, "The team breakdown needs each user's teams")
+		}
+		if showAuth && config.Privacy.AggregateOnly {
+			return configError(errAggregateOnly, "The sign-in breakdown needs each user's auth service")
+		}
+		if showRoles && config.Privacy.AggregateOnly {
+			return configError(errAggregateOnly, "The role breakdown needs each user's roles")
+		}
+		if showShared && config.Privacy.AggregateOnly {
+			return configError(errAggregateOnly, "The shared devices identify individual users")
+		}
+		if showAdoption && config.Privacy.AggregateOnly {
+			return configError(errAggregateOnly, "Adoption needs the number of users from the Users table")
+		}
+		var nodes *nodeAttributor
+		if showNodes {
+			if nodes, err = newNodeAttributor(config); err != nil {
+				return configError(err, "The node breakdown can't be shown")
+			}
+		}
+		if diagnosticsFile != "" && containerMode {
+			return usageError("-container doesn't write local files, so -diagnostics can't be used")
+		}
+		if sampleUnknown < 0 {
+			return usageError("-sample-unknown can't be negative")
+		}
+		if sampleUnknown > 0 && containerMode {
+			return usageError("-container doesn't write local files, so -sample-unknown can't be used")
+		}
+		if diagnosticsFile != "" && config.Privacy.AggregateOnly {
+			return configError(errAggregateOnly, "The diagnostics file identifies individual sessions and users")
+		}
+
+		if sendAdminSummary && (config.Bot.URL == "" || config.Bot.Token == "") {
+			return configError(nil, "-notify-admins needs a bot account, set as bot.url and bot.token in the config file")
+		}
+
+		sessions, err := source.Sessions(ctx)
+		if err != nil {
+			return queryError(err, "Error processing database")
+		}
+		summary := tallySessions(sessions)
+		if sendAdminSummary {
+			message := adminSummary(summary, sessions, config.Report.MinDesktopVersion, config.Report.MinMobileVersion)
+			if err := notifyAdmins(ctx, config, message); err != nil {
+				return webhookError(err, "Failed to notify the admins")
+			}
+		}
+		if diagnosticsFile != "" {
+			if err := writeDiagnostics(ctx, diagnosticsFile, summary.Problems, config); err != nil {
+				return err
+			}
+		}
+		if sampleUnknown > 0 {
+			if err := writeUnknownSamples(ctx, sampleFile, sessions, sampleUnknown, config); err != nil {
+				return err
+			}
+		}
+		compliance := checkCompliance(summary, config)
+		maxSessionDays := config.Report.MaxSessionDays
+		if maxSessionDays == 0 {
+			maxSessionDays = defaultMaxSessionDays
+		}
+		anomalies := tallyExpiryAnomalies(sessions, time.Now(), maxSessionDays)
+		warnExpiryAnomalies(anomalies, mmversions.Total(summary.Desktop)+mmversions.Total(summary.Mobile), maxSessionDays)
+		if writer != nil {
+			if err := writer.Write(ctx, summary); err != nil {
+				return outputError(err, "Failed to write the report")
+			}
+			return complianceFailed(compliance)
+		}
+
+		// The users are read together, for the breakdowns that need them, rather than looking each one up
+		var users map[string]UserRecord
+		if showAuth || showRoles || showLocales {
+			if users, err = sessionUsers(ctx, source); err != nil {
+				return queryError(err, "Error looking up users")
+			}
+		}
+
+		style.color = useColor(noColor)
+		if !noLicenseHeader {
+			license, err := source.License(ctx)
+			switch {
+			case errors.Is(err, errNoLicenseData):
+				DebugPrint("Leaving out the license, since the input doesn't include it")
+			case err != nil:
+				LogMessage(warningLevel, "Leaving out the license, since it couldn't be read: "+err.Error())
+			default:
+				printLicenseHeader(os.Stdout, license, time.Now(), style)
+			}
+		}
+		printResults(summary, style)
+		if len(compliance) > 0 {
+			printCompliance(os.Stdout, compliance, style)
+		}
+
+		if showOSVersions && len(summary.Desktop) > 0 {
+			printOSVersionTable(os.Stdout, summary.Desktop)
+		}
+		if showBuilds && len(summary.MobileBuilds) > 0 {
+			printBuildTable(os.Stdout, summary.MobileBuilds)
+		}
+		if showAdoption {
+			enabledUsers, err := source.UserCount(ctx)
+			if err != nil {
+				return queryError(err, "Error counting users")
+			}
+			printAdoptionTable(os.Stdout, tallyAdoption(sessions, enabledUsers))
+		}
+		if showLicense {
+			license, err := source.License(ctx)
+			if err != nil && !errors.Is(err, errNoLicenseData) {
+				return queryError(err, "Error reading the license")
+			}
+			seats := 0
+			if license != nil {
+				seats = license.Seats
+			}
+			users := tallyAdoption(sessions, 0).either
+			printLicenseUsage(os.Stdout, seats, mmversions.Total(summary.Desktop)+mmversions.Total(summary.Mobile), users, style)
+		}
+		if showExpiry {
+			printExpiryTable(os.Stdout, tallyExpiry(sessions, time.Now()))
+			if len(anomalies) > 0 {
+				printAnomalyTable(os.Stdout, anomalies, maxSessionDays)
+			}
+		}
+		if showPerUser {
+			perUser, most := tallySessionsPerUser(sessions)
+			printPerUserTable(os.Stdout, perUser, most)
+		}
+		if showShared {
+			if err := printSharedDevices(ctx, os.Stdout, source, findSharedDevices(sessions)); err != nil {
+				return queryError(err, "Error looking up users")
+			}
+		}
+		if showTeams {
+			teams, err := source.AllTeams(ctx)
+			if err != nil {
+				return queryError(err, "Error looking up teams")
+			}
+			printGroupTable(os.Stdout, msgTeamsFound, msgColumnTeam, msgNoTeam, tallyTeams(sessions, teams))
+		}
+		if showAuth {
+			printGroupTable(os.Stdout, msgAuthFound, msgColumnAuth, msgUnknownAuth, tallyAuthServices(sessions, users))
+		}
+		if showNodes {
+			printNodeTable(os.Stdout, tallyNodes(sessions, nodes), style)
+		}
+		if showBlocked {
+			minimums, err := source.ServerMinimums(ctx)
+			if err != nil {
+				return queryError(err, "Error reading the server's configuration")
+			}
+			if minimums.empty() {
+				fmt.Println("\n" + tr(msgNoServerMinimums))
+			} else {
+				printBlockedTable(os.Stdout, tallyBlocked(sessions, minimums))
+			}
+		}
+		if showRoles {
+			printRoleTable(os.Stdout, tallyRoles(sessions, users, style.minDesktopVersion, style.minMobileVersion), style)
+		}
+		if showLocales {
+			printLocaleTable(os.Stdout, tallyLocales(sessions, users))
+		}
+		return complianceFailed(compliance)
+	}
+}
+
+func lookupCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("lookup"))
+	opts := addSourceFlags(fs)
+	var lookupVersion string
+	var outputFile string
+	fs.StringVar(&lookupVersion, "ver", "", "[required] user with desktop clients of this version and older will be returned, or mobile clients with -client mobile")
+	var lookupClient, mobileVersion string
+	fs.StringVar(&lookupClient, "client", "desktop", "[optional] which `clients` to look up: desktop, mobile, or all, with -mobile-ver as the mobile version")
+	fs.StringVar(&mobileVersion, "mobile-ver", "", "[optional] with -client all, users with mobile clients of this version and older will also be returned")
+	var options lookupOptions
+	fs.StringVar(&outputFile, "outfile", defaultOutputFile, "[optional] Specify an alternative output CSV filename")
+	var userColumns string
+	fs.StringVar(&options.format, "format", "csv", "[optional] output `format`: csv, json, ndjson, or grouped for a text listing of each user's sessions")
+	fs.BoolVar(&options.includeTeams, "teams", false, "[optional] add a column with the names of each user's teams")
+	fs.BoolVar(&options.redact, "redact", false, "[optional] replace names, emails and device IDs with pseudonymous IDs, keyed with lookup.pseudonym_key from the config file, e.g. for sharing with third parties")
+	var hashEmails bool
+	fs.BoolVar(&hashEmails, "hash-emails", false, "[optional] replace emails with their HMAC-SHA256, keyed with lookup.email_hmac_key from the config file")
+	fs.BoolVar(&options.adminsFirst, "admins-first", false, "[optional] list system admins before everyone else")
+	fs.IntVar(&options.limit, "limit", 0, "[optional] only write this `number` of users, starting with those on the oldest versions")
+	fs.BoolVar(&options.dedupeUsers, "dedupe-users", false, "[optional] write one row for each user, with their oldest and newest outdated versions, rather than one for each session")
+	var usersFile, userFilter string
+	fs.StringVar(&userFilter, "user-filter", "", "[optional] only look up the users whose username or email matches this glob, e.g. '*@contractor.example.com', or /regexp/.  Start with ! to leave them out instead")
+	fs.StringVar(&usersFile, "users-file", "", "[optional] only look up the users in this `file`, with a username or email on each line, e.g. a pilot team")
+	fs.BoolVar(&options.includeSessionID, "session-ids", false, "[optional] add a column with each session's ID, e.g. for revoking sessions with mmctl")
+	fs.StringVar(&userColumns, "user-columns", "", "[optional] comma-separated extra `columns` from the Users table, or props.<key>, overriding those in the config file")
+	opts.overrideSetting("user-columns", func(config *Config) { config.Lookup.UserColumns = splitList(userColumns) })
+	opts.overrideSetting("outfile", func(config *Config) { config.Output.LookupFile = outputFile })
+	addOutputFileFlags(fs, opts)
+
+	return fs, func(ctx context.Context, args []string) error {
+		if lookupVersion == "" {
+			fs.Usage()
+			return usageError("A client version is required for lookup mode")
+		}
+		var versions lookupVersions
+		switch lookupClient {
+		case "desktop":
+			versions = desktopOnly(lookupVersion)
+		case "mobile":
+			versions = lookupVersions{mmversions.Mobile: lookupVersion}
+		case "all":
+			if mobileVersion == "" {
+				return usageError("-client all needs a mobile version as well, given with -mobile-ver")
+			}
+			versions = lookupVersions{mmversions.Desktop: lookupVersion, mmversions.Mobile: mobileVersion}
+		default:
+			return usageError("Unsupported -client %q.  This must be desktop, mobile or all", lookupClient)
+		}
+		if mobileVersion != "" && lookupClient != "all" {
+			return usageError("-mobile-ver is only used with -client all")
+		}
+		if options.limit < 0 {
+			return usageError("-limit must be a positive number of users")
+		}
+		if options.dedupeUsers && options.format == "grouped" {
+			return usageError("-format grouped already lists each user once, so -dedupe-users can't be used with it")
+		}
+		if userFilter != "" {
+			var err error
+			if options.userPattern, err = parseUserPattern(userFilter); err != nil {
+				return usageError("Invalid -user-filter: %v", err)
+			}
+		}
+		if usersFile != "" {
+			var err error
+			if options.onlyUsers, err = readUserList(usersFile); err != nil {
+				return inputError(err, "Failed to read the users file")
+			}
+		}
+		source, config, closeSource, err := openSource(ctx, opts, false)
+		if err != nil {
+			return err
+		}
+		defer closeSource()
+		outputFile = config.Output.LookupFile
+		reserveStdout(outputFile)
+		if config.Privacy.AggregateOnly {
+			return configError(errAggregateOnly, "Lookup lists individual users")
+		}
+		if err := checkOutputFile(config); err != nil {
+			return err
+		}
+		options.userColumns = config.Lookup.UserColumns
+		options.config = config
+		if _, err := newOutputWriter(options.format, nil, config); err != nil {
+			return usageError("Invalid -format: %v", err)
+		}
+		if containerMode {
+			if isFlagSet(fs, "outfile") {
+				return usageError("-container writes the results to stdout, so -outfile can't be used")
+			}
+			outputFile = "-"
+			if !isFlagSet(fs, "format") {
+				options.format = "ndjson"
+			}
+		}
+		if options.format != "csv" && !isFlagSet(fs, "outfile") && outputFile == defaultOutputFile {
+			extension := options.format
+			if extension == "grouped" {
+				extension = "txt"
+			}
+			outputFile = strings.TrimSuffix(defaultOutputFile, ".csv") + "." + extension
+		}
+		if options.encryption, err = loadEncryption(config); err != nil {
+			return configError(err, "Invalid encryption settings")
+		}
+		if options.encryption != nil && outputFile != "-" {
+			outputFile += options.encryption.extension()
+		}
+		if options.redact {
+			if config.Lookup.PseudonymKey == "" {
+				return configError(nil, "-redact needs a key, set as lookup.pseudonym_key in the config file or %s_LOOKUP_PSEUDONYM_KEY", envPrefix)
+			}
+			if hashEmails {
+				return usageError("-redact can't be combined with -hash-emails, since a hash of the real email would identify each pseudonym")
+			}
+			if len(config.Lookup.UserColumns) > 0 {
+				return configError(nil, "-redact can't be used with extra user columns, from -user-columns or lookup.user_columns, since they can hold names or emails")
+			}
+			if config.LDAP.URL != "" && len(config.LDAP.Attributes) > 0 {
+				return configError(nil, "-redact can't be used with ldap.attributes, since they can hold names or emails")
+			}
+			options.pseudonymKey = config.Lookup.PseudonymKey
+		}
+		if hashEmails {
+			if config.Lookup.EmailHMACKey == "" {
+				return configError(nil, "-hash-emails needs a key, set as lookup.email_hmac_key in the config file or %s_LOOKUP_EMAIL_HMAC_KEY", envPrefix)
+			}
+			options.emailHMACKey = config.Lookup.EmailHMACKey
+		}
+		if config.LDAP.URL != "" {
+			directory, err := openLDAP(config)
+			if err != nil {
+				return connectionError(err, "Failed to connect to the LDAP server")
+			}
+			defer directory.Close()
+			options.directory = directory
+		}
+		LogMessage(infoLevel, "Running in lookup mode, for "+versions.String()+" and earlier.  Writing results to: "+outputName(outputFile))
+
+		DebugPrint("Staring lookup")
+		if err := doLookup(ctx, source, outputFile, versions, options); err != nil {
+			return err
+		}
+		if outputFile == "-" {
+			return nil
+		}
+		if err := finishOutput(ctx, config, outputFile); err != nil {
+			return err
+		}
+		return nil
+	}
+}
+
+func whoisCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("whois"))
+	opts := addSourceFlags(fs)
+	addLanguageFlag(fs, opts)
+
+	return fs, func(ctx context.Context, args []string) error {
+		if len(args) != 1 {
+			fs.Usage()
+			return usageError("A username or email is required")
+		}
+		source, config, closeSource, err := openSource(ctx, opts, false)
+		if err != nil {
+			return err
+		}
+		defer closeSource()
+		if err := applyLanguage(config); err != nil {
+			return err
+		}
+		if config.Privacy.AggregateOnly {
+			return configError(errAggregateOnly, "Whois lists an individual user's sessions")
+		}
+
+		users, err := source.FindUser(ctx, args[0])
+		if err != nil {
+			return queryError(err, "Error looking up the user")
+		}
+		if len(users) == 0 {
+			return usageError("No user found with the username or email %q", args[0])
+		}
+		sessions, err := source.Sessions(ctx)
+		if err != nil {
+			return queryError(err, "Error processing database")
+		}
+		for i, user := range users {
+			if i > 0 {
+				fmt.Println()
+			}
+			printWhois(os.Stdout, user, sessions)
+		}
+		return nil
+	}
+}
+
+func staleCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("stale"))
+	opts := addSourceFlags(fs)
+	var days int
+	var outputFile string
+	fs.IntVar(&days, "days", 30, "[optional] number of days without activity before a session counts as stale")
+	fs.StringVar(&outputFile, "outfile", "", "[optional] also write each stale session, with its session and user IDs, to this CSV `file`")
+	addLanguageFlag(fs, opts)
+	addOutputFileFlags(fs, opts)
+
+	return fs, func(ctx context.Context, args []string) error {
+		if days < 1 {
+			return usageError("-days must be at least 1")
+		}
+		source, config, closeSource, err := openSource(ctx, opts, false)
+		if err != nil {
+			return err
+		}
+		defer closeSource()
+		if err := applyLanguage(config); err != nil {
+			return err
+		}
+		if outputFile != "" && containerMode {
+			return usageError("-container doesn't write local files, so -outfile can't be used")
+		}
+		if outputFile != "" && config.Privacy.AggregateOnly {
+			return configError(errAggregateOnly, "The stale session list identifies individual users")
+		}
+		if err := checkOutputFile(config); err != nil {
+			return err
+		}
+
+		sessions, err := source.Sessions(ctx)
+		if err != nil {
+			return queryError(err, "Error processing database")
+		}
+		now := time.Now()
+		stale := staleSessions(sessions, days, now)
+		printStaleSummary(stale, len(sessions), days)
+
+		if outputFile != "" {
+			file, err := os.Create(outputFile)
+			if err != nil {
+				return outputError(err, "Failed to create output file")
+			}
+			defer file.Close()
+			writer, err := newOutputWriter("csv", file, config)
+			if err != nil {
+				return outputError(err, "Failed to write stale sessions")
+			}
+			if err := writer.WriteUsers(ctx, staleRows(stale, now)); err != nil {
+				return outputError(err, "Failed to write stale sessions")
+			}
+			if err := file.Close(); err != nil {
+				return outputError(err, "Failed to write output file")
+			}
+			LogMessage(infoLevel, "Stale sessions written to: "+outputFile)
+			if err := finishOutput(ctx, config, outputFile); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func planCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("plan"))
+	opts := addSourceFlags(fs)
+	var version, groupBy, startDate, outputFile string
+	var interval int
+	fs.StringVar(&version, "ver", "", "[required] plan the upgrade of desktop clients of this version and older")
+	fs.StringVar(&groupBy, "group-by", planByVersion, "[optional] split the plan into a step for each `version`, os or team")
+	fs.StringVar(&startDate, "start", "", "[optional] `date` of the first step, as YYYY-MM-DD (default today)")
+	fs.IntVar(&interval, "interval", 7, "[optional] number of `days` between the steps")
+	fs.StringVar(&outputFile, "outfile", defaultPlanFile, "[optional] markdown `file` to write, or - for stdout")
+	addOutputFileFlags(fs, opts)
+
+	return fs, func(ctx context.Context, args []string) error {
+		if version == "" {
+			fs.Usage()
+			return usageError("A desktop client version is required")
+		}
+		groupBy = strings.ToLower(groupBy)
+		if groupBy != planByVersion && groupBy != planByOS && groupBy != planByTeam {
+			return usageError("-group-by must be %s, %s or %s", planByVersion, planByOS, planByTeam)
+		}
+		if interval < 1 {
+			return usageError("-interval must be at least 1")
+		}
+		now := time.Now().In(outputLocation)
+		start := now
+		if startDate != "" {
+			var err error
+			if start, err = time.ParseInLocation("2006-01-02", startDate, outputLocation); err != nil {
+				return usageError("Invalid -start: %v", err)
+			}
+		}
+
+		source, config, closeSource, err := openSource(ctx, opts, false)
+		if err != nil {
+			return err
+		}
+		defer closeSource()
+		if groupBy == planByTeam && config.Privacy.AggregateOnly {
+			return configError(errAggregateOnly, "Grouping by team needs each user's teams")
+		}
+		if err := checkOutputFile(config); err != nil {
+			return err
+		}
+		if containerMode {
+			if isFlagSet(fs, "outfile") {
+				return usageError("-container writes the results to stdout, so -outfile can't be used")
+			}
+			outputFile = "-"
+		}
+
+		sessions, err := source.Sessions(ctx)
+		if err != nil {
+			return queryError(err, "Error processing database")
+		}
+		plan, err := buildPlan(ctx, source, sessions, version, groupBy, start, interval)
+		if err != nil {
+			return queryError(err, "Error looking up teams")
+		}
+		if outputFile == "-" {
+			if err := writePlan(os.Stdout, plan, now); err != nil {
+				return outputError(err, "Failed to write the plan")
+			}
+			return nil
+		}
+
+		file, err := os.Create(outputFile)
+		if err != nil {
+			return outputError(err, "Failed to create output file")
+		}
+		defer file.Close()
+		if err := writePlan(file, plan, now); err != nil {
+			return outputError(err, "Failed to write %s", outputFile)
+		}
+		if err := file.Close(); err != nil {
+			return outputError(err, "Failed to write output file")
+		}
+		LogMessage(infoLevel, fmt.Sprintf("Remediation plan in %d steps written to: %s", len(plan.steps), outputFile))
+		return finishOutput(ctx, config, outputFile)
+	}
+}
+
+func mdmExportCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("mdm-export"))
+	opts := addSourceFlags(fs)
+	var minimum, outputFile string
+	fs.StringVar(&minimum, "min-mobile-version", "", "[optional] export the devices with mobile apps older than this `version`, overriding report.min_mobile_version in the config file")
+	opts.overrideSetting("min-mobile-version", func(config *Config) { config.Report.MinMobileVersion = minimum })
+	fs.StringVar(&outputFile, "outfile", defaultMDMFile, "[optional] CSV `file` to write, or - for stdout")
+	addOutputFileFlags(fs, opts)
+
+	return fs, func(ctx context.Context, args []string) error {
+		source, config, closeSource, err := openSource(ctx, opts, false)
+		if err != nil {
+			return err
+		}
+		defer closeSource()
+		if config.Privacy.AggregateOnly {
+			return configError(errAggregateOnly, "The MDM export lists individual users")
+		}
+		minimum = config.Report.MinMobileVersion
+		if minimum == "" {
+			fs.Usage()
+			return usageError("A minimum mobile version is required, from -min-mobile-version or report.min_mobile_version")
+		}
+		if _, _, _, err := mmversions.ParseVersion(minimum); err != nil {
+			return usageError("Invalid -min-mobile-version: %v", err)
+		}
+		if err := checkOutputFile(config); err != nil {
+			return err
+		}
+		if containerMode {
+			if isFlagSet(fs, "outfile") {
+				return usageError("-container writes the results to stdout, so -outfile can't be used")
+			}
+			outputFile = "-"
+		}
+
+		sessions, err := source.Sessions(ctx)
+		if err != nil {
+			return queryError(err, "Error processing database")
+		}
+		devices := outdatedDevices(sessions, minimum)
+		rows, err := mdmRows(ctx, source, devices)
+		if err != nil {
+			return queryError(err, "Error looking up users")
+		}
+		if outputFile == "-" {
+			writer, err := newOutputWriter("csv", os.Stdout, config)
+			if err != nil {
+				return outputError(err, "Failed to write the MDM export")
+			}
+			if err := writer.WriteUsers(ctx, rows); err != nil {
+				return outputError(err, "Failed to write the MDM export")
+			}
+			return nil
+		}
+		if err := writeCSVFile(ctx, outputFile, rows, config); err != nil {
+			return err
+		}
+		LogMessage(infoLevel, fmt.Sprintf("%d devices with mobile apps older than %s written to: %s", len(devices), minimum, outputFile))
+		return finishOutput(ctx, config, outputFile)
+	}
+}
+
+func readinessCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("readiness"))
+	opts := addSourceFlags(fs)
+	var target, minDesktop, minMobile, outputFile string
+	fs.StringVar(&target, "target", "", "[required] the server `version` being upgraded to, e.g. 10.5.0")
+	fs.StringVar(&minDesktop, "min-desktop-version", "", "[optional] the target release's minimum desktop `version`, overriding upgrade.releases in the config file")
+	fs.StringVar(&minMobile, "min-mobile-version", "", "[optional] the target release's minimum mobile `version`, overriding upgrade.releases in the config file")
+	fs.StringVar(&outputFile, "outfile", defaultReadinessFile, "[optional] CSV `file` listing the users who would need to upgrade, or - for stdout")
+	addLanguageFlag(fs, opts)
+	addOutputFileFlags(fs, opts)
+
+	return fs, func(ctx context.Context, args []string) error {
+		if target == "" {
+			fs.Usage()
+			return usageError("The target server version is required, with -target")
+		}
+		if _, _, _, err := mmversions.ParseVersion(target); err != nil {
+			return usageError("Invalid -target: %v", err)
+		}
+		for _, minimum := range []struct{ flag, value string }{{"min-desktop-version", minDesktop}, {"min-mobile-version", minMobile}} {
+			if minimum.value == "" {
+				continue
+			}
+			if _, _, _, err := mmversions.ParseVersion(minimum.value); err != nil {
+				return usageError("Invalid -%s: %v", minimum.flag, err)
+			}
+		}
+
+		source, config, closeSource, err := openSource(ctx, opts, false)
+		if err != nil {
+			return err
+		}
+		defer closeSource()
+		if err := applyLanguage(config); err != nil {
+			return err
+		}
+
+		release, found := releaseMinimums(config.Upgrade.Releases, target)
+		release.Server = target
+		if minDesktop != "" {
+			release.MinDesktopVersion = minDesktop
+		}
+		if minMobile != "" {
+			release.MinMobileVersion = minMobile
+		}
+		if release.MinDesktopVersion == "" && release.MinMobileVersion == "" {
+			if !found {
+				return configError(nil, "No minimum versions are known for server %s.  Add the release to upgrade.releases in the config file, or give -min-desktop-version and -min-mobile-version", target)
+			}
+			return configError(nil, "The release for server %s in upgrade.releases has no minimum versions", target)
+		}
+
+		writeList := !config.Privacy.AggregateOnly
+		if config.Privacy.AggregateOnly && isFlagSet(fs, "outfile") {
+			return configError(errAggregateOnly, "The readiness file lists individual users")
+		}
+		if containerMode {
+			if isFlagSet(fs, "outfile") {
+				return usageError("-container writes the results to stdout, so -outfile can't be used")
+			}
+			outputFile = "-"
+		}
+		if writeList && outputFile != "-" {
+			if err := checkOutputFile(config); err != nil {
+				return err
+			}
+		}
+
+		sessions, err := source.Sessions(ctx)
+		if err != nil {
+			return queryError(err, "Error processing database")
+		}
+		if outputFile != "-" || !writeList {
+			printReadiness(os.Stdout, target, sessions, release)
+		}
+		if !writeList {
+			return nil
+		}
+
+		affected := affectedClients(sessions, release.MinDesktopVersion, release.MinMobileVersion)
+		rows, err := readinessRows(ctx, source, affected)
+		if err != nil {
+			return queryError(err, "Error looking up users")
+		}
+		if outputFile == "-" {
+			writer, err := newOutputWriter("csv", os.Stdout, config)
+			if err != nil {
+				return outputError(err, "Failed to write the readiness list")
+			}
+			if err := writer.WriteUsers(ctx, rows); err != nil {
+				return outputError(err, "Failed to write the readiness list")
+			}
+			return nil
+		}
+		if err := writeCSVFile(ctx, outputFile, rows, config); err != nil {
+			return err
+		}
+		LogMessage(infoLevel, fmt.Sprintf("%d clients below the minimum versions for server %s written to: %s", len(affected), target, outputFile))
+		return finishOutput(ctx, config, outputFile)
+	}
+}
+
+func forgottenCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("forgotten"))
+	opts := addSourceFlags(fs)
+	var months int
+	var version, outputFile string
+	fs.IntVar(&months, "months", 6, "[optional] number of months since a session was created before it counts as long-lived")
+	fs.StringVar(&version, "ver", "", "[required] only include sessions from desktop clients of this version and older")
+	fs.StringVar(&outputFile, "outfile", "", "[optional] also write each session, with its session and user IDs, to this CSV `file`")
+	addLanguageFlag(fs, opts)
+	addOutputFileFlags(fs, opts)
+
+	return fs, func(ctx context.Context, args []string) error {
+		if version == "" {
+			fs.Usage()
+			return usageError("A desktop client version is required")
+		}
+		if months < 1 {
+			return usageError("-months must be at least 1")
+		}
+		source, config, closeSource, err := openSource(ctx, opts, false)
+		if err != nil {
+			return err
+		}
+		defer closeSource()
+		if err := applyLanguage(config); err != nil {
+			return err
+		}
+		if outputFile != "" && containerMode {
+			return usageError("-container doesn't write local files, so -outfile can't be used")
+		}
+		if outputFile != "" && config.Privacy.AggregateOnly {
+			return configError(errAggregateOnly, "The session list identifies individual users")
+		}
+		if err := checkOutputFile(config); err != nil {
+			return err
+		}
+
+		sessions, err := source.Sessions(ctx)
+		if err != nil {
+			return queryError(err, "Error processing database")
+		}
+		now := time.Now()
+		forgotten := forgottenSessions(sessions, months, version, now)
+		fmt.Println(trf(msgForgottenHeading, months, version))
+		fmt.Println()
+		printResults(tallySessions(forgotten), reportStyle{})
+		fmt.Println("\n" + trf(msgForgottenTotal, len(forgotten), len(sessions)))
+
+		if outputFile != "" {
+			if err := writeCSVFile(ctx, outputFile, forgottenRows(forgotten, now), config); err != nil {
+				return err
+			}
+			LogMessage(infoLevel, "Long-lived sessions written to: "+outputFile)
+			if err := finishOutput(ctx, config, outputFile); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func notifyCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("notify"))
+	opts := addSourceFlags(fs)
+	var webhookURL string
+	var webhookFormat string
+	fs.StringVar(&webhookURL, "url", "", "[optional] webhook URL, overriding the one in the config file")
+	fs.StringVar(&webhookFormat, "format", "", "[optional] webhook format (mattermost, slack or teams), overriding the one in the config file")
+	opts.overrideSetting("url", func(config *Config) { config.Webhook.URL = webhookURL })
+	opts.overrideSetting("format", func(config *Config) { config.Webhook.Format = webhookFormat })
+	addLanguageFlag(fs, opts)
+
+	return fs, func(ctx context.Context, args []string) error {
+		source, config, closeSource, err := openSource(ctx, opts, true)
+		if err != nil {
+			return err
+		}
+		defer closeSource()
+		if err := applyLanguage(config); err != nil {
+			return err
+		}
+
+		if config.Webhook.URL == "" {
+			return configError(nil, "No webhook URL found in the config file")
+		}
+
+		summary, processErr := processSessions(ctx, source)
+		if processErr != nil {
+			return queryError(processErr, "Error processing database")
+		}
+
+		writer, err := newOutputWriter("webhook", nil, config)
+		if err != nil {
+			return webhookError(err, "Failed to post summary to webhook")
+		}
+		if err := writer.Write(ctx, summary); err != nil {
+			return webhookError(err, "Failed to post summary to webhook")
+		}
+		LogMessage(infoLevel, "Summary posted to webhook")
+		return nil
+	}
+}
+
+func digestCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("digest"))
+	opts := addSourceFlags(fs)
+	var stateFile string
+	var dryRun bool
+	fs.StringVar(&stateFile, "state", defaultDigestFile, "[optional] `file` that keeps what the last digest saw, for the next one to compare with")
+	fs.BoolVar(&dryRun, "dry-run", false, "[optional] print the digest instead of sending it, and leave the state file alone")
+	opts.overrideSetting("state", func(config *Config) { config.Output.DigestFile = stateFile })
+	addLanguageFlag(fs, opts)
+
+	return fs, func(ctx context.Context, args []string) error {
+		source, config, closeSource, err := openSource(ctx, opts, false)
+		if err != nil {
+			return err
+		}
+		defer closeSource()
+		if err := applyLanguage(config); err != nil {
+			return err
+		}
+
+		message, err := runDigest(ctx, source, config, time.Now(), dryRun)
+		if err != nil {
+			return err
+		}
+		fmt.Print(message)
+		return nil
+	}
+}
+
+func serveCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("serve"))
+	opts := addSourceFlags(fs)
+	var listenAddr string
+	fs.StringVar(&listenAddr, "listen", ":9090", "[optional] address to listen on")
+	var accessLogPath, accessLogFormat string
+	fs.StringVar(&accessLogPath, "access-log", "", "[optional] log each request to this `file`, or - for stderr")
+	fs.StringVar(&accessLogFormat, "access-log-format", accessLogCommon, "[optional] access log format: common or json")
+	var refreshEvery time.Duration
+	fs.DurationVar(&refreshEvery, "refresh-every", defaultRefreshEvery, "[optional] serve the same version counts for this long before counting the sessions again, or 0 to count them for every request")
+	var rateLimit, rateBurst int
+	fs.IntVar(&rateLimit, "rate-limit", defaultRateLimit, "[optional] how many `requests` a minute each client can make, or 0 for no limit")
+	fs.IntVar(&rateBurst, "rate-burst", defaultRateBurst, "[optional] how many `requests` each client can make at once, within -rate-limit")
+	var digestEvery time.Duration
+	fs.DurationVar(&digestEvery, "digest-every", 0, "[optional] also send a digest of what's changed this often, e.g. 168h for weekly (default never)")
+
+	return fs, func(ctx context.Context, args []string) error {
+		switch accessLogFormat {
+		case accessLogCommon, accessLogJSON:
+		default:
+			return usageError("Unsupported access log format %q.  This must be \"common\" or \"json\"", accessLogFormat)
+		}
+		if containerMode && accessLogPath != "" && accessLogPath != "-" {
+			return usageError("-container doesn't write local files, so the access log can only go to stderr, with -access-log -")
+		}
+		if digestEvery < 0 {
+			return usageError("-digest-every can't be negative")
+		}
+		if refreshEvery < 0 {
+			return usageError("-refresh-every can't be negative")
+		}
+		if rateLimit < 0 {
+			return usageError("-rate-limit can't be negative")
+		}
+		if rateLimit > 0 && rateBurst < 1 {
+			return usageError("-rate-burst must be at least 1")
+		}
+		var limiter *rateLimiter
+		if rateLimit > 0 {
+			limiter = newRateLimiter(rateLimit, rateBurst)
+		}
+
+		opts.lazyConnect = true
+		source, config, closeSource, err := openSource(ctx, opts, false)
+		if err != nil {
+			return err
+		}
+		if digestEvery > 0 {
+			if err := applyLanguage(config); err != nil {
+				closeSource()
+				return err
+			}
+		}
+		// The access log is opened last, so that it doesn't have to be closed again if anything else fails
+		var accessLog *accessLogger
+		if accessLogPath != "" {
+			if accessLog, err = openAccessLog(accessLogPath, accessLogFormat); err != nil {
+				closeSource()
+				return outputError(err, "Unable to open access log")
+			}
+		}
+
+		// The config file is read again from scratch on reload, so that settings removed from it don't linger.  The new
+		// settings must work before they replace the old ones, in case the old credentials are still valid.
+		reopen := func() (Store, *Config, func(), error) {
+			viper.Reset()
+			reloadOpts := *opts
+			reloadOpts.lazyConnect = false
+			return openSource(ctx, &reloadOpts, false)
+		}
+		server := &versionServer{source: source, config: config, closeSource: closeSource, reopen: reopen, accessLog: accessLog,
+			rateLimit: limiter, digestEvery: digestEvery, refreshEvery: refreshEvery}
+		defer server.close()
+		if err := server.serve(ctx, listenAddr); err != nil {
+			return serverError(err, "HTTP server failed")
+		}
+		return nil
+	}
+}
+
+func snapshotCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("snapshot"))
+	opts := addSourceFlags(fs)
+	var outputFile string
+	fs.StringVar(&outputFile, "outfile", defaultSnapshotFile, "[optional] Specify an alternative snapshot filename")
+	opts.overrideSetting("outfile", func(config *Config) { config.Output.SnapshotFile = outputFile })
+	addOutputFileFlags(fs, opts)
+
+	return fs, func(ctx context.Context, args []string) error {
+		source, config, closeSource, err := openSource(ctx, opts, false)
+		if err != nil {
+			return err
+		}
+		defer closeSource()
+		outputFile = config.Output.SnapshotFile
+		if err := checkOutputFile(config); err != nil {
+			return err
+		}
+
+		summary, processErr := processSessions(ctx, source)
+		if processErr != nil {
+			return queryError(processErr, "Error processing database")
+		}
+
+		if containerMode {
+			if isFlagSet(fs, "outfile") {
+				return usageError("-container writes the snapshot to stdout, so -outfile can't be used")
+			}
+			// The snapshot goes to stdout as a single line, for the container's log collector to pick up
+			writer, _ := newOutputWriter("ndjson", os.Stdout, config)
+			if err := writer.Write(ctx, summary); err != nil {
+				return outputError(err, "Failed to write snapshot")
+			}
+			return nil
+		}
+		if err := writeSnapshot(outputFile, newSnapshot(summary)); err != nil {
+			return outputError(err, "Failed to write snapshot")
+		}
+		LogMessage(infoLevel, "Snapshot written to: "+outputFile)
+		if err := finishOutput(ctx, config, outputFile); err != nil {
+			return err
+		}
+		return nil
+	}
+}
+
+func diffCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("diff"))
+	addLanguageFlag(fs, nil)
+
+	return fs, func(ctx context.Context, args []string) error {
+		if len(args) != 2 {
+			fs.Usage()
+			return usageError("Two snapshot files are required")
+		}
+
+		before, err := readSnapshot(args[0])
+		if err != nil {
+			return inputError(err, "Failed to read snapshot")
+		}
+		after, err := readSnapshot(args[1])
+		if err != nil {
+			return inputError(err, "Failed to read snapshot")
+		}
+
+		printDiff(before, after)
+		return nil
+	}
+}
+
+func compareCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("compare"))
+	var configFile, profiles, filter string
+	fs.StringVar(&configFile, "config", "config.json", "path to config file")
+	fs.StringVar(&profiles, "profiles", "", "[optional] comma-separated `profiles` to compare (default all the profiles in the config file)")
+	fs.StringVar(&filter, "filter", "", "[optional] only include sessions matching this `expression`, e.g. 'os == \"Windows\"'")
+	var style reportStyle
+	var noColor bool
+	fs.StringVar(&style.minDesktopVersion, "min-desktop-version", "", "[optional] count desktop versions older than this one as outdated (default the newest on any server)")
+	fs.StringVar(&style.minMobileVersion, "min-mobile-version", "", "[optional] count mobile versions older than this one as outdated (default the newest on any server)")
+	fs.BoolVar(&noColor, "no-color", false, "[optional] don't colour the output, e.g. when writing to logs or in CI")
+	addLanguageFlag(fs, nil)
+
+	return fs, func(ctx context.Context, args []string) error {
+		var servers []serverSnapshot
+		if len(args) > 0 {
+			if profiles != "" {
+				return usageError("Compare either snapshot files or profiles, not both")
+			}
+			for _, filename := range args {
+				snapshot, err := readSnapshot(filename)
+				if err != nil {
+					return inputError(err, "Failed to read snapshot")
+				}
+				name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+				servers = append(servers, serverSnapshot{name: name, snapshot: snapshot})
+			}
+		} else {
+			names := splitList(profiles)
+			if len(names) == 0 {
+				v := viper.New()
+				v.SetConfigFile(configFile)
+				if err := v.ReadInConfig(); err != nil {
+					return configError(err, "Failed to process config file")
+				}
+				names = profileNames(v)
+			}
+			for _, name := range names {
+				// Each profile is merged over the top-level settings, so the config is read afresh for each one
+				viper.Reset()
+				opts := &sourceOptions{fs: fs, configFile: configFile, profile: name, filter: filter, db: &dbOverrides{}}
+				source, _, closeSource, err := openSource(ctx, opts, true)
+				if err != nil {
+					return err
+				}
+				summary, err := processSessions(ctx, source)
+				closeSource()
+				if err != nil {
+					return queryError(err, "Error processing database for profile %s", name)
+				}
+				snapshot := newSnapshot(summary)
+				servers = append(servers, serverSnapshot{name: name, snapshot: &snapshot})
+			}
+		}
+		if len(servers) < 2 {
+			fs.Usage()
+			return usageError("At least two servers are needed for a comparison, as profiles in the config file or snapshot files")
+		}
+
+		style.color = useColor(noColor)
+		printComparison(os.Stdout, servers, style)
+		return nil
+	}
+}
+
+func tenantsCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("tenants"))
+	var configFile, tenants, outputDir string
+	var noColor bool
+	fs.StringVar(&configFile, "config", "config.json", "path to config file")
+	fs.StringVar(&tenants, "tenants", "", "[optional] comma-separated `tenants` to report on (default all the tenants in the config file)")
+	fs.StringVar(&outputDir, "outdir", defaultTenantsDir, "[optional] `directory` for the report bundles, one directory per tenant")
+	fs.BoolVar(&noColor, "no-color", false, "[optional] don't colour the output, e.g. when writing to logs or in CI")
+	addLanguageFlag(fs, nil)
+
+	return fs, func(ctx context.Context, args []string) error {
+		if containerMode {
+			return usageError("-container reads its settings from %s_ environment variables only, so there are no tenants", envPrefix)
+		}
+		names := splitList(tenants)
+		if len(names) == 0 {
+			v := viper.New()
+			v.SetConfigFile(configFile)
+			if err := v.ReadInConfig(); err != nil {
+				return configError(err, "Failed to process config file")
+			}
+			names = tenantNames(v)
+		}
+		if len(names) == 0 {
+			return configError(nil, "%s doesn't contain any tenants", configFile)
+		}
+
+		var results []tenantResult
+		for _, name := range names {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Each tenant is merged over the top-level settings, so the config is read afresh for each one
+			viper.Reset()
+			result, err := reportTenant(ctx, fs, configFile, name, filepath.Join(outputDir, name))
+			if err != nil {
+				LogMessage(errorLevel, fmt.Sprintf("Tenant %s: %v", name, err))
+				result = tenantResult{name: name, err: err}
+			}
+			results = append(results, result)
+		}
+
+		printTenantOverview(os.Stdout, results, reportStyle{color: useColor(noColor)})
+		if err := os.MkdirAll(outputDir, 0o755); err != nil {
+			return outputError(err, "Failed to create %s", outputDir)
+		}
+		overviewFile := filepath.Join(outputDir, tenantOverviewFile)
+		if err := writeCSVFile(ctx, overviewFile, tenantOverviewRows(results), &Config{}); err != nil {
+			return err
+		}
+		LogMessage(infoLevel, fmt.Sprintf("Wrote the report bundles and %s", overviewFile))
+		return tenantsFailed(results)
+	}
+}
+
+// reportTenant tallies one tenant's clients and writes its report bundle to dir.
+func reportTenant(ctx context.Context, fs *flag.FlagSet, configFile, name, dir string) (tenantResult, error) {
+	opts := &sourceOptions{fs: fs, configFile: configFile, tenant: name, db: &dbOverrides{}}
+	source, config, closeSource, err := openSource(ctx, opts, true)
+	if err != nil {
+		return tenantResult{}, err
+	}
+	defer closeSource()
+	if err := checkThresholds(config); err != nil {
+		return tenantResult{}, err
+	}
+
+	summary, err := processSessions(ctx, source)
+	if err != nil {
+		return tenantResult{}, queryError(err, "Error processing database for tenant %s", name)
+	}
+	if err := writeTenantBundle(ctx, dir, summary, config); err != nil {
+		return tenantResult{}, outputError(err, "Failed to write the report bundle for tenant %s", name)
+	}
+	return newTenantResult(name, summary, config), nil
+}
+
+func schemaCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("schema"))
+
+	return fs, func(ctx context.Context, args []string) error {
+		switch len(args) {
+		case 0:
+			for _, name := range schemaNames() {
+				fmt.Println(name)
+			}
+			return nil
+		case 1:
+			schema, err := readSchema(args[0])
+			if err != nil {
+				return usageError("Invalid schema name: %v", err)
+			}
+			if _, err := os.Stdout.Write(schema); err != nil {
+				return outputError(err, "Failed to write the schema")
+			}
+			return nil
+		default:
+			fs.Usage()
+			return usageError("Only one schema can be printed at a time")
+		}
+	}
+}
+
+func tuiCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("tui"))
+	opts := addSourceFlags(fs)
+	var interval time.Duration
+	fs.DurationVar(&interval, "refresh", 30*time.Second, "[optional] how often to refresh the dashboard")
+
+	return fs, func(ctx context.Context, args []string) error {
+		source, _, closeSource, err := openSource(ctx, opts, false)
+		if err != nil {
+			return err
+		}
+		defer closeSource()
+
+		if err := runDashboard(ctx, source, interval); err != nil {
+			return queryError(err, "Dashboard failed")
+		}
+		return nil
+	}
+}
+
+func classifyTestCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("classify-test"))
+	var propsFile string
+	var deviceID string
+	fs.StringVar(&propsFile, "file", "", "[optional] read props JSON from this `file`, one per line, or - for stdin")
+	fs.StringVar(&deviceID, "device-id", "", "[optional] classify the sessions as if they had this device ID")
+	var oauth bool
+	fs.BoolVar(&oauth, "oauth", false, "[optional] classify the sessions as if they were created by an OAuth app")
+	var legacyPatterns []string
+	fs.Func("legacy-pattern", "[optional] recognise legacy desktop builds with this `regexp` instead of the defaults, whose first group is the version.  Can be repeated", func(pattern string) error {
+		legacyPatterns = append(legacyPatterns, pattern)
+		return nil
+	})
+
+	return fs, func(ctx context.Context, args []string) error {
+		props := args
+		if propsFile != "" {
+			fromFile, err := readPropsFile(propsFile)
+			if err != nil {
+				return inputError(err, "Failed to read props file")
+			}
+			props = append(props, fromFile...)
+		}
+		if len(props) == 0 {
+			fs.Usage()
+			return usageError("Props JSON is required, either as arguments or with -file")
+		}
+		if err := setLegacyDesktopPatterns(legacyPatterns); err != nil {
+			return usageError("Invalid -legacy-pattern: %v", err)
+		}
+
+		if invalid := printClassification(os.Stdout, props, mmversions.Session{DeviceID: deviceID, IsOAuth: oauth}); invalid > 0 {
+			return inputError(nil, "%d of %d props could not be parsed", invalid, len(props))
+		}
+		return nil
+	}
+}
+
+func completionCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("completion"))
+	var program string
+	fs.StringVar(&program, "name", appName, "[optional] name of the executable to complete, if it has been renamed")
+
+	return fs, func(ctx context.Context, args []string) error {
+		if len(args) != 1 {
+			fs.Usage()
+			return usageError("A shell name is required")
+		}
+
+		if err := writeCompletion(os.Stdout, args[0], program); err != nil {
+			return usageError("%v", err)
+		}
+		return nil
+	}
+}
+
+func initCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("init"))
+	var configFile string
+	fs.StringVar(&configFile, "config", "config.json", "path to the config file to create")
+
+	return fs, func(ctx context.Context, args []string) error {
+		if err := runWizard(ctx, configFile, os.Stdin, os.Stdout); err != nil {
+			return configError(err, "Unable to create config file")
+		}
+		return nil
+	}
+}
+
+func validateConfigCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("validate-config"))
+	var configFile string
+	var profile string
+	fs.StringVar(&configFile, "config", "config.json", "path to config file")
+	var tenant string
+	fs.StringVar(&profile, "profile", "", "[optional] validate the settings with the named profile applied")
+	fs.StringVar(&tenant, "tenant", "", "[optional] validate the settings with the named tenant applied")
+
+	return fs, func(ctx context.Context, args []string) error {
+		problems, err := validateConfig(configFile, profile, tenant)
+		if err != nil {
+			return configError(err, "")
+		}
+
+		errorCount := 0
+		for _, problem := range problems {
+			fmt.Println(problem)
+			if problem.Level == errorLevel {
+				errorCount++
+			}
+		}
+
+		if errorCount > 0 {
+			fmt.Println()
+			return configError(nil, "%s has %d error(s) and %d warning(s)", configFile, errorCount, len(problems)-errorCount)
+		}
+		fmt.Printf("%s is valid (%d warning(s))\n", configFile, len(problems))
+		return nil
+	}
+}
+
+func testConnectionCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("test-connection"))
+	var configFile string
+	var profile string
+	fs.StringVar(&configFile, "config", "config.json", "path to config file")
+	var tenant string
+	fs.StringVar(&profile, "profile", "", "[optional] test the named profile from the config file")
+	fs.StringVar(&tenant, "tenant", "", "[optional] test the named tenant's database from the config file")
+	overrides := addDBFlags(fs)
+
+	return fs, func(ctx context.Context, args []string) error {
+		config, err := loadConfig(configFile, profile, tenant)
+		if err != nil {
+			return configError(err, "Failed to process config file")
+		}
+		overrides.apply(config)
+
+		failed := false
+		for _, check := range checkConnection(ctx, config) {
+			if check.Err != nil {
+				fmt.Printf("[FAIL] %s: %v\n", check.Name, check.Err)
+				failed = true
+			} else {
+				fmt.Printf("[ OK ] %s\n", check.Name)
+			}
+		}
+
+		if failed {
+			return connectionError(nil, "The database connection test failed")
+		}
+		fmt.Println("\nThe database connection is working correctly")
+		return nil
+	}
+}
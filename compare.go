@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// serverSnapshot is the version tally of one server in a comparison, named after its profile or snapshot file.
+type serverSnapshot struct {
+	name     string
+	snapshot *Snapshot
+}
+
+// serverLag is how far one server's clients are behind.
+type serverLag struct {
+	name            string
+	desktop, mobile int
+	outdated        int
+	total           int
+}
+
+// share is the outdated clients as a percentage of all the server's clients.
+func (l serverLag) share() float64 {
+	return percentOf(l.outdated, l.total)
+}
+
+// newestVersion returns the newest valid version across all the servers, as the baseline when there's no minimum.
+func newestVersion(servers []serverSnapshot, entries func(*Snapshot) []VersionEntry) string {
+	newest := ""
+	for _, server := range servers {
+		for _, entry := range entries(server.snapshot) {
+			if _, _, _, err := mmversions.ParseVersion(entry.Version); err != nil {
+				continue
+			}
+			if newest == "" || mmversions.Less(newest, entry.Version) {
+				newest = entry.Version
+			}
+		}
+	}
+	return newest
+}
+
+// compareLag works out how many of each server's clients are older than the baselines.
+func compareLag(servers []serverSnapshot, desktopBase, mobileBase string) []serverLag {
+	lags := make([]serverLag, 0, len(servers))
+	for _, server := range servers {
+		desktop, _ := outdatedEntries(server.snapshot.Desktop, desktopBase)
+		mobile, _ := outdatedEntries(server.snapshot.Mobile, mobileBase)
+		lag := serverLag{name: server.name, desktop: sumEntries(desktop), mobile: sumEntries(mobile), total: server.snapshot.Total}
+		lag.outdated = lag.desktop + lag.mobile
+		lags = append(lags, lag)
+	}
+	return lags
+}
+
+// printComparison writes the desktop and mobile versions side by side, as a percentage of each server's clients so
+// that servers of different sizes can be compared, followed by the outdated clients on each server.  Outdated means
+// older than the minimum versions or, without them, the newest version on any of the servers.  The server that's
+// furthest behind is highlighted.
+func printComparison(w io.Writer, servers []serverSnapshot, style reportStyle) {
+	desktopBase := style.minDesktopVersion
+	if desktopBase == "" {
+		desktopBase = newestVersion(servers, func(s *Snapshot) []VersionEntry { return s.Desktop })
+	}
+	mobileBase := style.minMobileVersion
+	if mobileBase == "" {
+		mobileBase = newestVersion(servers, func(s *Snapshot) []VersionEntry { return s.Mobile })
+	}
+
+	fmt.Fprintln(w, tr(msgCompareDesktop))
+	printServerVersions(w, servers, func(s *Snapshot) ([]VersionEntry, int) { return s.Desktop, s.DesktopTotal })
+	fmt.Fprintln(w, "\n"+tr(msgCompareMobile))
+	printServerVersions(w, servers, func(s *Snapshot) ([]VersionEntry, int) { return s.Mobile, s.MobileTotal })
+
+	lags := compareLag(servers, desktopBase, mobileBase)
+	worst := -1
+	for i, lag := range lags {
+		if lag.total > 0 && (worst < 0 || lag.share() > lags[worst].share()) {
+			worst = i
+		}
+	}
+
+	fmt.Fprintln(w, "\n"+tr(msgCompareLag))
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", tr(msgColumnServer), tr(msgColumnDesktop), tr(msgColumnMobile), tr(msgColumnOutdated))
+	for _, lag := range lags {
+		fmt.Fprintf(tw, "  %s\t%d\t%d\t%.1f%%\n", lag.name, lag.desktop, lag.mobile, lag.share())
+	}
+	tw.Flush()
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	fmt.Fprintln(w, lines[0])
+	for i, line := range lines[1:] {
+		if i == worst && style.color {
+			line = colorRed + line + colorReset
+		}
+		fmt.Fprintln(w, line)
+	}
+
+	fmt.Fprintln(w, "\n"+trf(msgCompareBaseline, orDash(desktopBase), orDash(mobileBase)))
+	if worst >= 0 && lags[worst].outdated > 0 {
+		fmt.Fprintln(w, trf(msgCompareFurthest, lags[worst].name, lags[worst].share()))
+	}
+}
+
+// printServerVersions writes a table with a row for each version, across all the servers, and a column for each
+// server.
+func printServerVersions(w io.Writer, servers []serverSnapshot, category func(*Snapshot) ([]VersionEntry, int)) {
+	counts := make([]map[string]int, len(servers))
+	totals := make([]int, len(servers))
+	combined := make(VersionCount)
+	for i, server := range servers {
+		entries, total := category(server.snapshot)
+		counts[i] = make(map[string]int)
+		totals[i] = total
+		for _, entry := range entries {
+			counts[i][entry.Version] += entry.Count
+			combined[entry.Version] = nil
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "  "+tr(msgColumnVersion))
+	for _, server := range servers {
+		fmt.Fprint(tw, "\t"+server.name)
+	}
+	fmt.Fprintln(tw)
+	for _, version := range mmversions.SortedVersions(combined) {
+		fmt.Fprint(tw, "  "+version)
+		for i := range servers {
+			if counts[i][version] == 0 {
+				fmt.Fprint(tw, "\t-")
+			} else {
+				fmt.Fprintf(tw, "\t%.1f%%", percentOf(counts[i][version], totals[i]))
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	fmt.Fprint(tw, "  "+tr(msgCompareTotal))
+	for i := range servers {
+		fmt.Fprintf(tw, "\t%d", totals[i])
+	}
+	fmt.Fprintln(tw)
+	tw.Flush()
+}
+
+// orDash shows an empty value as a dash.
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// completionFlag describes a single flag for the purposes of shell completion.
+type completionFlag struct {
+	name       string
+	usage      string
+	takesValue bool
+}
+
+// completionSpec describes a command and its flags for the purposes of shell completion.
+type completionSpec struct {
+	name    string
+	summary string
+	flags   []completionFlag
+}
+
+// completionSpecs builds the completion definitions from the command table, so that completions never drift from
+// the real flags.
+func completionSpecs() []completionSpec {
+	var specs []completionSpec
+	for _, cmd := range commands {
+		fs, _ := cmd.newFlags()
+		spec := completionSpec{name: cmd.name, summary: cmd.summary}
+		fs.VisitAll(func(f *flag.Flag) {
+			boolFlag, isBool := f.Value.(interface{ IsBoolFlag() bool })
+			spec.flags = append(spec.flags, completionFlag{
+				name:       f.Name,
+				usage:      f.Usage,
+				takesValue: !isBool || !boolFlag.IsBoolFlag(),
+			})
+		})
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+func flagNames(flags []completionFlag, valuesOnly bool) []string {
+	var names []string
+	for _, f := range flags {
+		if !valuesOnly || f.takesValue {
+			names = append(names, "-"+f.name)
+		}
+	}
+	return names
+}
+
+// functionName turns the program name into something that can be used as a shell function name.
+func functionName(program string) string {
+	return "_" + strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, program)
+}
+
+func writeBashCompletion(w io.Writer, program string, specs []completionSpec) {
+	fn := functionName(program)
+	var names []string
+	for _, spec := range specs {
+		names = append(names, spec.name)
+	}
+
+	fmt.Fprintf(w, "# bash completion for %s\n", program)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintf(w, "    local cur prev opts valueopts\n")
+	fmt.Fprintf(w, "    cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	fmt.Fprintf(w, "    prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n\n")
+	fmt.Fprintf(w, "    if [[ ${COMP_CWORD} -eq 1 ]]; then\n")
+	fmt.Fprintf(w, "        COMPREPLY=( $(compgen -W \"%s help -help -version\" -- \"${cur}\") )\n", strings.Join(names, " "))
+	fmt.Fprintf(w, "        return\n")
+	fmt.Fprintf(w, "    fi\n\n")
+	fmt.Fprintf(w, "    case \"${COMP_WORDS[1]}\" in\n")
+	fmt.Fprintf(w, "        help)\n")
+	fmt.Fprintf(w, "            COMPREPLY=( $(compgen -W \"%s\" -- \"${cur}\") )\n", strings.Join(names, " "))
+	fmt.Fprintf(w, "            return\n")
+	fmt.Fprintf(w, "            ;;\n")
+	for _, spec := range specs {
+		fmt.Fprintf(w, "        %s)\n", spec.name)
+		fmt.Fprintf(w, "            opts=\"%s -help\"\n", strings.Join(flagNames(spec.flags, false), " "))
+		fmt.Fprintf(w, "            valueopts=\"%s\"\n", strings.Join(flagNames(spec.flags, true), " "))
+		fmt.Fprintf(w, "            ;;\n")
+	}
+	fmt.Fprintf(w, "        *)\n")
+	fmt.Fprintf(w, "            return\n")
+	fmt.Fprintf(w, "            ;;\n")
+	fmt.Fprintf(w, "    esac\n\n")
+	fmt.Fprintf(w, "    # Flags that take a value are most often given a filename\n")
+	fmt.Fprintf(w, "    if [[ \" ${valueopts} \" == *\" ${prev} \"* ]]; then\n")
+	fmt.Fprintf(w, "        COMPREPLY=( $(compgen -f -- \"${cur}\") )\n")
+	fmt.Fprintf(w, "        return\n")
+	fmt.Fprintf(w, "    fi\n\n")
+	fmt.Fprintf(w, "    COMPREPLY=( $(compgen -W \"${opts}\" -- \"${cur}\") )\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "complete -o default -F %s %s\n", fn, program)
+}
+
+// zshEscape escapes text for use inside a single-quoted zsh _arguments or _describe spec.
+func zshEscape(text string) string {
+	return strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`, `:`, `\:`).Replace(text)
+}
+
+func writeZshCompletion(w io.Writer, program string, specs []completionSpec) {
+	fn := functionName(program)
+
+	fmt.Fprintf(w, "#compdef %s\n\n", program)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintf(w, "    local -a commands\n")
+	fmt.Fprintf(w, "    commands=(\n")
+	for _, spec := range specs {
+		fmt.Fprintf(w, "        '%s:%s'\n", spec.name, zshEscape(spec.summary))
+	}
+	fmt.Fprintf(w, "        'help:show help for a command'\n")
+	fmt.Fprintf(w, "    )\n\n")
+	fmt.Fprintf(w, "    if (( CURRENT == 2 )); then\n")
+	fmt.Fprintf(w, "        _describe 'command' commands\n")
+	fmt.Fprintf(w, "        return\n")
+	fmt.Fprintf(w, "    fi\n\n")
+	fmt.Fprintf(w, "    local cmd=\"${words[2]}\"\n")
+	fmt.Fprintf(w, "    shift words\n")
+	fmt.Fprintf(w, "    (( CURRENT-- ))\n\n")
+	fmt.Fprintf(w, "    case \"${cmd}\" in\n")
+	fmt.Fprintf(w, "        help)\n")
+	fmt.Fprintf(w, "            _describe 'command' commands\n")
+	fmt.Fprintf(w, "            ;;\n")
+	for _, spec := range specs {
+		fmt.Fprintf(w, "        %s)\n", spec.name)
+		fmt.Fprintf(w, "            _arguments \\\n")
+		for _, f := range spec.flags {
+			if f.takesValue {
+				fmt.Fprintf(w, "                '-%s[%s]:value:_files' \\\n", f.name, zshEscape(f.usage))
+			} else {
+				fmt.Fprintf(w, "                '-%s[%s]' \\\n", f.name, zshEscape(f.usage))
+			}
+		}
+		fmt.Fprintf(w, "                '-help[show help for this command]' \\\n")
+		fmt.Fprintf(w, "                '*:file:_files'\n")
+		fmt.Fprintf(w, "            ;;\n")
+	}
+	fmt.Fprintf(w, "    esac\n")
+	fmt.Fprintf(w, "}\n\n")
+	fmt.Fprintf(w, "compdef %s %s\n", fn, program)
+}
+
+func writeFishCompletion(w io.Writer, program string, specs []completionSpec) {
+	quote := func(text string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(text) + "'"
+	}
+
+	fmt.Fprintf(w, "# fish completion for %s\n", program)
+	fmt.Fprintf(w, "complete -c %s -f\n", program)
+	for _, spec := range specs {
+		fmt.Fprintf(w, "complete -c %s -n '__fish_use_subcommand' -a %s -d %s\n", program, spec.name, quote(spec.summary))
+	}
+	fmt.Fprintf(w, "complete -c %s -n '__fish_use_subcommand' -a help -d 'show help for a command'\n", program)
+	for _, spec := range specs {
+		condition := quote("__fish_seen_subcommand_from " + spec.name)
+		for _, f := range spec.flags {
+			requiresValue := ""
+			if f.takesValue {
+				requiresValue = " -r -F"
+			}
+			fmt.Fprintf(w, "complete -c %s -n %s -o %s -d %s%s\n", program, condition, f.name, quote(f.usage), requiresValue)
+		}
+	}
+}
+
+func writePowerShellCompletion(w io.Writer, program string, specs []completionSpec) {
+	fmt.Fprintf(w, "# PowerShell completion for %s\n", program)
+	fmt.Fprintf(w, "Register-ArgumentCompleter -Native -CommandName '%s' -ScriptBlock {\n", program)
+	fmt.Fprintf(w, "    param($wordToComplete, $commandAst, $cursorPosition)\n\n")
+	fmt.Fprintf(w, "    $commands = @{\n")
+	for _, spec := range specs {
+		var quoted []string
+		for _, name := range append(flagNames(spec.flags, false), "-help") {
+			quoted = append(quoted, "'"+name+"'")
+		}
+		sort.Strings(quoted)
+		fmt.Fprintf(w, "        '%s' = @(%s)\n", spec.name, strings.Join(quoted, ", "))
+	}
+	fmt.Fprintf(w, "    }\n\n")
+	fmt.Fprintf(w, "    $elements = $commandAst.CommandElements\n")
+	fmt.Fprintf(w, "    if ($elements.Count -lt 2 -or ($elements.Count -eq 2 -and $wordToComplete -ne '')) {\n")
+	fmt.Fprintf(w, "        $candidates = @($commands.Keys) + 'help'\n")
+	fmt.Fprintf(w, "    } elseif ($elements[1].ToString() -eq 'help') {\n")
+	fmt.Fprintf(w, "        $candidates = $commands.Keys\n")
+	fmt.Fprintf(w, "    } else {\n")
+	fmt.Fprintf(w, "        $candidates = $commands[$elements[1].ToString()]\n")
+	fmt.Fprintf(w, "    }\n\n")
+	fmt.Fprintf(w, "    $candidates | Where-Object { $_ -like \"$wordToComplete*\" } | Sort-Object | ForEach-Object {\n")
+	fmt.Fprintf(w, "        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)\n")
+	fmt.Fprintf(w, "    }\n")
+	fmt.Fprintf(w, "}\n")
+}
+
+// writeCompletion writes the completion script for the given shell.
+func writeCompletion(w io.Writer, shell string, program string) error {
+	specs := completionSpecs()
+	switch shell {
+	case "bash":
+		writeBashCompletion(w, program, specs)
+	case "zsh":
+		writeZshCompletion(w, program, specs)
+	case "fish":
+		writeFishCompletion(w, program, specs)
+	case "powershell":
+		writePowerShellCompletion(w, program, specs)
+	default:
+		return fmt.Errorf("unsupported shell: %s", shell)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// Each platform with a compliance threshold has a bit in the exit code when it's breached, so that monitoring
+// pipelines can tell which ones need attention from the exit code alone.
+const (
+	desktopBreach = 1 << iota
+	mobileBreach
+	webBreach
+)
+
+// complianceResult is how the clients on one platform measure up against its threshold.
+type complianceResult struct {
+	// platform is desktop, mobile or web, for the logs, and key is its translated name, for the table
+	platform string
+	key      string
+	bit      int
+	// minimum describes the versions that count as compliant, e.g. 5.9.0, or Chrome 120 for browsers
+	minimum   string
+	compliant int
+	total     int
+	threshold float64
+}
+
+// percent is the share of the platform's clients that are compliant.  A platform without any clients can't be out of
+// compliance.
+func (r complianceResult) percent() float64 {
+	if r.total == 0 {
+		return 100
+	}
+	return percentOf(r.compliant, r.total)
+}
+
+func (r complianceResult) breached() bool {
+	return r.percent() < r.threshold
+}
+
+// checkCompliance measures each platform that has a threshold set against its minimum version.  Desktop and mobile
+// clients are compliant at or above the minimum version.  Browsers are compliant at or above the minimum major version
+// for that browser, and browsers without a minimum aren't counted.
+func checkCompliance(summary *mmversions.Summary, config *Config) []complianceResult {
+	settings := config.Report
+	var results []complianceResult
+	for _, platform := range []struct {
+		name      string
+		key       string
+		bit       int
+		threshold float64
+		minimum   string
+		entries   []VersionEntry
+	}{
+		{"desktop", msgPlatformDesktop, desktopBreach, settings.DesktopThreshold, settings.MinDesktopVersion, mmversions.Entries(summary.Desktop)},
+		{"mobile", msgPlatformMobile, mobileBreach, settings.MobileThreshold, settings.MinMobileVersion, mmversions.Entries(summary.Mobile)},
+	} {
+		if platform.threshold <= 0 {
+			continue
+		}
+		outdated, _ := outdatedEntries(platform.entries, platform.minimum)
+		total := sumEntries(platform.entries)
+		results = append(results, complianceResult{platform: platform.name, key: platform.key, bit: platform.bit, minimum: platform.minimum,
+			compliant: total - sumEntries(outdated), total: total, threshold: platform.threshold})
+	}
+
+	if settings.WebThreshold > 0 {
+		result := complianceResult{platform: "web", key: msgPlatformWeb, bit: webBreach, minimum: browserMinimums(settings.MinBrowserVersions), threshold: settings.WebThreshold}
+		for _, entry := range mmversions.Entries(summary.Web) {
+			browser, major, ok := splitBrowserVersion(entry.Version)
+			minimum, assessed := settings.MinBrowserVersions[browser]
+			if !assessed {
+				continue
+			}
+			result.total += entry.Count
+			if ok && major >= minimum {
+				result.compliant += entry.Count
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// splitBrowserVersion splits a web client's key, e.g. "Chrome 120", into the browser and its major version.
+func splitBrowserVersion(key string) (string, int, bool) {
+	i := strings.LastIndex(key, " ")
+	if i < 0 {
+		return key, 0, false
+	}
+	major, err := strconv.Atoi(key[i+1:])
+	return key[:i], major, err == nil
+}
+
+// browserMinimums lists the minimum browser versions, e.g. "Chrome 120, Firefox 115", in alphabetical order.
+func browserMinimums(minimums map[string]int) string {
+	browsers := make([]string, 0, len(minimums))
+	for browser := range minimums {
+		browsers = append(browsers, browser)
+	}
+	sort.Strings(browsers)
+	for i, browser := range browsers {
+		browsers[i] = fmt.Sprintf("%s %d", browser, minimums[browser])
+	}
+	return strings.Join(browsers, ", ")
+}
+
+// complianceFailed returns an error for any platforms below their threshold, with the breached platforms in the exit
+// code, or nil if they're all compliant.
+func complianceFailed(results []complianceResult) error {
+	breaches := 0
+	var details []string
+	for _, result := range results {
+		if result.breached() {
+			breaches |= result.bit
+			details = append(details, fmt.Sprintf("%s %.1f%% < %g%%", result.platform, result.percent(), result.threshold))
+		}
+	}
+	if breaches == 0 {
+		return nil
+	}
+	return complianceError(breaches, "Compliance thresholds breached: %s", strings.Join(details, ", "))
+}
+
+// printCompliance writes a table of each platform's compliance against its threshold.
+func printCompliance(w io.Writer, results []complianceResult, style reportStyle) {
+	fmt.Fprintln(w, "\n"+tr(msgComplianceFound))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", tr(msgColumnPlatform), tr(msgColumnMinimum), tr(msgColumnCompliant), tr(msgColumnThreshold), tr(msgColumnStatus))
+	for _, result := range results {
+		status := tr(msgComplianceOK)
+		if result.breached() {
+			status = tr(msgComplianceBreach)
+			if style.color {
+				status = colorRed + status + colorReset
+			}
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%.1f%% (%d/%d)\t%g%%\t%s\n", tr(result.key), orDash(result.minimum), result.percent(), result.compliant, result.total, result.threshold, status)
+	}
+	tw.Flush()
+}
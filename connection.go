@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// requiredTable is a table the utility reads, along with the columns it needs.
+type requiredTable struct {
+	name    string
+	columns []string
+}
+
+var requiredTables = []requiredTable{
+	{name: "Sessions", columns: sessionFields},
+	{name: "Users", columns: userFields},
+}
+
+// connectionCheck is the result of a single step of the connection test.
+type connectionCheck struct {
+	Name string
+	Err  error
+}
+
+// checkConnection works through each step needed for a successful run, stopping at the first one that fails, since
+// the later steps can't succeed without it.
+func checkConnection(ctx context.Context, config *Config) []connectionCheck {
+	var checks []connectionCheck
+
+	db, err := openDatabase(config)
+	checks = append(checks, connectionCheck{Name: "Open " + config.DB.Type + " connection", Err: err})
+	if err != nil {
+		return checks
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, connectionTestTimeout)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	checks = append(checks, connectionCheck{Name: fmt.Sprintf("Connect to %s:%d as %s", config.DB.Host, config.DB.Port, config.DB.User), Err: err})
+	if err != nil {
+		return checks
+	}
+
+	for _, table := range requiredTables {
+		if table.name == "Users" && config.Privacy.AggregateOnly {
+			continue
+		}
+		checks = append(checks, connectionCheck{
+			Name: "Read " + strings.Join(table.columns, ", ") + " from " + table.name,
+			Err:  checkTable(ctx, db, config, table),
+		})
+	}
+
+	return checks
+}
+
+// checkTable confirms that we can select the required columns from a table.  If we can't, the database's own
+// catalogue is used to work out whether the table or columns are missing, or whether it's a permissions problem.
+func checkTable(ctx context.Context, db *sql.DB, config *Config, table requiredTable) error {
+	d, err := dialectFor(config.DB.Type)
+	if err != nil {
+		return err
+	}
+	name := d.identifier(table.name)
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE 1 = 0", strings.Join(table.columns, ", "), name)
+	rows, selectErr := db.QueryContext(ctx, query)
+	if selectErr == nil {
+		rows.Close()
+		return nil
+	}
+
+	rows, err = db.QueryContext(ctx, d.columnsQuery(), name)
+	if err != nil {
+		return selectErr
+	}
+	defer rows.Close()
+
+	found := make(map[string]bool)
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return selectErr
+		}
+		found[strings.ToLower(column)] = true
+	}
+
+	if len(found) == 0 {
+		return fmt.Errorf("table %s not found - is %q the Mattermost database? (%v)", name, config.DB.Name, selectErr)
+	}
+
+	var missing []string
+	for _, column := range table.columns {
+		if !found[strings.ToLower(column)] {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("table %s is missing column(s) %s - is this a supported Mattermost version?", name, strings.Join(missing, ", "))
+	}
+
+	return fmt.Errorf("the table and columns exist, so the database user probably lacks SELECT permission on %s (%v)", name, selectErr)
+}
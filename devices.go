@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// sharedDevice is a device ID that's been signed in to by more than one user.
+type sharedDevice struct {
+	deviceID string
+	userIDs  []string
+}
+
+// findSharedDevices returns the device IDs with active sessions for more than one user, which are either mobile
+// devices shared between people or cloned MDM enrolments.  Either way, one user's push notifications can reach
+// another.  The devices shared by the most users come first.
+func findSharedDevices(sessions []SessionRecord) []sharedDevice {
+	deviceUsers := make(map[string]map[string]bool)
+	for _, session := range sessions {
+		if session.DeviceID == "" || session.UserID == "" {
+			continue
+		}
+		if deviceUsers[session.DeviceID] == nil {
+			deviceUsers[session.DeviceID] = make(map[string]bool)
+		}
+		deviceUsers[session.DeviceID][session.UserID] = true
+	}
+
+	var shared []sharedDevice
+	for deviceID, users := range deviceUsers {
+		if len(users) < 2 {
+			continue
+		}
+		device := sharedDevice{deviceID: deviceID}
+		for userID := range users {
+			device.userIDs = append(device.userIDs, userID)
+		}
+		sort.Strings(device.userIDs)
+		shared = append(shared, device)
+	}
+	sort.Slice(shared, func(i, j int) bool {
+		if len(shared[i].userIDs) != len(shared[j].userIDs) {
+			return len(shared[i].userIDs) > len(shared[j].userIDs)
+		}
+		return shared[i].deviceID < shared[j].deviceID
+	})
+	return shared
+}
+
+// printSharedDevices writes the shared device IDs, with the usernames of the users signed in on each.  Users who
+// can't be found are shown by their user ID.
+func printSharedDevices(ctx context.Context, w io.Writer, source Store, shared []sharedDevice) error {
+	if len(shared) == 0 {
+		fmt.Fprintln(w, "\n"+tr(msgNoSharedDevices))
+		return nil
+	}
+
+	fmt.Fprintln(w, "\n"+tr(msgSharedDevicesFound))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\n", tr(msgColumnDeviceID), tr(msgColumnUsers), tr(msgColumnUsernames))
+	for _, device := range shared {
+		names := make([]string, 0, len(device.userIDs))
+		for _, userID := range device.userIDs {
+			users, err := source.User(ctx, userID)
+			if err != nil {
+				return err
+			}
+			name := userID
+			if len(users) > 0 && users[0].Username != "" {
+				name = users[0].Username
+			}
+			names = append(names, name)
+		}
+		fmt.Fprintf(tw, "  %s\t%d\t%s\n", device.deviceID, len(device.userIDs), strings.Join(names, ", "))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// diagnosticRows lists the sessions that couldn't be counted normally, one per row, with their raw props, so that
+// data quality problems can be reported to Mattermost support.
+func diagnosticRows(problems []mmversions.Problem) [][]string {
+	rows := [][]string{{"Session ID", "User ID", "Device ID", "Problem", "Client", "Created", "Last Activity", "Props"}}
+	for _, problem := range problems {
+		description := fmt.Sprintf("invalid props JSON: %v", problem.Err)
+		if errors.Is(problem.Err, mmversions.ErrPlaceholderVersion) {
+			description = "placeholder version " + mmversions.PlaceholderVersion
+		}
+		session := problem.Session
+		rows = append(rows, []string{session.ID, session.UserID, session.DeviceID, description, string(problem.Client.Kind),
+			formatMillis(session.CreateAt), formatMillis(session.LastActivityAt), session.Props})
+	}
+	return rows
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dialect holds everything that differs between the supported databases, so that each query is only written once.
+type dialect interface {
+	// driver and dsn are what sql.Open needs to connect
+	driver() string
+	dsn(config *Config) string
+	// identifier returns a table or column name as it appears in this database
+	identifier(name string) string
+	// placeholder returns the bind parameter for the n'th argument, counting from 1
+	placeholder(n int) string
+	// hasProps is the condition for a non-empty JSON props column
+	hasProps(column string) string
+	// columnsQuery lists the columns of the table given as its only argument
+	columnsQuery() string
+}
+
+// postgresDialect is PostgreSQL, which folds unquoted names to lower case.
+type postgresDialect struct{}
+
+func (postgresDialect) driver() string { return "postgres" }
+
+func (postgresDialect) dsn(config *Config) string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		config.DB.Host, config.DB.Port, config.DB.User, config.DB.Password, config.DB.Name)
+}
+
+func (postgresDialect) identifier(name string) string { return strings.ToLower(name) }
+
+func (postgresDialect) placeholder(n int) string { return fmt.Sprintf("$%d", n) }
+
+func (postgresDialect) hasProps(column string) string { return column + " != '{}'" }
+
+func (postgresDialect) columnsQuery() string {
+	return "SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1"
+}
+
+// mysqlDialect is MySQL, which keeps the case used by Mattermost.
+type mysqlDialect struct{}
+
+func (mysqlDialect) driver() string { return "mysql" }
+
+func (mysqlDialect) dsn(config *Config) string {
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
+		config.DB.User, config.DB.Password, config.DB.Host, config.DB.Port, config.DB.Name)
+}
+
+func (mysqlDialect) identifier(name string) string { return name }
+
+func (mysqlDialect) placeholder(n int) string { return "?" }
+
+func (mysqlDialect) hasProps(column string) string { return "JSON_LENGTH(" + column + ") > 0" }
+
+func (mysqlDialect) columnsQuery() string {
+	return "SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?"
+}
+
+// dialects are the supported databases, keyed by the db.type setting.
+var dialects = map[string]dialect{
+	"postgresql": postgresDialect{},
+	"mysql":      mysqlDialect{},
+}
+
+// dialectFor returns the dialect for a db.type setting.
+func dialectFor(dbType string) (dialect, error) {
+	d, ok := dialects[dbType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported DB type: %s", dbType)
+	}
+	return d, nil
+}
+
+// identifiers applies the dialect's naming to a list of table or column names.
+func identifiers(d dialect, names ...string) []string {
+	result := make([]string, len(names))
+	for i, name := range names {
+		result[i] = d.identifier(name)
+	}
+	return result
+}
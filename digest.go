@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// defaultDigestFile keeps what the last digest saw, for the next one to compare with.
+const defaultDigestFile = "digest-state.json"
+
+// digestListLimit is the most users listed by name in each section of the digest.
+const digestListLimit = 20
+
+// digestState is what a digest saw, saved so that the next digest can work out what's changed since.
+type digestState struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Snapshot    Snapshot  `json:"snapshot"`
+	// OutdatedUsers maps the ID of each user with an outdated client to the oldest on each platform, e.g.
+	// "desktop 5.4.0, mobile 2.12.0".
+	// It's left out in aggregate-only mode, since it identifies individual users.
+	OutdatedUsers map[string]string `json:"outdated_users,omitempty"`
+}
+
+// newDigestState records the version counts and the users with outdated clients.  Outdated means older than the
+// minimum version for the platform or, without one, the newest version in use, as in the executive summary.
+func newDigestState(summary *mmversions.Summary, sessions []SessionRecord, config *Config, now time.Time) digestState {
+	state := digestState{GeneratedAt: now, Snapshot: newSnapshot(summary)}
+	state.Snapshot.GeneratedAt = now
+	if config.Privacy.AggregateOnly {
+		return state
+	}
+
+	_, desktopBase := outdatedEntries(state.Snapshot.Desktop, config.Report.MinDesktopVersion)
+	_, mobileBase := outdatedEntries(state.Snapshot.Mobile, config.Report.MinMobileVersion)
+	oldest := make(map[string]map[mmversions.ClientKind]string)
+	for _, session := range sessions {
+		client, ok := countedAppClient(session)
+		if !ok {
+			continue
+		}
+		base := desktopBase
+		if client.Kind == mmversions.Mobile {
+			base = mobileBase
+		}
+		if base == "" || !mmversions.Less(client.Version, base) {
+			continue
+		}
+		if oldest[session.UserID] == nil {
+			oldest[session.UserID] = make(map[mmversions.ClientKind]string)
+		}
+		if previous, seen := oldest[session.UserID][client.Kind]; !seen || mmversions.Less(client.Version, previous) {
+			oldest[session.UserID][client.Kind] = client.Version
+		}
+	}
+	state.OutdatedUsers = make(map[string]string, len(oldest))
+	for userID, versions := range oldest {
+		var clients []string
+		for _, kind := range []mmversions.ClientKind{mmversions.Desktop, mmversions.Mobile} {
+			if version, ok := versions[kind]; ok {
+				clients = append(clients, string(kind)+" "+version)
+			}
+		}
+		state.OutdatedUsers[userID] = strings.Join(clients, ", ")
+	}
+	return state
+}
+
+// readDigestState loads the state saved by the last digest, or returns nil if there hasn't been one.
+func readDigestState(filename string) (*digestState, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state digestState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("%s is not a valid digest state file: %w", filename, err)
+	}
+	return &state, nil
+}
+
+func writeDigestState(filename string, state digestState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0600)
+}
+
+// digestChanges is what's changed between two digests.
+type digestChanges struct {
+	since, until  *digestState
+	newlyOutdated []string
+	resolved      []string
+	desktop       []versionChange
+	mobile        []versionChange
+}
+
+// compareDigests works out which users have become outdated or are no longer outdated, either because they've
+// upgraded or because their outdated sessions have ended, and which version counts have changed.
+func compareDigests(before, after *digestState) digestChanges {
+	changes := digestChanges{
+		since:   before,
+		until:   after,
+		desktop: diffEntries(before.Snapshot.Desktop, after.Snapshot.Desktop),
+		mobile:  diffEntries(before.Snapshot.Mobile, after.Snapshot.Mobile),
+	}
+	for userID := range after.OutdatedUsers {
+		if _, ok := before.OutdatedUsers[userID]; !ok {
+			changes.newlyOutdated = append(changes.newlyOutdated, userID)
+		}
+	}
+	for userID := range before.OutdatedUsers {
+		if _, ok := after.OutdatedUsers[userID]; !ok {
+			changes.resolved = append(changes.resolved, userID)
+		}
+	}
+	sort.Strings(changes.newlyOutdated)
+	sort.Strings(changes.resolved)
+	return changes
+}
+
+// digestMessage renders the changes as Markdown, naming up to digestListLimit users in each list.  Users are named by
+// their username where they can be found, and their ID otherwise.
+func digestMessage(ctx context.Context, source Store, changes digestChanges, aggregateOnly bool) string {
+	before, after := changes.since.Snapshot, changes.until.Snapshot
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**%s:** %d (%+d)\n", tr(msgCardDesktopClients), after.DesktopTotal, after.DesktopTotal-before.DesktopTotal)
+	fmt.Fprintf(&sb, "**%s:** %d (%+d)\n", tr(msgCardMobileClients), after.MobileTotal, after.MobileTotal-before.MobileTotal)
+	if !aggregateOnly {
+		outdatedBefore, outdatedAfter := len(changes.since.OutdatedUsers), len(changes.until.OutdatedUsers)
+		fmt.Fprintf(&sb, "**%s:** %d (%+d)\n", tr(msgDigestOutdated), outdatedAfter, outdatedAfter-outdatedBefore)
+
+		userList := func(heading string, userIDs []string, versions map[string]string) {
+			if len(userIDs) == 0 {
+				return
+			}
+			sb.WriteString("\n**" + trf(heading, len(userIDs)) + "**\n")
+			var lines []string
+			for i, userID := range userIDs {
+				if i == digestListLimit {
+					lines = append(lines, trf(msgDigestMore, len(userIDs)-digestListLimit))
+					break
+				}
+				lines = append(lines, fmt.Sprintf("%s (%s)", digestUsername(ctx, source, userID), versions[userID]))
+			}
+			sb.WriteString(bulletList(lines))
+		}
+		userList(msgDigestNewOutdated, changes.newlyOutdated, changes.until.OutdatedUsers)
+		userList(msgDigestResolved, changes.resolved, changes.since.OutdatedUsers)
+	}
+
+	if len(changes.desktop) == 0 && len(changes.mobile) == 0 {
+		sb.WriteString("\n" + tr(msgDigestNoShifts) + "\n")
+		return sb.String()
+	}
+	for _, platform := range []struct {
+		heading string
+		changes []versionChange
+	}{
+		{msgCardDesktopHeading, changes.desktop},
+		{msgCardMobileHeading, changes.mobile},
+	} {
+		if len(platform.changes) == 0 {
+			continue
+		}
+		sb.WriteString("\n**" + tr(platform.heading) + "**\n")
+		var lines []string
+		for _, change := range platform.changes {
+			lines = append(lines, fmt.Sprintf("%s (%s): %d -> %d (%+d)", change.Version, change.OS, change.Before, change.After, change.After-change.Before))
+		}
+		sb.WriteString(bulletList(lines))
+	}
+	return sb.String()
+}
+
+// digestUsername looks up a user's username for the digest, falling back to their ID.
+func digestUsername(ctx context.Context, source Store, userID string) string {
+	users, err := source.User(ctx, userID)
+	if err != nil || len(users) == 0 || users[0].Username == "" {
+		return userID
+	}
+	return users[0].Username
+}
+
+// deliverDigest sends the digest to the configured webhook, and to the admins through the bot, if there's a bot.  It
+// only fails if none of them could be sent the digest, so that one broken integration doesn't hold up the others.
+func deliverDigest(ctx context.Context, config *Config, title, message string) error {
+	var deliveries []func() error
+	if config.Webhook.URL != "" {
+		deliveries = append(deliveries, func() error {
+			return postWebhookMessage(ctx, config.Webhook.URL, config.Webhook.Format, title, message)
+		})
+	}
+	if config.Bot.URL != "" && config.Bot.Token != "" {
+		deliveries = append(deliveries, func() error {
+			return notifyAdmins(ctx, config, "#### "+title+"\n"+message)
+		})
+	}
+	if len(deliveries) == 0 {
+		return errors.New("there's nowhere to send the digest.  Set webhook.url, or bot.url and bot.token, in the config file")
+	}
+
+	var errs []error
+	for _, deliver := range deliveries {
+		if err := deliver(); err != nil {
+			LogMessage(warningLevel, "Unable to send the digest: "+err.Error())
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == len(deliveries) {
+		return errors.Join(errs...)
+	}
+	LogMessage(infoLevel, "Digest sent")
+	return nil
+}
+
+// runDigest tallies the sessions, compares them with the state saved by the last digest, sends the changes, and saves
+// the new state.  The first run has nothing to compare with, so it only saves the state.  If the digest can't be sent,
+// the state is left alone, so that the next digest still covers the changes.  With dryRun, the digest is returned
+// rather than sent, and the state isn't saved.
+func runDigest(ctx context.Context, source Store, config *Config, now time.Time, dryRun bool) (string, error) {
+	sessions, err := source.Sessions(ctx)
+	if err != nil {
+		return "", queryError(err, "Error processing database")
+	}
+	after := newDigestState(tallySessions(sessions), sessions, config, now)
+
+	stateFile := config.Output.DigestFile
+	before, err := readDigestState(stateFile)
+	if err != nil {
+		return "", inputError(err, "Failed to read the previous digest")
+	}
+	if before == nil {
+		LogMessage(infoLevel, fmt.Sprintf("There's no previous digest in %s to compare with", stateFile))
+		if dryRun {
+			return "", nil
+		}
+		if err := writeDigestState(stateFile, after); err != nil {
+			return "", outputError(err, "Failed to save the digest state")
+		}
+		LogMessage(infoLevel, "Saved the current state, for the next digest to compare with")
+		return "", nil
+	}
+
+	title := trf(msgDigestTitle, formatTimestamp(before.GeneratedAt))
+	message := digestMessage(ctx, source, compareDigests(before, &after), config.Privacy.AggregateOnly)
+	message += fmt.Sprintf("\n%s %s\n", tr(msgCardGenerated), formatTimestamp(now))
+	if dryRun {
+		return "#### " + title + "\n" + message, nil
+	}
+	if err := deliverDigest(ctx, config, title, message); err != nil {
+		return "", webhookError(err, "Failed to send the digest")
+	}
+	if err := writeDigestState(stateFile, after); err != nil {
+		return "", outputError(err, "Failed to save the digest state")
+	}
+	return "", nil
+}
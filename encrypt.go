@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// outputEncryption encrypts output files to the age recipients or GPG public keys given in the config file, since
+// the lookup CSV contains personal data and is often copied between machines.
+type outputEncryption struct {
+	ageRecipients []age.Recipient
+	gpgKeys       openpgp.EntityList
+}
+
+// loadEncryption reads the encryption keys from the config.  It returns nil if output files aren't to be encrypted.
+func loadEncryption(config *Config) (*outputEncryption, error) {
+	settings := config.Encryption
+	if len(settings.AgeRecipients) == 0 && len(settings.GPGKeys) == 0 {
+		return nil, nil
+	}
+	if len(settings.AgeRecipients) > 0 && len(settings.GPGKeys) > 0 {
+		return nil, fmt.Errorf("set either age_recipients or gpg_keys, not both")
+	}
+
+	encryption := &outputEncryption{}
+	if len(settings.AgeRecipients) > 0 {
+		recipients, err := age.ParseRecipients(strings.NewReader(strings.Join(settings.AgeRecipients, "\n")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient: %v", err)
+		}
+		encryption.ageRecipients = recipients
+	}
+	for _, filename := range settings.GPGKeys {
+		keys, err := readGPGKeys(filename)
+		if err != nil {
+			return nil, err
+		}
+		encryption.gpgKeys = append(encryption.gpgKeys, keys...)
+	}
+
+	return encryption, nil
+}
+
+// readGPGKeys reads a GPG public key file, which can be either ASCII armored or binary.
+func readGPGKeys(filename string) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read GPG key: %v", err)
+	}
+
+	keys, err := openpgp.ReadArmoredKeyRing(strings.NewReader(string(data)))
+	if err != nil {
+		keys, err = openpgp.ReadKeyRing(strings.NewReader(string(data)))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse GPG key %s: %v", filename, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", filename)
+	}
+	return keys, nil
+}
+
+// extension is added to the names of encrypted files, so it's obvious how to decrypt them.
+func (e *outputEncryption) extension() string {
+	if len(e.ageRecipients) > 0 {
+		return ".age"
+	}
+	return ".gpg"
+}
+
+// encryptedFile is an output file with encryption on top.  Closing it finishes the encryption before closing the
+// file, and must be checked, since the file is incomplete until then.  Closing it again does nothing, so that it can
+// also be closed with defer.
+type encryptedFile struct {
+	io.WriteCloser
+	file   io.Closer
+	closed bool
+}
+
+func (f *encryptedFile) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	err := f.WriteCloser.Close()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// nopCloser leaves stdout open when the output written to it is closed.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// createOutputFile creates an output file, encrypting it if encryption is set.  A filename of "-" writes to stdout
+// instead.
+func createOutputFile(filename string, encryption *outputEncryption) (io.WriteCloser, error) {
+	var file io.WriteCloser = nopCloser{os.Stdout}
+	var err error
+	if filename != "-" {
+		if file, err = os.Create(filename); err != nil {
+			return nil, err
+		}
+	}
+	if encryption == nil {
+		return file, nil
+	}
+
+	var writer io.WriteCloser
+	if len(encryption.ageRecipients) > 0 {
+		writer, err = age.Encrypt(file, encryption.ageRecipients...)
+	} else {
+		writer, err = openpgp.Encrypt(file, encryption.gpgKeys, nil, &openpgp.FileHints{FileName: strings.TrimSuffix(filepath.Base(filename), ".gpg")}, nil)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &encryptedFile{WriteCloser: writer, file: file}, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// errorClass is the kind of failure that stopped a command, which decides the exit code.  Wrapper scripts rely on
+// the exit codes, so they must not change once released.
+type errorClass int
+
+const (
+	usageFailure errorClass = iota
+	configFailure
+	connectionFailure
+	queryFailure
+	webhookFailure
+	inputFailure
+	serverFailure
+	outputFailure
+	interruptedFailure
+	complianceFailure
+	lookupFailure
+)
+
+// exitCodes maps each class of failure to its documented exit code.
+var exitCodes = map[errorClass]int{
+	usageFailure:      1,
+	configFailure:     2,
+	connectionFailure: 3,
+	queryFailure:      4,
+	webhookFailure:    5,
+	inputFailure:      6,
+	serverFailure:     7,
+	outputFailure:     8,
+	// Lookup failures kept the exit code they had before the failures were classified, since scripts check for it
+	lookupFailure: 10,
+	// The shell convention for a process stopped by SIGINT
+	interruptedFailure: 130,
+	// The breached platforms are added to this, see complianceError
+	complianceFailure: 16,
+}
+
+// errorCodes names each class of failure in the JSON error reports.  Like the exit codes, they must not change once
+// released.
+var errorCodes = map[errorClass]string{
+	usageFailure:       "usage",
+	configFailure:      "config",
+	connectionFailure:  "connection",
+	queryFailure:       "query",
+	webhookFailure:     "webhook",
+	inputFailure:       "input",
+	serverFailure:      "server",
+	outputFailure:      "output",
+	interruptedFailure: "interrupted",
+	complianceFailure:  "compliance",
+	lookupFailure:      "lookup",
+}
+
+// Exit codes that aren't failures as such.
+const (
+	exitOK      = 0
+	exitVersion = 1
+	exitHelp    = 99
+)
+
+// commandError is an error returned by a command, carrying the class of failure along with a message for the user.
+type commandError struct {
+	class   errorClass
+	message string
+	err     error
+	// breaches are the platforms that breached their compliance thresholds, for complianceFailure
+	breaches int
+}
+
+func (e *commandError) Error() string {
+	if e.err == nil {
+		return e.message
+	}
+	if e.message == "" {
+		return e.err.Error()
+	}
+	return e.message + ": " + e.err.Error()
+}
+
+func (e *commandError) Unwrap() error {
+	return e.err
+}
+
+func newCommandError(class errorClass, err error, format string, args ...interface{}) error {
+	return &commandError{class: class, message: fmt.Sprintf(format, args...), err: err}
+}
+
+// usageError is returned when the command line is wrong.
+func usageError(format string, args ...interface{}) error {
+	return newCommandError(usageFailure, nil, format, args...)
+}
+
+// configError is returned when the config file can't be read or isn't valid.
+func configError(err error, format string, args ...interface{}) error {
+	return newCommandError(configFailure, err, format, args...)
+}
+
+// connectionError is returned when the database can't be reached.
+func connectionError(err error, format string, args ...interface{}) error {
+	return newCommandError(connectionFailure, err, format, args...)
+}
+
+// queryError is returned when the database or input can't be queried, or the results can't be processed.
+func queryError(err error, format string, args ...interface{}) error {
+	return newCommandError(queryFailure, err, format, args...)
+}
+
+// webhookError is returned when a summary can't be posted to a webhook.
+func webhookError(err error, format string, args ...interface{}) error {
+	return newCommandError(webhookFailure, err, format, args...)
+}
+
+// inputError is returned when an input file or snapshot can't be read.
+func inputError(err error, format string, args ...interface{}) error {
+	return newCommandError(inputFailure, err, format, args...)
+}
+
+// serverError is returned when the HTTP server fails, or the Windows service can't be installed or controlled.
+func serverError(err error, format string, args ...interface{}) error {
+	return newCommandError(serverFailure, err, format, args...)
+}
+
+// outputError is returned when a report, CSV or snapshot can't be written.
+func outputError(err error, format string, args ...interface{}) error {
+	return newCommandError(outputFailure, err, format, args...)
+}
+
+// lookupError is returned when the sessions can't be looked up, or the lookup results can't be written.
+func lookupError(err error, format string, args ...interface{}) error {
+	return newCommandError(lookupFailure, err, format, args...)
+}
+
+// interruptedError is returned when a command is stopped part-way through by a signal, whatever it was doing at the
+// time.
+func interruptedError(err error) error {
+	return newCommandError(interruptedFailure, err, "Interrupted")
+}
+
+// complianceError is returned when too few clients on one or more platforms are at the minimum version.  The exit
+// code is 16 plus desktopBreach, mobileBreach and webBreach for the platforms that breached their thresholds, so 17
+// to 23.
+func complianceError(breaches int, format string, args ...interface{}) error {
+	return &commandError{class: complianceFailure, message: fmt.Sprintf(format, args...), breaches: breaches}
+}
+
+// exitCode returns the exit code for an error returned by a command.  Errors that haven't been classified are
+// treated as query failures, since that's where anything unexpected is most likely to come from.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var cmdErr *commandError
+	if errors.As(err, &cmdErr) {
+		return exitCodes[cmdErr.class] + cmdErr.breaches
+	}
+	return exitCodes[queryFailure]
+}
+
+// errorFormat is how failures are reported, set with '-errors'.  As well as the usual log message, "json" writes
+// each failure to stderr as a JSON object, so that orchestration systems can show why a run failed.
+var errorFormat = "text"
+
+// setErrorFormat validates and sets the error format.
+func setErrorFormat(format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unsupported error format %q.  This must be either \"text\" or \"json\"", format)
+	}
+	errorFormat = format
+	return nil
+}
+
+// errorReport is a failure as written with '-errors json'.  Its schema is printed by 'schema error'.
+type errorReport struct {
+	Code     string `json:"code"`
+	ExitCode int    `json:"exit_code"`
+	Command  string `json:"command"`
+	Message  string `json:"message"`
+	Cause    string `json:"cause,omitempty"`
+	Time     string `json:"time"`
+}
+
+// writeErrorReport writes a failure of a command as a single line of JSON.  Errors that haven't been classified are
+// reported as query failures, to match their exit code.
+func writeErrorReport(w io.Writer, command string, err error) {
+	report := errorReport{
+		Code:     errorCodes[queryFailure],
+		ExitCode: exitCode(err),
+		Command:  command,
+		Message:  err.Error(),
+		Time:     formatTimestamp(time.Now()),
+	}
+	var cmdErr *commandError
+	if errors.As(err, &cmdErr) {
+		report.Code = errorCodes[cmdErr.class]
+		if cmdErr.message != "" {
+			report.Message = cmdErr.message
+			if cmdErr.err != nil {
+				report.Cause = cmdErr.err.Error()
+			}
+		}
+	}
+
+	data, _ := json.Marshal(report)
+	fmt.Fprintln(w, string(data))
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// filterFields are the names that can be used in a '-filter' expression.
+var filterFields = []string{"browser", "client", "os", "user_id", "version"}
+
+// sessionFilter is a compiled '-filter' expression, which decides whether a session is included.
+type sessionFilter interface {
+	match(fields map[string]string) bool
+}
+
+type andFilter struct{ left, right sessionFilter }
+type orFilter struct{ left, right sessionFilter }
+type notFilter struct{ operand sessionFilter }
+
+type comparisonFilter struct {
+	field string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+func (f andFilter) match(fields map[string]string) bool {
+	return f.left.match(fields) && f.right.match(fields)
+}
+
+func (f orFilter) match(fields map[string]string) bool {
+	return f.left.match(fields) || f.right.match(fields)
+}
+
+func (f notFilter) match(fields map[string]string) bool {
+	return !f.operand.match(fields)
+}
+
+// match compares a field with the value.  Equality ignores case, since the OS and browser names aren't always
+// capitalised consistently, and the ordering operators compare version numbers rather than strings, never matching
+// a value that isn't a version number.
+func (f comparisonFilter) match(fields map[string]string) bool {
+	actual := fields[f.field]
+	switch f.op {
+	case "<", "<=", ">", ">=":
+		if _, _, _, err := mmversions.ParseVersion(actual); err != nil {
+			return false
+		}
+	}
+
+	switch f.op {
+	case "==":
+		return strings.EqualFold(actual, f.value)
+	case "!=":
+		return !strings.EqualFold(actual, f.value)
+	case "<":
+		return mmversions.Less(actual, f.value)
+	case "<=":
+		return !mmversions.Less(f.value, actual)
+	case ">":
+		return mmversions.Less(f.value, actual)
+	case ">=":
+		return !mmversions.Less(actual, f.value)
+	case "=~":
+		return f.re.MatchString(actual)
+	}
+	return false
+}
+
+// osFilter is the '-os' flag, which only includes sessions from the listed operating systems, whatever their version.
+type osFilter struct {
+	families []string
+}
+
+// osAliases are the other names that an OS family is known by, so that e.g. -os Darwin or -os macOS matches the
+// "Mac OS" and "Mac OS X" that the clients report.
+var osAliases = map[string]string{
+	"mac os":   "darwin",
+	"mac os x": "darwin",
+	"macos":    "darwin",
+	"mac":      "darwin",
+	"osx":      "darwin",
+}
+
+// osFamily is the lower-case name of an OS without its version, with the aliases resolved.
+func osFamily(os string) string {
+	name, _ := mmversions.SplitOS(os)
+	name = strings.ToLower(name)
+	if family, ok := osAliases[name]; ok {
+		return family
+	}
+	return name
+}
+
+// parseOSFilter reads a comma-separated list of operating systems, e.g. Windows,Darwin.
+func parseOSFilter(list string) (sessionFilter, error) {
+	var families []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			return nil, fmt.Errorf("empty operating system in %q", list)
+		}
+		families = append(families, osFamily(name))
+	}
+	return osFilter{families: families}, nil
+}
+
+func (f osFilter) match(fields map[string]string) bool {
+	return slices.Contains(f.families, osFamily(fields["os"]))
+}
+
+// filterParser is a recursive descent parser for filter expressions:
+//
+//	expression = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | "(" expression ")" | field operator value
+//
+// Values are either double-quoted strings or bare words such as 5.5.0.
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func parseFilter(expression string) (sessionFilter, error) {
+	tokens, err := tokenizeFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("the filter is empty")
+	}
+
+	p := &filterParser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in filter", p.tokens[p.pos])
+	}
+	return filter, nil
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *filterParser) next() (string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", fmt.Errorf("the filter ended unexpectedly")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *filterParser) parseOr() (sessionFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (sessionFilter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andFilter{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (sessionFilter, error) {
+	token, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case "!":
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{operand}, nil
+	case "(":
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing, err := p.next(); err != nil || closing != ")" {
+			return nil, fmt.Errorf("missing ) in filter")
+		}
+		return inner, nil
+	}
+
+	field := strings.ToLower(token)
+	if !slices.Contains(filterFields, field) {
+		return nil, fmt.Errorf("unknown field %q in filter.  Valid fields are: %s", strings.TrimPrefix(token, "\x00"), strings.Join(filterFields, ", "))
+	}
+
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "=~":
+	default:
+		return nil, fmt.Errorf("expected a comparison after %q in filter, but found %q", token, op)
+	}
+
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	value = strings.TrimPrefix(value, "\x00")
+
+	comparison := comparisonFilter{field: field, op: op, value: value}
+	switch op {
+	case "=~":
+		if comparison.re, err = regexp.Compile(value); err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q in filter: %v", value, err)
+		}
+	case "<", "<=", ">", ">=":
+		if _, _, _, err := mmversions.ParseVersion(value); err != nil {
+			return nil, fmt.Errorf("%s needs a version number such as 5.5.0, not %q", op, value)
+		}
+	}
+	return comparison, nil
+}
+
+// tokenizeFilter splits a filter expression into tokens.  Quoted strings are marked with a leading NUL, so that a
+// quoted value such as "&&" can't be mistaken for an operator.
+func tokenizeFilter(expression string) ([]string, error) {
+	var tokens []string
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			var sb strings.Builder
+			i++
+			for i < len(runes) && runes[i] != '"' {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string in filter")
+			}
+			i++
+			tokens = append(tokens, "\x00"+sb.String())
+		case r == '(' || r == ')':
+			tokens = append(tokens, string(r))
+			i++
+		case strings.ContainsRune("=!<>&|", r):
+			if i+1 < len(runes) {
+				pair := string(runes[i : i+2])
+				switch pair {
+				case "==", "!=", "<=", ">=", "=~", "&&", "||":
+					tokens = append(tokens, pair)
+					i += 2
+					continue
+				}
+			}
+			if r != '!' && r != '<' && r != '>' {
+				return nil, fmt.Errorf("unexpected %q in filter", r)
+			}
+			tokens = append(tokens, string(r))
+			i++
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune("\"()=!<>&|", runes[i]) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		}
+	}
+	return tokens, nil
+}
+
+// sessionFilterFields extracts the values that a filter can test from a session.  The classification matches the
+// one used when tallying the sessions.
+func sessionFilterFields(session SessionRecord) (map[string]string, error) {
+	client, err := mmversions.Classify(session)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"os":      client.OS,
+		"browser": client.Browser,
+		"user_id": session.UserID,
+		"client":  string(client.Kind),
+		"version": client.Version,
+	}, nil
+}
+
+// filteredSource applies a '-filter' expression, and the '-os' list, to the sessions from another source.
+type filteredSource struct {
+	Store
+	filter sessionFilter
+}
+
+func (s *filteredSource) Sessions(ctx context.Context) ([]SessionRecord, error) {
+	sessions, err := s.Store.Sessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.match(sessions), nil
+}
+
+func (s *filteredSource) EachSessionUser(ctx context.Context, fn func(session SessionRecord, user *UserRecord) error) error {
+	matched, total := 0, 0
+	err := s.Store.EachSessionUser(ctx, func(session SessionRecord, user *UserRecord) error {
+		total++
+		if !s.matches(session) {
+			return nil
+		}
+		matched++
+		return fn(session, user)
+	})
+	DebugPrint(fmt.Sprintf("Filter matched %d of %d sessions", matched, total))
+	return err
+}
+
+// match returns the sessions that match the filter.
+func (s *filteredSource) match(sessions []SessionRecord) []SessionRecord {
+	var matched []SessionRecord
+	for _, session := range sessions {
+		if s.matches(session) {
+			matched = append(matched, session)
+		}
+	}
+
+	DebugPrint(fmt.Sprintf("Filter matched %d of %d sessions", len(matched), len(sessions)))
+	return matched
+}
+
+// matches reports whether a session matches the filter.
+func (s *filteredSource) matches(session SessionRecord) bool {
+	fields, err := sessionFilterFields(session)
+	if err != nil {
+		// Leave the session in, so that it's reported in the same way as it would be without a filter
+		return true
+	}
+	return s.filter.match(fields)
+}
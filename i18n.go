@@ -0,0 +1,884 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// reportLanguage is the language used for report output, set with '-lang'.  Log messages are always in English,
+// since they're aimed at whoever is running the utility rather than the people reading the reports.
+var reportLanguage = "en"
+
+// Keys for the report strings.  Any key missing from a translation falls back to English.
+const (
+	msgNoApps             = "no_apps"
+	msgDesktopFound       = "desktop_found"
+	msgNoDesktop          = "no_desktop"
+	msgMobileFound        = "mobile_found"
+	msgNoMobile           = "no_mobile"
+	msgTotalDesktop       = "total_desktop"
+	msgTotalMobile        = "total_mobile"
+	msgTotalActive        = "total_active"
+	msgColumnVersion      = "column_version"
+	msgColumnOS           = "column_os"
+	msgColumnCount        = "column_count"
+	msgCardTitle          = "card_title"
+	msgCardFallback       = "card_fallback"
+	msgCardDesktopClients = "card_desktop_clients"
+	msgCardMobileClients  = "card_mobile_clients"
+	msgCardTotalClients   = "card_total_clients"
+	msgCardDesktopHeading = "card_desktop_heading"
+	msgCardMobileHeading  = "card_mobile_heading"
+	msgCardGenerated      = "card_generated"
+	msgDiffComparing      = "diff_comparing"
+	msgDiffNoChanges      = "diff_no_changes"
+	msgDiffChanges        = "diff_changes"
+	msgDiffDesktop        = "diff_desktop"
+	msgDiffMobile         = "diff_mobile"
+	msgLocalesFound       = "locales_found"
+	msgColumnLocale       = "column_locale"
+	msgUnknownLocale      = "unknown_locale"
+	msgAPIFound           = "api_found"
+	msgTotalAPI           = "total_api"
+	msgColumnType         = "column_type"
+	msgCardAPIClients     = "card_api_clients"
+	msgCardAPIHeading     = "card_api_heading"
+	msgDiffAPI            = "diff_api"
+	msgDevFound           = "dev_found"
+	msgTotalDev           = "total_dev"
+	msgCardDevClients     = "card_dev_clients"
+	msgCardDevHeading     = "card_dev_heading"
+	msgDiffDev            = "diff_dev"
+	msgBuildsFound        = "builds_found"
+	msgColumnBuild        = "column_build"
+	msgOSVersionsFound    = "os_versions_found"
+	msgColumnOSVersion    = "column_os_version"
+	msgColumnAppVersion   = "column_app_version"
+	msgExpiryFound        = "expiry_found"
+	msgColumnExpires      = "column_expires"
+	msgColumnDesktop      = "column_desktop"
+	msgColumnMobile       = "column_mobile"
+	msgExpiresWeek        = "expires_week"
+	msgExpiresMonth       = "expires_month"
+	msgExpiresQuarter     = "expires_quarter"
+	msgExpiresLater       = "expires_later"
+	msgExpiresNever       = "expires_never"
+	msgStaleHeading       = "stale_heading"
+	msgStaleTotal         = "stale_total"
+	msgAdoptionFound      = "adoption_found"
+	msgColumnClient       = "column_client"
+	msgColumnUsers        = "column_users"
+	msgColumnAdoption     = "column_adoption"
+	msgAdoptionEither     = "adoption_either"
+	msgEnabledUsers       = "enabled_users"
+	msgLicenseFound       = "license_found"
+	msgNoLicense          = "no_license"
+	msgLicensedSeats      = "licensed_seats"
+	msgLicenseClients     = "license_clients"
+	msgLicenseUsers       = "license_users"
+	msgLicenseExceeded    = "license_exceeded"
+	msgExecTitle          = "exec_title"
+	msgExecNoClients      = "exec_no_clients"
+	msgExecTotals         = "exec_totals"
+	msgExecUpToDate       = "exec_up_to_date"
+	msgExecOutdated       = "exec_outdated"
+	msgExecOldDesktop     = "exec_old_desktop"
+	msgExecOldMobile      = "exec_old_mobile"
+	msgExecRisks          = "exec_risks"
+	msgExecNoRisks        = "exec_no_risks"
+	msgExecRiskDesktop    = "exec_risk_desktop"
+	msgExecRiskMobile     = "exec_risk_mobile"
+	msgExecRiskDev        = "exec_risk_dev"
+	msgExecTrend          = "exec_trend"
+	msgCompareDesktop     = "compare_desktop"
+	msgCompareMobile      = "compare_mobile"
+	msgCompareTotal       = "compare_total"
+	msgCompareLag         = "compare_lag"
+	msgColumnServer       = "column_server"
+	msgColumnOutdated     = "column_outdated"
+	msgCompareBaseline    = "compare_baseline"
+	msgCompareFurthest    = "compare_furthest"
+	msgComplianceFound    = "compliance_found"
+	msgColumnPlatform     = "column_platform"
+	msgColumnMinimum      = "column_minimum"
+	msgColumnCompliant    = "column_compliant"
+	msgColumnThreshold    = "column_threshold"
+	msgColumnStatus       = "column_status"
+	msgComplianceOK       = "compliance_ok"
+	msgComplianceBreach   = "compliance_breach"
+	msgPlatformDesktop    = "platform_desktop"
+	msgPlatformMobile     = "platform_mobile"
+	msgPlatformWeb        = "platform_web"
+	msgMobileDevices      = "mobile_devices"
+	msgAnomaliesFound     = "anomalies_found"
+	msgColumnAnomaly      = "column_anomaly"
+	msgAnomalyFarFuture   = "anomaly_far_future"
+	msgAnomalyBackwards   = "anomaly_backwards"
+	msgAnomalyFromFuture  = "anomaly_from_future"
+	msgTeamsFound         = "teams_found"
+	msgColumnTeam         = "column_team"
+	msgNoTeam             = "no_team"
+	msgAuthFound          = "auth_found"
+	msgColumnAuth         = "column_auth"
+	msgUnknownAuth        = "unknown_auth"
+	msgRolesFound         = "roles_found"
+	msgColumnRole         = "column_role"
+	msgRoleAdmin          = "role_admin"
+	msgRoleMember         = "role_member"
+	msgRoleGuest          = "role_guest"
+	msgUnknownRole        = "unknown_role"
+	msgOutdatedByRole     = "outdated_by_role"
+	msgBlockedFound       = "blocked_found"
+	msgColumnSessions     = "column_sessions"
+	msgPlatformAndroid    = "platform_android"
+	msgPlatformIOS        = "platform_ios"
+	msgNoServerMinimums   = "no_server_minimums"
+	msgServerLicense      = "server_license"
+	msgEdition            = "edition"
+	msgEditionFree        = "edition_free"
+	msgEditionTrial       = "edition_trial"
+	msgLicensedTo         = "licensed_to"
+	msgLicenseExpires     = "license_expires"
+	msgLicenseExpired     = "license_expired"
+	msgForgottenHeading   = "forgotten_heading"
+	msgForgottenTotal     = "forgotten_total"
+	msgPerUserFound       = "sessions_per_user_found"
+	msgColumnPerUser      = "column_per_user"
+	msgMostSessions       = "most_sessions"
+	msgSharedDevicesFound = "shared_devices_found"
+	msgNoSharedDevices    = "no_shared_devices"
+	msgColumnDeviceID     = "column_device_id"
+	msgColumnUsernames    = "column_usernames"
+	msgColumnTotal        = "column_total"
+	msgUsersBelowMinimum  = "users_below_minimum"
+	msgPlatformAPI        = "platform_api"
+	msgColumnLastUsed     = "column_last_used"
+	msgColumnCreated      = "column_created"
+	msgWhoisSessions      = "whois_sessions"
+	msgWhoisDeactivated   = "whois_deactivated"
+	msgTenantsFound       = "tenants_found"
+	msgColumnTenant       = "column_tenant"
+	msgTenantFailed       = "tenant_failed"
+	msgTenantsTotal       = "tenants_total"
+	msgDigestTitle        = "digest_title"
+	msgDigestOutdated     = "digest_outdated"
+	msgDigestNewOutdated  = "digest_new_outdated"
+	msgDigestResolved     = "digest_resolved"
+	msgDigestNoShifts     = "digest_no_shifts"
+	msgDigestMore         = "digest_more"
+	msgLegacyFound        = "legacy_found"
+	msgTotalLegacy        = "total_legacy"
+	msgDiffLegacy         = "diff_legacy"
+	msgReadinessFound     = "readiness_found"
+	msgReadinessReady     = "readiness_ready"
+	msgReadinessUsers     = "readiness_users"
+	msgNodesFound         = "nodes_found"
+	msgColumnNode         = "column_node"
+	msgUnknownNode        = "unknown_node"
+	msgNodesOutdated      = "nodes_outdated"
+)
+
+var translations = map[string]map[string]string{
+	"en": {
+		msgNoApps:             "No Mattermost Apps Found",
+		msgDesktopFound:       "Mattermost Desktop App Versions Found:",
+		msgNoDesktop:          "No Mattermost Desktop Apps Found",
+		msgMobileFound:        "Mattermost Mobile App Versions Found:",
+		msgNoMobile:           "No Mattermost Mobile Apps Found",
+		msgTotalDesktop:       "Total Active Desktop Clients: %d",
+		msgTotalMobile:        "Total Active Mobile Clients: %d",
+		msgTotalActive:        "Total Active Clients: %d",
+		msgColumnVersion:      "VERSION",
+		msgColumnOS:           "OS",
+		msgColumnCount:        "COUNT",
+		msgCardTitle:          "Mattermost Client Versions",
+		msgCardFallback:       "%s: %d active clients",
+		msgCardDesktopClients: "Desktop clients",
+		msgCardMobileClients:  "Mobile clients",
+		msgCardTotalClients:   "Total active clients",
+		msgCardDesktopHeading: "Desktop App Versions",
+		msgCardMobileHeading:  "Mobile App Versions",
+		msgCardGenerated:      "Generated",
+		msgDiffComparing:      "Comparing %s with %s",
+		msgDiffNoChanges:      "No changes to %s",
+		msgDiffChanges:        "Changes to %s:",
+		msgDiffDesktop:        "Mattermost Desktop App Versions",
+		msgDiffMobile:         "Mattermost Mobile App Versions",
+		msgLocalesFound:       "Active Clients by User Locale:",
+		msgColumnLocale:       "LOCALE",
+		msgUnknownLocale:      "unknown",
+		msgAPIFound:           "API and Integration Clients Found:",
+		msgTotalAPI:           "Total Active API Clients: %d",
+		msgColumnType:         "TYPE",
+		msgCardAPIClients:     "API clients",
+		msgCardAPIHeading:     "API and Integration Clients",
+		msgDiffAPI:            "API and Integration Clients",
+		msgDevFound:           "Nightly and Development Desktop Builds Found:",
+		msgTotalDev:           "Total Active Nightly/Development Desktop Clients: %d",
+		msgCardDevClients:     "Nightly/development desktop clients",
+		msgCardDevHeading:     "Nightly and Development Desktop Builds",
+		msgDiffDev:            "Nightly and Development Desktop Builds",
+		msgBuildsFound:        "Mattermost Mobile App Builds Found:",
+		msgColumnBuild:        "BUILD",
+		msgOSVersionsFound:    "Mattermost Desktop App Versions by OS Version:",
+		msgColumnOSVersion:    "OS VERSION",
+		msgColumnAppVersion:   "APP VERSION",
+		msgExpiryFound:        "Active Clients by Session Expiry:",
+		msgColumnExpires:      "EXPIRES",
+		msgColumnDesktop:      "DESKTOP",
+		msgColumnMobile:       "MOBILE",
+		msgExpiresWeek:        "Within 7 days",
+		msgExpiresMonth:       "In 8-30 days",
+		msgExpiresQuarter:     "In 31-90 days",
+		msgExpiresLater:       "After 90 days",
+		msgExpiresNever:       "Never",
+		msgStaleHeading:       "Active sessions with no activity for %d days or more:",
+		msgStaleTotal:         "%d of %d active sessions are stale",
+		msgAdoptionFound:      "Adoption by Enabled Users:",
+		msgColumnClient:       "CLIENT",
+		msgColumnUsers:        "USERS",
+		msgColumnAdoption:     "ADOPTION",
+		msgAdoptionEither:     "Desktop or mobile clients",
+		msgEnabledUsers:       "Enabled Users: %d",
+		msgLicenseFound:       "License Usage:",
+		msgNoLicense:          "No license found",
+		msgLicensedSeats:      "Licensed seats: %d",
+		msgLicenseClients:     "Active clients: %d (%.1f%% of seats)",
+		msgLicenseUsers:       "Active users: %d (%.1f%% of seats)",
+		msgLicenseExceeded:    "WARNING: %d active users exceeds the %d licensed seats",
+		msgExecTitle:          "Mattermost app versions: summary as of %s",
+		msgExecNoClients:      "No desktop or mobile app clients are in use.",
+		msgExecTotals:         "%d desktop and mobile app clients are in use: %d desktop and %d mobile.",
+		msgExecUpToDate:       "All of them are up to date.",
+		msgExecOutdated:       "%d of them (%.0f%%) are on an outdated version:",
+		msgExecOldDesktop:     "%d of %d desktop clients (%.0f%%) are older than version %s.",
+		msgExecOldMobile:      "%d of %d mobile clients (%.0f%%) are older than version %s.",
+		msgExecRisks:          "Top risks:",
+		msgExecNoRisks:        "No outdated versions are in use.",
+		msgExecRiskDesktop:    "%d desktop clients are still on version %s.",
+		msgExecRiskMobile:     "%d mobile clients are still on version %s.",
+		msgExecRiskDev:        "%d desktop clients are running nightly or developer builds, which aren't meant for everyday use.",
+		msgExecTrend:          "Since the last check on %s, the clients in use went from %d to %d, and the outdated clients from %d to %d.",
+		msgCompareDesktop:     "Desktop App Versions by Server (% of each server's desktop clients):",
+		msgCompareMobile:      "Mobile App Versions by Server (% of each server's mobile clients):",
+		msgCompareTotal:       "Total clients",
+		msgCompareLag:         "Outdated Clients by Server:",
+		msgColumnServer:       "SERVER",
+		msgColumnOutdated:     "OUTDATED",
+		msgCompareBaseline:    "Outdated means older than desktop %s and mobile %s.",
+		msgCompareFurthest:    "Furthest behind: %s, with %.1f%% of clients outdated",
+		msgComplianceFound:    "Compliance by Platform:",
+		msgColumnPlatform:     "PLATFORM",
+		msgColumnMinimum:      "MINIMUM",
+		msgColumnCompliant:    "COMPLIANT",
+		msgColumnThreshold:    "THRESHOLD",
+		msgColumnStatus:       "STATUS",
+		msgComplianceOK:       "OK",
+		msgComplianceBreach:   "BREACHED",
+		msgPlatformDesktop:    "Desktop",
+		msgPlatformMobile:     "Mobile",
+		msgPlatformWeb:        "Web",
+		msgMobileDevices:      "Unique Mobile Devices: %d",
+		msgAnomaliesFound:     "Sessions with Implausible Expiry Times:",
+		msgColumnAnomaly:      "ANOMALY",
+		msgAnomalyFarFuture:   "Expire in more than %d days",
+		msgAnomalyBackwards:   "Expire before they were created",
+		msgAnomalyFromFuture:  "Created or used in the future",
+		msgTeamsFound:         "Active Clients by Team:",
+		msgColumnTeam:         "TEAM",
+		msgNoTeam:             "(no team)",
+		msgAuthFound:          "Active Clients by Sign-in Method:",
+		msgColumnAuth:         "SIGN-IN",
+		msgUnknownAuth:        "unknown",
+		msgRolesFound:         "Active Clients by Role:",
+		msgColumnRole:         "ROLE",
+		msgRoleAdmin:          "System admin",
+		msgRoleMember:         "Member",
+		msgRoleGuest:          "Guest",
+		msgUnknownRole:        "unknown",
+		msgOutdatedByRole:     "Users on %s versions older than %s: %s",
+		msgBlockedFound:       "Sessions Below the Server's Minimum Versions:",
+		msgColumnSessions:     "SESSIONS",
+		msgPlatformAndroid:    "Android",
+		msgPlatformIOS:        "iOS",
+		msgNoServerMinimums:   "The server doesn't enforce any minimum app versions.",
+		msgServerLicense:      "Mattermost Server License:",
+		msgEdition:            "Edition: %s",
+		msgEditionFree:        "Free (no license)",
+		msgEditionTrial:       "%s (trial)",
+		msgLicensedTo:         "Licensed to: %s",
+		msgLicenseExpires:     "Expires: %s (in %d days)",
+		msgLicenseExpired:     "Expired: %s (%d days ago)",
+		msgForgottenHeading:   "Active sessions created %d months ago or more, on desktop version %s or older:",
+		msgForgottenTotal:     "%d of %d active sessions look like forgotten installs",
+		msgPerUserFound:       "Active Sessions per User:",
+		msgColumnPerUser:      "SESSIONS PER USER",
+		msgMostSessions:       "Most active sessions for one user: %d",
+		msgSharedDevicesFound: "Device IDs Shared by More Than One User:",
+		msgNoSharedDevices:    "No device IDs are shared by more than one user",
+		msgColumnDeviceID:     "DEVICE ID",
+		msgColumnUsernames:    "USERNAMES",
+		msgColumnTotal:        "TOTAL",
+		msgUsersBelowMinimum:  "Users on %s versions older than %s",
+		msgPlatformAPI:        "API",
+		msgColumnLastUsed:     "LAST ACTIVITY",
+		msgColumnCreated:      "CREATED",
+		msgWhoisSessions:      "Active sessions: %d",
+		msgWhoisDeactivated:   "Deactivated",
+		msgTenantsFound:       "Compliance across Tenants:",
+		msgColumnTenant:       "TENANT",
+		msgTenantFailed:       "FAILED",
+		msgTenantsTotal:       "Tenants: %d (%d compliant, %d breached, %d failed)",
+		msgDigestTitle:        "Mattermost Client Versions: What Changed since %s",
+		msgDigestOutdated:     "Users on outdated versions",
+		msgDigestNewOutdated:  "Newly outdated users (%d)",
+		msgDigestResolved:     "No longer outdated (%d)",
+		msgDigestNoShifts:     "No version counts have changed.",
+		msgDigestMore:         "...and %d more",
+		msgLegacyFound:        "Legacy Desktop Builds Found:",
+		msgTotalLegacy:        "Total Active Legacy Desktop Clients: %d",
+		msgDiffLegacy:         "Legacy Desktop Builds",
+		msgReadinessFound:     "Clients Below the Minimum Versions for Server %s:",
+		msgReadinessReady:     "All active clients meet the minimum versions for server %s.",
+		msgReadinessUsers:     "%d users would need to upgrade before the server is upgraded to %s.",
+		msgNodesFound:         "Active Clients by Node:",
+		msgColumnNode:         "NODE",
+		msgUnknownNode:        "unknown",
+		msgNodesOutdated:      "Outdated Clients by Node:",
+	},
+	"de": {
+		msgNoApps:             "Keine Mattermost-Apps gefunden",
+		msgDesktopFound:       "Gefundene Versionen der Mattermost Desktop-App:",
+		msgNoDesktop:          "Keine Mattermost Desktop-Apps gefunden",
+		msgMobileFound:        "Gefundene Versionen der Mattermost Mobile-App:",
+		msgNoMobile:           "Keine Mattermost Mobile-Apps gefunden",
+		msgTotalDesktop:       "Aktive Desktop-Clients gesamt: %d",
+		msgTotalMobile:        "Aktive Mobile-Clients gesamt: %d",
+		msgTotalActive:        "Aktive Clients gesamt: %d",
+		msgColumnVersion:      "VERSION",
+		msgColumnOS:           "BETRIEBSSYSTEM",
+		msgColumnCount:        "ANZAHL",
+		msgCardTitle:          "Mattermost-Clientversionen",
+		msgCardFallback:       "%s: %d aktive Clients",
+		msgCardDesktopClients: "Desktop-Clients",
+		msgCardMobileClients:  "Mobile-Clients",
+		msgCardTotalClients:   "Aktive Clients gesamt",
+		msgCardDesktopHeading: "Versionen der Desktop-App",
+		msgCardMobileHeading:  "Versionen der Mobile-App",
+		msgCardGenerated:      "Erstellt",
+		msgDiffComparing:      "Vergleich von %s mit %s",
+		msgDiffNoChanges:      "Keine Änderungen: %s",
+		msgDiffChanges:        "Änderungen: %s",
+		msgDiffDesktop:        "Versionen der Mattermost Desktop-App",
+		msgDiffMobile:         "Versionen der Mattermost Mobile-App",
+		msgLocalesFound:       "Aktive Clients nach Benutzersprache:",
+		msgColumnLocale:       "SPRACHE",
+		msgUnknownLocale:      "unbekannt",
+		msgAPIFound:           "Gefundene API- und Integrations-Clients:",
+		msgTotalAPI:           "Aktive API-Clients gesamt: %d",
+		msgColumnType:         "TYP",
+		msgCardAPIClients:     "API-Clients",
+		msgCardAPIHeading:     "API- und Integrations-Clients",
+		msgDiffAPI:            "API- und Integrations-Clients",
+		msgDevFound:           "Gefundene Nightly- und Entwicklungs-Builds der Desktop-App:",
+		msgTotalDev:           "Aktive Nightly-/Entwicklungs-Desktop-Clients gesamt: %d",
+		msgCardDevClients:     "Nightly-/Entwicklungs-Desktop-Clients",
+		msgCardDevHeading:     "Nightly- und Entwicklungs-Builds der Desktop-App",
+		msgDiffDev:            "Nightly- und Entwicklungs-Builds der Desktop-App",
+		msgBuildsFound:        "Gefundene Builds der Mattermost Mobile-App:",
+		msgColumnBuild:        "BUILD",
+		msgOSVersionsFound:    "Versionen der Mattermost Desktop-App nach Betriebssystemversion:",
+		msgColumnOSVersion:    "BS-VERSION",
+		msgColumnAppVersion:   "APP-VERSION",
+		msgExpiryFound:        "Aktive Clients nach Ablauf der Sitzung:",
+		msgColumnExpires:      "LÄUFT AB",
+		msgColumnDesktop:      "DESKTOP",
+		msgColumnMobile:       "MOBIL",
+		msgExpiresWeek:        "Innerhalb von 7 Tagen",
+		msgExpiresMonth:       "In 8-30 Tagen",
+		msgExpiresQuarter:     "In 31-90 Tagen",
+		msgExpiresLater:       "Nach 90 Tagen",
+		msgExpiresNever:       "Nie",
+		msgStaleHeading:       "Aktive Sitzungen ohne Aktivität seit %d Tagen oder länger:",
+		msgStaleTotal:         "%d von %d aktiven Sitzungen sind veraltet",
+		msgAdoptionFound:      "Verbreitung unter aktivierten Benutzern:",
+		msgColumnClient:       "CLIENT",
+		msgColumnUsers:        "BENUTZER",
+		msgColumnAdoption:     "VERBREITUNG",
+		msgAdoptionEither:     "Desktop- oder Mobile-Clients",
+		msgEnabledUsers:       "Aktivierte Benutzer: %d",
+		msgLicenseFound:       "Lizenznutzung:",
+		msgNoLicense:          "Keine Lizenz gefunden",
+		msgLicensedSeats:      "Lizenzierte Plätze: %d",
+		msgLicenseClients:     "Aktive Clients: %d (%.1f%% der Plätze)",
+		msgLicenseUsers:       "Aktive Benutzer: %d (%.1f%% der Plätze)",
+		msgLicenseExceeded:    "WARNUNG: %d aktive Benutzer überschreiten die %d lizenzierten Plätze",
+		msgExecTitle:          "Mattermost-App-Versionen: Zusammenfassung vom %s",
+		msgExecNoClients:      "Es werden keine Desktop- oder Mobile-Apps verwendet.",
+		msgExecTotals:         "Es werden %d Desktop- und Mobile-Apps verwendet: %d Desktop und %d Mobile.",
+		msgExecUpToDate:       "Alle sind auf dem aktuellen Stand.",
+		msgExecOutdated:       "%d davon (%.0f%%) nutzen eine veraltete Version:",
+		msgExecOldDesktop:     "%d von %d Desktop-Apps (%.0f%%) sind älter als Version %s.",
+		msgExecOldMobile:      "%d von %d Mobile-Apps (%.0f%%) sind älter als Version %s.",
+		msgExecRisks:          "Größte Risiken:",
+		msgExecNoRisks:        "Es werden keine veralteten Versionen verwendet.",
+		msgExecRiskDesktop:    "%d Desktop-Apps nutzen noch Version %s.",
+		msgExecRiskMobile:     "%d Mobile-Apps nutzen noch Version %s.",
+		msgExecRiskDev:        "%d Desktop-Apps nutzen Nightly- oder Entwickler-Builds, die nicht für den Alltagseinsatz gedacht sind.",
+		msgExecTrend:          "Seit der letzten Prüfung am %s ist die Zahl der verwendeten Apps von %d auf %d und die der veralteten von %d auf %d gegangen.",
+		msgCompareDesktop:     "Desktop-App-Versionen nach Server (% der Desktop-Apps des Servers):",
+		msgCompareMobile:      "Mobile-App-Versionen nach Server (% der Mobile-Apps des Servers):",
+		msgCompareTotal:       "Apps insgesamt",
+		msgCompareLag:         "Veraltete Apps nach Server:",
+		msgColumnServer:       "SERVER",
+		msgColumnOutdated:     "VERALTET",
+		msgCompareBaseline:    "Veraltet heißt älter als Desktop %s und Mobile %s.",
+		msgCompareFurthest:    "Am weitesten zurück: %s, mit %.1f%% veralteten Apps",
+		msgComplianceFound:    "Konformität nach Plattform:",
+		msgColumnPlatform:     "PLATTFORM",
+		msgColumnMinimum:      "MINIMUM",
+		msgColumnCompliant:    "KONFORM",
+		msgColumnThreshold:    "SCHWELLE",
+		msgColumnStatus:       "STATUS",
+		msgComplianceOK:       "OK",
+		msgComplianceBreach:   "VERLETZT",
+		msgPlatformDesktop:    "Desktop",
+		msgPlatformMobile:     "Mobil",
+		msgPlatformWeb:        "Web",
+		msgMobileDevices:      "Eindeutige Mobilgeräte: %d",
+		msgAnomaliesFound:     "Sitzungen mit unplausiblen Ablaufzeiten:",
+		msgColumnAnomaly:      "ANOMALIE",
+		msgAnomalyFarFuture:   "Ablauf in mehr als %d Tagen",
+		msgAnomalyBackwards:   "Ablauf vor der Erstellung",
+		msgAnomalyFromFuture:  "In der Zukunft erstellt oder genutzt",
+		msgTeamsFound:         "Aktive Clients nach Team:",
+		msgColumnTeam:         "TEAM",
+		msgNoTeam:             "(kein Team)",
+		msgAuthFound:          "Aktive Clients nach Anmeldemethode:",
+		msgColumnAuth:         "ANMELDUNG",
+		msgUnknownAuth:        "unbekannt",
+		msgRolesFound:         "Aktive Clients nach Rolle:",
+		msgColumnRole:         "ROLLE",
+		msgRoleAdmin:          "Systemadministrator",
+		msgRoleMember:         "Mitglied",
+		msgRoleGuest:          "Gast",
+		msgUnknownRole:        "unbekannt",
+		msgOutdatedByRole:     "Benutzer mit %s-Versionen älter als %s: %s",
+		msgBlockedFound:       "Sitzungen unter den Mindestversionen des Servers:",
+		msgColumnSessions:     "SITZUNGEN",
+		msgPlatformAndroid:    "Android",
+		msgPlatformIOS:        "iOS",
+		msgNoServerMinimums:   "Der Server erzwingt keine Mindestversionen der Apps.",
+		msgServerLicense:      "Mattermost-Serverlizenz:",
+		msgEdition:            "Edition: %s",
+		msgEditionFree:        "Free (keine Lizenz)",
+		msgEditionTrial:       "%s (Testversion)",
+		msgLicensedTo:         "Lizenziert für: %s",
+		msgLicenseExpires:     "Läuft ab: %s (in %d Tagen)",
+		msgLicenseExpired:     "Abgelaufen: %s (vor %d Tagen)",
+		msgForgottenHeading:   "Aktive Sitzungen, die vor %d Monaten oder früher erstellt wurden, mit Desktop-Version %s oder älter:",
+		msgForgottenTotal:     "%d von %d aktiven Sitzungen sehen nach vergessenen Installationen aus",
+		msgPerUserFound:       "Aktive Sitzungen pro Benutzer:",
+		msgColumnPerUser:      "SITZUNGEN PRO BENUTZER",
+		msgMostSessions:       "Meiste aktive Sitzungen eines Benutzers: %d",
+		msgSharedDevicesFound: "Von mehreren Benutzern geteilte Geräte-IDs:",
+		msgNoSharedDevices:    "Keine Geräte-ID wird von mehreren Benutzern geteilt",
+		msgColumnDeviceID:     "GERÄTE-ID",
+		msgColumnUsernames:    "BENUTZERNAMEN",
+		msgColumnTotal:        "GESAMT",
+		msgUsersBelowMinimum:  "Benutzer mit %s-Versionen älter als %s",
+		msgPlatformAPI:        "API",
+		msgColumnLastUsed:     "LETZTE AKTIVITÄT",
+		msgColumnCreated:      "ERSTELLT",
+		msgWhoisSessions:      "Aktive Sitzungen: %d",
+		msgWhoisDeactivated:   "Deaktiviert",
+		msgTenantsFound:       "Konformität über alle Mandanten:",
+		msgColumnTenant:       "MANDANT",
+		msgTenantFailed:       "FEHLGESCHLAGEN",
+		msgTenantsTotal:       "Mandanten: %d (%d konform, %d verletzt, %d fehlgeschlagen)",
+		msgDigestTitle:        "Mattermost-Clientversionen: Änderungen seit %s",
+		msgDigestOutdated:     "Benutzer mit veralteten Versionen",
+		msgDigestNewOutdated:  "Neu veraltete Benutzer (%d)",
+		msgDigestResolved:     "Nicht mehr veraltet (%d)",
+		msgDigestNoShifts:     "Keine Versionszahlen haben sich geändert.",
+		msgDigestMore:         "...und %d weitere",
+		msgLegacyFound:        "Gefundene Legacy-Builds der Desktop-App:",
+		msgTotalLegacy:        "Aktive Legacy-Desktop-Clients gesamt: %d",
+		msgDiffLegacy:         "Legacy-Builds der Desktop-App",
+		msgReadinessFound:     "Clients unter den Mindestversionen für Server %s:",
+		msgReadinessReady:     "Alle aktiven Clients erfüllen die Mindestversionen für Server %s.",
+		msgReadinessUsers:     "%d Benutzer müssten vor dem Upgrade des Servers auf %s aktualisieren.",
+		msgNodesFound:         "Aktive Clients nach Knoten:",
+		msgColumnNode:         "KNOTEN",
+		msgUnknownNode:        "unbekannt",
+		msgNodesOutdated:      "Veraltete Clients nach Knoten:",
+	},
+	"fr": {
+		msgNoApps:             "Aucune application Mattermost trouvée",
+		msgDesktopFound:       "Versions de l'application de bureau Mattermost trouvées :",
+		msgNoDesktop:          "Aucune application de bureau Mattermost trouvée",
+		msgMobileFound:        "Versions de l'application mobile Mattermost trouvées :",
+		msgNoMobile:           "Aucune application mobile Mattermost trouvée",
+		msgTotalDesktop:       "Total des clients de bureau actifs : %d",
+		msgTotalMobile:        "Total des clients mobiles actifs : %d",
+		msgTotalActive:        "Total des clients actifs : %d",
+		msgColumnVersion:      "VERSION",
+		msgColumnOS:           "SE",
+		msgColumnCount:        "NOMBRE",
+		msgCardTitle:          "Versions des clients Mattermost",
+		msgCardFallback:       "%s : %d clients actifs",
+		msgCardDesktopClients: "Clients de bureau",
+		msgCardMobileClients:  "Clients mobiles",
+		msgCardTotalClients:   "Total des clients actifs",
+		msgCardDesktopHeading: "Versions de l'application de bureau",
+		msgCardMobileHeading:  "Versions de l'application mobile",
+		msgCardGenerated:      "Généré le",
+		msgDiffComparing:      "Comparaison de %s avec %s",
+		msgDiffNoChanges:      "Aucun changement : %s",
+		msgDiffChanges:        "Changements : %s",
+		msgDiffDesktop:        "Versions de l'application de bureau Mattermost",
+		msgDiffMobile:         "Versions de l'application mobile Mattermost",
+		msgLocalesFound:       "Clients actifs par langue de l'utilisateur :",
+		msgColumnLocale:       "LANGUE",
+		msgUnknownLocale:      "inconnue",
+		msgAPIFound:           "Clients d'API et d'intégration trouvés :",
+		msgTotalAPI:           "Total des clients API actifs : %d",
+		msgColumnType:         "TYPE",
+		msgCardAPIClients:     "Clients API",
+		msgCardAPIHeading:     "Clients d'API et d'intégration",
+		msgDiffAPI:            "Clients d'API et d'intégration",
+		msgDevFound:           "Versions nightly et de développement de l'application de bureau trouvées :",
+		msgTotalDev:           "Total des clients de bureau nightly/développement actifs : %d",
+		msgCardDevClients:     "Clients de bureau nightly/développement",
+		msgCardDevHeading:     "Versions nightly et de développement de l'application de bureau",
+		msgDiffDev:            "Versions nightly et de développement de l'application de bureau",
+		msgBuildsFound:        "Builds de l'application mobile Mattermost trouvés :",
+		msgColumnBuild:        "BUILD",
+		msgOSVersionsFound:    "Versions de l'application de bureau Mattermost par version du SE :",
+		msgColumnOSVersion:    "VERSION DU SE",
+		msgColumnAppVersion:   "VERSION DE L'APP",
+		msgExpiryFound:        "Clients actifs par expiration de session :",
+		msgColumnExpires:      "EXPIRE",
+		msgColumnDesktop:      "BUREAU",
+		msgColumnMobile:       "MOBILE",
+		msgExpiresWeek:        "Dans les 7 jours",
+		msgExpiresMonth:       "Dans 8 à 30 jours",
+		msgExpiresQuarter:     "Dans 31 à 90 jours",
+		msgExpiresLater:       "Après 90 jours",
+		msgExpiresNever:       "Jamais",
+		msgStaleHeading:       "Sessions actives sans activité depuis %d jours ou plus :",
+		msgStaleTotal:         "%d sessions actives sur %d sont inactives",
+		msgAdoptionFound:      "Adoption parmi les utilisateurs actifs :",
+		msgColumnClient:       "CLIENT",
+		msgColumnUsers:        "UTILISATEURS",
+		msgColumnAdoption:     "ADOPTION",
+		msgAdoptionEither:     "Clients de bureau ou mobiles",
+		msgEnabledUsers:       "Utilisateurs actifs : %d",
+		msgLicenseFound:       "Utilisation de la licence :",
+		msgNoLicense:          "Aucune licence trouvée",
+		msgLicensedSeats:      "Places sous licence : %d",
+		msgLicenseClients:     "Clients actifs : %d (%.1f%% des places)",
+		msgLicenseUsers:       "Utilisateurs actifs : %d (%.1f%% des places)",
+		msgLicenseExceeded:    "ATTENTION : %d utilisateurs actifs dépassent les %d places sous licence",
+		msgExecTitle:          "Versions des applications Mattermost : synthèse au %s",
+		msgExecNoClients:      "Aucune application de bureau ou mobile n'est utilisée.",
+		msgExecTotals:         "%d applications de bureau et mobiles sont utilisées : %d de bureau et %d mobiles.",
+		msgExecUpToDate:       "Toutes sont à jour.",
+		msgExecOutdated:       "%d d'entre elles (%.0f%%) utilisent une version obsolète :",
+		msgExecOldDesktop:     "%d applications de bureau sur %d (%.0f%%) sont antérieures à la version %s.",
+		msgExecOldMobile:      "%d applications mobiles sur %d (%.0f%%) sont antérieures à la version %s.",
+		msgExecRisks:          "Principaux risques :",
+		msgExecNoRisks:        "Aucune version obsolète n'est utilisée.",
+		msgExecRiskDesktop:    "%d applications de bureau utilisent encore la version %s.",
+		msgExecRiskMobile:     "%d applications mobiles utilisent encore la version %s.",
+		msgExecRiskDev:        "%d applications de bureau utilisent des versions nightly ou de développement, qui ne sont pas destinées à un usage quotidien.",
+		msgExecTrend:          "Depuis le dernier contrôle du %s, les applications utilisées sont passées de %d à %d, et les applications obsolètes de %d à %d.",
+		msgCompareDesktop:     "Versions de l'application de bureau par serveur (% des applications de bureau du serveur) :",
+		msgCompareMobile:      "Versions de l'application mobile par serveur (% des applications mobiles du serveur) :",
+		msgCompareTotal:       "Total des applications",
+		msgCompareLag:         "Applications obsolètes par serveur :",
+		msgColumnServer:       "SERVEUR",
+		msgColumnOutdated:     "OBSOLÈTES",
+		msgCompareBaseline:    "Obsolète signifie antérieure à la version de bureau %s et à la version mobile %s.",
+		msgCompareFurthest:    "Le plus en retard : %s, avec %.1f%% d'applications obsolètes",
+		msgComplianceFound:    "Conformité par plateforme :",
+		msgColumnPlatform:     "PLATEFORME",
+		msgColumnMinimum:      "MINIMUM",
+		msgColumnCompliant:    "CONFORMES",
+		msgColumnThreshold:    "SEUIL",
+		msgColumnStatus:       "ÉTAT",
+		msgComplianceOK:       "OK",
+		msgComplianceBreach:   "NON RESPECTÉ",
+		msgPlatformDesktop:    "Bureau",
+		msgPlatformMobile:     "Mobile",
+		msgPlatformWeb:        "Web",
+		msgMobileDevices:      "Appareils mobiles uniques : %d",
+		msgAnomaliesFound:     "Sessions avec des dates d'expiration invraisemblables :",
+		msgColumnAnomaly:      "ANOMALIE",
+		msgAnomalyFarFuture:   "Expirent dans plus de %d jours",
+		msgAnomalyBackwards:   "Expirent avant leur création",
+		msgAnomalyFromFuture:  "Créées ou utilisées dans le futur",
+		msgTeamsFound:         "Clients actifs par équipe :",
+		msgColumnTeam:         "ÉQUIPE",
+		msgNoTeam:             "(aucune équipe)",
+		msgAuthFound:          "Clients actifs par méthode de connexion :",
+		msgColumnAuth:         "CONNEXION",
+		msgUnknownAuth:        "inconnu",
+		msgRolesFound:         "Clients actifs par rôle :",
+		msgColumnRole:         "RÔLE",
+		msgRoleAdmin:          "Administrateur système",
+		msgRoleMember:         "Membre",
+		msgRoleGuest:          "Invité",
+		msgUnknownRole:        "inconnu",
+		msgOutdatedByRole:     "Utilisateurs avec des versions %s antérieures à %s : %s",
+		msgBlockedFound:       "Sessions sous les versions minimales du serveur :",
+		msgColumnSessions:     "SESSIONS",
+		msgPlatformAndroid:    "Android",
+		msgPlatformIOS:        "iOS",
+		msgNoServerMinimums:   "Le serveur n'impose aucune version minimale des applications.",
+		msgServerLicense:      "Licence du serveur Mattermost :",
+		msgEdition:            "Édition : %s",
+		msgEditionFree:        "Free (aucune licence)",
+		msgEditionTrial:       "%s (essai)",
+		msgLicensedTo:         "Licence accordée à : %s",
+		msgLicenseExpires:     "Expire : %s (dans %d jours)",
+		msgLicenseExpired:     "Expirée : %s (il y a %d jours)",
+		msgForgottenHeading:   "Sessions actives créées il y a %d mois ou plus, avec la version de bureau %s ou antérieure :",
+		msgForgottenTotal:     "%d sur %d sessions actives semblent être des installations oubliées",
+		msgPerUserFound:       "Sessions actives par utilisateur :",
+		msgColumnPerUser:      "SESSIONS PAR UTILISATEUR",
+		msgMostSessions:       "Nombre maximal de sessions actives pour un utilisateur : %d",
+		msgSharedDevicesFound: "Identifiants d'appareil partagés par plusieurs utilisateurs :",
+		msgNoSharedDevices:    "Aucun identifiant d'appareil n'est partagé par plusieurs utilisateurs",
+		msgColumnDeviceID:     "ID D'APPAREIL",
+		msgColumnUsernames:    "NOMS D'UTILISATEUR",
+		msgColumnTotal:        "TOTAL",
+		msgUsersBelowMinimum:  "Utilisateurs avec des versions %s antérieures à %s",
+		msgPlatformAPI:        "API",
+		msgColumnLastUsed:     "DERNIÈRE ACTIVITÉ",
+		msgColumnCreated:      "CRÉÉE",
+		msgWhoisSessions:      "Sessions actives : %d",
+		msgWhoisDeactivated:   "Désactivé",
+		msgTenantsFound:       "Conformité de tous les locataires :",
+		msgColumnTenant:       "LOCATAIRE",
+		msgTenantFailed:       "ÉCHEC",
+		msgTenantsTotal:       "Locataires : %d (%d conformes, %d en infraction, %d en échec)",
+		msgDigestTitle:        "Versions des clients Mattermost : changements depuis %s",
+		msgDigestOutdated:     "Utilisateurs sur des versions obsolètes",
+		msgDigestNewOutdated:  "Nouveaux utilisateurs obsolètes (%d)",
+		msgDigestResolved:     "Plus obsolètes (%d)",
+		msgDigestNoShifts:     "Aucun nombre de versions n'a changé.",
+		msgDigestMore:         "...et %d de plus",
+		msgLegacyFound:        "Anciennes versions de l'application de bureau trouvées :",
+		msgTotalLegacy:        "Total des anciens clients de bureau actifs : %d",
+		msgDiffLegacy:         "Anciennes versions de l'application de bureau",
+		msgReadinessFound:     "Clients en dessous des versions minimales du serveur %s :",
+		msgReadinessReady:     "Tous les clients actifs respectent les versions minimales du serveur %s.",
+		msgReadinessUsers:     "%d utilisateurs devraient effectuer la mise à jour avant que le serveur passe à la version %s.",
+		msgNodesFound:         "Clients actifs par nœud :",
+		msgColumnNode:         "NŒUD",
+		msgUnknownNode:        "inconnu",
+		msgNodesOutdated:      "Clients obsolètes par nœud :",
+	},
+	"es": {
+		msgNoApps:             "No se encontraron aplicaciones de Mattermost",
+		msgDesktopFound:       "Versiones de la aplicación de escritorio de Mattermost encontradas:",
+		msgNoDesktop:          "No se encontraron aplicaciones de escritorio de Mattermost",
+		msgMobileFound:        "Versiones de la aplicación móvil de Mattermost encontradas:",
+		msgNoMobile:           "No se encontraron aplicaciones móviles de Mattermost",
+		msgTotalDesktop:       "Total de clientes de escritorio activos: %d",
+		msgTotalMobile:        "Total de clientes móviles activos: %d",
+		msgTotalActive:        "Total de clientes activos: %d",
+		msgColumnVersion:      "VERSIÓN",
+		msgColumnOS:           "SO",
+		msgColumnCount:        "CANTIDAD",
+		msgCardTitle:          "Versiones de clientes de Mattermost",
+		msgCardFallback:       "%s: %d clientes activos",
+		msgCardDesktopClients: "Clientes de escritorio",
+		msgCardMobileClients:  "Clientes móviles",
+		msgCardTotalClients:   "Total de clientes activos",
+		msgCardDesktopHeading: "Versiones de la aplicación de escritorio",
+		msgCardMobileHeading:  "Versiones de la aplicación móvil",
+		msgCardGenerated:      "Generado el",
+		msgDiffComparing:      "Comparando %s con %s",
+		msgDiffNoChanges:      "Sin cambios: %s",
+		msgDiffChanges:        "Cambios: %s",
+		msgDiffDesktop:        "Versiones de la aplicación de escritorio de Mattermost",
+		msgDiffMobile:         "Versiones de la aplicación móvil de Mattermost",
+		msgLocalesFound:       "Clientes activos por idioma del usuario:",
+		msgColumnLocale:       "IDIOMA",
+		msgUnknownLocale:      "desconocido",
+		msgAPIFound:           "Clientes de API e integraciones encontrados:",
+		msgTotalAPI:           "Total de clientes de API activos: %d",
+		msgColumnType:         "TIPO",
+		msgCardAPIClients:     "Clientes de API",
+		msgCardAPIHeading:     "Clientes de API e integraciones",
+		msgDiffAPI:            "Clientes de API e integraciones",
+		msgDevFound:           "Compilaciones nightly y de desarrollo de la aplicación de escritorio encontradas:",
+		msgTotalDev:           "Total de clientes de escritorio nightly/desarrollo activos: %d",
+		msgCardDevClients:     "Clientes de escritorio nightly/desarrollo",
+		msgCardDevHeading:     "Compilaciones nightly y de desarrollo de la aplicación de escritorio",
+		msgDiffDev:            "Compilaciones nightly y de desarrollo de la aplicación de escritorio",
+		msgBuildsFound:        "Compilaciones de la aplicación móvil de Mattermost encontradas:",
+		msgColumnBuild:        "COMPILACIÓN",
+		msgOSVersionsFound:    "Versiones de la aplicación de escritorio de Mattermost por versión del SO:",
+		msgColumnOSVersion:    "VERSIÓN DEL SO",
+		msgColumnAppVersion:   "VERSIÓN DE LA APP",
+		msgExpiryFound:        "Clientes activos por caducidad de la sesión:",
+		msgColumnExpires:      "CADUCA",
+		msgColumnDesktop:      "ESCRITORIO",
+		msgColumnMobile:       "MÓVIL",
+		msgExpiresWeek:        "En 7 días o menos",
+		msgExpiresMonth:       "En 8-30 días",
+		msgExpiresQuarter:     "En 31-90 días",
+		msgExpiresLater:       "Después de 90 días",
+		msgExpiresNever:       "Nunca",
+		msgStaleHeading:       "Sesiones activas sin actividad durante %d días o más:",
+		msgStaleTotal:         "%d de %d sesiones activas están inactivas",
+		msgAdoptionFound:      "Adopción entre los usuarios habilitados:",
+		msgColumnClient:       "CLIENTE",
+		msgColumnUsers:        "USUARIOS",
+		msgColumnAdoption:     "ADOPCIÓN",
+		msgAdoptionEither:     "Clientes de escritorio o móviles",
+		msgEnabledUsers:       "Usuarios habilitados: %d",
+		msgLicenseFound:       "Uso de la licencia:",
+		msgNoLicense:          "No se encontró ninguna licencia",
+		msgLicensedSeats:      "Puestos con licencia: %d",
+		msgLicenseClients:     "Clientes activos: %d (%.1f%% de los puestos)",
+		msgLicenseUsers:       "Usuarios activos: %d (%.1f%% de los puestos)",
+		msgLicenseExceeded:    "AVISO: %d usuarios activos superan los %d puestos con licencia",
+		msgExecTitle:          "Versiones de las aplicaciones de Mattermost: resumen a %s",
+		msgExecNoClients:      "No hay clientes de escritorio ni móviles en uso.",
+		msgExecTotals:         "Hay %d clientes de escritorio y móviles en uso: %d de escritorio y %d móviles.",
+		msgExecUpToDate:       "Todos están actualizados.",
+		msgExecOutdated:       "%d de ellos (%.0f%%) usan una versión desactualizada:",
+		msgExecOldDesktop:     "%d de %d clientes de escritorio (%.0f%%) son anteriores a la versión %s.",
+		msgExecOldMobile:      "%d de %d clientes móviles (%.0f%%) son anteriores a la versión %s.",
+		msgExecRisks:          "Principales riesgos:",
+		msgExecNoRisks:        "No hay versiones desactualizadas en uso.",
+		msgExecRiskDesktop:    "%d clientes de escritorio siguen en la versión %s.",
+		msgExecRiskMobile:     "%d clientes móviles siguen en la versión %s.",
+		msgExecRiskDev:        "%d clientes de escritorio usan compilaciones nightly o de desarrollo, que no están pensadas para el uso diario.",
+		msgExecTrend:          "Desde la última comprobación del %s, los clientes en uso han pasado de %d a %d, y los desactualizados de %d a %d.",
+		msgCompareDesktop:     "Versiones de la aplicación de escritorio por servidor (% de los clientes de escritorio del servidor):",
+		msgCompareMobile:      "Versiones de la aplicación móvil por servidor (% de los clientes móviles del servidor):",
+		msgCompareTotal:       "Total de clientes",
+		msgCompareLag:         "Clientes desactualizados por servidor:",
+		msgColumnServer:       "SERVIDOR",
+		msgColumnOutdated:     "DESACTUALIZADOS",
+		msgCompareBaseline:    "Desactualizado significa anterior a la versión de escritorio %s y a la móvil %s.",
+		msgCompareFurthest:    "El más atrasado: %s, con un %.1f%% de clientes desactualizados",
+		msgComplianceFound:    "Cumplimiento por plataforma:",
+		msgColumnPlatform:     "PLATAFORMA",
+		msgColumnMinimum:      "MÍNIMO",
+		msgColumnCompliant:    "CONFORMES",
+		msgColumnThreshold:    "UMBRAL",
+		msgColumnStatus:       "ESTADO",
+		msgComplianceOK:       "OK",
+		msgComplianceBreach:   "INCUMPLIDO",
+		msgPlatformDesktop:    "Escritorio",
+		msgPlatformMobile:     "Móvil",
+		msgPlatformWeb:        "Web",
+		msgMobileDevices:      "Dispositivos móviles únicos: %d",
+		msgAnomaliesFound:     "Sesiones con fechas de caducidad inverosímiles:",
+		msgColumnAnomaly:      "ANOMALÍA",
+		msgAnomalyFarFuture:   "Caducan en más de %d días",
+		msgAnomalyBackwards:   "Caducan antes de crearse",
+		msgAnomalyFromFuture:  "Creadas o usadas en el futuro",
+		msgTeamsFound:         "Clientes activos por equipo:",
+		msgColumnTeam:         "EQUIPO",
+		msgNoTeam:             "(sin equipo)",
+		msgAuthFound:          "Clientes activos por método de inicio de sesión:",
+		msgColumnAuth:         "INICIO DE SESIÓN",
+		msgUnknownAuth:        "desconocido",
+		msgRolesFound:         "Clientes activos por rol:",
+		msgColumnRole:         "ROL",
+		msgRoleAdmin:          "Administrador del sistema",
+		msgRoleMember:         "Miembro",
+		msgRoleGuest:          "Invitado",
+		msgUnknownRole:        "desconocido",
+		msgOutdatedByRole:     "Usuarios con versiones de %s anteriores a %s: %s",
+		msgBlockedFound:       "Sesiones por debajo de las versiones mínimas del servidor:",
+		msgColumnSessions:     "SESIONES",
+		msgPlatformAndroid:    "Android",
+		msgPlatformIOS:        "iOS",
+		msgNoServerMinimums:   "El servidor no exige ninguna versión mínima de las aplicaciones.",
+		msgServerLicense:      "Licencia del servidor Mattermost:",
+		msgEdition:            "Edición: %s",
+		msgEditionFree:        "Free (sin licencia)",
+		msgEditionTrial:       "%s (prueba)",
+		msgLicensedTo:         "Licenciado a: %s",
+		msgLicenseExpires:     "Caduca: %s (en %d días)",
+		msgLicenseExpired:     "Caducada: %s (hace %d días)",
+		msgForgottenHeading:   "Sesiones activas creadas hace %d meses o más, con la versión de escritorio %s o anterior:",
+		msgForgottenTotal:     "%d de %d sesiones activas parecen instalaciones olvidadas",
+		msgPerUserFound:       "Sesiones activas por usuario:",
+		msgColumnPerUser:      "SESIONES POR USUARIO",
+		msgMostSessions:       "Máximo de sesiones activas de un usuario: %d",
+		msgSharedDevicesFound: "ID de dispositivo compartidos por más de un usuario:",
+		msgNoSharedDevices:    "Ningún ID de dispositivo es compartido por más de un usuario",
+		msgColumnDeviceID:     "ID DE DISPOSITIVO",
+		msgColumnUsernames:    "NOMBRES DE USUARIO",
+		msgColumnTotal:        "TOTAL",
+		msgUsersBelowMinimum:  "Usuarios con versiones de %s anteriores a %s",
+		msgPlatformAPI:        "API",
+		msgColumnLastUsed:     "ÚLTIMA ACTIVIDAD",
+		msgColumnCreated:      "CREADA",
+		msgWhoisSessions:      "Sesiones activas: %d",
+		msgWhoisDeactivated:   "Desactivado",
+		msgTenantsFound:       "Cumplimiento de todos los inquilinos:",
+		msgColumnTenant:       "INQUILINO",
+		msgTenantFailed:       "FALLIDO",
+		msgTenantsTotal:       "Inquilinos: %d (%d conformes, %d incumplidos, %d fallidos)",
+		msgDigestTitle:        "Versiones de clientes de Mattermost: cambios desde %s",
+		msgDigestOutdated:     "Usuarios con versiones obsoletas",
+		msgDigestNewOutdated:  "Nuevos usuarios obsoletos (%d)",
+		msgDigestResolved:     "Ya no obsoletos (%d)",
+		msgDigestNoShifts:     "Ningún recuento de versiones ha cambiado.",
+		msgDigestMore:         "...y %d más",
+		msgLegacyFound:        "Compilaciones heredadas de la aplicación de escritorio encontradas:",
+		msgTotalLegacy:        "Total de clientes de escritorio heredados activos: %d",
+		msgDiffLegacy:         "Compilaciones heredadas de la aplicación de escritorio",
+		msgReadinessFound:     "Clientes por debajo de las versiones mínimas del servidor %s:",
+		msgReadinessReady:     "Todos los clientes activos cumplen las versiones mínimas del servidor %s.",
+		msgReadinessUsers:     "%d usuarios tendrían que actualizar antes de que el servidor se actualice a %s.",
+		msgNodesFound:         "Clientes activos por nodo:",
+		msgColumnNode:         "NODO",
+		msgUnknownNode:        "desconocido",
+		msgNodesOutdated:      "Clientes obsoletos por nodo:",
+	},
+}
+
+// tr returns the report string for the key in the current report language.
+func tr(key string) string {
+	if text, ok := translations[reportLanguage][key]; ok {
+		return text
+	}
+	return translations["en"][key]
+}
+
+// trf is tr with formatting.
+func trf(key string, args ...interface{}) string {
+	return fmt.Sprintf(tr(key), args...)
+}
+
+// supportedLanguages lists the available report languages, for help and error messages.
+func supportedLanguages() string {
+	var languages []string
+	for language := range translations {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return strings.Join(languages, ", ")
+}
+
+// setReportLanguage validates and sets the report language.
+func setReportLanguage(language string) error {
+	language = strings.ToLower(language)
+	if _, ok := translations[language]; !ok {
+		return fmt.Errorf("unsupported language %q.  Supported languages are: %s", language, supportedLanguages())
+	}
+	reportLanguage = language
+	return nil
+}
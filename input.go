@@ -0,0 +1,407 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// offlineSource holds session and user data that has been loaded from files, rather than a live database.  Exports
+// contain every session, so the same rules as the database queries are applied when they're read.
+type offlineSource struct {
+	memoryStore
+	// active decides which sessions are read
+	active activeRule
+}
+
+func newOfflineSource() *offlineSource {
+	return &offlineSource{memoryStore: memoryStore{users: make(map[string]UserRecord)}}
+}
+
+func (s *offlineSource) EachSessionUser(ctx context.Context, fn func(session SessionRecord, user *UserRecord) error) error {
+	sessions, err := s.Sessions(ctx)
+	if err != nil {
+		return err
+	}
+	return eachSessionUser(sessions, s.users, fn)
+}
+
+func (s *offlineSource) Sessions(ctx context.Context) ([]SessionRecord, error) {
+	// Apply the same rules as the database queries, so that the results are comparable
+	now := time.Now()
+
+	var active []SessionRecord
+	for _, session := range s.sessions {
+		props := strings.TrimSpace(session.Props)
+		if props == "" || props == "{}" {
+			continue
+		}
+		if s.active.isActive(session, now) {
+			active = append(active, session)
+		}
+	}
+
+	return active, nil
+}
+
+// loadInput loads session data from a support packet or an exported Sessions table, depending on the file type.
+// An export of the Users table can optionally be supplied alongside a Sessions export.
+func loadInput(sessionsFile string, usersFile string) (*offlineSource, error) {
+	ext := strings.ToLower(filepath.Ext(sessionsFile))
+	if ext == ".zip" {
+		if usersFile != "" {
+			LogMessage(warningLevel, "Ignoring users file, since user data is read from the support packet")
+		}
+		return loadSupportPacket(sessionsFile)
+	}
+
+	source := newOfflineSource()
+
+	rows, err := readTableFile(sessionsFile)
+	if err != nil {
+		return nil, err
+	}
+	source.sessions, err = sessionsFromRows(rows)
+	if err != nil {
+		errMsg := fmt.Sprintf("Invalid session data in %s: %v", sessionsFile, err)
+		LogMessage(errorLevel, errMsg)
+		return nil, err
+	}
+
+	if usersFile == "" {
+		LogMessage(warningLevel, "No users file provided.  Lookup results will not include user details.")
+		return source, nil
+	}
+
+	rows, err = readTableFile(usersFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range usersFromRows(rows) {
+		source.users[user.ID] = user
+	}
+
+	return source, nil
+}
+
+// readTableFile reads a CSV or JSON table export from disk.
+func readTableFile(filename string) ([]map[string]string, error) {
+	DebugPrint("Reading table export: " + filename)
+
+	file, err := os.Open(filename)
+	if err != nil {
+		errMsg := fmt.Sprintf("Unable to open input file: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return nil, err
+	}
+	defer file.Close()
+
+	rows, err := readTable(file, strings.ToLower(filepath.Ext(filename)))
+	if err != nil {
+		errMsg := fmt.Sprintf("Unable to parse %s: %v", filename, err)
+		LogMessage(errorLevel, errMsg)
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// loadSupportPacket reads the Sessions and Users table dumps out of a support packet, or any other zip file.  The
+// dumps can be anywhere in the archive, and must be named sessions.json / sessions.csv and users.json / users.csv.
+// The Teams and TeamMembers tables are also read, if present, for the team names in the lookup CSV, the Systems
+// and Licenses tables for the licensed seats, and the server's configuration for its minimum app versions.
+func loadSupportPacket(filename string) (*offlineSource, error) {
+	DebugPrint("Reading support packet: " + filename)
+
+	archive, err := zip.OpenReader(filename)
+	if err != nil {
+		errMsg := fmt.Sprintf("Unable to open support packet: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return nil, err
+	}
+	defer archive.Close()
+
+	source := newOfflineSource()
+	foundSessions := false
+	var teamRows, memberRows, systemRows, licenseRows []map[string]string
+
+	for _, file := range archive.File {
+		name := strings.ToLower(path.Base(file.Name))
+		ext := path.Ext(name)
+		table := strings.TrimSuffix(name, ext)
+		if name == serverConfigFile {
+			if source.serverConfig, err = readArchiveFile(file); err != nil {
+				errMsg := fmt.Sprintf("Unable to read %s from support packet: %v", file.Name, err)
+				LogMessage(errorLevel, errMsg)
+				return nil, err
+			}
+			continue
+		}
+		switch table {
+		case "sessions", "users", "teams", "teammembers", "systems", "licenses":
+		default:
+			continue
+		}
+		if ext != ".json" && ext != ".csv" {
+			continue
+		}
+
+		DebugPrint("Found " + table + " data in support packet: " + file.Name)
+		reader, err := file.Open()
+		if err != nil {
+			errMsg := fmt.Sprintf("Unable to read %s from support packet: %v", file.Name, err)
+			LogMessage(errorLevel, errMsg)
+			return nil, err
+		}
+		rows, err := readTable(reader, ext)
+		reader.Close()
+		if err != nil {
+			errMsg := fmt.Sprintf("Unable to parse %s: %v", file.Name, err)
+			LogMessage(errorLevel, errMsg)
+			return nil, err
+		}
+
+		switch table {
+		case "sessions":
+			sessions, err := sessionsFromRows(rows)
+			if err != nil {
+				errMsg := fmt.Sprintf("Invalid session data in %s: %v", file.Name, err)
+				LogMessage(errorLevel, errMsg)
+				return nil, err
+			}
+			source.sessions = append(source.sessions, sessions...)
+			foundSessions = true
+		case "users":
+			for _, user := range usersFromRows(rows) {
+				source.users[user.ID] = user
+			}
+		case "teams":
+			teamRows = append(teamRows, rows...)
+		case "teammembers":
+			memberRows = append(memberRows, rows...)
+		case "systems":
+			systemRows = append(systemRows, rows...)
+		case "licenses":
+			licenseRows = append(licenseRows, rows...)
+		}
+	}
+	source.teams = teamsFromRows(teamRows, memberRows)
+	source.license = activeLicense(systemRows, licenseRows)
+	source.hasLicenseData = len(systemRows) > 0
+
+	if !foundSessions {
+		err := fmt.Errorf("no sessions.json or sessions.csv found in %s", filename)
+		LogMessage(errorLevel, err.Error())
+		return nil, err
+	}
+	if len(source.users) == 0 {
+		LogMessage(warningLevel, "No user data found in the support packet.  Lookup results will not include user details.")
+	}
+
+	return source, nil
+}
+
+// serverConfigFile is the server's configuration in a support packet, with the secrets removed.
+const serverConfigFile = "sanitized_config.json"
+
+// readArchiveFile reads the whole of a file in a zip archive.
+func readArchiveFile(file *zip.File) (string, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	return string(data), err
+}
+
+// readTable reads a table dump in either CSV (with a header row) or JSON (an array of objects) format.  Each row is
+// returned as a map keyed by lower-case column name, so we don't care whether the dump came from PostgreSQL or MySQL.
+func readTable(r io.Reader, ext string) ([]map[string]string, error) {
+	var rows []map[string]string
+
+	switch ext {
+	case ".csv":
+		reader := csv.NewReader(r)
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, nil
+		}
+		header := records[0]
+		for i := range header {
+			header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+		}
+		for _, record := range records[1:] {
+			row := make(map[string]string, len(header))
+			for i, value := range record {
+				if i < len(header) {
+					row[header[i]] = value
+				}
+			}
+			rows = append(rows, row)
+		}
+
+	case ".json":
+		var objects []map[string]json.RawMessage
+		if err := json.NewDecoder(r).Decode(&objects); err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			row := make(map[string]string, len(object))
+			for key, raw := range object {
+				row[strings.ToLower(key)] = jsonValueString(raw)
+			}
+			rows = append(rows, row)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported file type: %s", ext)
+	}
+
+	return rows, nil
+}
+
+// jsonValueString flattens a JSON value to the string we'd have got from the database.  Props is stored as a JSON
+// string by some export tools and as a nested object by others, so objects are kept as raw JSON.
+func jsonValueString(raw json.RawMessage) string {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			return s
+		}
+	}
+	return string(raw)
+}
+
+func sessionsFromRows(rows []map[string]string) ([]SessionRecord, error) {
+	sessions := make([]SessionRecord, 0, len(rows))
+	for i, row := range rows {
+		expiresAt, err := millisColumn(row, "ExpiresAt", i)
+		if err != nil {
+			return nil, err
+		}
+		lastActivityAt, err := millisColumn(row, "LastActivityAt", i)
+		if err != nil {
+			return nil, err
+		}
+		createAt, err := millisColumn(row, "CreateAt", i)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, SessionRecord{
+			ID:             row["id"],
+			UserID:         row["userid"],
+			Props:          row["props"],
+			DeviceID:       row["deviceid"],
+			ExpiresAt:      expiresAt,
+			LastActivityAt: lastActivityAt,
+			CreateAt:       createAt,
+			IsOAuth:        boolColumn(row, "IsOAuth"),
+		})
+	}
+	return sessions, nil
+}
+
+// millisColumn reads a timestamp column, in milliseconds since the epoch.  A missing or empty column is treated as
+// zero, so that older exports without the column can still be read.
+func millisColumn(row map[string]string, column string, i int) (int64, error) {
+	value := strings.TrimSpace(row[strings.ToLower(column)])
+	if value == "" {
+		return 0, nil
+	}
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("row %d: invalid %s value %q", i+1, column, value)
+	}
+	return millis, nil
+}
+
+// boolColumn reads a boolean column, treating anything missing or unrecognised as false.  MySQL exports can have
+// 0 and 1 rather than true and false, which ParseBool accepts.
+func boolColumn(row map[string]string, column string) bool {
+	value, _ := strconv.ParseBool(strings.TrimSpace(row[strings.ToLower(column)]))
+	return value
+}
+
+// teamsFromRows works out the names of each user's teams from the Teams and TeamMembers tables, ignoring deleted
+// teams and memberships in the same way as the database query.
+func teamsFromRows(teamRows, memberRows []map[string]string) map[string][]string {
+	names := make(map[string]string)
+	for _, row := range teamRows {
+		if deleted(row) {
+			continue
+		}
+		names[row["id"]] = row["displayname"]
+	}
+
+	teams := make(map[string][]string)
+	for _, row := range memberRows {
+		name, ok := names[row["teamid"]]
+		if !ok || deleted(row) {
+			continue
+		}
+		teams[row["userid"]] = append(teams[row["userid"]], name)
+	}
+	for _, userTeams := range teams {
+		sort.Strings(userTeams)
+	}
+	return teams
+}
+
+// deleted reports whether a row has been soft-deleted, which Mattermost does by setting DeleteAt.
+func deleted(row map[string]string) bool {
+	deleteAt := strings.TrimSpace(row["deleteat"])
+	return deleteAt != "" && deleteAt != "0"
+}
+
+func usersFromRows(rows []map[string]string) []UserRecord {
+	users := make([]UserRecord, 0, len(rows))
+	for _, row := range rows {
+		user := UserRecord{
+			ID:        row["id"],
+			Username:  row["username"],
+			Email:     row["email"],
+			FirstName: row["firstname"],
+			LastName:  row["lastname"],
+			Locale:    row["locale"],
+		}
+		if mfaActive, err := strconv.ParseBool(strings.TrimSpace(row["mfaactive"])); err == nil {
+			user.MfaActive = sql.NullBool{Bool: mfaActive, Valid: true}
+		}
+		if authService, ok := row["authservice"]; ok {
+			user.AuthService = sql.NullString{String: authService, Valid: true}
+		}
+		if roles, ok := row["roles"]; ok {
+			user.Roles = sql.NullString{String: roles, Valid: true}
+		}
+		if authData, ok := row["authdata"]; ok {
+			user.AuthData = sql.NullString{String: authData, Valid: true}
+		}
+		if deleteAt, err := strconv.ParseInt(strings.TrimSpace(row["deleteat"]), 10, 64); err == nil {
+			user.DeleteAt = deleteAt
+		}
+		// Keep every column, since any of them could be configured as an extra column for the lookup CSV
+		user.Extra = row
+		users = append(users, user)
+	}
+	return users
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ldapDirectory looks up users in an LDAP directory, to add directory attributes such as department, manager and
+// office to the lookup CSV.  Mattermost keeps each LDAP user's ID attribute in Users.AuthData, so that's what the
+// directory is searched by.
+type ldapDirectory struct {
+	conn        *ldap.Conn
+	baseDN      string
+	idAttribute string
+	attributes  []string
+	// cache holds the attributes found for each AuthData value, since a user can have many sessions
+	cache map[string]map[string]string
+}
+
+// openLDAP connects and binds to the directory given in the config file.
+func openLDAP(config *Config) (*ldapDirectory, error) {
+	settings := config.LDAP
+	DebugPrint("Connecting to LDAP server: " + settings.URL)
+
+	dialer := &net.Dialer{Timeout: connectionTestTimeout}
+	tlsConfig := &tls.Config{InsecureSkipVerify: settings.InsecureSkipVerify}
+	conn, err := ldap.DialURL(settings.URL, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		errMsg := fmt.Sprintf("Unable to connect to LDAP server: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return nil, err
+	}
+
+	if settings.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			errMsg := fmt.Sprintf("Unable to start TLS with LDAP server: %v", err)
+			LogMessage(errorLevel, errMsg)
+			return nil, err
+		}
+	}
+
+	if settings.BindDN != "" {
+		if err := conn.Bind(settings.BindDN, settings.BindPassword); err != nil {
+			conn.Close()
+			errMsg := fmt.Sprintf("Unable to bind to LDAP server as %s: %v", settings.BindDN, err)
+			LogMessage(errorLevel, errMsg)
+			return nil, err
+		}
+	}
+
+	return &ldapDirectory{
+		conn:        conn,
+		baseDN:      settings.BaseDN,
+		idAttribute: settings.IDAttribute,
+		attributes:  settings.Attributes,
+		cache:       make(map[string]map[string]string),
+	}, nil
+}
+
+// lookup returns the configured attributes for the user with the given AuthData.  A user that isn't found in the
+// directory isn't an error, and has no attributes.
+func (d *ldapDirectory) lookup(authData string) (map[string]string, error) {
+	if attributes, ok := d.cache[authData]; ok {
+		return attributes, nil
+	}
+
+	filter := fmt.Sprintf("(%s=%s)", d.idAttribute, ldap.EscapeFilter(authData))
+	TracePrint("Searching LDAP for: " + filter)
+	request := ldap.NewSearchRequest(d.baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		filter, d.attributes, nil)
+	result, err := d.conn.Search(request)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error searching LDAP for %s: %v", authData, err)
+		LogMessage(errorLevel, errMsg)
+		return nil, err
+	}
+
+	attributes := make(map[string]string)
+	switch len(result.Entries) {
+	case 0:
+		TracePrint("No LDAP entry found for: " + authData)
+	case 1:
+		for _, attribute := range d.attributes {
+			attributes[attribute] = strings.Join(result.Entries[0].GetAttributeValues(attribute), "; ")
+		}
+	default:
+		LogMessage(warningLevel, "More than one LDAP entry found for "+authData+".  Leaving its directory attributes empty.")
+	}
+
+	d.cache[authData] = attributes
+	return attributes, nil
+}
+
+func (d *ldapDirectory) Close() {
+	d.conn.Close()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// licenseSignatureSize is the length of the RSA signature that Mattermost appends to the license JSON.
+const licenseSignatureSize = 256
+
+// errNoLicenseData is returned by an offline source without the Systems and Licenses tables, which can't tell
+// whether there's a license or not.
+var errNoLicenseData = errors.New("the input doesn't include the Systems and Licenses tables")
+
+// licenseInfo is what the report needs from the server's license.
+type licenseInfo struct {
+	Seats int
+	// Edition is the license's SKU, e.g. Enterprise or Professional
+	Edition  string
+	Customer string
+	// ExpiresAt is when the license expires, in milliseconds since the epoch
+	ExpiresAt int64
+	Trial     bool
+}
+
+// parseLicense reads a license, as stored in the Licenses table: the license JSON followed by its signature, base64
+// encoded.  The signature isn't checked, since the server has already done that when the license was uploaded.
+func parseLicense(encoded string) (*licenseInfo, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("license isn't valid base64: %w", err)
+	}
+	if len(decoded) <= licenseSignatureSize {
+		return nil, fmt.Errorf("license is too short")
+	}
+
+	var license struct {
+		Customer struct {
+			Name    string `json:"name"`
+			Company string `json:"company"`
+		} `json:"customer"`
+		Features struct {
+			Users *int `json:"users"`
+		} `json:"features"`
+		ExpiresAt    int64  `json:"expires_at"`
+		SkuName      string `json:"sku_name"`
+		SkuShortName string `json:"sku_short_name"`
+		IsTrial      bool   `json:"is_trial"`
+	}
+	if err := json.Unmarshal(decoded[:len(decoded)-licenseSignatureSize], &license); err != nil {
+		return nil, fmt.Errorf("license isn't valid JSON: %w", err)
+	}
+	if license.Features.Users == nil {
+		return nil, fmt.Errorf("license doesn't include a number of users")
+	}
+
+	info := &licenseInfo{Seats: *license.Features.Users, Customer: license.Customer.Company, ExpiresAt: license.ExpiresAt,
+		Trial: license.IsTrial, Edition: license.SkuName}
+	if info.Customer == "" {
+		info.Customer = license.Customer.Name
+	}
+	if info.Edition == "" {
+		info.Edition = license.SkuShortName
+	}
+	return info, nil
+}
+
+// activeLicense finds the active license in the Systems and Licenses tables, returning an empty string if there
+// isn't one.
+func activeLicense(systemRows, licenseRows []map[string]string) string {
+	licenseID := ""
+	for _, row := range systemRows {
+		if row["name"] == "ActiveLicenseId" {
+			licenseID = row["value"]
+		}
+	}
+	for _, row := range licenseRows {
+		if licenseID != "" && row["id"] == licenseID {
+			return row["bytes"]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Settings for logging to a file, set from the command line.
+var logFilePath string
+var logMaxSizeMB int = 10
+var logMaxAgeDays int = 30
+
+// Settings for logging to syslog or the Windows Event Log, set from the command line.
+var useSystemLog bool
+var syslogAddress string
+
+// systemLogger sends messages to the operating system's logging service - syslog on Unix, or the Event Log on
+// Windows.
+type systemLogger interface {
+	Log(level LogLevel, message string) error
+	Close() error
+}
+
+// rotatingFile is a log file that is rotated once it reaches a maximum size.  Rotated files are renamed with a
+// timestamp, and removed once they're older than the maximum age.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	file    *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxSizeMB int, maxAgeDays int) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:    path,
+		maxSize: int64(maxSizeMB) * 1024 * 1024,
+		maxAge:  time.Duration(maxAgeDays) * 24 * time.Hour,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	r.removeExpired()
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			// Keep logging to the current file rather than losing messages
+			fmt.Fprintf(os.Stderr, "Unable to rotate log file: %v\n", err)
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file with a timestamp and starts a new one.
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(r.path)
+	rotated := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(r.path, ext), time.Now().Format("20060102-150405.000"), ext)
+	if err := os.Rename(r.path, rotated); err != nil {
+		// Reopen the original so that we can carry on writing to it
+		if openErr := r.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.removeExpired()
+	return nil
+}
+
+// rotatedFiles lists the previously rotated files, oldest first.
+func (r *rotatingFile) rotatedFiles() []string {
+	ext := filepath.Ext(r.path)
+	matches, err := filepath.Glob(strings.TrimSuffix(r.path, ext) + "-*" + ext)
+	if err != nil {
+		return nil
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// removeExpired deletes rotated files older than the maximum age.
+func (r *rotatingFile) removeExpired() {
+	if r.maxAge <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-r.maxAge)
+	for _, name := range r.rotatedFiles() {
+		if info, err := os.Stat(name); err == nil && info.ModTime().Before(cutoff) {
+			os.Remove(name)
+		}
+	}
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
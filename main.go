@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/csv"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
 	_ "github.com/lib/pq"
 	"github.com/spf13/viper"
 )
@@ -21,6 +28,8 @@ var Version = "development" // default value
 
 var defaultOutputFile = "users.csv"
 
+var defaultSnapshotFile = "snapshot.json"
+
 type Config struct {
 	DB struct {
 		Type     string `json:"type"`
@@ -30,28 +39,118 @@ type Config struct {
 		User     string `json:"user"`
 		Password string `json:"password"`
 	} `json:"db"`
+	Webhook struct {
+		URL    string `json:"url"`
+		Format string `json:"format"`
+	} `json:"webhook"`
+	Lookup struct {
+		UserColumns  []string `mapstructure:"user_columns" json:"user_columns"`
+		EmailHMACKey string   `mapstructure:"email_hmac_key" json:"email_hmac_key"`
+		PseudonymKey string   `mapstructure:"pseudonym_key" json:"pseudonym_key"`
+	} `json:"lookup"`
+	// Bot is a Mattermost bot account, for sending direct messages through the API
+	Bot struct {
+		URL   string `json:"url"`
+		Token string `json:"token"`
+		// Admins are the usernames to message with '-notify-admins', instead of every system admin
+		Admins []string `json:"admins,omitempty"`
+	} `json:"bot"`
+	LDAP struct {
+		URL                string   `json:"url"`
+		BindDN             string   `mapstructure:"bind_dn" json:"bind_dn"`
+		BindPassword       string   `mapstructure:"bind_password" json:"bind_password"`
+		BaseDN             string   `mapstructure:"base_dn" json:"base_dn"`
+		IDAttribute        string   `mapstructure:"id_attribute" json:"id_attribute"`
+		Attributes         []string `json:"attributes"`
+		StartTLS           bool     `mapstructure:"start_tls" json:"start_tls"`
+		InsecureSkipVerify bool     `mapstructure:"insecure_skip_verify" json:"insecure_skip_verify"`
+	} `json:"ldap"`
+	Encryption struct {
+		AgeRecipients []string `mapstructure:"age_recipients" json:"age_recipients"`
+		GPGKeys       []string `mapstructure:"gpg_keys" json:"gpg_keys"`
+	} `json:"encryption"`
+	Privacy struct {
+		AggregateOnly bool `mapstructure:"aggregate_only" json:"aggregate_only"`
+	} `json:"privacy"`
+	Sessions struct {
+		// Active is what makes a session active: activeByExpiry, activeByActivity or activeByBoth
+		Active     string `json:"active"`
+		ActiveDays int    `mapstructure:"active_days" json:"active_days,omitempty"`
+		// Where is an extra condition on the Sessions query, with a ? for each of Params
+		Where  string        `json:"where,omitempty"`
+		Params []interface{} `json:"params,omitempty"`
+		// LegacyDesktopPatterns replace mmversions.DefaultLegacyDesktopPatterns for recognising old desktop builds
+		LegacyDesktopPatterns []string `mapstructure:"legacy_desktop_patterns" json:"legacy_desktop_patterns,omitempty"`
+		// NodeProps are the session props that name the app node or connection origin, in order of preference, and
+		// NodePools group the addresses among them, e.g. by load balancer pool
+		NodeProps []string   `mapstructure:"node_props" json:"node_props,omitempty"`
+		NodePools []nodePool `mapstructure:"node_pools" json:"node_pools,omitempty"`
+	} `json:"sessions"`
+	Report struct {
+		MinDesktopVersion string `mapstructure:"min_desktop_version" json:"min_desktop_version"`
+		MinMobileVersion  string `mapstructure:"min_mobile_version" json:"min_mobile_version"`
+		// MinBrowserVersions is the oldest major version of each browser that's compliant, e.g. {"Chrome": 120}
+		MinBrowserVersions map[string]int `mapstructure:"min_browser_versions" json:"min_browser_versions,omitempty"`
+		// The thresholds are the percentage of each platform's clients that must be compliant, or 0 for no check
+		DesktopThreshold float64 `mapstructure:"desktop_threshold" json:"desktop_threshold,omitempty"`
+		MobileThreshold  float64 `mapstructure:"mobile_threshold" json:"mobile_threshold,omitempty"`
+		WebThreshold     float64 `mapstructure:"web_threshold" json:"web_threshold,omitempty"`
+		// MaxSessionDays is the longest plausible session, beyond which a session's expiry time is reported as an
+		// anomaly.  It defaults to defaultMaxSessionDays.
+		MaxSessionDays int `mapstructure:"max_session_days" json:"max_session_days,omitempty"`
+	} `json:"report"`
+	// Upgrade lists the minimum app versions of the Mattermost server releases, for checking readiness for an upgrade
+	Upgrade struct {
+		Releases []serverRelease `json:"releases,omitempty"`
+	} `json:"upgrade"`
+	Signing struct {
+		Checksums   bool   `json:"checksums"`
+		MinisignKey string `mapstructure:"minisign_key" json:"minisign_key"`
+		GPGKey      string `mapstructure:"gpg_key" json:"gpg_key"`
+		Passphrase  string `json:"passphrase"`
+	} `json:"signing"`
+	Upload struct {
+		URL string `json:"url"`
+		// Password, SSHKey and KnownHosts are only used for SFTP and WebDAV, since the object stores use their
+		// provider's own credentials
+		Password   string `json:"password"`
+		SSHKey     string `mapstructure:"ssh_key" json:"ssh_key"`
+		KnownHosts string `mapstructure:"known_hosts" json:"known_hosts"`
+	} `json:"upload"`
+	Output struct {
+		LookupFile   string `mapstructure:"lookup_file" json:"lookup_file"`
+		SnapshotFile string `mapstructure:"snapshot_file" json:"snapshot_file"`
+		DigestFile   string `mapstructure:"digest_file" json:"digest_file"`
+		Lang         string `json:"lang"`
+	} `json:"output"`
+	// Profiles holds named sets of settings, e.g. for prod and staging, which are merged over the top-level settings
+	// when selected with '-profile'.
+	Profiles map[string]interface{} `json:"profiles,omitempty"`
+	// Tenants holds the settings for each customer, for a managed service running the 'tenants' command.  Like
+	// profiles, each tenant only needs the settings that differ from the top level, usually the database.
+	Tenants map[string]interface{} `json:"tenants,omitempty"`
 }
 
-type Props struct {
-	Browser  string `json:"browser"`
-	OS       string `json:"os"`
-	IsMobile string `json:"isMobile"`
-	DeviceID string `json:"deviceid"`
-}
+// The session and version types are shared with the analysis package.
+type Props = mmversions.Props
+type VersionInfo = mmversions.VersionInfo
+type VersionCount = mmversions.VersionCount
 
-type VersionInfo struct {
-	OS    string
-	Count int
-}
+var debugMode bool = false
 
-type VersionCount map[string][]VersionInfo
+// quietMode suppresses everything except errors, and traceMode adds per-session detail on top of debug mode.
+var quietMode bool = false
+var traceMode bool = false
 
-var debugMode bool = false
+// containerMode is set with '-container', for running as a Kubernetes CronJob or similar: settings only come from
+// the environment, results go to stdout, log messages go to stderr as JSON, and no local files are written.
+var containerMode bool = false
 
 // LogLevel is used to refer to the type of message that will be written using the logging code.
 type LogLevel string
 
 const (
+	traceLevel   LogLevel = "TRACE"
 	debugLevel   LogLevel = "DEBUG"
 	infoLevel    LogLevel = "INFO"
 	warningLevel LogLevel = "WARNING"
@@ -60,31 +159,143 @@ const (
 
 // Logging functions
 
-// LogMessage logs a formatted message to stdout or stderr
+// The loggers are kept separate, rather than switching the output of the standard logger, so that messages from
+// concurrent requests in serve mode can't end up in the wrong place.
+var stdoutLogger = log.New(os.Stdout, "", log.Ldate|log.Ltime)
+var stderrLogger = log.New(os.Stderr, "", log.Ldate|log.Ltime)
+
+// stdoutReserved is set when a command writes JSON, CSV or a file to stdout, so that the log messages go to stderr instead
+// and the output can be piped into other tools as it is.
+var stdoutReserved bool
+
+// reserveStdout sends the log messages to stderr if the output file is stdout, given as "-".
+func reserveStdout(filename string) {
+	if filename == "-" {
+		stdoutReserved = true
+	}
+}
+
+// logFileLogger is set when logging to a file with '-log-file', and systemLog when logging with '-system-log'
+var logFileLogger *log.Logger
+var systemLog systemLogger
+
+// LogMessage logs a formatted message to stdout or stderr, or to the log file and/or system log if they have been
+// configured.  When logging elsewhere, errors are still written to stderr as well, so that failures aren't missed.
 func LogMessage(level LogLevel, message string) {
-	if level == errorLevel {
-		log.SetOutput(os.Stderr)
+	if quietMode && level != errorLevel {
+		return
+	}
+
+	if containerMode {
+		writeJSONLog(level, message)
+		return
+	}
+
+	if logFileLogger != nil || systemLog != nil {
+		if logFileLogger != nil {
+			logFileLogger.Printf("[%s] %s\n", level, message)
+		}
+		if systemLog != nil {
+			if err := systemLog.Log(level, message); err != nil {
+				stderrLogger.Printf("[%s] Unable to write to system log: %v\n", errorLevel, err)
+			}
+		}
+		if level == errorLevel {
+			stderrLogger.Printf("[%s] %s\n", level, message)
+		}
+		return
+	}
+
+	if level == errorLevel || stdoutReserved {
+		stderrLogger.Printf("[%s] %s\n", level, message)
 	} else {
-		log.SetOutput(os.Stdout)
+		stdoutLogger.Printf("[%s] %s\n", level, message)
+	}
+}
+
+// jsonLogEntry is a log message as written in container mode.
+type jsonLogEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"msg"`
+}
+
+// writeJSONLog writes a log message to stderr as a single line of JSON, which log collectors can parse without any
+// configuration.
+func writeJSONLog(level LogLevel, message string) {
+	line, err := json.Marshal(jsonLogEntry{Time: time.Now().UTC(), Level: strings.ToLower(string(level)), Message: message})
+	if err != nil {
+		return
 	}
-	log.SetFlags(log.Ldate | log.Ltime)
-	log.Printf("[%s] %s\n", level, message)
+	jsonLogMu.Lock()
+	defer jsonLogMu.Unlock()
+	os.Stderr.Write(append(line, '\n'))
 }
 
+// jsonLogMu stops log messages from concurrent requests in serve mode being interleaved.
+var jsonLogMu sync.Mutex
+
 // DebugPrint allows us to add debug messages into our code, which are only printed if we're running in debug more.
 // Note that the command line parameter '-debug' can be used to enable this at runtime.
 func DebugPrint(message string) {
-	if debugMode {
+	if debugMode || traceMode {
 		LogMessage(debugLevel, message)
 	}
 }
 
-func loadConfig(configFile string) (*Config, error) {
-	viper.SetConfigFile(configFile)
-	if err := viper.ReadInConfig(); err != nil {
-		errMsg := fmt.Sprintf("Error reading config file, %s", err)
-		LogMessage(errorLevel, errMsg)
-		return nil, err
+// TracePrint is for the very noisy messages, such as those written for every session, which are only printed when
+// running with '-vv'.
+func TracePrint(message string) {
+	if traceMode {
+		LogMessage(traceLevel, message)
+	}
+}
+
+// envPrefix is the prefix for environment variables that override the config file, e.g. MMDV_DB_PASSWORD.
+const envPrefix = "MMDV"
+
+// loadConfig reads the config file, applying the profile and then the tenant if they're given.  Settings from the config file are
+// overridden by environment variables, which are in turn overridden by command-line flags (see openSource).  If no
+// config file is given, the settings come from the environment alone.
+func loadConfig(configFile string, profile string, tenant string) (*Config, error) {
+	viper.SetDefault("webhook.format", webhookMattermost)
+	viper.SetDefault("output.lookup_file", defaultOutputFile)
+	viper.SetDefault("output.snapshot_file", defaultSnapshotFile)
+	viper.SetDefault("output.digest_file", defaultDigestFile)
+	viper.SetDefault("output.lang", "en")
+	viper.SetDefault("sessions.active", activeByExpiry)
+
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+		if err := viper.ReadInConfig(); err != nil {
+			errMsg := fmt.Sprintf("Error reading config file, %s", err)
+			LogMessage(errorLevel, errMsg)
+			return nil, err
+		}
+	}
+
+	if profile != "" {
+		if err := selectProfile(viper.GetViper(), profile); err != nil {
+			LogMessage(errorLevel, err.Error())
+			return nil, err
+		}
+		DebugPrint("Using profile: " + profile)
+	}
+	if tenant != "" {
+		if err := selectTenant(viper.GetViper(), tenant); err != nil {
+			LogMessage(errorLevel, err.Error())
+			return nil, err
+		}
+		DebugPrint("Using tenant: " + tenant)
+	}
+
+	// Bind every setting explicitly, since viper only looks up environment variables for keys it already knows about
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	for _, key := range configKeys(reflect.TypeOf(Config{}), "") {
+		if key != "profiles" && key != "tenants" {
+			viper.BindEnv(key)
+		}
 	}
 
 	var config Config
@@ -97,22 +308,77 @@ func loadConfig(configFile string) (*Config, error) {
 	return &config, nil
 }
 
-func connectDatabase(config *Config) (*sql.DB, error) {
-	var db *sql.DB
-	var err error
+// selectProfile merges the named profile over the top-level settings, so that a profile only needs to contain the
+// settings that differ.
+func selectProfile(v *viper.Viper, profile string) error {
+	return selectSection(v, "profiles", "profile", profile)
+}
 
-	if config.DB.Type == "postgresql" {
-		db, err = sql.Open("postgres", fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-			config.DB.Host, config.DB.Port, config.DB.User, config.DB.Password, config.DB.Name))
-	} else if config.DB.Type == "mysql" {
-		db, err = sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
-			config.DB.User, config.DB.Password, config.DB.Host, config.DB.Port, config.DB.Name))
-	} else {
-		errMsg := fmt.Sprintf("Unsupported DB type: %s", config.DB.Type)
+// selectTenant merges the named tenant over the top-level settings, in the same way as a profile.
+func selectTenant(v *viper.Viper, tenant string) error {
+	return selectSection(v, "tenants", "tenant", tenant)
+}
+
+// selectSection merges one of the named sets of settings in section over the top-level settings.  kind names the
+// set in errors.
+func selectSection(v *viper.Viper, section, kind, name string) error {
+	key := section + "." + strings.ToLower(name)
+	if !v.IsSet(key) {
+		names := sectionNames(v, section)
+		if len(names) == 0 {
+			return fmt.Errorf("%s %q not found, as the config file doesn't contain any %s", kind, name, section)
+		}
+		return fmt.Errorf("%s %q not found.  The config file contains: %s", kind, name, strings.Join(names, ", "))
+	}
+	return v.MergeConfigMap(v.GetStringMap(key))
+}
+
+// profileNames lists the profiles in the config file, in alphabetical order.
+func profileNames(v *viper.Viper) []string {
+	return sectionNames(v, "profiles")
+}
+
+// tenantNames lists the tenants in the config file, in alphabetical order.
+func tenantNames(v *viper.Viper) []string {
+	return sectionNames(v, "tenants")
+}
+
+func sectionNames(v *viper.Viper, section string) []string {
+	var names []string
+	for name := range v.GetStringMap(section) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// connectDatabase opens the database and checks that it can be reached, so that a database that's down is reported
+// as a connection failure rather than a failed query.
+func connectDatabase(ctx context.Context, config *Config) (*sql.DB, error) {
+	db, err := openDatabase(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		errMsg := fmt.Sprintf("Error connecting to database: %v", err)
 		LogMessage(errorLevel, errMsg)
 		return nil, err
 	}
 
+	return db, nil
+}
+
+// openDatabase prepares the database handle.  No connection is made until it's first used.
+func openDatabase(config *Config) (*sql.DB, error) {
+	d, err := dialectFor(config.DB.Type)
+	if err != nil {
+		LogMessage(errorLevel, "Unsupported DB type: "+config.DB.Type)
+		return nil, err
+	}
+
+	db, err := sql.Open(d.driver(), d.dsn(config))
 	if err != nil {
 		errMsg := fmt.Sprintf("Error opening database: %v", err)
 		LogMessage(errorLevel, errMsg)
@@ -122,419 +388,924 @@ func connectDatabase(config *Config) (*sql.DB, error) {
 	return db, nil
 }
 
-func splitVersion(version string) (int, int, int, error) {
-	parts := strings.Split(version, ".")
-	if len(parts) != 3 {
-		return 0, 0, 0, fmt.Errorf("invalid version format")
+// lookupOptions are the optional extras for the lookup CSV.
+type lookupOptions struct {
+	includeTeams     bool
+	includeSessionID bool
+	userColumns      []string
+	// adminsFirst puts system admins at the top of the CSV, since they're the most important to upgrade
+	adminsFirst bool
+	// redact replaces names, emails and device IDs with pseudonyms, so the CSV can be shared outside the organisation
+	redact bool
+	// pseudonymKey is the secret that the pseudonyms are keyed with
+	pseudonymKey string
+	// emailHMACKey is set when emails are replaced by their HMAC, so they can be joined with other datasets
+	emailHMACKey string
+	// encryption is set when the CSV is to be encrypted
+	encryption *outputEncryption
+	// format is the name of the output writer, and config is passed on to it
+	format string
+	config *Config
+	// directory is set when the lookup CSV is enriched with LDAP attributes
+	directory *ldapDirectory
+	// limit, if set, keeps only this many users, starting with those on the oldest versions
+	limit int
+	// dedupeUsers writes one row for each user, rather than one for each outdated session
+	dedupeUsers bool
+	// onlyUsers, if set, limits the lookup to the users with these lower-case usernames or emails
+	onlyUsers map[string]bool
+	// userPattern, if set, limits the lookup to the users whose username or email matches it
+	userPattern *userPattern
+}
+
+// lookupMatch is a desktop session at or below the lookup version.
+type lookupMatch struct {
+	session SessionRecord
+	client  mmversions.Client
+	// newest and sessionIDs are set when the user's outdated sessions are merged into one row, which then shows the
+	// session with the oldest version
+	newest     string
+	sessionIDs []string
+}
+
+// dedupeLookup merges each user's outdated sessions into the one with the oldest version, noting the newest version
+// and every session's ID.  Desktop and mobile sessions are merged separately, since their versions can't be compared.
+// The users stay in the order they were first seen.
+func dedupeLookup(matches []lookupMatch) []lookupMatch {
+	type key struct {
+		userID string
+		kind   mmversions.ClientKind
 	}
+	var merged []lookupMatch
+	index := make(map[key]int)
+	for _, match := range matches {
+		k := key{match.session.UserID, match.client.Kind}
+		i, seen := index[k]
+		if !seen {
+			match.newest = match.client.Version
+			match.sessionIDs = []string{match.session.ID}
+			index[k] = len(merged)
+			merged = append(merged, match)
+			continue
+		}
 
-	major, err := strconv.Atoi(parts[0])
-	if err != nil {
-		return 0, 0, 0, err
+		user := &merged[i]
+		user.sessionIDs = append(user.sessionIDs, match.session.ID)
+		if mmversions.Less(user.newest, match.client.Version) {
+			user.newest = match.client.Version
+		}
+		if mmversions.Less(match.client.Version, user.client.Version) {
+			user.session, user.client = match.session, match.client
+		}
 	}
+	return merged
+}
 
-	minor, err := strconv.Atoi(parts[1])
-	if err != nil {
-		return 0, 0, 0, err
+// limitLookup keeps the sessions of the limit users with the oldest versions, ordered from the oldest version.  A
+// user's position is decided by their oldest session, and all of their outdated sessions are kept.  Desktop versions
+// can't be compared with mobile ones, so desktop sessions come first when there are both.
+func limitLookup(matches []lookupMatch, limit int) []lookupMatch {
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].client.Kind != matches[j].client.Kind {
+			return matches[i].client.Kind == mmversions.Desktop
+		}
+		return mmversions.Less(matches[i].client.Version, matches[j].client.Version)
+	})
+
+	users := make(map[string]bool)
+	for _, match := range matches {
+		if len(users) == limit {
+			break
+		}
+		users[match.session.UserID] = true
 	}
 
-	patch, err := strconv.Atoi(parts[2])
+	var limited []lookupMatch
+	for _, match := range matches {
+		if users[match.session.UserID] {
+			limited = append(limited, match)
+		}
+	}
+	return limited
+}
+
+// readUserList reads the usernames and emails to limit a lookup to, one per line.  Blank lines and lines starting with
+// # are ignored, and usernames can be given with or without an @.
+func readUserList(filename string) (map[string]bool, error) {
+	data, err := os.ReadFile(filename)
 	if err != nil {
-		return 0, 0, 0, err
+		return nil, err
 	}
+	names := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names[strings.ToLower(strings.TrimPrefix(line, "@"))] = true
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no users listed in %s", filename)
+	}
+	return names, nil
+}
 
-	return major, minor, patch, nil
+// listedName returns the username or email that a user is listed under, or "" if they're not listed.
+func listedName(users []UserRecord, names map[string]bool) string {
+	for _, user := range users {
+		for _, name := range []string{user.Username, user.Email} {
+			if name != "" && names[strings.ToLower(name)] {
+				return strings.ToLower(name)
+			}
+		}
+	}
+	return ""
 }
 
-func isOlderOrEqual(version, lookupVersion string) (bool, error) {
-	vMajor, vMinor, vPatch, err := splitVersion(version)
-	if err != nil {
-		return false, err
+// userPattern matches usernames and emails with a glob, e.g. *@contractor.example.com, or a regular expression
+// between slashes, e.g. /^ext-/.  Both ignore case.  A leading ! matches the users that don't match the rest.
+type userPattern struct {
+	glob    string
+	re      *regexp.Regexp
+	exclude bool
+}
+
+func parseUserPattern(pattern string) (*userPattern, error) {
+	p := &userPattern{}
+	if rest, ok := strings.CutPrefix(pattern, "!"); ok {
+		p.exclude = true
+		pattern = rest
+	}
+	if pattern == "" {
+		return nil, errors.New("empty pattern")
+	}
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile("(?i)" + pattern[1:len(pattern)-1])
+		if err != nil {
+			return nil, err
+		}
+		p.re = re
+		return p, nil
+	}
+	p.glob = strings.ToLower(pattern)
+	if _, err := path.Match(p.glob, ""); err != nil {
+		return nil, fmt.Errorf("invalid glob %q: %w", pattern, err)
+	}
+	return p, nil
+}
+
+// matches reports whether any of the user's records has a username or email that matches the pattern, or none do
+// for an excluding pattern.  Users who can't be found never match, so they're only included by an excluding pattern.
+func (p *userPattern) matches(users []UserRecord) bool {
+	matched := false
+	for _, user := range users {
+		for _, name := range []string{user.Username, user.Email} {
+			if name == "" {
+				continue
+			}
+			if p.re != nil {
+				matched = matched || p.re.MatchString(name)
+			} else if ok, _ := path.Match(p.glob, strings.ToLower(name)); ok {
+				matched = true
+			}
+		}
+	}
+	return matched != p.exclude
+}
+
+// lookupVersions are the versions that a lookup finds the clients at or below, for each kind of client it covers.
+type lookupVersions map[mmversions.ClientKind]string
+
+// desktopOnly is the lookup for desktop clients at or below a version.
+func desktopOnly(version string) lookupVersions {
+	return lookupVersions{mmversions.Desktop: version}
+}
+
+// String describes the versions for log messages, e.g. "desktop version v5.5.0 or mobile version v2.13.0".
+func (v lookupVersions) String() string {
+	var versions []string
+	for _, kind := range []mmversions.ClientKind{mmversions.Desktop, mmversions.Mobile} {
+		if version, ok := v[kind]; ok {
+			versions = append(versions, fmt.Sprintf("%s version v%s", kind, version))
+		}
 	}
+	return strings.Join(versions, " or ")
+}
 
-	lvMajor, lvMinor, lvPatch, err := splitVersion(lookupVersion)
+// matchLookupSession reports whether a session is from a client at or below the lookup version for its kind.
+// Versions that can't be parsed are included, so that nobody is missed.  Nightly and developer builds are compared on
+// the release they're building towards, e.g. 5.9.0 for 5.9.0-nightly.20240601.
+func matchLookupSession(session SessionRecord, versions lookupVersions) (lookupMatch, bool) {
+	client, err := mmversions.Classify(session)
 	if err != nil {
-		return false, err
+		errMsg := fmt.Sprintf("Error unmarshalling JSON: %v", err)
+		LogMessage(warningLevel, errMsg)
+		return lookupMatch{}, false
 	}
 
-	if vMajor < lvMajor {
-		return true, nil
+	lookupVersion, ok := versions[client.Kind]
+	if !ok {
+		TracePrint(fmt.Sprintf("Not a %s client.  Skipping for lookup.", versions))
+		return lookupMatch{}, false
+	}
+	if client.Version == "" {
+		return lookupMatch{}, false
 	}
-	if vMajor > lvMajor {
-		return false, nil
+	if client.Version == mmversions.PlaceholderVersion {
+		debugMessage := fmt.Sprintf("Troubleshooting: %s", session.Props)
+		TracePrint(debugMessage)
+		return lookupMatch{}, false
 	}
 
-	// If major versions are equal, compare minor versions
-	if vMinor < lvMinor {
-		return true, nil
+	version := client.Version
+	if client.DevBuild {
+		version, _, _ = strings.Cut(version, "-")
 	}
-	if vMinor > lvMinor {
-		return false, nil
+	processRow, err := mmversions.OlderOrEqual(version, lookupVersion)
+	if err != nil {
+		LogMessage(warningLevel, "Unable to parse version string: "+client.Version)
+		processRow = true
 	}
+	return lookupMatch{session: session, client: client}, processRow
+}
 
-	// If minor versions are equal, compare patch versions
-	return vPatch <= lvPatch, nil
+// lookupUser is a user with outdated sessions, along with how many sessions they have in total and how many of them
+// are outdated, so that each row can say how many upgrades the user needs.
+type lookupUser struct {
+	user     UserRecord
+	active   int
+	outdated int
 }
 
-func doLookup(db *sql.DB, dbType string, outputFilename string, lookupVersion string) error {
+func doLookup(ctx context.Context, source Store, outputFilename string, versions lookupVersions, options lookupOptions) error {
+
+	DebugPrint("Running doLookup.  Writing output to: " + outputFilename + " - Processing " + versions.String() + " and earlier")
 
-	DebugPrint("Running doLookup.  Writing output to: " + outputFilename + " - Processing desktop version prior to " + lookupVersion)
+	// Build the header row
+	header := []string{"Version", "OS", "Username", "Email", "First Name", "Last Name", "Last Activity", "Created", "Session Age (Days)", "MFA Active", "Auth Service", "Locale", "Is Admin",
+		"Active Sessions", "Outdated Sessions", "Dev Build"}
+	// The client and device ID are only needed to tell the mobile sessions apart
+	_, withMobile := versions[mmversions.Mobile]
+	if withMobile {
+		header = append(header, "Client", "Device ID")
+	}
+	if options.dedupeUsers {
+		header = append(header, "Newest Version")
+	}
+	if options.includeSessionID {
+		header = append(header, "Session ID")
+	}
+	if options.includeTeams {
+		header = append(header, "Teams")
+	}
+	header = append(header, options.userColumns...)
+	if options.directory != nil {
+		header = append(header, options.directory.attributes...)
+	}
+
+	// Every user's teams are read in one go, rather than with a query for each row
+	var teams map[string][]string
+	if options.includeTeams {
+		var err error
+		if teams, err = source.AllTeams(ctx); err != nil {
+			return lookupError(err, "Error looking up teams")
+		}
+	}
 
 	// Create the output file
-	file, err := os.Create(outputFilename)
+	file, err := createOutputFile(outputFilename, options.encryption)
 	if err != nil {
-		LogMessage(errorLevel, "Failed to create CSV file: "+err.Error())
-		return err
+		return lookupError(err, "Failed to create output file")
 	}
 	defer file.Close()
 
-	// Prepare the CSv writer
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	writer, err := newOutputWriter(options.format, file, options.config)
+	if err != nil {
+		return usageError("Invalid -format: %v", err)
+	}
 
-	// Write the CSV header row
-	header := []string{"Version", "OS", "Username", "Email", "First Name", "Last Name"}
-	if err := writer.Write(header); err != nil {
-		LogMessage(errorLevel, "Failed to write header row to CSV: "+err.Error())
-		return err
+	// The rows are written as they're found, if the format allows it, unless they have to be sorted first
+	var stream userStream
+	if streamer, ok := writer.(userStreamer); ok && options.limit == 0 && !options.adminsFirst {
+		if stream, err = streamer.StreamUsers(ctx, header); err != nil {
+			return lookupError(err, "Failed to write lookup results")
+		}
+	}
+
+	type lookupRow struct {
+		record []string
+		admin  bool
 	}
+	var rows []lookupRow
+
+	now := time.Now()
+	writeRows := func(matches []lookupMatch, found lookupUser) error {
+		user := found.user
+		email := user.Email
+		if options.redact {
+			user = redactUser(user, options.pseudonymKey)
+		}
+		if options.emailHMACKey != "" {
+			user.Email = hashEmail(email, options.emailHMACKey)
+		}
+		var attributes map[string]string
+		if options.directory != nil && user.AuthService.String == "ldap" && user.AuthData.String != "" {
+			var err error
+			if attributes, err = options.directory.lookup(user.AuthData.String); err != nil {
+				return lookupError(err, "Error looking up LDAP attributes")
+			}
+		}
 
-	// We need the current epoch to ensure we only retrieve sessions that are still active
-	currentEpochMillis := time.Now().UnixMilli()
+		for _, match := range matches {
+			session, version, client := match.session, match.client.Version, match.client
+			csvRecord := []string{version, client.OS, user.Username, user.Email, user.FirstName, user.LastName,
+				formatMillis(session.LastActivityAt), formatMillis(session.CreateAt), ageInDays(session.CreateAt, now),
+				nullBoolString(user.MfaActive), authServiceName(user.AuthService), user.Locale, nullBoolString(isSystemAdmin(user.Roles)),
+				strconv.Itoa(found.active), strconv.Itoa(found.outdated),
+				strconv.FormatBool(client.DevBuild)}
+			if withMobile {
+				deviceID := session.DeviceID
+				if options.redact {
+					deviceID = devicePseudonym(deviceID, options.pseudonymKey)
+				}
+				csvRecord = append(csvRecord, string(client.Kind), deviceID)
+			}
+			if options.dedupeUsers {
+				csvRecord = append(csvRecord, match.newest)
+			}
+			if options.includeSessionID {
+				if options.dedupeUsers {
+					csvRecord = append(csvRecord, strings.Join(match.sessionIDs, " "))
+				} else {
+					csvRecord = append(csvRecord, session.ID)
+				}
+			}
+			if options.includeTeams {
+				csvRecord = append(csvRecord, strings.Join(teams[user.ID], ", "))
+			}
+			for _, column := range options.userColumns {
+				csvRecord = append(csvRecord, userColumnValue(user, column))
+			}
+			if options.directory != nil {
+				for _, attribute := range options.directory.attributes {
+					csvRecord = append(csvRecord, attributes[attribute])
+				}
+			}
 
-	query := ""
-	if dbType == "postgresql" {
-		query = fmt.Sprintf("SELECT userid, props, deviceid, expiresat FROM sessions WHERE props != '{}' AND (expiresat > %d OR expiresat = 0)", currentEpochMillis)
-	} else if dbType == "mysql" {
-		query = fmt.Sprintf("SELECT UserId, Props, DeviceId, ExpiresAt FROM Sessions WHERE JSON_LENGTH(props) > 0 AND (ExpiresAt > %d OR ExpiresAt = 0)", currentEpochMillis)
+			if stream != nil {
+				if err := stream.WriteRow(csvRecord); err != nil {
+					return lookupError(err, "Failed to write lookup results")
+				}
+				continue
+			}
+			rows = append(rows, lookupRow{record: csvRecord, admin: isSystemAdmin(user.Roles).Bool})
+		}
+		return nil
 	}
 
-	rows, err := db.Query(query)
+	// The sessions come in order of user, so each user's sessions can be counted, and their rows written, as soon as
+	// the next user's sessions start.  With -limit, the outdated sessions have to be kept until every user has been
+	// seen, but the rest are still dropped straight away.
+	var held []lookupMatch
+	heldUsers := make(map[string]lookupUser)
+	outdatedUsers := 0
+	// activeListed and outdatedListed are the names of the users from -users-file with active and outdated sessions
+	activeListed := make(map[string]bool)
+	outdatedListed := make(map[string]bool)
+
+	var userSessions []SessionRecord
+	var sessionUser *UserRecord
+	finishUser := func() error {
+		sessions := userSessions
+		userSessions = nil
+		if len(sessions) == 0 {
+			return nil
+		}
+		var users []UserRecord
+		if sessionUser != nil {
+			users = []UserRecord{*sessionUser}
+		}
+		name := ""
+		switch {
+		case options.userPattern != nil && !options.userPattern.matches(users):
+			return nil
+		case options.onlyUsers != nil:
+			if name = listedName(users, options.onlyUsers); name == "" {
+				return nil
+			}
+			activeListed[name] = true
+		}
+
+		var matches []lookupMatch
+		for _, session := range sessions {
+			if match, ok := matchLookupSession(session, versions); ok {
+				matches = append(matches, match)
+			}
+		}
+		if len(matches) == 0 {
+			return nil
+		}
+		outdatedUsers++
+		if name != "" {
+			outdatedListed[name] = true
+		}
+		if sessionUser == nil {
+			return nil
+		}
+
+		found := lookupUser{user: *sessionUser, active: len(sessions), outdated: len(matches)}
+		if options.limit > 0 {
+			held = append(held, matches...)
+			heldUsers[found.user.ID] = found
+			return nil
+		}
+		if options.dedupeUsers {
+			matches = dedupeLookup(matches)
+		}
+		return writeRows(matches, found)
+	}
+
+	// The users are read along with the sessions, since looking each of them up separately is far too slow on a
+	// large installation
+	var writeErr error
+	err = source.EachSessionUser(ctx, func(session SessionRecord, user *UserRecord) error {
+		if len(userSessions) > 0 && userSessions[0].UserID != session.UserID {
+			if writeErr = finishUser(); writeErr != nil {
+				return writeErr
+			}
+		}
+		userSessions = append(userSessions, session)
+		sessionUser = user
+		return nil
+	})
+	if writeErr != nil {
+		return writeErr
+	}
 	if err != nil {
-		errMsg := fmt.Sprintf("Error executing query: %v", err)
-		LogMessage(errorLevel, errMsg)
+		return lookupError(err, "Error processing lookup")
+	}
+	if err := finishUser(); err != nil {
 		return err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var props, deviceID string
-		var expiresAt int64
-		var userID string
-		if dbType == "postgresql" {
-			if err := rows.Scan(&userID, &props, &deviceID, &expiresAt); err != nil {
-				errMsg := fmt.Sprintf("Error scanning PostgreSQL row: %v", err)
-				LogMessage(errorLevel, errMsg)
-				return err
+	if options.onlyUsers != nil {
+		for name := range options.onlyUsers {
+			if !activeListed[name] {
+				DebugPrint("No active sessions for listed user: " + name)
 			}
-		} else if dbType == "mysql" {
-			if err := rows.Scan(&userID, &props, &deviceID, &expiresAt); err != nil {
-				errMsg := fmt.Sprintf("Error scanning MySQL row: %v", err)
-				LogMessage(errorLevel, errMsg)
+		}
+		LogMessage(infoLevel, fmt.Sprintf("%d of the %d listed users have active sessions, and %d of them are still on %s or earlier",
+			len(activeListed), len(options.onlyUsers), len(outdatedListed), versions))
+	}
+
+	if options.limit > 0 {
+		held = limitLookup(held, options.limit)
+		if outdatedUsers > options.limit {
+			LogMessage(infoLevel, fmt.Sprintf("Limiting the results to the %d users with the oldest versions, of %d", options.limit, outdatedUsers))
+		}
+		if options.dedupeUsers {
+			held = dedupeLookup(held)
+		}
+		for _, match := range held {
+			if err := writeRows([]lookupMatch{match}, heldUsers[match.session.UserID]); err != nil {
 				return err
 			}
 		}
+	}
+
+	if stream != nil {
+		if err := stream.Close(); err != nil {
+			return lookupError(err, "Failed to write lookup results")
+		}
+	} else {
+		if options.adminsFirst {
+			sort.SliceStable(rows, func(i, j int) bool { return rows[i].admin && !rows[j].admin })
+		}
+
+		records := [][]string{header}
+		for _, row := range rows {
+			records = append(records, row.record)
+		}
+		if err := writer.WriteUsers(ctx, records); err != nil {
+			return lookupError(err, "Failed to write lookup results")
+		}
+	}
+
+	// An encrypted file isn't complete until it's closed, so check that everything made it to disk
+	if err := file.Close(); err != nil {
+		return lookupError(err, "Failed to write output file")
+	}
+
+	return nil
+}
+
+// isSystemAdmin reports whether a user has the system admin role.  It isn't valid if the roles are unknown.
+func isSystemAdmin(roles sql.NullString) sql.NullBool {
+	if !roles.Valid {
+		return sql.NullBool{}
+	}
+	return sql.NullBool{Bool: slices.Contains(strings.Fields(roles.String), "system_admin"), Valid: true}
+}
+
+// nullBoolString renders an optional boolean for the CSV, leaving it empty if the value is unknown.
+func nullBoolString(value sql.NullBool) string {
+	if !value.Valid {
+		return ""
+	}
+	return strconv.FormatBool(value.Bool)
+}
+
+// authServiceName returns how the user signs in, e.g. ldap or saml.  Mattermost leaves AuthService empty for users
+// with an email address and password, so that's shown as "email".
+func authServiceName(authService sql.NullString) string {
+	if !authService.Valid {
+		return ""
+	}
+	if authService.String == "" {
+		return "email"
+	}
+	return authService.String
+}
+
+func processSessions(ctx context.Context, source Store) (*mmversions.Summary, error) {
+
+	sessions, err := source.Sessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return tallySessions(sessions), nil
+}
+
+// tallySessions counts the desktop and mobile app versions in a set of sessions, logging any that can't be counted.
+func tallySessions(sessions []SessionRecord) *mmversions.Summary {
+	summary := mmversions.Tally(sessions)
 
-		var propData Props
-		if err := json.Unmarshal([]byte(props), &propData); err != nil {
-			errMsg := fmt.Sprintf("Error unmarshalling JSON: %v", err)
+	for _, problem := range summary.Problems {
+		switch {
+		case problem.Client.Kind == "":
+			errMsg := fmt.Sprintf("Error unmarshalling JSON: %v", problem.Err)
 			LogMessage(warningLevel, errMsg)
-			continue
+		case problem.Client.Kind == mmversions.Mobile:
+			errMsg := fmt.Sprintf("Unrecognised entry - Device ID: %s, JSON Session: %s", problem.Session.DeviceID, problem.Session.Props)
+			LogMessage(warningLevel, errMsg)
+		default:
+			debugMessage := fmt.Sprintf("Troubleshooting: %s", problem.Session.Props)
+			TracePrint(debugMessage)
 		}
-		propData.DeviceID = deviceID
+	}
 
-		if propData.IsMobile == "true" || deviceID != "" || propData.OS == "Android" || propData.OS == "iOS" {
-			DebugPrint("Mobile device.  Skipping for lookup.")
-		} else if strings.Contains(propData.Browser, "Desktop App") {
-			version := ""
-			processRow := false
-			var err error
-			parts := strings.Split(propData.Browser, "/")
-			if len(parts) == 2 {
-				version = parts[1]
-				if version == "0.0" {
-					debugMessage := fmt.Sprintf("Troubleshooting: %s", props)
-					DebugPrint(debugMessage)
-					continue
-				}
+	return summary
+}
 
-				processRow, err = isOlderOrEqual(version, lookupVersion)
-				if err != nil {
-					LogMessage(warningLevel, "Unable to parse version string: "+version)
-					processRow = true
-				}
-			}
+// countedAppClient classifies a session, reporting whether it's one of the desktop or mobile clients in the report's
+// total.  The breakdowns use it to count the same sessions as tallySessions, so that their totals agree.
+func countedAppClient(session SessionRecord) (mmversions.Client, bool) {
+	client, err := mmversions.Classify(session)
+	if err != nil || client.Version == "" {
+		return client, false
+	}
+	switch client.Kind {
+	case mmversions.Desktop:
+		return client, !client.DevBuild && !client.Legacy && client.Version != mmversions.PlaceholderVersion
+	case mmversions.Mobile:
+		return client, true
+	}
+	return client, false
+}
 
-			if processRow {
-				userQuery := ""
-				if dbType == "postgresql" {
-					userQuery = fmt.Sprintf("SELECT username, email, firstname, lastname FROM users WHERE id = '%s'", userID)
-				} else if dbType == "mysql" {
-					userQuery = fmt.Sprintf("SELECT Username, Email, FirstName, LastName FROM Users WHERE Id = '%s'", userID)
-				}
+// expiryBuckets are the ranges in the session expiry breakdown, by the most days until the session expires.  The
+// last bucket has no limit.
+var expiryBuckets = []struct {
+	maxDays int
+	label   string
+}{
+	{7, msgExpiresWeek},
+	{30, msgExpiresMonth},
+	{90, msgExpiresQuarter},
+	{0, msgExpiresLater},
+}
 
-				userRows, err := db.Query(userQuery)
-				if err != nil {
-					errMsg := fmt.Sprintf("Error executing query: %v", err)
-					LogMessage(errorLevel, errMsg)
-					return err
-				}
-				defer userRows.Close()
-
-				for userRows.Next() {
-					var username, email, firstname, lastname string
-					if dbType == "postgresql" {
-						if err := userRows.Scan(&username, &email, &firstname, &lastname); err != nil {
-							errMsg := fmt.Sprintf("Error scanning PostgreSQL row: %v", err)
-							LogMessage(errorLevel, errMsg)
-							return err
-						}
-					} else if dbType == "mysql" {
-						if err := userRows.Scan(&username, &email, &firstname, &lastname); err != nil {
-							errMsg := fmt.Sprintf("Error scanning MySQL row: %v", err)
-							LogMessage(errorLevel, errMsg)
-							return err
-						}
-					}
-
-					csvRecord := []string{version, propData.OS, username, email, firstname, lastname}
-
-					// Write the record
-					if err := writer.Write(csvRecord); err != nil {
-						warningMessage := fmt.Sprintf("Failed to write record to CSV! Version: %s, OS: %s, Usermame: %s, Email: %s, Name: %s %s",
-							version,
-							propData.OS,
-							username,
-							email,
-							firstname,
-							lastname)
-						LogMessage(warningLevel, warningMessage)
-					}
+// expiryCount is the number of desktop and mobile sessions in one expiry bucket.
+type expiryCount struct {
+	label   string
+	desktop int
+	mobile  int
+}
+
+// tallyExpiry counts the desktop and mobile clients by how soon their sessions expire, which shows how quickly a
+// forced upgrade will reach people as they sign in again.  Sessions that never expire are counted last.
+func tallyExpiry(sessions []SessionRecord, now time.Time) []expiryCount {
+	counts := make([]expiryCount, len(expiryBuckets)+1)
+	for i, bucket := range expiryBuckets {
+		counts[i].label = bucket.label
+	}
+	counts[len(expiryBuckets)].label = msgExpiresNever
+
+	for _, session := range sessions {
+		client, ok := countedAppClient(session)
+		if !ok {
+			continue
+		}
+
+		i := len(expiryBuckets)
+		if session.ExpiresAt != 0 {
+			days := time.UnixMilli(session.ExpiresAt).Sub(now).Hours() / 24
+			for i = 0; i < len(expiryBuckets)-1; i++ {
+				if days <= float64(expiryBuckets[i].maxDays) {
+					break
 				}
 			}
 		}
+		if client.Kind == mmversions.Desktop {
+			counts[i].desktop++
+		} else {
+			counts[i].mobile++
+		}
 	}
 
-	return nil
+	return counts
 }
 
-func processDatabase(db *sql.DB, dbType string) (VersionCount, VersionCount, error) {
+// adoption is the number of distinct users with an active desktop or mobile client, out of all enabled users.
+type adoption struct {
+	desktop int
+	mobile  int
+	either  int
+	users   int
+}
 
-	// We need the current epoch to ensure we only retrieve sessions that are still active
-	currentEpochMillis := time.Now().UnixMilli()
+// tallyAdoption counts the distinct users with each kind of client, counting the same sessions as tallySessions.
+func tallyAdoption(sessions []SessionRecord, enabledUsers int) adoption {
+	desktop := make(map[string]bool)
+	mobile := make(map[string]bool)
+	either := make(map[string]bool)
+	for _, session := range sessions {
+		client, ok := countedAppClient(session)
+		if !ok {
+			continue
+		}
+		if client.Kind == mmversions.Desktop {
+			desktop[session.UserID] = true
+		} else {
+			mobile[session.UserID] = true
+		}
+		either[session.UserID] = true
+	}
+	return adoption{desktop: len(desktop), mobile: len(mobile), either: len(either), users: enabledUsers}
+}
+
+// perUserBuckets are the ranges in the sessions per user breakdown, by the most sessions a user has.  The last
+// bucket has no limit.
+var perUserBuckets = []struct {
+	max   int
+	label string
+}{
+	{1, "1"},
+	{3, "2-3"},
+	{0, "4+"},
+}
+
+// perUserCount is the number of users, and their sessions, in one sessions per user bucket.
+type perUserCount struct {
+	label    string
+	users    int
+	sessions int
+}
+
+// tallySessionsPerUser counts the users by how many active desktop and mobile sessions they have.  Users with many
+// sessions at once inflate the client counts, and are often shared accounts.  It also returns the most sessions any
+// one user has.
+func tallySessionsPerUser(sessions []SessionRecord) ([]perUserCount, int) {
+	perUser := make(map[string]int)
+	for _, session := range sessions {
+		if _, ok := countedAppClient(session); ok {
+			perUser[session.UserID]++
+		}
+	}
 
-	query := ""
-	if dbType == "postgresql" {
-		query = fmt.Sprintf("SELECT props, deviceid, expiresat FROM sessions WHERE props != '{}' AND (expiresat > %d OR expiresat = 0)", currentEpochMillis)
-	} else if dbType == "mysql" {
-		query = fmt.Sprintf("SELECT props, DeviceId, ExpiresAt FROM Sessions WHERE JSON_LENGTH(props) > 0 AND (ExpiresAt > %d OR ExpiresAt = 0)", currentEpochMillis)
+	counts := make([]perUserCount, len(perUserBuckets))
+	for i, bucket := range perUserBuckets {
+		counts[i].label = bucket.label
 	}
+	most := 0
+	for _, n := range perUser {
+		i := 0
+		for i < len(perUserBuckets)-1 && n > perUserBuckets[i].max {
+			i++
+		}
+		counts[i].users++
+		counts[i].sessions += n
+		most = max(most, n)
+	}
+	return counts, most
+}
 
-	rows, err := db.Query(query)
+// sessionUsers reads the users of the sessions, keyed by user ID, with EachSessionUser rather than looking each one
+// up separately.  Users who can't be found are left out.
+func sessionUsers(ctx context.Context, source Store) (map[string]UserRecord, error) {
+	users := make(map[string]UserRecord)
+	err := source.EachSessionUser(ctx, func(session SessionRecord, user *UserRecord) error {
+		if user != nil {
+			users[session.UserID] = *user
+		}
+		return nil
+	})
 	if err != nil {
-		errMsg := fmt.Sprintf("Error executing query: %v", err)
-		LogMessage(errorLevel, errMsg)
-		return nil, nil, err
+		return nil, err
 	}
-	defer rows.Close()
+	return users, nil
+}
 
-	desktopVersionCount := make(VersionCount)
-	mobileVersionCount := make(VersionCount)
+// tallyLocales counts the desktop and mobile clients by their user's locale, so that upgrade communications can be
+// planned for each language.  Users who can't be found are counted under an empty locale.
+func tallyLocales(sessions []SessionRecord, users map[string]UserRecord) map[string]int {
+	localeCount := make(map[string]int)
 
-	for rows.Next() {
-		var props, deviceID string
-		var expiresAt int64
-		if dbType == "postgresql" {
-			if err := rows.Scan(&props, &deviceID, &expiresAt); err != nil {
-				errMsg := fmt.Sprintf("Error scanning PostgreSQL row: %v", err)
-				LogMessage(errorLevel, errMsg)
-				return nil, nil, err
-			}
-		} else if dbType == "mysql" {
-			if err := rows.Scan(&props, &deviceID, &expiresAt); err != nil {
-				errMsg := fmt.Sprintf("Error scanning MySQL row: %v", err)
-				LogMessage(errorLevel, errMsg)
-				return nil, nil, err
-			}
+	for _, session := range sessions {
+		if _, ok := countedAppClient(session); !ok {
+			continue
 		}
+		localeCount[users[session.UserID].Locale]++
+	}
 
-		var propData Props
-		if err := json.Unmarshal([]byte(props), &propData); err != nil {
-			errMsg := fmt.Sprintf("Error unmarshalling JSON: %v", err)
-			LogMessage(warningLevel, errMsg)
+	return localeCount
+}
+
+// groupCount is the number of desktop and mobile clients on each version within one group of users, e.g. a team.
+type groupCount struct {
+	desktop map[string]int
+	mobile  map[string]int
+}
+
+// tallyGroups counts the desktop and mobile clients by version for each of the groups their user is in, as returned
+// by groupsOf, which is only called once for each user.  A user in several groups is counted in each of them, and
+// users without a group are counted under "".
+func tallyGroups(sessions []SessionRecord, groupsOf func(userID string) []string) map[string]groupCount {
+	userGroups := make(map[string][]string)
+	groupCounts := make(map[string]groupCount)
+
+	for _, session := range sessions {
+		client, ok := countedAppClient(session)
+		if !ok {
 			continue
 		}
-		propData.DeviceID = deviceID
 
-		if propData.IsMobile == "true" || deviceID != "" || propData.OS == "Android" || propData.OS == "iOS" {
-			parts := strings.Split(propData.Browser, "/")
-			if len(parts) == 2 {
-				versionParts := strings.Split(parts[1], "+")
-				version := versionParts[0]
-				if version == "0.0" {
-					errMsg := fmt.Sprintf("Unrecognised entry - Device ID: %s, JSON Session: %s", deviceID, props)
-					LogMessage(warningLevel, errMsg)
-				}
-				if mobileVersionCount[version] == nil {
-					mobileVersionCount[version] = make([]VersionInfo, 0)
-				}
-				mobileVersionCount[version] = append(mobileVersionCount[version], VersionInfo{OS: propData.OS, Count: 1})
-			}
-		} else if strings.Contains(propData.Browser, "Desktop App") {
-			parts := strings.Split(propData.Browser, "/")
-			if len(parts) == 2 {
-				version := parts[1]
-				if version == "0.0" {
-					debugMessage := fmt.Sprintf("Troubleshooting: %s", props)
-					DebugPrint(debugMessage)
-					continue
-				}
-				if desktopVersionCount[version] == nil {
-					desktopVersionCount[version] = make([]VersionInfo, 0)
-				}
-				desktopVersionCount[version] = append(desktopVersionCount[version], VersionInfo{OS: propData.OS, Count: 1})
+		groups, ok := userGroups[session.UserID]
+		if !ok {
+			if groups = groupsOf(session.UserID); len(groups) == 0 {
+				groups = []string{""}
 			}
+			userGroups[session.UserID] = groups
 		}
-	}
 
-	if err := rows.Err(); err != nil {
-		errMsg := fmt.Sprintf("Error iterating over rows: %v", err)
-		LogMessage(errorLevel, errMsg)
-		return nil, nil, err
+		for _, group := range groups {
+			count, ok := groupCounts[group]
+			if !ok {
+				count = groupCount{desktop: make(map[string]int), mobile: make(map[string]int)}
+				groupCounts[group] = count
+			}
+			if client.Kind == mmversions.Desktop {
+				count.desktop[client.Version]++
+			} else {
+				count.mobile[client.Version]++
+			}
+		}
 	}
 
-	aggregateCounts(desktopVersionCount)
-	aggregateCounts(mobileVersionCount)
+	return groupCounts
+}
 
-	return desktopVersionCount, mobileVersionCount, nil
+// tallyTeams counts the desktop and mobile clients by version for each of their user's teams, as read by AllTeams.
+func tallyTeams(sessions []SessionRecord, teams map[string][]string) map[string]groupCount {
+	return tallyGroups(sessions, func(userID string) []string {
+		return teams[userID]
+	})
 }
 
-func aggregateCounts(versionCount VersionCount) {
-	for version, infos := range versionCount {
-		osCount := make(map[string]int)
-		for _, info := range infos {
-			osCount[info.OS] += info.Count
+// tallyAuthServices counts the desktop and mobile clients by version for each way of signing in, e.g. ldap, saml or
+// email.  Users who can't be found are counted under "".
+func tallyAuthServices(sessions []SessionRecord, users map[string]UserRecord) map[string]groupCount {
+	return tallyGroups(sessions, func(userID string) []string {
+		user, ok := users[userID]
+		if !ok {
+			return nil
 		}
+		return []string{authServiceName(user.AuthService)}
+	})
+}
 
-		versionCount[version] = nil
-		for os, count := range osCount {
-			versionCount[version] = append(versionCount[version], VersionInfo{OS: os, Count: count})
-		}
+// userRole is the role a user is counted under in the role breakdown: system admin, guest or member.  It's "" if
+// the user's roles aren't known.
+func userRole(roles sql.NullString) string {
+	if !roles.Valid {
+		return ""
+	}
+	switch fields := strings.Fields(roles.String); {
+	case slices.Contains(fields, "system_admin"):
+		return msgRoleAdmin
+	case slices.Contains(fields, "system_guest"):
+		return msgRoleGuest
 	}
+	return msgRoleMember
 }
 
-func printResults(desktopVersionCount, mobileVersionCount VersionCount) {
-	hasDesktopApps := len(desktopVersionCount) > 0
-	hasMobileApps := len(mobileVersionCount) > 0
-
-	totalDesktopClients := 0
-	totalMobileClients := 0
+// roleTally is the role breakdown: the version counts for each role, and the number of users in each role with a
+// client older than the minimum version, for desktop and mobile.  Roles are keyed by their message, so that they
+// can be translated when they're printed.
+type roleTally struct {
+	counts          map[string]groupCount
+	outdatedDesktop map[string]int
+	outdatedMobile  map[string]int
+}
 
-	for _, desktopVersionInfos := range desktopVersionCount {
-		for _, desktopVersionInfo := range desktopVersionInfos {
-			totalDesktopClients += desktopVersionInfo.Count
+// tallyRoles counts the desktop and mobile clients by version for each role, and the users in each role with a
+// client older than minDesktop or minMobile, if they're set.
+func tallyRoles(sessions []SessionRecord, users map[string]UserRecord, minDesktop, minMobile string) roleTally {
+	roleOf := func(userID string) []string {
+		if role := userRole(users[userID].Roles); role != "" {
+			return []string{role}
 		}
+		return nil
 	}
-	for _, mobileVersionInfos := range mobileVersionCount {
-		for _, mobileVersionInfo := range mobileVersionInfos {
-			totalMobileClients += mobileVersionInfo.Count
+
+	tally := roleTally{counts: tallyGroups(sessions, roleOf), outdatedDesktop: make(map[string]int), outdatedMobile: make(map[string]int)}
+
+	// Count each user once, however many outdated sessions they have
+	seen := make(map[string]bool)
+	for _, session := range sessions {
+		client, ok := countedAppClient(session)
+		if !ok {
+			continue
 		}
+		minimum, outdated := minDesktop, tally.outdatedDesktop
+		if client.Kind == mmversions.Mobile {
+			minimum, outdated = minMobile, tally.outdatedMobile
+		}
+		key := string(client.Kind) + "\x00" + session.UserID
+		if minimum == "" || seen[key] || !mmversions.Less(client.Version, minimum) {
+			continue
+		}
+		seen[key] = true
+		outdated[userRole(users[session.UserID].Roles)]++
 	}
+	return tally
+}
+
+func printResults(summary *mmversions.Summary, style reportStyle) {
+	desktopVersionCount, mobileVersionCount := summary.Desktop, summary.Mobile
+	hasDesktopApps := len(desktopVersionCount) > 0
+	hasMobileApps := len(mobileVersionCount) > 0
 
+	totalDesktopClients := mmversions.Total(desktopVersionCount)
+	totalMobileClients := mmversions.Total(mobileVersionCount)
 	totalActiveClients := totalDesktopClients + totalMobileClients
 
-	if !hasDesktopApps && !hasMobileApps {
-		fmt.Println("No Mattermost Apps Found")
+	if !hasDesktopApps && !hasMobileApps && len(summary.DesktopDev) == 0 && len(summary.DesktopLegacy) == 0 && len(summary.API) == 0 {
+		fmt.Println(tr(msgNoApps))
 	} else {
 		if hasDesktopApps {
-			fmt.Println("Mattermost Desktop App Versions Found:")
-			for version, infos := range desktopVersionCount {
-				for _, info := range infos {
-					fmt.Printf("  %s (%s) - %d\n", version, info.OS, info.Count)
-				}
-			}
-			fmt.Printf("\nTotal Active Desktop Clients: %d\n", totalDesktopClients)
+			fmt.Println(tr(msgDesktopFound))
+			printVersionTable(os.Stdout, desktopVersionCount, style.minDesktopVersion, style)
+			fmt.Println("\n" + trf(msgTotalDesktop, totalDesktopClients))
 		} else {
-			fmt.Println("No Mattermost Desktop Apps Found")
+			fmt.Println(tr(msgNoDesktop))
 		}
 
 		if hasMobileApps {
-			fmt.Println("\nMattermost Mobile App Versions Found:")
-			for version, infos := range mobileVersionCount {
-				for _, info := range infos {
-					fmt.Printf("  %s (%s) - %d\n", version, info.OS, info.Count)
-				}
-			}
-			fmt.Printf("\nTotal Active Mobile Clients: %d\n", totalMobileClients)
+			fmt.Println("\n" + tr(msgMobileFound))
+			printVersionTable(os.Stdout, mobileVersionCount, style.minMobileVersion, style)
+			fmt.Println("\n" + trf(msgTotalMobile, totalMobileClients))
+			fmt.Println(trf(msgMobileDevices, summary.MobileDevices))
 		} else {
-			fmt.Println("No Mattermost Mobile Apps Found")
+			fmt.Println(tr(msgNoMobile))
+		}
+
+		// Nightly builds are internal testers, and API clients aren't people using an app, so they're listed
+		// separately and left out of the total
+		if len(summary.DesktopDev) > 0 {
+			fmt.Println("\n" + tr(msgDevFound))
+			printVersionTable(os.Stdout, summary.DesktopDev, "", reportStyle{})
+			fmt.Println("\n" + trf(msgTotalDev, mmversions.Total(summary.DesktopDev)))
+		}
+		if len(summary.DesktopLegacy) > 0 {
+			fmt.Println("\n" + tr(msgLegacyFound))
+			printVersionTable(os.Stdout, summary.DesktopLegacy, "", reportStyle{})
+			fmt.Println("\n" + trf(msgTotalLegacy, mmversions.Total(summary.DesktopLegacy)))
+		}
+		if len(summary.API) > 0 {
+			fmt.Println("\n" + tr(msgAPIFound))
+			printAPITable(os.Stdout, summary.API)
+			fmt.Println("\n" + trf(msgTotalAPI, mmversions.Total(summary.API)))
 		}
 
-		fmt.Printf("\nTotal Active Clients: %d\n", totalActiveClients)
+		fmt.Println("\n" + trf(msgTotalActive, totalActiveClients))
 	}
 }
 
 func main() {
-	// Define command-line flag
-	var showVersion bool
-	var showHelp bool
-	var lookupMode bool
-	var lookupVersion string
-	var outputFile string
-	configFile := flag.String("config", "config.json", "path to config file")
-	flag.BoolVar(&lookupMode, "lookup", false, "lookup desktop users prior to an existing version")
-	flag.StringVar(&lookupVersion, "ver", "", "[required for lookup] user with desktop clients of this version and older will be returned")
-	flag.StringVar(&outputFile, "outfile", defaultOutputFile, "[optional] Specify an alternative output CSV filename when using lookup mode.  Default:"+defaultOutputFile)
-	flag.BoolVar(&showVersion, "version", false, "show version infomration and exit")
-	flag.BoolVar(&showHelp, "help", false, "show help and exit")
-	flag.BoolVar(&debugMode, "debug", false, "run the utility in debug mode for additional output")
-	flag.Parse()
-
-	if showVersion {
-		fmt.Printf("Version: %s\n", Version)
-		os.Exit(1)
-	}
-
-	if showHelp {
-		flag.Usage()
-		os.Exit(99)
-	}
-
-	if lookupMode {
-		if lookupVersion == "" {
-			LogMessage(errorLevel, "A desktop client version is required for lookup mode")
-			flag.Usage()
-			os.Exit(1)
-		}
-		LogMessage(infoLevel, "Running in lookup mode, for desktop version v"+lookupVersion+" and earlier.  Writing results to: "+outputFile)
-	}
-
-	config, cfgErr := loadConfig(*configFile)
-	if cfgErr != nil {
-		LogMessage(errorLevel, "Failed to process config file")
-		os.Exit(2)
-	}
-	db, dbErr := connectDatabase(config)
-	if dbErr != nil {
-		LogMessage(errorLevel, "Failed to connect to database")
-		os.Exit(3)
-	}
-	defer db.Close()
-
-	if lookupMode {
-		DebugPrint("Staring lookup")
-		lookupErr := doLookup(db, config.DB.Type, outputFile, lookupVersion)
-		if lookupErr != nil {
-			LogMessage(errorLevel, "Error processing lookup")
-			os.Exit(10)
-		}
-	} else {
-		desktopVersionCount, mobileVersionCount, processErr := processDatabase(db, config.DB.Type)
-		if processErr != nil {
-			LogMessage(errorLevel, "Error processing database")
-			os.Exit(4)
-		}
-
-		printResults(desktopVersionCount, mobileVersionCount)
+	if code, ok := runAsService(os.Args[1:]); ok {
+		os.Exit(code)
 	}
+	os.Exit(run(context.Background(), os.Args[1:]))
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// defaultMDMFile is where 'mdm-export' writes the outdated devices, if -outfile isn't given.
+const defaultMDMFile = "outdated-devices.csv"
+
+// mdmHeader is the header of the MDM export.  The columns are named the way Intune and Jamf name them in their own
+// imports, so that the file can be mapped with as little editing as possible.
+var mdmHeader = []string{"Device ID", "User Principal Name", "Platform", "App Version"}
+
+// mdmDevice is a mobile device, with the newest app version signed in on it.
+type mdmDevice struct {
+	deviceID string
+	userID   string
+	os       string
+	version  string
+}
+
+// outdatedDevices returns the mobile devices whose app is older than the minimum version.  A device can have
+// several sessions, from signing in again, so it's judged by the newest version signed in on it, since that's the
+// app that's installed now.  Sessions without a device ID are each treated as a device of their own.
+func outdatedDevices(sessions []SessionRecord, minimum string) []mdmDevice {
+	var devices []mdmDevice
+	newest := make(map[string]int)
+	for _, session := range sessions {
+		client, ok := countedAppClient(session)
+		if !ok || client.Kind != mmversions.Mobile {
+			continue
+		}
+		device := mdmDevice{deviceID: session.DeviceID, userID: session.UserID, os: client.OS, version: client.Version}
+		if i, seen := newest[session.DeviceID]; seen && session.DeviceID != "" {
+			if mmversions.Less(devices[i].version, device.version) {
+				devices[i] = device
+			}
+			continue
+		}
+		newest[session.DeviceID] = len(devices)
+		devices = append(devices, device)
+	}
+
+	var outdated []mdmDevice
+	for _, device := range devices {
+		if mmversions.Less(device.version, minimum) {
+			outdated = append(outdated, device)
+		}
+	}
+	sort.SliceStable(outdated, func(i, j int) bool {
+		if outdated[i].userID != outdated[j].userID {
+			return outdated[i].userID < outdated[j].userID
+		}
+		return outdated[i].deviceID < outdated[j].deviceID
+	})
+	return outdated
+}
+
+// mdmPlatform is the platform name used by the MDM imports, e.g. iOS rather than the app's iPadOS.
+func mdmPlatform(os string) string {
+	switch osFamily(os) {
+	case "ios", "ipados":
+		return "iOS"
+	case "android":
+		return "Android"
+	}
+	return os
+}
+
+// mdmRows lists the outdated devices for import into an MDM, with the user's email as their UPN.  Users who can't
+// be found are left with an empty UPN, rather than being left out.
+func mdmRows(ctx context.Context, source Store, devices []mdmDevice) ([][]string, error) {
+	rows := [][]string{mdmHeader}
+	emails := make(map[string]string)
+	for _, device := range devices {
+		email, seen := emails[device.userID]
+		if !seen {
+			users, err := source.User(ctx, device.userID)
+			if err != nil {
+				return nil, err
+			}
+			if len(users) > 0 {
+				email = users[0].Email
+			}
+			emails[device.userID] = email
+		}
+		rows = append(rows, []string{device.deviceID, email, mdmPlatform(device.os), device.version})
+	}
+	return rows, nil
+}
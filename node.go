@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// nodePool names a range of addresses, e.g. the app nodes behind one load balancer pool, from the sessions section of
+// the config file.
+type nodePool struct {
+	CIDR string `json:"cidr"`
+	Name string `json:"name"`
+}
+
+// nodeAttributor works out which app node or connection origin a session came through, from the session props named
+// in the config file.  Mattermost doesn't record this itself, so the props have to be added by something in front of
+// it, such as a plugin or the load balancer.
+type nodeAttributor struct {
+	props []string
+	pools []*net.IPNet
+	names []string
+}
+
+// newNodeAttributor reads the props to look for, and the pools to group addresses into, from the config file.
+func newNodeAttributor(config *Config) (*nodeAttributor, error) {
+	if len(config.Sessions.NodeProps) == 0 {
+		return nil, errors.New("there are no session props to attribute the clients by.  Set sessions.node_props in the config file")
+	}
+	attributor := &nodeAttributor{props: config.Sessions.NodeProps}
+	for i, pool := range config.Sessions.NodePools {
+		_, network, err := net.ParseCIDR(pool.CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("pool %d: %q is not a valid address range, such as 10.0.1.0/24", i+1, pool.CIDR)
+		}
+		if pool.Name == "" {
+			return nil, fmt.Errorf("pool %d: %s has no name", i+1, pool.CIDR)
+		}
+		attributor.pools = append(attributor.pools, network)
+		attributor.names = append(attributor.names, pool.Name)
+	}
+	return attributor, nil
+}
+
+// node returns the first of the props that the session has, or "" if it has none of them.  An address, with or
+// without a port, is replaced by the name of the first pool it falls in.
+func (a *nodeAttributor) node(session SessionRecord) string {
+	var props map[string]interface{}
+	if err := json.Unmarshal([]byte(session.Props), &props); err != nil {
+		return ""
+	}
+	for _, prop := range a.props {
+		value, ok := props[prop].(string)
+		if !ok || strings.TrimSpace(value) == "" {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		host := value
+		if h, _, err := net.SplitHostPort(value); err == nil {
+			host = h
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			for i, pool := range a.pools {
+				if pool.Contains(ip) {
+					return a.names[i]
+				}
+			}
+		}
+		return value
+	}
+	return ""
+}
+
+// tallyNodes counts the desktop and mobile clients by version for each node.  Sessions without any of the props are
+// counted under "".
+func tallyNodes(sessions []SessionRecord, attributor *nodeAttributor) map[string]groupCount {
+	nodeCounts := make(map[string]groupCount)
+	for _, session := range sessions {
+		client, ok := countedAppClient(session)
+		if !ok {
+			continue
+		}
+		node := attributor.node(session)
+		count, ok := nodeCounts[node]
+		if !ok {
+			count = groupCount{desktop: make(map[string]int), mobile: make(map[string]int)}
+			nodeCounts[node] = count
+		}
+		if client.Kind == mmversions.Desktop {
+			count.desktop[client.Version]++
+		} else {
+			count.mobile[client.Version]++
+		}
+	}
+	return nodeCounts
+}
+
+// nodeOutdated is the share of a node's clients that are outdated.
+type nodeOutdated struct {
+	node            string
+	desktop, mobile int
+	outdated        int
+}
+
+func (n nodeOutdated) percent() float64 {
+	return percentOf(n.outdated, n.desktop+n.mobile)
+}
+
+// outdatedByNode counts each node's clients older than the minimum versions or, without them, the newest versions in
+// use on any node, so that the nodes are all compared with the same versions.
+func outdatedByNode(nodeCounts map[string]groupCount, minDesktop, minMobile string) ([]nodeOutdated, nodeOutdated) {
+	desktopBase, mobileBase := minDesktop, minMobile
+	for _, count := range nodeCounts {
+		if minDesktop == "" {
+			desktopBase = newestCounted(count.desktop, desktopBase)
+		}
+		if minMobile == "" {
+			mobileBase = newestCounted(count.mobile, mobileBase)
+		}
+	}
+	older := func(counts map[string]int, base string) int {
+		n := 0
+		for version, count := range counts {
+			if base != "" && mmversions.Less(version, base) {
+				n += count
+			}
+		}
+		return n
+	}
+
+	var nodes []nodeOutdated
+	var all nodeOutdated
+	for node, count := range nodeCounts {
+		n := nodeOutdated{
+			node:     node,
+			desktop:  sumCounts(count.desktop),
+			mobile:   sumCounts(count.mobile),
+			outdated: older(count.desktop, desktopBase) + older(count.mobile, mobileBase),
+		}
+		nodes = append(nodes, n)
+		all.desktop += n.desktop
+		all.mobile += n.mobile
+		all.outdated += n.outdated
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if (nodes[i].node == "") != (nodes[j].node == "") {
+			return nodes[j].node == ""
+		}
+		return strings.ToLower(nodes[i].node) < strings.ToLower(nodes[j].node)
+	})
+	return nodes, all
+}
+
+// newestCounted returns the newest of the versions counted and newest, ignoring those that can't be parsed.
+func newestCounted(counts map[string]int, newest string) string {
+	for version := range counts {
+		if _, _, _, err := mmversions.ParseVersion(version); err != nil {
+			continue
+		}
+		if newest == "" || mmversions.Less(newest, version) {
+			newest = version
+		}
+	}
+	return newest
+}
+
+func sumCounts(counts map[string]int) int {
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	return total
+}
+
+// printNodeTable writes the version counts for each node, followed by the share of each node's clients that are
+// outdated.  Nodes with a larger share than across all the nodes are highlighted, since they point to a pool serving
+// disproportionately old clients.
+func printNodeTable(w io.Writer, nodeCounts map[string]groupCount, style reportStyle) {
+	printGroupTable(w, msgNodesFound, msgColumnNode, msgUnknownNode, nodeCounts)
+
+	nodes, all := outdatedByNode(nodeCounts, style.minDesktopVersion, style.minMobileVersion)
+	fmt.Fprintln(w, "\n"+tr(msgNodesOutdated))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", tr(msgColumnNode), tr(msgColumnDesktop), tr(msgColumnMobile), tr(msgColumnOutdated))
+	for _, n := range nodes {
+		name := n.node
+		if name == "" {
+			name = tr(msgUnknownNode)
+		}
+		outdated := fmt.Sprintf("%.1f%%", n.percent())
+		if style.color && n.node != "" && n.percent() > all.percent() {
+			outdated = colorRed + outdated + colorReset
+		}
+		fmt.Fprintf(tw, "  %s\t%d\t%d\t%s\n", name, n.desktop, n.mobile, outdated)
+	}
+	fmt.Fprintf(tw, "  %s\t%d\t%d\t%.1f%%\n", tr(msgColumnTotal), all.desktop, all.mobile, all.percent())
+	tw.Flush()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// OutputWriter writes results in one output format.  Each format lives in its own file and registers itself with
+// registerOutputWriter from an init function, so adding a format, in a fork or otherwise, only needs a new file.
+// Writers that send the results somewhere over the network should give up when the context is cancelled.
+type OutputWriter interface {
+	// Write writes the version counts.
+	Write(ctx context.Context, summary *mmversions.Summary) error
+	// WriteUsers writes the lookup results.  The first row is the header.
+	WriteUsers(ctx context.Context, rows [][]string) error
+}
+
+// userStreamer is implemented by the formats that can write the lookup results a row at a time, as they're found,
+// rather than all at once, so that a large lookup doesn't have to be held in memory.
+type userStreamer interface {
+	// StreamUsers writes the header, and returns the stream to write each of the rows to.
+	StreamUsers(ctx context.Context, header []string) (userStream, error)
+}
+
+// userStream writes the rows of the lookup results.  It must be closed once they've all been written.
+type userStream interface {
+	WriteRow(row []string) error
+	Close() error
+}
+
+// errUnsupportedOutput is returned by a writer for output it can't produce, e.g. lookup results for a webhook.
+var errUnsupportedOutput = errors.New("not supported by this output format")
+
+// outputWriterFactory creates a writer for a format.  Writers that don't write to a file, such as webhooks, can
+// ignore w and take their settings from the config.
+type outputWriterFactory func(w io.Writer, config *Config) OutputWriter
+
+var outputWriters = make(map[string]outputWriterFactory)
+
+// registerOutputWriter makes a format available by name.  It's called from init functions, so a duplicate name is
+// a programming error.
+func registerOutputWriter(name string, factory outputWriterFactory) {
+	if _, ok := outputWriters[name]; ok {
+		panic("output format registered twice: " + name)
+	}
+	outputWriters[name] = factory
+}
+
+// newOutputWriter creates a writer for the named format.
+func newOutputWriter(name string, w io.Writer, config *Config) (OutputWriter, error) {
+	factory, ok := outputWriters[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported output format %q.  Supported formats are: %s", name, outputFormats())
+	}
+	return factory(w, config), nil
+}
+
+// outputFormats lists the registered formats, for help and error messages.
+func outputFormats() string {
+	names := make([]string, 0, len(outputWriters))
+	for name := range outputWriters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// summaryRows flattens the desktop, mobile and API client counts into rows for the tabular formats.  Nightly and
+// developer desktop builds are listed as desktop-dev, legacy desktop builds as desktop-legacy, and API clients have
+// their type in the Version column.
+func summaryRows(summary *mmversions.Summary) [][]string {
+	rows := [][]string{{"Client", "Version", "OS", "Count"}}
+	for _, category := range []struct {
+		client string
+		counts mmversions.VersionCount
+	}{
+		{string(mmversions.Desktop), summary.Desktop},
+		{string(mmversions.Desktop) + "-dev", summary.DesktopDev},
+		{string(mmversions.Desktop) + "-legacy", summary.DesktopLegacy},
+		{string(mmversions.Mobile), summary.Mobile},
+		{string(mmversions.API), summary.API},
+	} {
+		for _, entry := range mmversions.Entries(category.counts) {
+			rows = append(rows, []string{category.client, entry.Version, entry.OS, fmt.Sprint(entry.Count)})
+		}
+	}
+	return rows
+}
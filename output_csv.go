@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+func init() {
+	registerOutputWriter("csv", func(w io.Writer, config *Config) OutputWriter { return &csvOutput{w: w} })
+}
+
+// csvOutput writes comma-separated values, with a header row.
+type csvOutput struct {
+	w io.Writer
+}
+
+func (o *csvOutput) Write(ctx context.Context, summary *mmversions.Summary) error {
+	return o.WriteUsers(ctx, summaryRows(summary))
+}
+
+func (o *csvOutput) WriteUsers(ctx context.Context, rows [][]string) error {
+	writer := csv.NewWriter(o.w)
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+func (o *csvOutput) StreamUsers(ctx context.Context, header []string) (userStream, error) {
+	writer := csv.NewWriter(o.w)
+	if err := writer.Write(header); err != nil {
+		return nil, err
+	}
+	return &csvUserStream{writer: writer}, nil
+}
+
+type csvUserStream struct {
+	writer *csv.Writer
+}
+
+func (s *csvUserStream) WriteRow(row []string) error {
+	return s.writer.Write(row)
+}
+
+func (s *csvUserStream) Close() error {
+	s.writer.Flush()
+	return s.writer.Error()
+}
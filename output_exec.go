@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+func init() {
+	registerOutputWriter("exec-summary", func(w io.Writer, config *Config) OutputWriter {
+		return &execSummaryOutput{
+			w:                 w,
+			minDesktopVersion: config.Report.MinDesktopVersion,
+			minMobileVersion:  config.Report.MinMobileVersion,
+			previousFile:      config.Output.SnapshotFile,
+		}
+	})
+}
+
+// execTopRisks is the number of outdated versions listed as risks in the executive summary.
+const execTopRisks = 3
+
+// execSummaryOutput writes a short, plain-language overview of the version counts, for pasting into an update for
+// people who won't read the tables.  The trend is taken from the snapshot file, if there is one.
+type execSummaryOutput struct {
+	w                 io.Writer
+	minDesktopVersion string
+	minMobileVersion  string
+	previousFile      string
+}
+
+func (o *execSummaryOutput) Write(ctx context.Context, summary *mmversions.Summary) error {
+	desktop := mmversions.Entries(summary.Desktop)
+	mobile := mmversions.Entries(summary.Mobile)
+	desktopTotal, mobileTotal := mmversions.Total(summary.Desktop), mmversions.Total(summary.Mobile)
+	total := desktopTotal + mobileTotal
+
+	var b strings.Builder
+	fmt.Fprintln(&b, trf(msgExecTitle, time.Now().In(outputLocation).Format("2006-01-02")))
+	fmt.Fprintln(&b)
+	if total == 0 {
+		fmt.Fprintln(&b, tr(msgExecNoClients))
+		_, err := io.WriteString(o.w, b.String())
+		return err
+	}
+
+	outdatedDesktop, desktopBase := outdatedEntries(desktop, o.minDesktopVersion)
+	outdatedMobile, mobileBase := outdatedEntries(mobile, o.minMobileVersion)
+	outdated := sumEntries(outdatedDesktop) + sumEntries(outdatedMobile)
+
+	fmt.Fprint(&b, trf(msgExecTotals, total, desktopTotal, mobileTotal))
+	if outdated == 0 {
+		fmt.Fprintln(&b, " "+tr(msgExecUpToDate))
+	} else {
+		fmt.Fprintln(&b, " "+trf(msgExecOutdated, outdated, percentOf(outdated, total)))
+		fmt.Fprintln(&b)
+		if n := sumEntries(outdatedDesktop); n > 0 {
+			fmt.Fprintln(&b, "- "+trf(msgExecOldDesktop, n, desktopTotal, percentOf(n, desktopTotal), desktopBase))
+		}
+		if n := sumEntries(outdatedMobile); n > 0 {
+			fmt.Fprintln(&b, "- "+trf(msgExecOldMobile, n, mobileTotal, percentOf(n, mobileTotal), mobileBase))
+		}
+	}
+
+	fmt.Fprintln(&b, "\n"+tr(msgExecRisks))
+	fmt.Fprintln(&b)
+	risks := topRisks(outdatedDesktop, outdatedMobile)
+	if devTotal := mmversions.Total(summary.DesktopDev); devTotal > 0 {
+		risks = append(risks, trf(msgExecRiskDev, devTotal))
+	}
+	if len(risks) == 0 {
+		fmt.Fprintln(&b, tr(msgExecNoRisks))
+	}
+	for i, risk := range risks {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, risk)
+	}
+
+	if previous := o.readPrevious(); previous != nil {
+		before, _ := outdatedEntries(previous.Desktop, o.minDesktopVersion)
+		beforeMobile, _ := outdatedEntries(previous.Mobile, o.minMobileVersion)
+		fmt.Fprintln(&b)
+		fmt.Fprintln(&b, trf(msgExecTrend, previous.GeneratedAt.In(outputLocation).Format("2006-01-02"),
+			previous.Total, total, sumEntries(before)+sumEntries(beforeMobile), outdated))
+	}
+
+	_, err := io.WriteString(o.w, b.String())
+	return err
+}
+
+func (o *execSummaryOutput) WriteUsers(ctx context.Context, rows [][]string) error {
+	return errUnsupportedOutput
+}
+
+// readPrevious loads the last snapshot for the trend.  Not having one is normal on the first run, so it's only
+// mentioned in debug mode.
+func (o *execSummaryOutput) readPrevious() *Snapshot {
+	if o.previousFile == "" {
+		return nil
+	}
+	if _, err := os.Stat(o.previousFile); errors.Is(err, fs.ErrNotExist) {
+		DebugPrint("No snapshot at " + o.previousFile + ", so the summary won't include a trend")
+		return nil
+	}
+	previous, err := readSnapshot(o.previousFile)
+	if err != nil {
+		LogMessage(warningLevel, "The summary won't include a trend")
+		return nil
+	}
+	return previous
+}
+
+// outdatedEntries returns the entries older than minVersion, along with the version they were compared with.  When
+// there's no minimum, anything older than the newest version in use is outdated, as in the text report.  Versions
+// that can't be parsed aren't counted either way.
+func outdatedEntries(entries []VersionEntry, minVersion string) ([]VersionEntry, string) {
+	base := minVersion
+	if base == "" {
+		for _, entry := range entries {
+			if _, _, _, err := mmversions.ParseVersion(entry.Version); err == nil {
+				base = entry.Version
+			}
+		}
+	}
+	var outdated []VersionEntry
+	for _, entry := range entries {
+		if older, err := mmversions.OlderOrEqual(entry.Version, base); err == nil && older && entry.Version != base {
+			outdated = append(outdated, entry)
+		}
+	}
+	return outdated, base
+}
+
+// sumEntries totals the counts in a list of entries.
+func sumEntries(entries []VersionEntry) int {
+	total := 0
+	for _, entry := range entries {
+		total += entry.Count
+	}
+	return total
+}
+
+// percentOf returns n as a percentage of total.
+func percentOf(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+// topRisks describes the outdated versions with the most clients, across all operating systems, with the oldest
+// version first when the counts are the same.
+func topRisks(desktop, mobile []VersionEntry) []string {
+	type risk struct {
+		key     string
+		version string
+		count   int
+	}
+	var risks []risk
+	for _, platform := range []struct {
+		key     string
+		entries []VersionEntry
+	}{
+		{msgExecRiskDesktop, desktop},
+		{msgExecRiskMobile, mobile},
+	} {
+		counts := make(map[string]int)
+		for _, entry := range platform.entries {
+			counts[entry.Version] += entry.Count
+		}
+		for version, count := range counts {
+			risks = append(risks, risk{key: platform.key, version: version, count: count})
+		}
+	}
+	sort.Slice(risks, func(i, j int) bool {
+		if risks[i].count != risks[j].count {
+			return risks[i].count > risks[j].count
+		}
+		if risks[i].version != risks[j].version {
+			return mmversions.Less(risks[i].version, risks[j].version)
+		}
+		return risks[i].key < risks[j].key
+	})
+	if len(risks) > execTopRisks {
+		risks = risks[:execTopRisks]
+	}
+
+	lines := make([]string, 0, len(risks))
+	for _, r := range risks {
+		lines = append(lines, trf(r.key, r.count, r.version))
+	}
+	return lines
+}
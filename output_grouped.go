@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+func init() {
+	registerOutputWriter("grouped", func(w io.Writer, config *Config) OutputWriter { return &groupedOutput{w: w} })
+}
+
+// groupedOutput writes the lookup results as text, with each user listed once, followed by a table of their
+// outdated sessions, so that support engineers have the full picture for a user in one place.  It only writes
+// lookup results.
+type groupedOutput struct {
+	w io.Writer
+}
+
+// groupedSessionColumns are the columns shown for each session, if they're in the results.
+var groupedSessionColumns = []string{"Version", "OS", "Client", "Device ID", "Last Activity", "Created", "Dev Build", "Session ID"}
+
+func (o *groupedOutput) Write(ctx context.Context, summary *mmversions.Summary) error {
+	return errUnsupportedOutput
+}
+
+func (o *groupedOutput) WriteUsers(ctx context.Context, rows [][]string) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	header := rows[0]
+	column := func(row []string, name string) string {
+		if i := slices.Index(header, name); i >= 0 && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	// Group the rows by user, keeping the users in the order they were first seen
+	var users []string
+	sessions := make(map[string][][]string)
+	for _, row := range rows[1:] {
+		key := column(row, "Username") + "\x00" + column(row, "Email")
+		if _, seen := sessions[key]; !seen {
+			users = append(users, key)
+		}
+		sessions[key] = append(sessions[key], row)
+	}
+
+	var shown []string
+	for _, name := range groupedSessionColumns {
+		if slices.Contains(header, name) {
+			shown = append(shown, name)
+		}
+	}
+
+	for i, key := range users {
+		userRows := sessions[key]
+		sort.SliceStable(userRows, func(a, b int) bool {
+			// Desktop and mobile versions can't be compared, so the desktop sessions come first
+			if clientA, clientB := column(userRows[a], "Client"), column(userRows[b], "Client"); clientA != clientB {
+				return clientA == string(mmversions.Desktop)
+			}
+			return mmversions.Less(column(userRows[a], "Version"), column(userRows[b], "Version"))
+		})
+
+		first := userRows[0]
+		if i > 0 {
+			fmt.Fprintln(o.w)
+		}
+		fmt.Fprintf(o.w, "%s <%s>\n", column(first, "Username"), column(first, "Email"))
+		if name := strings.TrimSpace(column(first, "First Name") + " " + column(first, "Last Name")); name != "" {
+			fmt.Fprintf(o.w, "  Name: %s\n", name)
+		}
+		if column(first, "Is Admin") == "true" {
+			fmt.Fprintln(o.w, "  System admin")
+		}
+		fmt.Fprintf(o.w, "  Outdated sessions: %s of %s\n", column(first, "Outdated Sessions"), column(first, "Active Sessions"))
+
+		tw := tabwriter.NewWriter(o.w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "    %s\n", strings.ToUpper(strings.Join(shown, "\t")))
+		for _, row := range userRows {
+			values := make([]string, len(shown))
+			for j, name := range shown {
+				values[j] = orDash(column(row, name))
+			}
+			fmt.Fprintf(tw, "    %s\n", strings.Join(values, "\t"))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+func init() {
+	registerOutputWriter("json", func(w io.Writer, config *Config) OutputWriter { return &jsonOutput{w: w} })
+}
+
+// jsonOutput writes indented JSON.  The version counts are written in the snapshot format, and the lookup results as
+// an array of objects keyed by the column headers.
+type jsonOutput struct {
+	w io.Writer
+}
+
+func (o *jsonOutput) Write(ctx context.Context, summary *mmversions.Summary) error {
+	return o.encode(newSnapshot(summary))
+}
+
+func (o *jsonOutput) WriteUsers(ctx context.Context, rows [][]string) error {
+	objects := make([]map[string]string, 0, len(rows))
+	if len(rows) > 0 {
+		header := rows[0]
+		for _, row := range rows[1:] {
+			object := make(map[string]string, len(header))
+			for i, column := range header {
+				if i < len(row) {
+					object[column] = row[i]
+				}
+			}
+			objects = append(objects, object)
+		}
+	}
+	return o.encode(objects)
+}
+
+func (o *jsonOutput) encode(value interface{}) error {
+	encoder := json.NewEncoder(o.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+func init() {
+	registerOutputWriter("matrix", func(w io.Writer, config *Config) OutputWriter { return &matrixOutput{w: w} })
+}
+
+// matrixOutput writes the desktop and mobile version counts as text, with a row for each version and a column for
+// each OS, which is far easier to scan than the report's list when there are many versions.  It only writes the
+// version counts.
+type matrixOutput struct {
+	w io.Writer
+}
+
+func (o *matrixOutput) Write(ctx context.Context, summary *mmversions.Summary) error {
+	if len(summary.Desktop) == 0 && len(summary.Mobile) == 0 {
+		_, err := fmt.Fprintln(o.w, tr(msgNoApps))
+		return err
+	}
+	first := true
+	for _, platform := range []struct {
+		heading string
+		counts  mmversions.VersionCount
+	}{
+		{msgDesktopFound, summary.Desktop},
+		{msgMobileFound, summary.Mobile},
+	} {
+		if len(platform.counts) == 0 {
+			continue
+		}
+		if !first {
+			fmt.Fprintln(o.w)
+		}
+		first = false
+		fmt.Fprintln(o.w, tr(platform.heading))
+		if err := writeMatrix(o.w, platform.counts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *matrixOutput) WriteUsers(ctx context.Context, rows [][]string) error {
+	return errUnsupportedOutput
+}
+
+// writeMatrix writes one platform's version counts, with the oldest version first, and the totals for each version
+// and OS.  Empty cells are shown as "-", so that the counts stand out.
+func writeMatrix(w io.Writer, counts mmversions.VersionCount) error {
+	osTotals := make(map[string]int)
+	for _, infos := range counts {
+		for _, info := range infos {
+			osTotals[info.OS] += info.Count
+		}
+	}
+	systems := make([]string, 0, len(osTotals))
+	for system := range osTotals {
+		systems = append(systems, system)
+	}
+	sort.Strings(systems)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\n", tr(msgColumnVersion), strings.Join(systems, "\t"), tr(msgColumnTotal))
+	for _, version := range mmversions.SortedVersions(counts) {
+		cells := make(map[string]int)
+		total := 0
+		for _, info := range counts[version] {
+			cells[info.OS] += info.Count
+			total += info.Count
+		}
+		row := make([]string, len(systems))
+		for i, system := range systems {
+			row[i] = "-"
+			if n := cells[system]; n > 0 {
+				row[i] = fmt.Sprint(n)
+			}
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%d\n", version, strings.Join(row, "\t"), total)
+	}
+	row := make([]string, len(systems))
+	for i, system := range systems {
+		row[i] = fmt.Sprint(osTotals[system])
+	}
+	fmt.Fprintf(tw, "  %s\t%s\t%d\n", tr(msgColumnTotal), strings.Join(row, "\t"), mmversions.Total(counts))
+	return tw.Flush()
+}
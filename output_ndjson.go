@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+func init() {
+	registerOutputWriter("ndjson", func(w io.Writer, config *Config) OutputWriter { return &ndjsonOutput{w: w} })
+}
+
+// ndjsonOutput writes newline-delimited JSON, for log collectors that take one object per line.  The version counts
+// are a single line in the snapshot format, and the lookup results are a line for each user, keyed by the column
+// headers.
+type ndjsonOutput struct {
+	w io.Writer
+}
+
+func (o *ndjsonOutput) Write(ctx context.Context, summary *mmversions.Summary) error {
+	return json.NewEncoder(o.w).Encode(newSnapshot(summary))
+}
+
+func (o *ndjsonOutput) WriteUsers(ctx context.Context, rows [][]string) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	stream, err := o.StreamUsers(ctx, rows[0])
+	if err != nil {
+		return err
+	}
+	for _, row := range rows[1:] {
+		if err := stream.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return stream.Close()
+}
+
+func (o *ndjsonOutput) StreamUsers(ctx context.Context, header []string) (userStream, error) {
+	return &ndjsonUserStream{encoder: json.NewEncoder(o.w), header: header}, nil
+}
+
+// ndjsonUserStream writes each row as an object keyed by the column headers.
+type ndjsonUserStream struct {
+	encoder *json.Encoder
+	header  []string
+}
+
+func (s *ndjsonUserStream) WriteRow(row []string) error {
+	object := make(map[string]string, len(s.header))
+	for i, column := range s.header {
+		if i < len(row) {
+			object[column] = row[i]
+		}
+	}
+	return s.encoder.Encode(object)
+}
+
+func (s *ndjsonUserStream) Close() error {
+	return nil
+}
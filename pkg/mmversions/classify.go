@@ -0,0 +1,265 @@
+package mmversions
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mileusna/useragent"
+)
+
+// Session holds the columns needed from a single row of the Sessions table.  Times are in milliseconds since the
+// epoch, as Mattermost stores them.
+type Session struct {
+	ID             string
+	UserID         string
+	Props          string
+	DeviceID       string
+	ExpiresAt      int64
+	LastActivityAt int64
+	CreateAt       int64
+	// IsOAuth is set for sessions created by an OAuth app on the user's behalf
+	IsOAuth bool
+}
+
+// Props is the part of a session's Props column that identifies the client.
+type Props struct {
+	Browser  string `json:"browser"`
+	OS       string `json:"os"`
+	IsMobile string `json:"isMobile"`
+	DeviceID string `json:"deviceid"`
+	// Type is "UserAccessToken" for sessions created from a personal access token
+	Type  string `json:"type"`
+	IsBot string `json:"is_bot"`
+	// UserAgent is the browser's full user agent, if it was recorded, which gives a more reliable browser and OS
+	// for web sessions than the browser string
+	UserAgent string `json:"user_agent"`
+}
+
+// ClientKind is the type of app a session is from.
+type ClientKind string
+
+const (
+	Desktop ClientKind = "desktop"
+	Mobile  ClientKind = "mobile"
+	Web     ClientKind = "web"
+	// API is an integration rather than an app: a bot, a personal access token or an OAuth app
+	API ClientKind = "api"
+)
+
+// The types of API client, which they're counted under in a Summary.
+const (
+	APIAccessToken = "Personal access token"
+	APIBot         = "Bot"
+	APIOAuth       = "OAuth app"
+)
+
+// PlaceholderVersion is reported by some clients when they don't know their own version.
+const PlaceholderVersion = "0.0"
+
+// Client is the app a session is from.
+type Client struct {
+	Kind ClientKind
+	// Browser is the browser string from the session's props, e.g. "Desktop App/5.5.0"
+	Browser string
+	OS      string
+	// Version is the part of the browser string after the "/", without any mobile build number.  It's empty if the
+	// browser string isn't in the usual name/version form.  For the web app, the browser, version and OS come from
+	// a user agent parser instead.
+	Version string
+	// Rule is the name of the classification rule that decided the kind, or DefaultRule if none of them matched
+	Rule string
+	// Build is the mobile app's build number, from the part of the browser string after the "+", if there is one
+	Build string
+	// DevBuild is set for nightly and developer builds of the desktop app, which are counted separately
+	DevBuild bool
+	// Legacy is set for old desktop builds that don't report "Desktop App" in their browser string, and are only
+	// recognised by the legacy desktop patterns.  They're also counted separately, under LegacyUnknownVersion if
+	// the pattern didn't give a version.
+	Legacy bool
+	// Unrecognised is set for web sessions whose browser string, or user agent, the user agent parser couldn't make
+	// sense of.  They're still counted as the web app, for want of anything better.
+	Unrecognised bool
+}
+
+// parseUserAgent fills in a web client's browser, version and OS using a user agent parser.  The full user agent is
+// used if the props have one, and otherwise the browser string, which the parser also understands.  The OS from the
+// props is kept if there is one, since it's what Mattermost itself recorded.
+func parseUserAgent(client *Client, props Props) {
+	ua := props.UserAgent
+	if ua == "" {
+		ua = props.Browser
+	}
+	if ua == "" {
+		client.Unrecognised = true
+		return
+	}
+
+	parsed := useragent.Parse(ua)
+	if parsed.Name == "" {
+		client.Unrecognised = true
+		return
+	}
+	client.Unrecognised = !knownBrowsers[parsed.Name]
+	client.Browser = parsed.Name
+	client.Version = parsed.Version
+	if parsed.Version != "" {
+		client.Browser += "/" + parsed.Version
+	}
+	if client.OS == "" && parsed.OS != "" {
+		client.OS = strings.TrimSpace(parsed.OS + " " + parsed.OSVersion)
+	}
+}
+
+// knownBrowsers are the browsers that the user agent parser recognises by name.  For anything else, it just echoes
+// the first name/version token it finds, so the session's client string isn't really understood.
+var knownBrowsers = map[string]bool{
+	useragent.Chrome: true, useragent.HeadlessChrome: true, useragent.Edge: true, useragent.Firefox: true,
+	useragent.Safari: true, useragent.MobileSafari: true, useragent.Opera: true, useragent.OperaMini: true,
+	useragent.OperaTouch: true, useragent.Vivaldi: true, useragent.SamsungBrowser: true,
+	useragent.InternetExplorer: true, useragent.NetFront: true,
+}
+
+// SplitOS separates the OS name from its version, if the OS string includes one, e.g. "Windows 11" is Windows
+// version 11, and "Mac OS X 10.15.7" is Mac OS X version 10.15.7.  The version is empty if there isn't one.
+func SplitOS(os string) (name string, version string) {
+	os = strings.TrimSpace(os)
+	i := strings.LastIndex(os, " ")
+	if i < 0 || i == len(os)-1 || os[i+1] < '0' || os[i+1] > '9' {
+		return os, ""
+	}
+	return strings.TrimSpace(os[:i]), os[i+1:]
+}
+
+// Rule is one step of the classification.  The rules are tried in order, and the first that matches decides which
+// kind of client a session is from.
+type Rule struct {
+	Name  string
+	Kind  ClientKind
+	Match func(session Session, props Props) bool
+}
+
+// DefaultRule is reported when no rule matches, and the session is treated as the web app.
+const DefaultRule = "default"
+
+// rules are the classification rules, in the order they're tried.  API clients come first, because a token or OAuth
+// app can be used from anything, followed by mobile, because the mobile apps embed a browser whose string can look
+// like anything.
+var rules = []Rule{
+	{Name: "bot", Kind: API, Match: func(_ Session, props Props) bool { return props.IsBot == "true" }},
+	{Name: "access-token", Kind: API, Match: func(_ Session, props Props) bool { return props.Type == "UserAccessToken" }},
+	{Name: "oauth", Kind: API, Match: func(session Session, _ Props) bool { return session.IsOAuth }},
+	{Name: "mobile-flag", Kind: Mobile, Match: func(_ Session, props Props) bool { return props.IsMobile == "true" }},
+	{Name: "device-id", Kind: Mobile, Match: func(session Session, _ Props) bool { return session.DeviceID != "" }},
+	{Name: "mobile-os", Kind: Mobile, Match: func(_ Session, props Props) bool { return props.OS == "Android" || props.OS == "iOS" }},
+	{Name: "desktop-browser", Kind: Desktop, Match: func(_ Session, props Props) bool { return strings.Contains(props.Browser, "Desktop App") }},
+	{Name: LegacyDesktopRule, Kind: Desktop, Match: func(_ Session, props Props) bool { _, ok := legacyDesktopVersion(props); return ok }},
+}
+
+// LegacyDesktopRule is the name of the rule that recognises old desktop builds by the legacy desktop patterns.
+const LegacyDesktopRule = "legacy-desktop"
+
+// LegacyUnknownVersion is what legacy desktop builds are counted under when their version can't be worked out.
+const LegacyUnknownVersion = "unknown"
+
+// DefaultLegacyDesktopPatterns recognise desktop builds from before the browser string included "Desktop App", by
+// the Mattermost/<version> token in their user agent or, failing that, the Electron token, which doesn't give the
+// app's version.
+var DefaultLegacyDesktopPatterns = []string{`Mattermost/(\d+\.\d+(?:\.\d+)?)`, `Electron/\d`}
+
+var legacyDesktopPatterns = mustCompilePatterns(DefaultLegacyDesktopPatterns)
+
+// SetLegacyDesktopPatterns replaces the patterns that recognise legacy desktop builds.  Each is a regular expression
+// that's matched against the browser string and the user agent, and its first capturing group, if it has one, is the
+// app's version.  The patterns are tried in order.  It should be called before any sessions are classified, as it
+// isn't safe to call at the same time as Classify.
+func SetLegacyDesktopPatterns(patterns []string) error {
+	compiled, err := CompilePatterns(patterns)
+	if err != nil {
+		return err
+	}
+	legacyDesktopPatterns = compiled
+	return nil
+}
+
+// CompilePatterns compiles a list of regular expressions, saying which one is invalid if any are.
+func CompilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func mustCompilePatterns(patterns []string) []*regexp.Regexp {
+	compiled, err := CompilePatterns(patterns)
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
+
+// legacyDesktopVersion reports whether the props match one of the legacy desktop patterns, and the version it gave.
+func legacyDesktopVersion(props Props) (string, bool) {
+	for _, re := range legacyDesktopPatterns {
+		for _, text := range []string{props.Browser, props.UserAgent} {
+			if text == "" {
+				continue
+			}
+			if match := re.FindStringSubmatch(text); match != nil {
+				if len(match) > 1 {
+					return match[1], true
+				}
+				return "", true
+			}
+		}
+	}
+	return "", false
+}
+
+// Rules returns a copy of the classification rules, in the order they're tried.
+func Rules() []Rule {
+	return append([]Rule(nil), rules...)
+}
+
+// Classify works out which app a session is from, using the first of the rules that matches.  Mobile sessions are
+// recognised by their props or device ID, desktop sessions by their browser string, or the legacy desktop patterns
+// for old builds, and anything else is treated as the web app.  It's an error if the session's props aren't valid JSON.
+func Classify(session Session) (Client, error) {
+	var props Props
+	if err := json.Unmarshal([]byte(session.Props), &props); err != nil {
+		return Client{}, err
+	}
+
+	client := Client{Browser: props.Browser, OS: props.OS, Kind: Web, Rule: DefaultRule}
+	if parts := strings.Split(props.Browser, "/"); len(parts) == 2 {
+		client.Version = parts[1]
+	}
+
+	for _, rule := range rules {
+		if rule.Match(session, props) {
+			client.Kind = rule.Kind
+			client.Rule = rule.Name
+			break
+		}
+	}
+	switch client.Kind {
+	case Mobile:
+		client.Version, client.Build, _ = strings.Cut(client.Version, "+")
+	case Desktop:
+		if client.Rule == LegacyDesktopRule {
+			client.Version, client.Legacy = legacyDesktopVersion(props)
+			break
+		}
+		client.DevBuild = IsDevBuild(client.Version)
+	case Web:
+		parseUserAgent(&client, props)
+	}
+
+	return client, nil
+}
@@ -0,0 +1,26 @@
+// Package mmversions works out which versions of the Mattermost desktop and mobile apps are in use, from the
+// sessions in a Mattermost database.  It's the analysis engine behind the mm-desktop-versions utility, for other
+// tools that want to embed it rather than running the binary.
+//
+// The API follows the same steps as the utility:
+//
+//   - Collect reads the active sessions from a Source and tallies them into a Summary.
+//   - Classify works out which client, version and OS a single session is from, using the rules listed by Rules.
+//   - Report writes a Summary as plain-text tables.
+//
+// Tally, Entries, Total and the version comparison functions are also exported, for building other reports.
+//
+// A Source is anything that can return the active sessions, e.g.:
+//
+//	type dbSource struct{ db *sql.DB }
+//
+//	func (s dbSource) Sessions() ([]mmversions.Session, error) {
+//		// SELECT Id, UserId, Props, DeviceId, ExpiresAt, LastActivityAt, CreateAt FROM Sessions WHERE ...
+//	}
+//
+//	summary, err := mmversions.Collect(dbSource{db})
+//	if err != nil {
+//		return err
+//	}
+//	mmversions.Report(os.Stdout, summary)
+package mmversions
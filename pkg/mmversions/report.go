@@ -0,0 +1,81 @@
+package mmversions
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Report writes the desktop and mobile versions in a Summary as plain-text tables, with totals, in the same layout
+// as the utility's report command.  Nightly, developer and legacy desktop builds, and API clients, are listed
+// separately, if there are any, and aren't included in the total, which is of the release apps people use.
+func Report(w io.Writer, summary *Summary) error {
+	desktopTotal := Total(summary.Desktop)
+	mobileTotal := Total(summary.Mobile)
+
+	if len(summary.Desktop) == 0 && len(summary.Mobile) == 0 && len(summary.DesktopDev) == 0 && len(summary.DesktopLegacy) == 0 && len(summary.API) == 0 {
+		_, err := fmt.Fprintln(w, "No Mattermost Apps Found")
+		return err
+	}
+
+	sections := []struct {
+		found, none, total string
+		counts             VersionCount
+	}{
+		{"Mattermost Desktop App Versions Found:", "No Mattermost Desktop Apps Found", "Total Active Desktop Clients: %d", summary.Desktop},
+		{"Mattermost Mobile App Versions Found:", "No Mattermost Mobile Apps Found", "Total Active Mobile Clients: %d", summary.Mobile},
+	}
+	for i, section := range sections {
+		if len(section.counts) == 0 {
+			fmt.Fprintln(w, section.none)
+			continue
+		}
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, section.found)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  VERSION\tOS\tCOUNT")
+		for _, entry := range Entries(section.counts) {
+			fmt.Fprintf(tw, "  %s\t%s\t%d\n", entry.Version, entry.OS, entry.Count)
+		}
+		tw.Flush()
+		fmt.Fprintf(w, "\n"+section.total+"\n", Total(section.counts))
+	}
+
+	if len(summary.DesktopDev) > 0 {
+		fmt.Fprintln(w, "\nNightly and Development Desktop Builds Found:")
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  VERSION\tOS\tCOUNT")
+		for _, entry := range Entries(summary.DesktopDev) {
+			fmt.Fprintf(tw, "  %s\t%s\t%d\n", entry.Version, entry.OS, entry.Count)
+		}
+		tw.Flush()
+		fmt.Fprintf(w, "\nTotal Active Nightly/Development Desktop Clients: %d\n", Total(summary.DesktopDev))
+	}
+
+	if len(summary.DesktopLegacy) > 0 {
+		fmt.Fprintln(w, "\nLegacy Desktop Builds Found:")
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  VERSION\tOS\tCOUNT")
+		for _, entry := range Entries(summary.DesktopLegacy) {
+			fmt.Fprintf(tw, "  %s\t%s\t%d\n", entry.Version, entry.OS, entry.Count)
+		}
+		tw.Flush()
+		fmt.Fprintf(w, "\nTotal Active Legacy Desktop Clients: %d\n", Total(summary.DesktopLegacy))
+	}
+
+	if len(summary.API) > 0 {
+		fmt.Fprintln(w, "\nAPI and Integration Clients Found:")
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  TYPE\tOS\tCOUNT")
+		for _, entry := range Entries(summary.API) {
+			fmt.Fprintf(tw, "  %s\t%s\t%d\n", entry.Version, entry.OS, entry.Count)
+		}
+		tw.Flush()
+		fmt.Fprintf(w, "\nTotal Active API Clients: %d\n", Total(summary.API))
+	}
+
+	_, err := fmt.Fprintf(w, "\nTotal Active Clients: %d\n", desktopTotal+mobileTotal)
+	return err
+}
@@ -0,0 +1,260 @@
+package mmversions
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VersionInfo is the number of sessions for one OS.
+type VersionInfo struct {
+	OS    string
+	Count int
+}
+
+// VersionCount holds the number of sessions for each version, broken down by OS.
+type VersionCount map[string][]VersionInfo
+
+// VersionEntry is a single version and OS combination, as listed by Entries.
+type VersionEntry struct {
+	Version string `json:"version"`
+	OS      string `json:"os"`
+	Count   int    `json:"count"`
+}
+
+// ErrPlaceholderVersion is the error in a Problem for a session that reported PlaceholderVersion.  Mobile sessions
+// with the placeholder are still counted, under that version, but desktop sessions aren't.
+var ErrPlaceholderVersion = errors.New("the client reported a placeholder version")
+
+// Problem is a session that couldn't be counted normally, e.g. because its props aren't valid JSON.
+type Problem struct {
+	Session Session
+	// Client is empty if the session couldn't be classified
+	Client Client
+	Err    error
+}
+
+// Summary is the tally of the versions in use.
+type Summary struct {
+	Desktop VersionCount
+	// DesktopDev is the nightly and developer builds of the desktop app, which are kept out of Desktop so that
+	// internal testers don't skew the production numbers
+	DesktopDev VersionCount
+	// DesktopLegacy is the old desktop builds recognised by the legacy desktop patterns, which are kept apart because
+	// their versions often can't be worked out
+	DesktopLegacy VersionCount
+	Mobile        VersionCount
+	// MobileDevices is the number of distinct phones and tablets in Mobile, since signing in again creates a new
+	// session on the same device.  Sessions without a device ID can't be matched up, so each counts as a device.
+	MobileDevices int
+	// MobileBuilds is Mobile broken down by build number as well, keyed by version+build, e.g. "2.13.4+512".  It's
+	// listed with BuildEntries.
+	MobileBuilds VersionCount
+	// Web is keyed by browser name and major version, e.g. "Chrome 120", since there are far too many point
+	// releases to be useful.
+	Web VersionCount
+	// API is keyed by the type of integration, e.g. APIAccessToken, rather than by version
+	API      VersionCount
+	Problems []Problem
+}
+
+// Source is where the sessions come from, e.g. a Mattermost database or an export of the Sessions table.
+type Source interface {
+	// Sessions returns the active sessions.
+	Sessions() ([]Session, error)
+}
+
+// Collect reads the active sessions from the source and tallies them.
+func Collect(source Source) (*Summary, error) {
+	sessions, err := source.Sessions()
+	if err != nil {
+		return nil, err
+	}
+	return Tally(sessions), nil
+}
+
+// apiTypes maps the rules that recognise API clients to the type they're counted under.
+var apiTypes = map[string]string{
+	"bot":          APIBot,
+	"access-token": APIAccessToken,
+	"oauth":        APIOAuth,
+}
+
+// Bucket returns the key a client is counted under in a Summary, or "" if it isn't counted at all.  Desktop and
+// mobile apps are counted by version, apart from desktop apps reporting the placeholder version, and legacy desktop
+// builds without a version are counted under LegacyUnknownVersion.  The web app is
+// counted by browser name and major version, and API clients by their type.
+func Bucket(client Client) string {
+	switch client.Kind {
+	case API:
+		return apiTypes[client.Rule]
+	case Desktop:
+		if client.Version == PlaceholderVersion {
+			return ""
+		}
+		if client.Legacy && client.Version == "" {
+			return LegacyUnknownVersion
+		}
+		return client.Version
+	case Mobile:
+		return client.Version
+	default:
+		name, version, _ := strings.Cut(client.Browser, "/")
+		major, _, _ := strings.Cut(version, ".")
+		return strings.TrimSpace(name + " " + major)
+	}
+}
+
+// Tally counts the desktop, mobile and web versions, and the API clients, in a set of sessions.  Nightly, developer
+// and legacy desktop builds are counted separately from the releases.  Sessions whose browser string doesn't
+// include a version aren't counted as desktop or mobile clients.
+func Tally(sessions []Session) *Summary {
+	summary := &Summary{
+		Desktop:       make(VersionCount),
+		DesktopDev:    make(VersionCount),
+		DesktopLegacy: make(VersionCount),
+		Mobile:        make(VersionCount),
+		MobileBuilds:  make(VersionCount),
+		Web:           make(VersionCount),
+		API:           make(VersionCount),
+	}
+
+	devices := make(map[string]bool)
+	for _, session := range sessions {
+		client, err := Classify(session)
+		if err != nil {
+			summary.Problems = append(summary.Problems, Problem{Session: session, Err: err})
+			continue
+		}
+
+		if (client.Kind == Desktop || client.Kind == Mobile) && client.Version == PlaceholderVersion {
+			summary.Problems = append(summary.Problems, Problem{Session: session, Client: client, Err: ErrPlaceholderVersion})
+		}
+		key := Bucket(client)
+		if key == "" {
+			continue
+		}
+
+		switch client.Kind {
+		case Mobile:
+			summary.Mobile[key] = append(summary.Mobile[key], VersionInfo{OS: client.OS, Count: 1})
+			if session.DeviceID == "" || !devices[session.DeviceID] {
+				summary.MobileDevices++
+				devices[session.DeviceID] = true
+			}
+			if client.Build != "" {
+				key += "+" + client.Build
+			}
+			summary.MobileBuilds[key] = append(summary.MobileBuilds[key], VersionInfo{OS: client.OS, Count: 1})
+		case Desktop:
+			if client.Legacy {
+				summary.DesktopLegacy[key] = append(summary.DesktopLegacy[key], VersionInfo{OS: client.OS, Count: 1})
+				continue
+			}
+			if client.DevBuild {
+				summary.DesktopDev[key] = append(summary.DesktopDev[key], VersionInfo{OS: client.OS, Count: 1})
+				continue
+			}
+			summary.Desktop[key] = append(summary.Desktop[key], VersionInfo{OS: client.OS, Count: 1})
+		case Web:
+			summary.Web[key] = append(summary.Web[key], VersionInfo{OS: client.OS, Count: 1})
+		case API:
+			summary.API[key] = append(summary.API[key], VersionInfo{OS: client.OS, Count: 1})
+		}
+	}
+
+	aggregate(summary.Desktop)
+	aggregate(summary.DesktopDev)
+	aggregate(summary.DesktopLegacy)
+	aggregate(summary.Mobile)
+	aggregate(summary.MobileBuilds)
+	aggregate(summary.Web)
+	aggregate(summary.API)
+
+	return summary
+}
+
+// aggregate combines the counts for the same version and OS.
+func aggregate(versionCount VersionCount) {
+	for version, infos := range versionCount {
+		osCount := make(map[string]int)
+		for _, info := range infos {
+			osCount[info.OS] += info.Count
+		}
+
+		versionCount[version] = nil
+		for os, count := range osCount {
+			versionCount[version] = append(versionCount[version], VersionInfo{OS: os, Count: count})
+		}
+	}
+}
+
+// Total sums the counts across all versions and operating systems.
+func Total(versionCount VersionCount) int {
+	total := 0
+	for _, infos := range versionCount {
+		for _, info := range infos {
+			total += info.Count
+		}
+	}
+	return total
+}
+
+// SortedVersions returns the versions in a VersionCount, oldest first.
+func SortedVersions(versionCount VersionCount) []string {
+	versions := make([]string, 0, len(versionCount))
+	for version := range versionCount {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return Less(versions[i], versions[j]) })
+
+	return versions
+}
+
+// BuildEntry is a single version, build and OS combination, as listed by BuildEntries.
+type BuildEntry struct {
+	Version string `json:"version"`
+	Build   string `json:"build"`
+	OS      string `json:"os"`
+	Count   int    `json:"count"`
+}
+
+// BuildEntries flattens a VersionCount keyed by version+build, such as Summary.MobileBuilds, into a list ordered by
+// version, build number and OS.  Sessions without a build number have an empty Build, listed first.
+func BuildEntries(buildCount VersionCount) []BuildEntry {
+	entries := make([]BuildEntry, 0)
+	for _, entry := range Entries(buildCount) {
+		version, build, _ := strings.Cut(entry.Version, "+")
+		entries = append(entries, BuildEntry{Version: version, Build: build, OS: entry.OS, Count: entry.Count})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Version != b.Version {
+			return Less(a.Version, b.Version)
+		}
+		if a.Build != b.Build {
+			aBuild, aErr := strconv.Atoi(a.Build)
+			bBuild, bErr := strconv.Atoi(b.Build)
+			if aErr == nil && bErr == nil {
+				return aBuild < bBuild
+			}
+			return a.Build < b.Build
+		}
+		return a.OS < b.OS
+	})
+	return entries
+}
+
+// Entries flattens a VersionCount into a list ordered by version and OS.
+func Entries(versionCount VersionCount) []VersionEntry {
+	entries := make([]VersionEntry, 0)
+	for _, version := range SortedVersions(versionCount) {
+		infos := versionCount[version]
+		sort.Slice(infos, func(i, j int) bool { return infos[i].OS < infos[j].OS })
+		for _, info := range infos {
+			entries = append(entries, VersionEntry{Version: version, OS: info.OS, Count: info.Count})
+		}
+	}
+	return entries
+}
@@ -0,0 +1,90 @@
+package mmversions
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseVersion splits a version such as 5.5.0 into its major, minor and patch numbers.  Anything other than three
+// numbers is an error.
+func ParseVersion(version string) (int, int, int, error) {
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return 0, 0, 0, fmt.Errorf("invalid version format")
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	patch, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	return major, minor, patch, nil
+}
+
+// IsDevBuild reports whether a version is from a nightly or developer build, e.g. 5.9.0-nightly.20240601 or
+// 5.9.0-develop.1, rather than a release.
+func IsDevBuild(version string) bool {
+	_, prerelease, found := strings.Cut(version, "-")
+	if !found {
+		return false
+	}
+	prerelease = strings.ToLower(prerelease)
+	return strings.Contains(prerelease, "nightly") || strings.Contains(prerelease, "dev")
+}
+
+// OlderOrEqual reports whether version is the same as, or older than, other.  It's an error if either of them
+// can't be parsed.
+func OlderOrEqual(version, other string) (bool, error) {
+	vMajor, vMinor, vPatch, err := ParseVersion(version)
+	if err != nil {
+		return false, err
+	}
+
+	oMajor, oMinor, oPatch, err := ParseVersion(other)
+	if err != nil {
+		return false, err
+	}
+
+	if vMajor != oMajor {
+		return vMajor < oMajor, nil
+	}
+	if vMinor != oMinor {
+		return vMinor < oMinor, nil
+	}
+	return vPatch <= oPatch, nil
+}
+
+// Less reports whether version a sorts before version b.  Versions that can't be parsed are sorted after the valid
+// ones, alphabetically.
+func Less(a, b string) bool {
+	aMajor, aMinor, aPatch, aErr := ParseVersion(a)
+	bMajor, bMinor, bPatch, bErr := ParseVersion(b)
+	if aErr != nil || bErr != nil {
+		if aErr == nil {
+			return true
+		}
+		if bErr == nil {
+			return false
+		}
+		return a < b
+	}
+
+	if aMajor != bMajor {
+		return aMajor < bMajor
+	}
+	if aMinor != bMinor {
+		return aMinor < bMinor
+	}
+	return aPatch < bPatch
+}
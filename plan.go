@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// defaultPlanFile is where 'plan' writes the remediation plan, if -outfile isn't given.
+const defaultPlanFile = "remediation-plan.md"
+
+// The ways the remediation plan can be split into steps, for '-group-by'.
+const (
+	planByVersion = "version"
+	planByOS      = "os"
+	planByTeam    = "team"
+)
+
+// planGroupNames describe the groups in the plan's introduction.
+var planGroupNames = map[string]string{planByVersion: "version", planByOS: "OS", planByTeam: "team"}
+
+// planCell is the outdated sessions, and their users, for one version and OS in a step of the plan.
+type planCell struct {
+	version  string
+	os       string
+	users    map[string]bool
+	sessions int
+}
+
+// planStep is one step of the remediation plan: a group of users to contact together.
+type planStep struct {
+	group    string
+	cells    map[string]*planCell
+	users    map[string]bool
+	sessions int
+	announce time.Time
+}
+
+// remediationPlan is the steps for upgrading the desktop clients at or below a version, in the order to work
+// through them.
+type remediationPlan struct {
+	version  string
+	groupBy  string
+	steps    []*planStep
+	users    int
+	sessions int
+	interval int
+}
+
+// buildPlan groups the desktop sessions at or below the version into steps, and schedules a step every interval
+// days from the start.  Grouped by version, the oldest versions come first, since they're the furthest behind;
+// otherwise the largest groups come first.  Users in more than one team are in the step for each of them.
+func buildPlan(ctx context.Context, source Store, sessions []SessionRecord, version, groupBy string, start time.Time, interval int) (*remediationPlan, error) {
+	plan := &remediationPlan{version: version, groupBy: groupBy, interval: interval}
+	steps := make(map[string]*planStep)
+	users := make(map[string]bool)
+	teams := make(map[string][]string)
+
+	for _, session := range sessions {
+		match, ok := matchLookupSession(session, desktopOnly(version))
+		if !ok {
+			continue
+		}
+		var groups []string
+		switch groupBy {
+		case planByVersion:
+			groups = []string{match.client.Version}
+		case planByOS:
+			groups = []string{match.client.OS}
+		case planByTeam:
+			userTeams, seen := teams[session.UserID]
+			if !seen {
+				var err error
+				if userTeams, err = source.Teams(ctx, session.UserID); err != nil {
+					return nil, err
+				}
+				if len(userTeams) == 0 {
+					userTeams = []string{"No team"}
+				}
+				teams[session.UserID] = userTeams
+			}
+			groups = userTeams
+		}
+
+		users[session.UserID] = true
+		plan.sessions++
+		for _, group := range groups {
+			step := steps[group]
+			if step == nil {
+				step = &planStep{group: group, cells: make(map[string]*planCell), users: make(map[string]bool)}
+				steps[group] = step
+				plan.steps = append(plan.steps, step)
+			}
+			key := match.client.Version + "\x00" + match.client.OS
+			cell := step.cells[key]
+			if cell == nil {
+				cell = &planCell{version: match.client.Version, os: match.client.OS, users: make(map[string]bool)}
+				step.cells[key] = cell
+			}
+			cell.users[session.UserID] = true
+			cell.sessions++
+			step.users[session.UserID] = true
+			step.sessions++
+		}
+	}
+	plan.users = len(users)
+
+	sort.Slice(plan.steps, func(i, j int) bool {
+		a, b := plan.steps[i], plan.steps[j]
+		if groupBy == planByVersion {
+			return mmversions.Less(a.group, b.group)
+		}
+		if len(a.users) != len(b.users) {
+			return len(a.users) > len(b.users)
+		}
+		return a.group < b.group
+	})
+	for i, step := range plan.steps {
+		step.announce = start.AddDate(0, 0, i*interval)
+	}
+	return plan, nil
+}
+
+// writePlan writes the remediation plan as markdown, with an overview of the steps followed by the detail of each,
+// for a programme manager to use as the starting point of a rollout plan.  Each step has an announcement date, a
+// reminder halfway through and a deadline the day before the next step starts.
+func writePlan(w io.Writer, plan *remediationPlan, now time.Time) error {
+	const dateFormat = "2006-01-02"
+	var b strings.Builder
+	fmt.Fprintln(&b, "# Desktop App Remediation Plan")
+	fmt.Fprintln(&b)
+	if len(plan.steps) == 0 {
+		fmt.Fprintf(&b, "No desktop clients were found at or below version %s, so there's nothing to upgrade.  Generated %s.\n", plan.version, now.Format(dateFormat))
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	fmt.Fprintf(&b, "Upgrading the desktop clients at or below version %s, with a step for each %s.  Generated %s.\n\n",
+		plan.version, planGroupNames[plan.groupBy], now.Format(dateFormat))
+	fmt.Fprintf(&b, "- Users: %d\n- Outdated sessions: %d\n- Steps: %d\n", plan.users, plan.sessions, len(plan.steps))
+	if plan.groupBy == planByTeam {
+		fmt.Fprintln(&b, "\nUsers in more than one team are included in the step for each of their teams.")
+	}
+
+	reminderDays := plan.interval / 2
+	deadlineDays := max(plan.interval-1, 0)
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "| Step | Group | Users | Sessions | Announce | Reminder | Deadline |")
+	fmt.Fprintln(&b, "|------|-------|-------|----------|----------|----------|----------|")
+	for i, step := range plan.steps {
+		fmt.Fprintf(&b, "| %d | %s | %d | %d | %s | %s | %s |\n", i+1, markdownCell(step.group), len(step.users), step.sessions,
+			step.announce.Format(dateFormat), step.announce.AddDate(0, 0, reminderDays).Format(dateFormat),
+			step.announce.AddDate(0, 0, deadlineDays).Format(dateFormat))
+	}
+
+	for i, step := range plan.steps {
+		fmt.Fprintf(&b, "\n## Step %d: %s\n\n", i+1, step.group)
+		fmt.Fprintf(&b, "- Users: %d\n- Outdated sessions: %d\n", len(step.users), step.sessions)
+		fmt.Fprintf(&b, "- Announce the upgrade: %s\n", step.announce.Format(dateFormat))
+		fmt.Fprintf(&b, "- Remind anyone still on an old version: %s\n", step.announce.AddDate(0, 0, reminderDays).Format(dateFormat))
+		fmt.Fprintf(&b, "- Upgrade deadline: %s\n\n", step.announce.AddDate(0, 0, deadlineDays).Format(dateFormat))
+
+		cells := make([]*planCell, 0, len(step.cells))
+		for _, cell := range step.cells {
+			cells = append(cells, cell)
+		}
+		sort.Slice(cells, func(i, j int) bool {
+			if cells[i].version != cells[j].version {
+				return mmversions.Less(cells[i].version, cells[j].version)
+			}
+			return cells[i].os < cells[j].os
+		})
+		fmt.Fprintln(&b, "| Version | OS | Users | Sessions |")
+		fmt.Fprintln(&b, "|---------|----|-------|----------|")
+		for _, cell := range cells {
+			fmt.Fprintf(&b, "| %s | %s | %d | %d |\n", cell.version, markdownCell(cell.os), len(cell.users), cell.sessions)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownCell escapes the pipes in a value, e.g. a team name, so that it doesn't break the table.
+func markdownCell(value string) string {
+	return strings.ReplaceAll(value, "|", "\\|")
+}
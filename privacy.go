@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// minHMACKeyLength is the shortest email HMAC or pseudonym key that validate-config accepts without a warning.
+const minHMACKeyLength = 32
+
+// The prefixes of the pseudonymous identifiers, so they're obviously not real usernames or device IDs.
+const (
+	pseudonymPrefix       = "user-"
+	devicePseudonymPrefix = "device-"
+)
+
+// pseudonym returns a stable identifier for a user, the HMAC-SHA256 of their ID keyed with the configured secret, so
+// that redacted lookup files from different runs can still be compared without revealing who anyone is.  Without the
+// key, it can't be reversed by hashing a list of user IDs.
+func pseudonym(userID string, key string) string {
+	return keyedPseudonym(pseudonymPrefix, userID, key)
+}
+
+// devicePseudonym returns a stable identifier for a mobile device, in the same way as pseudonym, since a device ID
+// can be matched to its owner in an MDM.  Sessions without a device ID are left without one.
+func devicePseudonym(deviceID string, key string) string {
+	if deviceID == "" {
+		return ""
+	}
+	return keyedPseudonym(devicePseudonymPrefix, deviceID, key)
+}
+
+func keyedPseudonym(prefix string, id string, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(id))
+	return prefix + hex.EncodeToString(mac.Sum(nil))[:12]
+}
+
+// redactUser replaces the user's name and email with their pseudonym, for '-redact'.
+func redactUser(user UserRecord, key string) UserRecord {
+	id := pseudonym(user.ID, key)
+	user.Username = id
+	user.Email = id
+	user.FirstName = ""
+	user.LastName = ""
+	return user
+}
+
+// hashEmail returns the hex HMAC-SHA256 of an email address, keyed with the configured secret.  The address is
+// trimmed and lower-cased first, since Mattermost and most other systems treat email addresses case-insensitively.
+// Other datasets hashed the same way, with the same key, can then be joined on the result.
+func hashEmail(email string, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// errAggregateOnly is returned for anything that needs per-user data when aggregate-only mode is enabled.
+var errAggregateOnly = errors.New("not available in aggregate-only mode")
+
+// aggregateOnlySource refuses to read user data, for organisations that can only report on counts.  Blocking it
+// here, rather than in each command, means nothing can query the Users table by mistake.
+type aggregateOnlySource struct {
+	Store
+}
+
+func (s *aggregateOnlySource) EachSessionUser(ctx context.Context, fn func(session SessionRecord, user *UserRecord) error) error {
+	return errAggregateOnly
+}
+
+func (s *aggregateOnlySource) User(ctx context.Context, userID string) ([]UserRecord, error) {
+	return nil, errAggregateOnly
+}
+
+func (s *aggregateOnlySource) FindUser(ctx context.Context, name string) ([]UserRecord, error) {
+	return nil, errAggregateOnly
+}
+
+func (s *aggregateOnlySource) Teams(ctx context.Context, userID string) ([]string, error) {
+	return nil, errAggregateOnly
+}
+
+func (s *aggregateOnlySource) AllTeams(ctx context.Context) (map[string][]string, error) {
+	return nil, errAggregateOnly
+}
+
+func (s *aggregateOnlySource) UserCount(ctx context.Context) (int, error) {
+	return 0, errAggregateOnly
+}
+
+// withPrivacy wraps the source so that user data can't be read, if aggregate-only mode is enabled.
+func withPrivacy(source Store, config *Config) Store {
+	if !config.Privacy.AggregateOnly {
+		return source
+	}
+	DebugPrint("Aggregate-only mode enabled.  User data won't be read.")
+	return &aggregateOnlySource{Store: source}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// defaultRateLimit and defaultRateBurst are how many requests a minute each client can make to the HTTP server, and
+// how many it can make at once, by default.
+const (
+	defaultRateLimit = 60
+	defaultRateBurst = 10
+)
+
+// rateLimiterIdle is the shortest time a client's limiter is kept after its last request.  It's kept for longer if
+// the burst takes longer than this to refill, since forgetting the limiter any sooner would give the client a full
+// burst again.
+const rateLimiterIdle = 10 * time.Minute
+
+// unlimitedPaths are the health checks, which aren't rate limited, so that a busy dashboard on the same host can't
+// get the server restarted or taken out of a load balancer.
+var unlimitedPaths = map[string]bool{"/healthz": true, "/readyz": true}
+
+// rateLimiter limits how often each client can make requests, so that a misbehaving dashboard can't overload the
+// server, or the database behind it.  Clients are told apart by the address of the connection, as in the access log.
+type rateLimiter struct {
+	perMinute int
+	burst     int
+	// idle is how long a client's limiter is kept after its last request: the time it takes to refill the burst, but
+	// at least rateLimiterIdle
+	idle time.Duration
+
+	mu       sync.Mutex
+	clients  map[string]*clientLimiter
+	lastTidy time.Time
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	idle := max(rateLimiterIdle, time.Duration(burst)*time.Minute/time.Duration(perMinute))
+	return &rateLimiter{perMinute: perMinute, burst: burst, idle: idle, clients: make(map[string]*clientLimiter)}
+}
+
+// allow reports whether the client can make a request now and, if not, how long it should wait before trying again.
+func (l *rateLimiter) allow(clientIP string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastTidy) > l.idle {
+		for ip, client := range l.clients {
+			if now.Sub(client.lastSeen) > l.idle {
+				delete(l.clients, ip)
+			}
+		}
+		l.lastTidy = now
+	}
+
+	client, ok := l.clients[clientIP]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(float64(l.perMinute)/60), l.burst)}
+		l.clients[clientIP] = client
+	}
+	client.lastSeen = now
+	reservation := client.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// middleware answers requests over the limit with 429 Too Many Requests, and a Retry-After header in whole seconds.
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unlimitedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			clientIP = r.RemoteAddr
+		}
+		if ok, delay := l.allow(clientIP, time.Now()); !ok {
+			w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(delay.Seconds()))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterIdle(t *testing.T) {
+	tests := []struct {
+		perMinute, burst int
+		idle             time.Duration
+	}{
+		{defaultRateLimit, defaultRateBurst, rateLimiterIdle},
+		{1, 20, 20 * time.Minute},
+		{6, 90, 15 * time.Minute},
+	}
+	for _, test := range tests {
+		if idle := newRateLimiter(test.perMinute, test.burst).idle; idle != test.idle {
+			t.Errorf("idle for %d a minute with a burst of %d = %s, want %s", test.perMinute, test.burst, idle, test.idle)
+		}
+	}
+}
+
+// TestRateLimiterKeepsClients checks that a client isn't forgotten while its burst is still refilling, which would
+// let it make a full burst of requests again.
+func TestRateLimiterKeepsClients(t *testing.T) {
+	limiter := newRateLimiter(1, 20)
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		if ok, _ := limiter.allow("192.0.2.1", start); !ok {
+			t.Fatalf("request %d of the burst was refused", i+1)
+		}
+	}
+
+	// Another client's request after the shortest idle time tidies the limiters, but the first client has only
+	// refilled 15 of its tokens
+	later := start.Add(15 * time.Minute)
+	limiter.allow("192.0.2.2", later)
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		if ok, _ := limiter.allow("192.0.2.1", later); ok {
+			allowed++
+		}
+	}
+	if allowed != 15 {
+		t.Errorf("%d requests were allowed after 15 minutes, want 15", allowed)
+	}
+}
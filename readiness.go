@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// defaultReadinessFile is where the readiness command lists the users who would need to upgrade.
+const defaultReadinessFile = "upgrade-readiness.csv"
+
+// readinessHeader is the header of the readiness file.
+var readinessHeader = []string{"Username", "Email", "Platform", "OS", "Version", "Minimum"}
+
+// serverRelease is the oldest desktop and mobile app versions that a Mattermost server release supports, from the
+// upgrade section of the config file.
+type serverRelease struct {
+	Server            string `json:"server"`
+	MinDesktopVersion string `mapstructure:"min_desktop_version" json:"min_desktop_version"`
+	MinMobileVersion  string `mapstructure:"min_mobile_version" json:"min_mobile_version"`
+}
+
+// releaseMinimums finds the minimum app versions for the target server version: those of the newest listed release
+// at or before it, since a release keeps the minimums of the one before unless it raises them.
+func releaseMinimums(releases []serverRelease, target string) (serverRelease, bool) {
+	var found serverRelease
+	ok := false
+	for _, release := range releases {
+		if _, _, _, err := mmversions.ParseVersion(release.Server); err != nil {
+			continue
+		}
+		if older, err := mmversions.OlderOrEqual(release.Server, target); err != nil || !older {
+			continue
+		}
+		if !ok || mmversions.Less(found.Server, release.Server) {
+			found, ok = release, true
+		}
+	}
+	return found, ok
+}
+
+// affectedClient is a user's oldest client on one platform, which is below the minimum for that platform.
+type affectedClient struct {
+	userID  string
+	kind    mmversions.ClientKind
+	os      string
+	version string
+	minimum string
+}
+
+// affectedClients finds the users with active desktop or mobile clients older than the minimum versions, with the
+// oldest of their clients on each platform.  A platform without a minimum is left out.
+func affectedClients(sessions []SessionRecord, minDesktop, minMobile string) []affectedClient {
+	type key struct {
+		userID string
+		kind   mmversions.ClientKind
+	}
+	oldest := make(map[key]affectedClient)
+	for _, session := range sessions {
+		client, ok := countedAppClient(session)
+		if !ok {
+			continue
+		}
+		minimum := minDesktop
+		if client.Kind == mmversions.Mobile {
+			minimum = minMobile
+		}
+		if minimum == "" || !mmversions.Less(client.Version, minimum) {
+			continue
+		}
+		k := key{session.UserID, client.Kind}
+		if previous, seen := oldest[k]; !seen || mmversions.Less(client.Version, previous.version) {
+			oldest[k] = affectedClient{userID: session.UserID, kind: client.Kind, os: client.OS, version: client.Version, minimum: minimum}
+		}
+	}
+
+	affected := make([]affectedClient, 0, len(oldest))
+	for _, client := range oldest {
+		affected = append(affected, client)
+	}
+	sort.Slice(affected, func(i, j int) bool {
+		if affected[i].userID != affected[j].userID {
+			return affected[i].userID < affected[j].userID
+		}
+		return affected[i].kind < affected[j].kind
+	})
+	return affected
+}
+
+// readinessRows lists the affected clients with their users' details, sorted by username.  Users who can't be found
+// are listed by their ID.
+func readinessRows(ctx context.Context, source Store, affected []affectedClient) ([][]string, error) {
+	users := make(map[string]UserRecord)
+	var rows [][]string
+	for _, client := range affected {
+		user, seen := users[client.userID]
+		if !seen {
+			found, err := source.User(ctx, client.userID)
+			if err != nil {
+				return nil, err
+			}
+			user = UserRecord{Username: client.userID}
+			if len(found) > 0 {
+				user = found[0]
+			}
+			users[client.userID] = user
+		}
+		rows = append(rows, []string{user.Username, user.Email, string(client.kind), client.os, client.version, client.minimum})
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	return append([][]string{readinessHeader}, rows...), nil
+}
+
+// printReadiness writes how many sessions and users on each platform are below the minimum versions for the target
+// server version, and would need to upgrade before the server does.
+func printReadiness(w io.Writer, target string, sessions []SessionRecord, release serverRelease) {
+	counts := tallyBlocked(sessions, serverMinimums{Desktop: release.MinDesktopVersion, Android: release.MinMobileVersion, IOS: release.MinMobileVersion})
+	fmt.Fprintln(w, trf(msgReadinessFound, target))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", tr(msgColumnPlatform), tr(msgColumnMinimum), tr(msgColumnSessions), tr(msgColumnUsers))
+	for _, count := range counts {
+		fmt.Fprintf(tw, "  %s\t%s\t%d\t%d\n", tr(count.label), count.minimum, count.sessions, count.users)
+	}
+	tw.Flush()
+
+	users := make(map[string]bool)
+	for _, client := range affectedClients(sessions, release.MinDesktopVersion, release.MinMobileVersion) {
+		users[client.userID] = true
+	}
+	if len(users) == 0 {
+		fmt.Fprintln(w, "\n"+trf(msgReadinessReady, target))
+		return
+	}
+	fmt.Fprintln(w, "\n"+trf(msgReadinessUsers, len(users), target))
+}
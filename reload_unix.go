@@ -0,0 +1,11 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals ask a long-running command to reload its configuration, as is usual for daemons.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// reloadSignals is empty, since Windows has no equivalent of SIGHUP.  Services are restarted instead.
+var reloadSignals []os.Signal
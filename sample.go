@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// defaultUnknownSampleFile is where -sample-unknown writes the client strings that couldn't be classified.
+const defaultUnknownSampleFile = "unknown-clients.csv"
+
+// unknownSample is one distinct combination of client strings that the classifier couldn't make sense of, and how
+// many sessions had it.
+type unknownSample struct {
+	browser   string
+	os        string
+	userAgent string
+	sessions  int
+}
+
+// The parts of client strings that could identify someone, and what they're replaced with in the samples: email
+// addresses, IP addresses, and long words mixing letters and digits, such as device or install IDs.  Only words of
+// minLength or more are replaced, so that version and build tokens survive.
+var anonymisers = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+	minLength   int
+}{
+	{regexp.MustCompile(`[\w.+-]+@[\w-]+(\.[\w-]+)+`), "<email>", 0},
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}\b`), "<ip>", 0},
+	{regexp.MustCompile(`\b[0-9a-fA-F]{0,4}(:[0-9a-fA-F]{0,4}){2,7}\b`), "<ip>", 0},
+	{regexp.MustCompile(`[\w-]*(\d[\w-]*[a-zA-Z]|[a-zA-Z][\w-]*\d)[\w-]*`), "<id>", 16},
+}
+
+// anonymiseClientString replaces anything in a browser, OS or user agent string that could identify a user or their
+// device.
+func anonymiseClientString(s string) string {
+	for _, anonymiser := range anonymisers {
+		s = anonymiser.pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if len(match) < anonymiser.minLength {
+				return match
+			}
+			return anonymiser.replacement
+		})
+	}
+	return s
+}
+
+// sampleUnknownClients collects up to limit distinct, anonymised examples of the client strings that the classifier
+// couldn't recognise, in the order they're first seen, along with the number of distinct examples found in all.
+// Session, user and device IDs are left out entirely.
+func sampleUnknownClients(sessions []SessionRecord, limit int) ([]unknownSample, int) {
+	var samples []unknownSample
+	index := make(map[unknownSample]int)
+	for _, session := range sessions {
+		client, err := mmversions.Classify(session)
+		if err != nil || !client.Unrecognised {
+			continue
+		}
+		var props mmversions.Props
+		if err := json.Unmarshal([]byte(session.Props), &props); err != nil {
+			continue
+		}
+		key := unknownSample{
+			browser:   anonymiseClientString(props.Browser),
+			os:        anonymiseClientString(props.OS),
+			userAgent: anonymiseClientString(props.UserAgent),
+		}
+		i, seen := index[key]
+		if !seen {
+			i = len(index)
+			index[key] = i
+			if i < limit {
+				samples = append(samples, key)
+			}
+		}
+		if i < limit {
+			samples[i].sessions++
+		}
+	}
+	return samples, len(index)
+}
+
+// unknownSampleRows lists the samples for the sample file.  The first row is the header.
+func unknownSampleRows(samples []unknownSample) [][]string {
+	rows := [][]string{{"Browser", "OS", "User Agent", "Sessions"}}
+	for _, sample := range samples {
+		rows = append(rows, []string{sample.browser, sample.os, sample.userAgent, fmt.Sprint(sample.sessions)})
+	}
+	return rows
+}
+
+// writeUnknownSamples writes up to limit examples of the client strings that couldn't be classified to a CSV file.
+func writeUnknownSamples(ctx context.Context, filename string, sessions []SessionRecord, limit int, config *Config) error {
+	samples, distinct := sampleUnknownClients(sessions, limit)
+	if err := writeCSVFile(ctx, filename, unknownSampleRows(samples), config); err != nil {
+		return err
+	}
+	LogMessage(infoLevel, fmt.Sprintf("%d of %d unrecognised client strings written to: %s", len(samples), distinct, filename))
+	return nil
+}
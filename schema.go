@@ -0,0 +1,42 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// schemaVersion is the version of the JSON output formats, which is part of each schema's $id and is written to
+// snapshots.  It only changes when a field is removed or changes meaning, so that consumers can rely on it; new
+// fields can be added without changing it.
+const schemaVersion = 1
+
+// schemaFiles holds the JSON schemas for the JSON outputs, so that the 'schema' command can print the ones that
+// match this build.
+//
+//go:embed schemas/*.schema.json
+var schemaFiles embed.FS
+
+const schemaSuffix = ".schema.json"
+
+// schemaNames lists the available schemas, in alphabetical order.
+func schemaNames() []string {
+	entries, _ := fs.ReadDir(schemaFiles, "schemas")
+	var names []string
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), schemaSuffix))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readSchema returns the named schema.
+func readSchema(name string) ([]byte, error) {
+	data, err := schemaFiles.ReadFile("schemas/" + name + schemaSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("unknown schema %q.  The available schemas are: %s", name, strings.Join(schemaNames(), ", "))
+	}
+	return data, nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// schemaOutputs render each output that has a schema from fixed data, as the documents that the schema describes.
+// The NDJSON lookup lines are gathered into an array, since the schema describes the JSON array that they're the
+// items of.
+var schemaOutputs = map[string]func(t *testing.T) []interface{}{
+	"snapshot": func(t *testing.T) []interface{} {
+		store := testStore()
+		store.sessions = append(store.sessions,
+			desktopSession("s6", "u1", "5.9.0-nightly.20240601"),
+			SessionRecord{ID: "s7", UserID: "u2", Props: `{"browser": "Mattermost/4.2.0", "os": "Windows"}`},
+			SessionRecord{ID: "s8", UserID: "u2", Props: `{"is_bot": "true"}`},
+		)
+		summary, err := processSessions(context.Background(), store)
+		if err != nil {
+			t.Fatalf("processSessions: %v", err)
+		}
+		var documents []interface{}
+		for _, format := range []string{"json", "ndjson"} {
+			var buf bytes.Buffer
+			writer, err := newOutputWriter(format, &buf, &Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.Write(context.Background(), summary); err != nil {
+				t.Fatalf("%s output: %v", format, err)
+			}
+			documents = append(documents, decodeLines(t, buf.Bytes(), format == "ndjson")...)
+		}
+		return documents
+	},
+	"lookup": func(t *testing.T) []interface{} {
+		versions := lookupVersions{mmversions.Desktop: "9.0.0", mmversions.Mobile: "2.12.0"}
+		var documents []interface{}
+		for _, options := range []lookupOptions{
+			{},
+			{includeTeams: true, includeSessionID: true, dedupeUsers: true},
+			{redact: true, pseudonymKey: "a key for the tests"},
+		} {
+			for _, format := range []string{"json", "ndjson"} {
+				filename := filepath.Join(t.TempDir(), "lookup."+format)
+				options.format = format
+				options.config = &Config{}
+				if err := doLookup(context.Background(), testStore(), filename, versions, options); err != nil {
+					t.Fatalf("doLookup: %v", err)
+				}
+				data, err := os.ReadFile(filename)
+				if err != nil {
+					t.Fatal(err)
+				}
+				lines := decodeLines(t, data, format == "ndjson")
+				if format == "ndjson" {
+					documents = append(documents, lines)
+				} else {
+					documents = append(documents, lines...)
+				}
+			}
+		}
+		return documents
+	},
+	"error": func(t *testing.T) []interface{} {
+		var buf bytes.Buffer
+		for class := range errorCodes {
+			writeErrorReport(&buf, "lookup", newCommandError(class, errors.New("the cause"), "Failed to do something"))
+		}
+		writeErrorReport(&buf, "report", errors.New("an error that wasn't classified"))
+		return decodeLines(t, buf.Bytes(), true)
+	},
+	"access-log": func(t *testing.T) []interface{} {
+		var buf bytes.Buffer
+		logger := &accessLogger{w: &buf, format: accessLogJSON}
+		handler := logger.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/summary" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintln(w, "{}")
+		}))
+		for _, path := range []string{"/summary?refresh=true", "/missing"} {
+			request := httptest.NewRequest(http.MethodGet, path, nil)
+			request.SetBasicAuth("someone", "anything")
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+		}
+		return decodeLines(t, buf.Bytes(), true)
+	},
+}
+
+// TestOutputsMatchSchemas checks that each JSON output validates against the schema that 'schema' prints for it.
+func TestOutputsMatchSchemas(t *testing.T) {
+	for _, name := range schemaNames() {
+		t.Run(name, func(t *testing.T) {
+			data, err := readSchema(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var schema map[string]interface{}
+			if err := json.Unmarshal(data, &schema); err != nil {
+				t.Fatalf("schema isn't valid JSON: %v", err)
+			}
+			render, ok := schemaOutputs[name]
+			if !ok {
+				t.Fatalf("there's no output to check against the %s schema", name)
+			}
+			documents := render(t)
+			if len(documents) == 0 {
+				t.Fatal("nothing was rendered")
+			}
+			for i, document := range documents {
+				for _, problem := range validateSchema(schema, schema, document, "") {
+					t.Errorf("document %d: %s", i, problem)
+				}
+			}
+		})
+	}
+}
+
+// decodeLines decodes the output as a single JSON document, or as one for each line.
+func decodeLines(t *testing.T, data []byte, perLine bool) []interface{} {
+	t.Helper()
+	if !perLine {
+		var document interface{}
+		if err := json.Unmarshal(data, &document); err != nil {
+			t.Fatalf("output isn't valid JSON: %v", err)
+		}
+		return []interface{}{document}
+	}
+	var documents []interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var document interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &document); err != nil {
+			t.Fatalf("line %q isn't valid JSON: %v", scanner.Text(), err)
+		}
+		documents = append(documents, document)
+	}
+	return documents
+}
+
+// validateSchema checks a value against the parts of JSON Schema that the schemas use, returning what's wrong with
+// it.  Keywords it doesn't know are reported too, so a schema can't start using one without the check growing to
+// cover it.
+func validateSchema(root, schema map[string]interface{}, value interface{}, path string) []string {
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s: %s", displayPath(path), fmt.Sprintf(format, args...)))
+	}
+
+	keywords := make([]string, 0, len(schema))
+	for keyword := range schema {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+	for _, keyword := range keywords {
+		rule := schema[keyword]
+		switch keyword {
+		case "$schema", "$id", "$defs", "title", "description", "properties":
+			// Annotations, or handled with another keyword
+		case "$ref":
+			ref := rule.(string)
+			target, ok := root, true
+			for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+				if target, ok = target[part].(map[string]interface{}); !ok {
+					break
+				}
+			}
+			if !ok {
+				fail("can't resolve %s", ref)
+				continue
+			}
+			problems = append(problems, validateSchema(root, target, value, path)...)
+		case "type":
+			if !hasType(value, rule.(string)) {
+				fail("%v isn't of type %s", value, rule)
+			}
+		case "const":
+			if value != rule {
+				fail("%v isn't %v", value, rule)
+			}
+		case "enum":
+			found := false
+			for _, allowed := range rule.([]interface{}) {
+				found = found || value == allowed
+			}
+			if !found {
+				fail("%v isn't one of %v", value, rule)
+			}
+		case "minimum":
+			if number, ok := value.(float64); ok && number < rule.(float64) {
+				fail("%v is below the minimum of %v", value, rule)
+			}
+		case "pattern":
+			if text, ok := value.(string); ok && !regexp.MustCompile(rule.(string)).MatchString(text) {
+				fail("%q doesn't match %s", text, rule)
+			}
+		case "format":
+			if text, ok := value.(string); ok && rule == "date-time" {
+				if _, err := time.Parse(time.RFC3339, text); err != nil {
+					fail("%q isn't a date-time", text)
+				}
+			}
+		case "required":
+			if object, ok := value.(map[string]interface{}); ok {
+				for _, name := range rule.([]interface{}) {
+					if _, ok := object[name.(string)]; !ok {
+						fail("%s is missing", name)
+					}
+				}
+			}
+		case "additionalProperties":
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			properties, _ := schema["properties"].(map[string]interface{})
+			for name, property := range object {
+				if _, ok := properties[name]; !ok {
+					problems = append(problems, validateSchema(root, rule.(map[string]interface{}), property, path+"/"+name)...)
+				}
+			}
+		case "items":
+			if array, ok := value.([]interface{}); ok {
+				for i, item := range array {
+					problems = append(problems, validateSchema(root, rule.(map[string]interface{}), item, fmt.Sprintf("%s/%d", path, i))...)
+				}
+			}
+		default:
+			fail("the %s keyword isn't supported by the check", keyword)
+		}
+	}
+
+	if properties, ok := schema["properties"].(map[string]interface{}); ok {
+		if object, ok := value.(map[string]interface{}); ok {
+			for name, property := range object {
+				if propertySchema, ok := properties[name].(map[string]interface{}); ok {
+					problems = append(problems, validateSchema(root, propertySchema, property, path+"/"+name)...)
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// hasType reports whether a decoded JSON value is of a JSON Schema type.
+func hasType(value interface{}, name string) bool {
+	switch name {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	}
+	return false
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemdNotifier tells systemd how a Type=notify service is getting on, through the socket in NOTIFY_SOCKET, and
+// pings its watchdog if WatchdogSec is set for the unit.  It's nil when the utility isn't run by systemd, and all of
+// its methods do nothing then.
+type systemdNotifier struct {
+	conn net.Conn
+	// watchdog is how often systemd expects to hear from the service, or 0 if the watchdog isn't enabled
+	watchdog time.Duration
+}
+
+// newSystemdNotifier connects to systemd's notification socket, if there is one.
+func newSystemdNotifier() *systemdNotifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// Names starting with @ are in the abstract namespace
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		LogMessage(warningLevel, "Unable to connect to systemd, so it won't be notified of the service's status: "+err.Error())
+		return nil
+	}
+	DebugPrint("Notifying systemd through " + os.Getenv("NOTIFY_SOCKET"))
+	return &systemdNotifier{conn: conn, watchdog: watchdogInterval()}
+}
+
+// watchdogInterval reads the watchdog timeout that systemd sets for the service.  WATCHDOG_PID is checked as well,
+// if it's set, so that child processes don't ping the watchdog on the service's behalf.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// notify sends one or more state lines, e.g. READY=1, to systemd.
+func (n *systemdNotifier) notify(states ...string) {
+	if n == nil {
+		return
+	}
+	if _, err := n.conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		DebugPrint("Failed to notify systemd: " + err.Error())
+	}
+}
+
+// runWatchdog pings the watchdog at half its timeout, as systemd recommends, until done is closed.  healthy is asked
+// before each ping, so that a service that's stuck stops pinging, and is restarted.
+func (n *systemdNotifier) runWatchdog(done <-chan struct{}, healthy func() bool) {
+	if n == nil || n.watchdog == 0 {
+		return
+	}
+	DebugPrint("Pinging the systemd watchdog every " + (n.watchdog / 2).String())
+	ticker := time.NewTicker(n.watchdog / 2)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if healthy() {
+					n.notify("WATCHDOG=1")
+				}
+			}
+		}
+	}()
+}
+
+// close disconnects from systemd.
+func (n *systemdNotifier) close() {
+	if n != nil {
+		n.conn.Close()
+	}
+}
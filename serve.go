@@ -0,0 +1,505 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shutdownTimeout is how long requests in progress are given to finish when the server is stopped.
+const shutdownTimeout = 10 * time.Second
+
+// defaultRefreshEvery is how long the server keeps serving the same version counts, by default, so that dashboards
+// polling every few seconds don't each cause a scan of the Sessions table.
+const defaultRefreshEvery = time.Minute
+
+// readyTimeout limits how long the readiness check waits for the database, so a probe doesn't hang on it.
+const readyTimeout = 5 * time.Second
+
+// versionServer exposes the version counts over HTTP, for Prometheus to scrape and for dashboards to poll.
+type versionServer struct {
+	// mu guards source and config, which are replaced when the configuration is reloaded
+	mu          sync.RWMutex
+	source      Store
+	config      *Config
+	closeSource func()
+	// reopen loads the configuration again and opens a new source, for reloading
+	reopen func() (Store, *Config, func(), error)
+	// digestEvery is how often a digest of the changes is sent, or 0 for never
+	digestEvery time.Duration
+	// refreshEvery is how long a tally is served from the cache before the sessions are counted again, or 0 to count
+	// them afresh for every request
+	refreshEvery time.Duration
+	// accessLog is set when requests are to be logged, and rateLimit when they're to be rate limited
+	accessLog *accessLogger
+	rateLimit *rateLimiter
+	// tallying is held by the tally or digest in progress, so that only one of them scans the sessions at a time
+	tallying chan struct{}
+
+	// notifier keeps systemd up to date, when it's running the server
+	notifier *systemdNotifier
+
+	// statusMu guards the outcome of the most recent tally, and the tallies in progress, for the health checks
+	statusMu      sync.Mutex
+	lastCollected time.Time
+	lastErr       error
+	running       map[int]time.Time
+	nextRun       int
+
+	// cacheMu guards the most recent tally, which is served until it's refreshEvery old, and its entity tag
+	cacheMu  sync.Mutex
+	cached   *Snapshot
+	cachedAt time.Time
+	etag     string
+}
+
+// healthStatus is the body of the /healthz and /readyz responses.
+type healthStatus struct {
+	Status   string `json:"status"`
+	Database string `json:"database,omitempty"`
+	// LastCollection is when the sessions were last tallied successfully, if they have been
+	LastCollection *time.Time `json:"last_collection,omitempty"`
+	// LastCollectionAge is how long ago that was, in whole seconds
+	LastCollectionAge *int64 `json:"last_collection_age_seconds,omitempty"`
+	// LastError is why the most recent tally failed, if it did
+	LastError string `json:"last_error,omitempty"`
+}
+
+// collect runs a fresh tally of the sessions.  The source is held until it's finished, so that a reload doesn't close
+// it part-way through.
+func (s *versionServer) collect(ctx context.Context) (Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	run := s.startCollection()
+	summary, err := processSessions(ctx, s.source)
+	s.finishCollection(run, err)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return newSnapshot(summary), nil
+}
+
+// latest returns the cached tally and its entity tag, running a fresh tally first if the cache is older than
+// refreshEvery, or if refresh is set.  Only one tally runs at a time: requests that arrive while one is running wait
+// for it, and are then answered from the cache if it's fresh enough, so a burst of requests doesn't stack up scans of
+// the Sessions table.
+func (s *versionServer) latest(ctx context.Context, refresh bool) (Snapshot, string, error) {
+	requested := time.Now()
+	if snapshot, etag, ok := s.fromCache(requested, refresh); ok {
+		return snapshot, etag, nil
+	}
+	release, err := s.acquireTally(ctx)
+	if err != nil {
+		return Snapshot{}, "", err
+	}
+	defer release()
+	if snapshot, etag, ok := s.fromCache(requested, refresh); ok {
+		return snapshot, etag, nil
+	}
+
+	started := time.Now()
+	snapshot, err := s.collect(ctx)
+	if err != nil {
+		return Snapshot{}, "", err
+	}
+	etag, err := snapshotETag(snapshot)
+	if err != nil {
+		return Snapshot{}, "", err
+	}
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	s.cached, s.cachedAt, s.etag = &snapshot, started, etag
+	return snapshot, etag, nil
+}
+
+// fromCache returns the cached tally if it was started after the request was made or, unless refresh is set, if
+// it's less than refreshEvery old.
+func (s *versionServer) fromCache(requested time.Time, refresh bool) (Snapshot, string, bool) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	if s.cached == nil {
+		return Snapshot{}, "", false
+	}
+	if s.cachedAt.After(requested) || (!refresh && time.Since(s.cachedAt) < s.refreshEvery) {
+		return *s.cached, s.etag, true
+	}
+	return Snapshot{}, "", false
+}
+
+// acquireTally waits until no other tally or digest is running, or until ctx is cancelled, e.g. because the client
+// has gone away.  The returned function lets the next one go ahead.
+func (s *versionServer) acquireTally(ctx context.Context) (func(), error) {
+	select {
+	case s.tallying <- struct{}{}:
+		return func() { <-s.tallying }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// invalidate drops the cached tally, so that the next request counts the sessions again.
+func (s *versionServer) invalidate() {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	s.cached = nil
+}
+
+// snapshotETag is the entity tag for a tally: a hash of the counts, leaving out when they were generated, so that it
+// only changes when the counts do.
+func snapshotETag(snapshot Snapshot) (string, error) {
+	snapshot.GeneratedAt = time.Time{}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// notModified answers a conditional request with 304 Not Modified if the client already has the current version of
+// the response, going by its If-None-Match header.  Otherwise it sets the entity tag, for the client to send next time.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// startCollection notes that a tally has started, returning its ID for finishCollection.
+func (s *versionServer) startCollection() int {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	if s.running == nil {
+		s.running = make(map[int]time.Time)
+	}
+	s.nextRun++
+	s.running[s.nextRun] = time.Now()
+	LogMessage(infoLevel, fmt.Sprintf("Tally %d started", s.nextRun))
+	return s.nextRun
+}
+
+// finishCollection notes the outcome of a tally for the health checks, and passes it on to systemd.
+func (s *versionServer) finishCollection(run int, err error) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	started := s.running[run]
+	delete(s.running, run)
+	elapsed := time.Since(started).Round(time.Millisecond)
+
+	s.lastErr = err
+	if err != nil {
+		LogMessage(warningLevel, fmt.Sprintf("Tally %d failed after %s: %v", run, elapsed, err))
+		s.notifier.notify("STATUS=Last tally failed: " + err.Error())
+		return
+	}
+	s.lastCollected = time.Now()
+	LogMessage(infoLevel, fmt.Sprintf("Tally %d finished in %s", run, elapsed))
+	s.notifier.notify("STATUS=Last tallied at " + s.lastCollected.In(outputLocation).Format(time.RFC3339))
+}
+
+// stuck reports whether a tally has been running for longer than limit, e.g. because a query has hung.
+func (s *versionServer) stuck(limit time.Duration) bool {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	for run, started := range s.running {
+		if time.Since(started) > limit {
+			LogMessage(warningLevel, fmt.Sprintf("Tally %d has been running for over %s, so the systemd watchdog won't be pinged", run, limit))
+			return true
+		}
+	}
+	return false
+}
+
+// health describes the most recent tally.
+func (s *versionServer) health() healthStatus {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	status := healthStatus{Status: "ok"}
+	if !s.lastCollected.IsZero() {
+		collected := s.lastCollected.In(outputLocation)
+		age := int64(time.Since(s.lastCollected).Seconds())
+		status.LastCollection, status.LastCollectionAge = &collected, &age
+	}
+	if s.lastErr != nil {
+		status.LastError = s.lastErr.Error()
+	}
+	return status
+}
+
+func (s *versionServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/summary", s.handleSummary)
+	mux.HandleFunc("/refresh", s.handleRefresh)
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/readyz", s.handleReady)
+	return mux
+}
+
+// handleMetrics writes the version counts in the Prometheus text exposition format.
+func (s *versionServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	snapshot, etag, err := s.latest(r.Context(), false)
+	if err != nil {
+		http.Error(w, "error collecting version counts", http.StatusInternalServerError)
+		return
+	}
+	if notModified(w, r, etag) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetricFamily(w, "mattermost_desktop_clients", "Active Mattermost desktop app sessions by version and OS.", "version", snapshot.Desktop)
+	writeMetricFamily(w, "mattermost_mobile_clients", "Active Mattermost mobile app sessions by version and OS.", "version", snapshot.Mobile)
+	writeMetricFamily(w, "mattermost_desktop_dev_clients", "Active nightly and developer desktop app sessions by version and OS.", "version", snapshot.DesktopDev)
+	writeMetricFamily(w, "mattermost_desktop_legacy_clients", "Active legacy desktop app sessions by version and OS.", "version", snapshot.DesktopLegacy)
+	writeMetricFamily(w, "mattermost_api_clients", "Active bot, personal access token and OAuth app sessions by type and OS.", "type", snapshot.API)
+	fmt.Fprintln(w, "# HELP mattermost_mobile_devices Distinct devices with an active Mattermost mobile app session.")
+	fmt.Fprintln(w, "# TYPE mattermost_mobile_devices gauge")
+	fmt.Fprintf(w, "mattermost_mobile_devices %d\n", snapshot.MobileDevices)
+	fmt.Fprintln(w, "# HELP mattermost_active_clients Total active Mattermost desktop and mobile app sessions.")
+	fmt.Fprintln(w, "# TYPE mattermost_active_clients gauge")
+	fmt.Fprintf(w, "mattermost_active_clients %d\n", snapshot.Total)
+}
+
+func writeMetricFamily(w http.ResponseWriter, name string, help string, label string, entries []VersionEntry) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s{%s=\"%s\",os=\"%s\"} %d\n", name, label, escapeLabel(entry.Version), escapeLabel(entry.OS), entry.Count)
+	}
+}
+
+// escapeLabel escapes a Prometheus label value.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// handleSummary writes the version counts as JSON, in the same format as the snapshot command.
+func (s *versionServer) handleSummary(w http.ResponseWriter, r *http.Request) {
+	s.writeSummary(w, r, false)
+}
+
+// handleRefresh counts the sessions again straight away, rather than waiting for the cache to expire, and writes the
+// new counts as /summary does.  It only accepts POST, so that crawlers and prefetching can't trigger a tally.
+func (s *versionServer) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "use POST to refresh the version counts", http.StatusMethodNotAllowed)
+		return
+	}
+	s.writeSummary(w, r, true)
+}
+
+func (s *versionServer) writeSummary(w http.ResponseWriter, r *http.Request, refresh bool) {
+	snapshot, etag, err := s.latest(r.Context(), refresh)
+	if err != nil {
+		http.Error(w, "error collecting version counts", http.StatusInternalServerError)
+		return
+	}
+	if !refresh && notModified(w, r, etag) {
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		LogMessage(warningLevel, "Failed to write summary response: "+err.Error())
+	}
+}
+
+// handleHealth is the liveness check.  It succeeds as long as the server is responding, since restarting won't fix a
+// database that's down, but reports when the sessions were last tallied so that monitoring can alert on it.
+func (s *versionServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, s.health())
+}
+
+// handleReady is the readiness check.  It fails while the database can't be reached, so that load balancers stop
+// sending requests that can only fail.
+func (s *versionServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	status := s.health()
+	s.mu.RLock()
+	err := s.source.Ping(ctx)
+	s.mu.RUnlock()
+
+	code := http.StatusOK
+	status.Database = "ok"
+	if err != nil {
+		code = http.StatusServiceUnavailable
+		status.Status = "unavailable"
+		status.Database = err.Error()
+	}
+	writeHealth(w, code, status)
+}
+
+func writeHealth(w http.ResponseWriter, code int, status healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		LogMessage(warningLevel, "Failed to write health response: "+err.Error())
+	}
+}
+
+// reload swaps in a freshly opened source, e.g. after database credentials have been rotated, and closes the old one
+// once any tallies using it have finished.  If the new configuration can't be used, the old source is kept, so a
+// mistake in the config file doesn't take the server down.
+func (s *versionServer) reload() {
+	LogMessage(infoLevel, "Reloading configuration")
+	s.notifier.notify("RELOADING=1")
+	defer s.notifier.notify("READY=1")
+	source, config, closeSource, err := s.reopen()
+	if err != nil {
+		LogMessage(errorLevel, "Failed to reload configuration, so carrying on with the previous one: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	oldClose := s.closeSource
+	s.source, s.config, s.closeSource = source, config, closeSource
+	s.mu.Unlock()
+
+	oldClose()
+	s.invalidate()
+	LogMessage(infoLevel, "Configuration reloaded")
+}
+
+// watchReload reloads the configuration whenever one of the reloadSignals is received, until ctx is cancelled.
+func (s *versionServer) watchReload(ctx context.Context) {
+	if s.reopen == nil || len(reloadSignals) == 0 {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, reloadSignals...)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				s.reload()
+			}
+		}
+	}()
+}
+
+// digestCheckInterval is the longest the server waits between checks for whether a digest is due.
+const digestCheckInterval = time.Hour
+
+// runDigests sends a digest whenever the last one is digestEvery old, until ctx is cancelled.  It goes by the time in
+// the digest state file rather than when the server started, so that restarts don't delay or repeat the digest.
+func (s *versionServer) runDigests(ctx context.Context) {
+	if s.digestEvery <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(min(s.digestEvery, digestCheckInterval))
+		defer ticker.Stop()
+		for {
+			s.digestIfDue(ctx, time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// digestIfDue sends a digest if there hasn't been one for digestEvery.  The source is held until it's finished, as for
+// a tally, and it waits for any tally in progress, since it scans the sessions too.
+func (s *versionServer) digestIfDue(ctx context.Context, now time.Time) {
+	release, err := s.acquireTally(ctx)
+	if err != nil {
+		return
+	}
+	defer release()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	last, err := readDigestState(s.config.Output.DigestFile)
+	if err != nil {
+		LogMessage(warningLevel, "Unable to read the previous digest: "+err.Error())
+		return
+	}
+	if last != nil && now.Sub(last.GeneratedAt) < s.digestEvery {
+		return
+	}
+	if _, err := runDigest(ctx, s.source, s.config, now, false); err != nil {
+		LogMessage(warningLevel, "Digest failed: "+err.Error())
+	}
+}
+
+// close closes the current source, and the access log.
+func (s *versionServer) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeSource()
+	s.accessLog.close()
+}
+
+// serve runs the HTTP server until it fails, or until ctx is cancelled.  Requests that are in progress when it's
+// cancelled are given shutdownTimeout to finish.  When it's run by systemd, systemd is told once the server is
+// listening, and the watchdog is pinged for as long as no tally is stuck.
+func (s *versionServer) serve(ctx context.Context, listenAddr string) error {
+	s.notifier = newSystemdNotifier()
+	defer s.notifier.close()
+	s.tallying = make(chan struct{}, 1)
+
+	var handler http.Handler = s.routes()
+	if s.rateLimit != nil {
+		handler = s.rateLimit.middleware(handler)
+	}
+	if s.accessLog != nil {
+		handler = s.accessLog.middleware(handler)
+	}
+	server := &http.Server{
+		Addr:              listenAddr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		LogMessage(infoLevel, "Shutting down the HTTP server")
+		s.notifier.notify("STOPPING=1")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		stopped <- server.Shutdown(shutdownCtx)
+	}()
+
+	s.watchReload(ctx)
+	s.runDigests(ctx)
+	s.notifier.runWatchdog(ctx.Done(), func() bool { return !s.stuck(s.notifier.watchdog) })
+
+	LogMessage(infoLevel, "Listening on "+listenAddr)
+	s.notifier.notify("READY=1", "STATUS=Listening on "+listenAddr)
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return <-stopped
+}
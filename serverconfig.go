@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// serverMinimums are the oldest app versions that the Mattermost server itself allows to connect, from the
+// ClientRequirements section of its configuration.  Users on older versions are already blocked by the server.  A
+// minimum is empty if it isn't enforced.
+type serverMinimums struct {
+	Desktop string `json:"DesktopMinVersion"`
+	Android string `json:"AndroidMinVersion"`
+	IOS     string `json:"IosMinVersion"`
+}
+
+func (m serverMinimums) empty() bool {
+	return m.Desktop == "" && m.Android == "" && m.IOS == ""
+}
+
+// parseServerMinimums reads the minimum app versions from the server's configuration, as stored in the
+// Configurations table or a support packet's sanitized_config.json.
+func parseServerMinimums(configJSON string) (serverMinimums, error) {
+	var config struct {
+		ClientRequirements serverMinimums
+	}
+	if err := json.Unmarshal([]byte(configJSON), &config); err != nil {
+		return serverMinimums{}, fmt.Errorf("the server's configuration isn't valid JSON: %w", err)
+	}
+	return config.ClientRequirements, nil
+}
+
+// blockedCount is the number of sessions, and distinct users, below one of the server's minimum versions.
+type blockedCount struct {
+	label    string
+	minimum  string
+	sessions int
+	users    int
+}
+
+// tallyBlocked counts the desktop, Android and iOS sessions below the server's minimum versions.  Platforms
+// without a minimum are left out.
+func tallyBlocked(sessions []SessionRecord, minimums serverMinimums) []blockedCount {
+	counts := []blockedCount{
+		{label: msgPlatformDesktop, minimum: minimums.Desktop},
+		{label: msgPlatformAndroid, minimum: minimums.Android},
+		{label: msgPlatformIOS, minimum: minimums.IOS},
+	}
+	users := make([]map[string]bool, len(counts))
+	for i := range users {
+		users[i] = make(map[string]bool)
+	}
+
+	for _, session := range sessions {
+		client, ok := countedAppClient(session)
+		if !ok {
+			continue
+		}
+		i := 0
+		if client.Kind == mmversions.Mobile {
+			switch osFamily(client.OS) {
+			case "android":
+				i = 1
+			case "ios":
+				i = 2
+			default:
+				continue
+			}
+		}
+		if counts[i].minimum == "" || !mmversions.Less(client.Version, counts[i].minimum) {
+			continue
+		}
+		counts[i].sessions++
+		users[i][session.UserID] = true
+	}
+
+	var enforced []blockedCount
+	for i, count := range counts {
+		if count.minimum != "" {
+			count.users = len(users[i])
+			enforced = append(enforced, count)
+		}
+	}
+	return enforced
+}
+
+// printBlockedTable writes the sessions below the server's minimum versions.
+func printBlockedTable(w io.Writer, counts []blockedCount) {
+	fmt.Fprintln(w, "\n"+tr(msgBlockedFound))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", tr(msgColumnPlatform), tr(msgColumnMinimum), tr(msgColumnSessions), tr(msgColumnUsers))
+	for _, count := range counts {
+		fmt.Fprintf(tw, "  %s\t%s\t%d\t%d\n", tr(count.label), count.minimum, count.sessions, count.users)
+	}
+	tw.Flush()
+}
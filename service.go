@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+)
+
+// serviceDisplayName is the name shown for the Windows service in the Services console.
+const serviceDisplayName = "Mattermost client version exporter"
+
+func serviceCommand() (*flag.FlagSet, func(context.Context, []string) error) {
+	fs := newFlagSet(findCommand("service"))
+	var name string
+	fs.StringVar(&name, "name", appName, "[optional] `name` of the service, to run more than one on the same machine")
+
+	return fs, func(ctx context.Context, args []string) error {
+		if len(args) == 0 {
+			return usageError("An action is required: install, uninstall, start or stop")
+		}
+		action, serveArgs := args[0], args[1:]
+		if action != "install" && len(serveArgs) > 0 {
+			return usageError("Flags for serve can only be given when installing the service")
+		}
+
+		switch action {
+		case "install":
+			// Check the flags now, rather than leaving the service to fail when it's started
+			serveFlags, _ := serveCommand()
+			serveFlags.SetOutput(io.Discard)
+			if err := serveFlags.Parse(serveArgs); err != nil {
+				return usageError("Invalid flags for serve: %v", err)
+			}
+			if serveFlags.NArg() > 0 {
+				return usageError("Unexpected argument for serve: %s", serveFlags.Arg(0))
+			}
+			return installService(name, serveArgs)
+		case "uninstall":
+			return removeService(name)
+		case "start":
+			return startService(name)
+		case "stop":
+			return stopService(name)
+		}
+		return usageError("Unknown action %q.  This must be install, uninstall, start or stop", action)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package main
+
+// errNoServices is returned by the service command everywhere but Windows.
+var errNoServices = usageError("Services can only be installed on Windows.  Elsewhere, run serve from systemd or another service manager")
+
+// runAsService never runs anything, since the process can only be started as a service on Windows.
+func runAsService(args []string) (int, bool) {
+	return 0, false
+}
+
+func installService(name string, serveArgs []string) error {
+	return errNoServices
+}
+
+func removeService(name string) error {
+	return errNoServices
+}
+
+func startService(name string) error {
+	return errNoServices
+}
+
+func stopService(name string) error {
+	return errNoServices
+}
@@ -0,0 +1,202 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceStopTimeout is how long 'service stop' waits for the service to finish stopping.
+const serviceStopTimeout = 30 * time.Second
+
+// runAsService runs the command line as a Windows service, if the process was started by the service manager.  The
+// second result is false, and nothing is run, otherwise.
+func runAsService(args []string) (int, bool) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return 0, false
+	}
+
+	// Services start in the system directory, so take relative paths, such as the default config.json, from the
+	// executable's directory instead
+	if exe, err := os.Executable(); err == nil {
+		_ = os.Chdir(filepath.Dir(exe))
+	}
+
+	handler := &serviceHandler{args: args}
+	if err := svc.Run(appName, handler); err != nil {
+		LogMessage(errorLevel, "Unable to run as a service: "+err.Error())
+		return exitCodes[serverFailure], true
+	}
+	return handler.exitCode, true
+}
+
+// serviceHandler runs a command for the service manager, stopping it when the service is stopped.
+type serviceHandler struct {
+	args     []string
+	exitCode int
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan int, 1)
+	go func() {
+		done <- run(ctx, h.args)
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case h.exitCode = <-done:
+			if h.exitCode != exitOK {
+				// Report the exit code as a service-specific error, so that the recovery actions restart it
+				return true, uint32(h.exitCode)
+			}
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+			}
+		}
+	}
+}
+
+// openServiceManager connects to the service manager, which needs administrator rights.
+func openServiceManager() (*mgr.Mgr, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, serverError(err, "Unable to connect to the service manager (run this as an administrator)")
+	}
+	return m, nil
+}
+
+// installService registers a service that runs serve with the given flags, starting automatically with Windows and
+// restarting if it fails.
+func installService(name string, serveArgs []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return serverError(err, "Unable to find the path of this program")
+	}
+	m, err := openServiceManager()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return usageError("The %s service is already installed.  Uninstall it first to change its flags", name)
+	}
+
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: serviceDisplayName,
+		Description: "Serves the versions of the Mattermost desktop and mobile apps in use over HTTP, for Prometheus and dashboards.",
+		StartType:   mgr.StartAutomatic,
+	}, append([]string{"serve"}, serveArgs...)...)
+	if err != nil {
+		return serverError(err, "Unable to install the %s service", name)
+	}
+	defer s.Close()
+
+	// Restart after a failure, backing off if it keeps failing, and forget the failures after a day
+	recovery := []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 10 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Minute},
+	}
+	if err := s.SetRecoveryActions(recovery, uint32((24 * time.Hour).Seconds())); err != nil {
+		LogMessage(warningLevel, "Unable to set the service to restart after failures: "+err.Error())
+	}
+
+	// Register the event source while we have administrator rights, for -system-log
+	_ = eventlog.InstallAsEventCreate(appName, eventlog.Error|eventlog.Warning|eventlog.Info)
+
+	LogMessage(infoLevel, fmt.Sprintf("Installed the %s service, running: %s serve %v", name, exe, serveArgs))
+	return nil
+}
+
+// removeService unregisters the service.  If it's running, Windows removes it once it has stopped.
+func removeService(name string) error {
+	m, err := openServiceManager()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return usageError("The %s service isn't installed", name)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return serverError(err, "Unable to uninstall the %s service", name)
+	}
+	LogMessage(infoLevel, "Uninstalled the "+name+" service")
+	return nil
+}
+
+func startService(name string) error {
+	m, err := openServiceManager()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return usageError("The %s service isn't installed", name)
+	}
+	defer s.Close()
+	if err := s.Start(); err != nil {
+		return serverError(err, "Unable to start the %s service", name)
+	}
+	LogMessage(infoLevel, "Started the "+name+" service")
+	return nil
+}
+
+// stopService asks the service to stop, and waits for it to finish.
+func stopService(name string) error {
+	m, err := openServiceManager()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return usageError("The %s service isn't installed", name)
+	}
+	defer s.Close()
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return serverError(err, "Unable to stop the %s service", name)
+	}
+
+	deadline := time.Now().Add(serviceStopTimeout)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return serverError(nil, "The %s service didn't stop within %s", name, serviceStopTimeout)
+		}
+		time.Sleep(300 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return serverError(err, "Unable to check the %s service", name)
+		}
+	}
+	LogMessage(infoLevel, "Stopped the "+name+" service")
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"aead.dev/minisign"
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// signOutput writes a SHA-256 checksum for an output file, in the format read by 'sha256sum -c', and signs the file
+// with the minisign and GPG keys in the config, so that people who receive it by email or from an upload can check
+// it hasn't been changed.  It returns the files written, which are named after the output file.
+func signOutput(config *Config, filename string) ([]string, error) {
+	settings := config.Signing
+	if !settings.Checksums && settings.MinisignKey == "" && settings.GPGKey == "" {
+		return nil, nil
+	}
+
+	var written []string
+	sum, err := fileChecksum(filename)
+	if err != nil {
+		return nil, err
+	}
+	checksumFile := filename + ".sha256"
+	if err := os.WriteFile(checksumFile, []byte(sum+"  "+filepath.Base(filename)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("unable to write %s: %w", checksumFile, err)
+	}
+	written = append(written, checksumFile)
+
+	if settings.MinisignKey != "" {
+		signatureFile, err := minisignFile(filename, settings.MinisignKey, settings.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		written = append(written, signatureFile)
+	}
+	if settings.GPGKey != "" {
+		signatureFile, err := gpgSignFile(filename, settings.GPGKey, settings.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		written = append(written, signatureFile)
+	}
+
+	return written, nil
+}
+
+// fileChecksum returns the hex SHA-256 of a file.
+func fileChecksum(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// minisignFile writes a minisign signature for a file to <file>.minisig, which can be checked with
+// 'minisign -Vm <file> -p <public key>'.
+func minisignFile(filename, keyFile, passphrase string) (string, error) {
+	key, err := minisign.PrivateKeyFromFile(passphrase, keyFile)
+	if err != nil {
+		return "", fmt.Errorf("unable to read minisign key %s: %w", keyFile, err)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	reader := minisign.NewReader(file)
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return "", err
+	}
+
+	signatureFile := filename + ".minisig"
+	if err := os.WriteFile(signatureFile, reader.Sign(key), 0600); err != nil {
+		return "", fmt.Errorf("unable to write %s: %w", signatureFile, err)
+	}
+	return signatureFile, nil
+}
+
+// gpgSignFile writes an ASCII armored, detached GPG signature for a file to <file>.asc, which can be checked with
+// 'gpg --verify <file>.asc'.  The secret key is decrypted with the passphrase, if it has one.
+func gpgSignFile(filename, keyFile, passphrase string) (string, error) {
+	keys, err := readGPGKeys(keyFile)
+	if err != nil {
+		return "", err
+	}
+	signer := keys[0]
+	if signer.PrivateKey == nil {
+		return "", fmt.Errorf("%s doesn't contain a secret key", keyFile)
+	}
+	if signer.PrivateKey.Encrypted {
+		if err := signer.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+			return "", fmt.Errorf("unable to decrypt GPG key %s: %w", keyFile, err)
+		}
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	signatureFile := filename + ".asc"
+	signature, err := os.Create(signatureFile)
+	if err != nil {
+		return "", err
+	}
+	if err := openpgp.ArmoredDetachSign(signature, signer, file, nil); err != nil {
+		signature.Close()
+		return "", fmt.Errorf("unable to sign %s: %w", filename, err)
+	}
+	if err := signature.Close(); err != nil {
+		return "", err
+	}
+	return signatureFile, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// Snapshot is a point-in-time record of the version counts, which can be saved to disk and compared later.
+type Snapshot struct {
+	// SchemaVersion is the version of the snapshot schema, printed by 'schema snapshot'.  Snapshots from before the
+	// schema was published don't have it.
+	SchemaVersion int            `json:"schema_version"`
+	GeneratedAt   time.Time      `json:"generated_at"`
+	ToolVersion   string         `json:"tool_version"`
+	DesktopTotal  int            `json:"desktop_total"`
+	MobileTotal   int            `json:"mobile_total"`
+	Total         int            `json:"total"`
+	Desktop       []VersionEntry `json:"desktop"`
+	Mobile        []VersionEntry `json:"mobile"`
+	// MobileDevices is the number of distinct mobile devices, which is less than MobileTotal when people have signed
+	// in more than once on the same device
+	MobileDevices int `json:"mobile_devices,omitempty"`
+	// DesktopDevTotal and DesktopDev are the nightly and developer desktop builds, which aren't included in Total
+	DesktopDevTotal int            `json:"desktop_dev_total,omitempty"`
+	DesktopDev      []VersionEntry `json:"desktop_dev,omitempty"`
+	// DesktopLegacyTotal and DesktopLegacy are the old desktop builds recognised by the legacy desktop patterns,
+	// which also aren't included in Total
+	DesktopLegacyTotal int            `json:"desktop_legacy_total,omitempty"`
+	DesktopLegacy      []VersionEntry `json:"desktop_legacy,omitempty"`
+	// APITotal and API are the integrations, which aren't included in Total.  API entries have the integration type
+	// in place of the version.
+	APITotal int            `json:"api_total,omitempty"`
+	API      []VersionEntry `json:"api,omitempty"`
+}
+
+// VersionEntry is a single version and OS combination within a Snapshot.
+type VersionEntry = mmversions.VersionEntry
+
+// newSnapshot builds a Snapshot from the version counts.
+func newSnapshot(summary *mmversions.Summary) Snapshot {
+	snapshot := Snapshot{
+		SchemaVersion: schemaVersion,
+		GeneratedAt:   time.Now().In(outputLocation).Truncate(time.Second),
+		ToolVersion:   Version,
+		DesktopTotal:  mmversions.Total(summary.Desktop),
+		MobileTotal:   mmversions.Total(summary.Mobile),
+		MobileDevices: summary.MobileDevices,
+		Desktop:       mmversions.Entries(summary.Desktop),
+		Mobile:        mmversions.Entries(summary.Mobile),
+		APITotal:      mmversions.Total(summary.API),
+		API:           mmversions.Entries(summary.API),
+	}
+	if len(summary.DesktopDev) > 0 {
+		snapshot.DesktopDevTotal = mmversions.Total(summary.DesktopDev)
+		snapshot.DesktopDev = mmversions.Entries(summary.DesktopDev)
+	}
+	if len(summary.DesktopLegacy) > 0 {
+		snapshot.DesktopLegacyTotal = mmversions.Total(summary.DesktopLegacy)
+		snapshot.DesktopLegacy = mmversions.Entries(summary.DesktopLegacy)
+	}
+	snapshot.Total = snapshot.DesktopTotal + snapshot.MobileTotal
+
+	return snapshot
+}
+
+// writeSnapshot saves a Snapshot as indented JSON.
+func writeSnapshot(filename string, snapshot Snapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		errMsg := fmt.Sprintf("Unable to encode snapshot: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return err
+	}
+
+	if err := os.WriteFile(filename, append(data, '\n'), 0600); err != nil {
+		errMsg := fmt.Sprintf("Unable to write snapshot file: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return err
+	}
+
+	return nil
+}
+
+// readSnapshot loads a Snapshot previously saved with writeSnapshot.
+func readSnapshot(filename string) (*Snapshot, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		errMsg := fmt.Sprintf("Unable to read snapshot file: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		errMsg := fmt.Sprintf("Unable to decode snapshot file %s: %v", filename, err)
+		LogMessage(errorLevel, errMsg)
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// versionChange is the difference in count for a single version and OS between two snapshots.
+type versionChange struct {
+	Version string
+	OS      string
+	Before  int
+	After   int
+}
+
+// diffEntries compares two lists of version entries, returning only those that have changed.
+func diffEntries(before, after []VersionEntry) []versionChange {
+	type key struct{ version, os string }
+	counts := make(map[key]*versionChange)
+	var order []key
+
+	get := func(entry VersionEntry) *versionChange {
+		k := key{entry.Version, entry.OS}
+		if counts[k] == nil {
+			counts[k] = &versionChange{Version: entry.Version, OS: entry.OS}
+			order = append(order, k)
+		}
+		return counts[k]
+	}
+	for _, entry := range before {
+		get(entry).Before += entry.Count
+	}
+	for _, entry := range after {
+		get(entry).After += entry.Count
+	}
+
+	var changes []versionChange
+	for _, k := range order {
+		if change := counts[k]; change.Before != change.After {
+			changes = append(changes, *change)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Version != changes[j].Version {
+			return mmversions.Less(changes[i].Version, changes[j].Version)
+		}
+		return changes[i].OS < changes[j].OS
+	})
+
+	return changes
+}
+
+// printDiff prints the changes between two snapshots.
+func printDiff(before, after *Snapshot) {
+	fmt.Println(trf(msgDiffComparing, formatTimestamp(before.GeneratedAt), formatTimestamp(after.GeneratedAt)))
+
+	printChanges := func(title string, changes []versionChange) {
+		if len(changes) == 0 {
+			fmt.Println("\n" + trf(msgDiffNoChanges, title))
+			return
+		}
+		fmt.Println("\n" + trf(msgDiffChanges, title))
+		for _, change := range changes {
+			fmt.Printf("  %s (%s): %d -> %d (%+d)\n", change.Version, change.OS, change.Before, change.After, change.After-change.Before)
+		}
+	}
+	printChanges(tr(msgDiffDesktop), diffEntries(before.Desktop, after.Desktop))
+	printChanges(tr(msgDiffMobile), diffEntries(before.Mobile, after.Mobile))
+	if len(before.DesktopDev) > 0 || len(after.DesktopDev) > 0 {
+		printChanges(tr(msgDiffDev), diffEntries(before.DesktopDev, after.DesktopDev))
+	}
+	if len(before.DesktopLegacy) > 0 || len(after.DesktopLegacy) > 0 {
+		printChanges(tr(msgDiffLegacy), diffEntries(before.DesktopLegacy, after.DesktopLegacy))
+	}
+	if len(before.API) > 0 || len(after.API) > 0 {
+		printChanges(tr(msgDiffAPI), diffEntries(before.API, after.API))
+	}
+
+	fmt.Printf("\n%s -> %d (%+d)\n", trf(msgTotalDesktop, before.DesktopTotal), after.DesktopTotal, after.DesktopTotal-before.DesktopTotal)
+	fmt.Printf("%s -> %d (%+d)\n", trf(msgTotalMobile, before.MobileTotal), after.MobileTotal, after.MobileTotal-before.MobileTotal)
+	if before.DesktopDevTotal > 0 || after.DesktopDevTotal > 0 {
+		fmt.Printf("%s -> %d (%+d)\n", trf(msgTotalDev, before.DesktopDevTotal), after.DesktopDevTotal, after.DesktopDevTotal-before.DesktopDevTotal)
+	}
+	if before.DesktopLegacyTotal > 0 || after.DesktopLegacyTotal > 0 {
+		fmt.Printf("%s -> %d (%+d)\n", trf(msgTotalLegacy, before.DesktopLegacyTotal), after.DesktopLegacyTotal, after.DesktopLegacyTotal-before.DesktopLegacyTotal)
+	}
+	if before.APITotal > 0 || after.APITotal > 0 {
+		fmt.Printf("%s -> %d (%+d)\n", trf(msgTotalAPI, before.APITotal), after.APITotal, after.APITotal-before.APITotal)
+	}
+	fmt.Printf("%s -> %d (%+d)\n", trf(msgTotalActive, before.Total), after.Total, after.Total-before.Total)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// staleSessions returns the sessions that haven't been used for at least the given number of days, although they
+// haven't expired.  Sessions without a last activity time, e.g. from an export without the column, are never stale.
+func staleSessions(sessions []SessionRecord, days int, now time.Time) []SessionRecord {
+	cutoff := now.AddDate(0, 0, -days).UnixMilli()
+	var stale []SessionRecord
+	for _, session := range sessions {
+		if session.LastActivityAt != 0 && session.LastActivityAt <= cutoff {
+			stale = append(stale, session)
+		}
+	}
+	return stale
+}
+
+// staleRows lists the stale desktop and mobile sessions, one per row, with the IDs needed to revoke them.
+func staleRows(sessions []SessionRecord, now time.Time) [][]string {
+	rows := [][]string{{"Session ID", "User ID", "Client", "Version", "OS", "Last Activity", "Idle (Days)", "Expires"}}
+	for _, session := range sessions {
+		client, err := mmversions.Classify(session)
+		if err != nil || (client.Kind != mmversions.Desktop && client.Kind != mmversions.Mobile) {
+			continue
+		}
+		rows = append(rows, []string{session.ID, session.UserID, string(client.Kind), client.Version, client.OS,
+			formatMillis(session.LastActivityAt), ageInDays(session.LastActivityAt, now), formatMillis(session.ExpiresAt)})
+	}
+	return rows
+}
+
+// forgottenSessions returns the desktop sessions at or below the given version that were created at least the given
+// number of months ago, and haven't expired.  They're usually installs that nobody uses any more, which are better
+// revoked than chased for an upgrade.
+func forgottenSessions(sessions []SessionRecord, months int, version string, now time.Time) []SessionRecord {
+	cutoff := now.AddDate(0, -months, 0).UnixMilli()
+	var forgotten []SessionRecord
+	for _, session := range sessions {
+		if session.CreateAt == 0 || session.CreateAt > cutoff {
+			continue
+		}
+		if _, ok := matchLookupSession(session, desktopOnly(version)); ok {
+			forgotten = append(forgotten, session)
+		}
+	}
+	return forgotten
+}
+
+// forgottenRows lists the forgotten sessions, one per row, with the IDs needed to revoke them.
+func forgottenRows(sessions []SessionRecord, now time.Time) [][]string {
+	rows := [][]string{{"Session ID", "User ID", "Version", "OS", "Created", "Age (Days)", "Last Activity", "Expires"}}
+	for _, session := range sessions {
+		client, err := mmversions.Classify(session)
+		if err != nil {
+			continue
+		}
+		rows = append(rows, []string{session.ID, session.UserID, client.Version, client.OS, formatMillis(session.CreateAt),
+			ageInDays(session.CreateAt, now), formatMillis(session.LastActivityAt), formatMillis(session.ExpiresAt)})
+	}
+	return rows
+}
+
+// printStaleSummary writes the version tally of the stale sessions, and how many of the active sessions they are.
+func printStaleSummary(stale []SessionRecord, total int, days int) {
+	fmt.Println(trf(msgStaleHeading, days))
+	fmt.Println()
+	printResults(tallySessions(stale), reportStyle{})
+	fmt.Println("\n" + trf(msgStaleTotal, len(stale), total))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// SessionRecord holds the columns we need from a single row of the Sessions table.
+type SessionRecord = mmversions.Session
+
+// UserRecord holds the columns we need from a single row of the Users table.
+type UserRecord struct {
+	ID        string
+	Username  string
+	Email     string
+	FirstName string
+	LastName  string
+	// MfaActive and AuthService aren't valid if they're unknown, e.g. when they're missing from an export of the
+	// Users table.  An empty AuthService means the user signs in with email and password.
+	MfaActive   sql.NullBool
+	AuthService sql.NullString
+	AuthData    sql.NullString
+	Locale      string
+	Roles       sql.NullString
+	// DeleteAt is when the user was deactivated, in milliseconds since the epoch, or 0 if they're enabled
+	DeleteAt int64
+	// Extra holds any additional columns configured for the lookup CSV, keyed by lower-case column name
+	Extra map[string]string
+}
+
+// The definitions of an active session, for sessions.active.  By default, a session is active until it expires, but
+// on servers with long session lengths that overstates how many clients are really in use, so it can instead be
+// one that's been used recently, or both.
+const (
+	activeByExpiry   = "expiry"
+	activeByActivity = "activity"
+	activeByBoth     = "both"
+)
+
+// defaultActiveDays is how recently a session must have been used to be active, when sessions.active_days isn't set.
+const defaultActiveDays = 30
+
+// activeRule decides which sessions the stores read as active.
+type activeRule struct {
+	// mode is activeByExpiry, activeByActivity or activeByBoth
+	mode string
+	// activityDays is how many days ago a session can have last been used, if recent activity counts
+	activityDays int
+	// expiredDays is how many days after they expire that sessions are still read, for '-include-expired'
+	expiredDays int
+}
+
+// newActiveRule returns the definition of an active session from the config.
+func newActiveRule(config *Config, expiredDays int) (activeRule, error) {
+	rule := activeRule{mode: strings.ToLower(config.Sessions.Active), activityDays: config.Sessions.ActiveDays, expiredDays: expiredDays}
+	switch rule.mode {
+	case "":
+		rule.mode = activeByExpiry
+	case activeByExpiry, activeByActivity, activeByBoth:
+	default:
+		return rule, fmt.Errorf("%q isn't a definition of active.  This should be %s, %s or %s", config.Sessions.Active, activeByExpiry, activeByActivity, activeByBoth)
+	}
+	if rule.activityDays == 0 {
+		rule.activityDays = defaultActiveDays
+	}
+	return rule, nil
+}
+
+func (r activeRule) byExpiry() bool {
+	return r.mode != activeByActivity
+}
+
+func (r activeRule) byActivity() bool {
+	return r.mode != activeByExpiry
+}
+
+// cutoffs returns the times, in milliseconds since the epoch, after which a session must expire, and after which it
+// must have last been used, to be active.
+func (r activeRule) cutoffs(now time.Time) (expires int64, used int64) {
+	return now.AddDate(0, 0, -r.expiredDays).UnixMilli(), now.AddDate(0, 0, -r.activityDays).UnixMilli()
+}
+
+// isActive applies the rule to a single session, for stores that can't filter in a query.
+func (r activeRule) isActive(session SessionRecord, now time.Time) bool {
+	expires, used := r.cutoffs(now)
+	if r.byExpiry() && session.ExpiresAt != 0 && session.ExpiresAt <= expires {
+		return false
+	}
+	if r.byActivity() && session.LastActivityAt <= used {
+		return false
+	}
+	return true
+}
+
+// Store is where the session and user data comes from.  This is normally the live database, through sqlStore, but it
+// can also be a set of files that have been exported from a database, through memoryStore.  Every method takes a
+// context, so that a query can be abandoned when the command is interrupted or times out.
+type Store interface {
+	// Sessions returns all currently active sessions that have props, along with any that expired within the
+	// store's grace period.
+	Sessions(ctx context.Context) ([]SessionRecord, error)
+	// EachSessionUser calls fn with each of the same sessions as Sessions, and its user, read together rather than one
+	// user at a time, and without holding them all in memory.  The sessions are in order of user ID, so that each
+	// user's sessions come together.  The user is nil if they can't be found.  An error from fn stops the reading.
+	EachSessionUser(ctx context.Context, fn func(session SessionRecord, user *UserRecord) error) error
+	// User returns the user with the given ID.  This is a slice so that a missing user is not an error.
+	User(ctx context.Context, userID string) ([]UserRecord, error)
+	// FindUser returns the users with the given username or email, ignoring case.
+	FindUser(ctx context.Context, name string) ([]UserRecord, error)
+	// Teams returns the display names of the teams the user belongs to.
+	Teams(ctx context.Context, userID string) ([]string, error)
+	// AllTeams returns the display names of the teams every user belongs to, keyed by user ID, read in one go.
+	AllTeams(ctx context.Context) (map[string][]string, error)
+	// UserCount returns the number of enabled users, not counting bots.
+	UserCount(ctx context.Context) (int, error)
+	// License returns the active license, or nil if there isn't one.
+	License(ctx context.Context) (*licenseInfo, error)
+	// ServerMinimums returns the minimum app versions enforced by the Mattermost server, which are empty if its
+	// configuration isn't available, e.g. because it's kept in a file rather than the database.
+	ServerMinimums(ctx context.Context) (serverMinimums, error)
+	// Ping checks that the data can still be read, for the readiness check of a long-running server.
+	Ping(ctx context.Context) error
+}
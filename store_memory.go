@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// memoryStore holds session and user data in memory, and returns it as-is.  It's the basis of offlineSource, and
+// can be filled in directly to try out the classification and lookup logic without a database.
+type memoryStore struct {
+	sessions []SessionRecord
+	users    map[string]UserRecord
+	teams    map[string][]string
+	// license is the active license, as stored in the Licenses table, if there is one.  It's only known if
+	// hasLicenseData is set, since an export of the Sessions table doesn't say anything about the license.
+	license        string
+	hasLicenseData bool
+	// serverConfig is the server's configuration as JSON, if it's known
+	serverConfig string
+}
+
+func (s *memoryStore) Sessions(ctx context.Context) ([]SessionRecord, error) {
+	return s.sessions, nil
+}
+
+func (s *memoryStore) EachSessionUser(ctx context.Context, fn func(session SessionRecord, user *UserRecord) error) error {
+	return eachSessionUser(s.sessions, s.users, fn)
+}
+
+// eachSessionUser calls fn with each of the sessions, in order of user ID, and its user, or nil if the user isn't in
+// users, for the stores that hold everything in memory.
+func eachSessionUser(sessions []SessionRecord, users map[string]UserRecord, fn func(session SessionRecord, user *UserRecord) error) error {
+	sorted := slices.Clone(sessions)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].UserID < sorted[j].UserID })
+	for _, session := range sorted {
+		var found *UserRecord
+		if user, ok := users[session.UserID]; ok {
+			found = &user
+		}
+		if err := fn(session, found); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) User(ctx context.Context, userID string) ([]UserRecord, error) {
+	if user, ok := s.users[userID]; ok {
+		return []UserRecord{user}, nil
+	}
+	return nil, nil
+}
+
+func (s *memoryStore) FindUser(ctx context.Context, name string) ([]UserRecord, error) {
+	name = strings.TrimPrefix(name, "@")
+	var found []UserRecord
+	for _, user := range s.users {
+		if strings.EqualFold(user.Username, name) || strings.EqualFold(user.Email, name) {
+			found = append(found, user)
+		}
+	}
+	return found, nil
+}
+
+func (s *memoryStore) Teams(ctx context.Context, userID string) ([]string, error) {
+	return s.teams[userID], nil
+}
+
+func (s *memoryStore) AllTeams(ctx context.Context) (map[string][]string, error) {
+	return s.teams, nil
+}
+
+func (s *memoryStore) License(ctx context.Context) (*licenseInfo, error) {
+	if !s.hasLicenseData {
+		return nil, errNoLicenseData
+	}
+	if s.license == "" {
+		return nil, nil
+	}
+	return parseLicense(s.license)
+}
+
+func (s *memoryStore) ServerMinimums(ctx context.Context) (serverMinimums, error) {
+	if s.serverConfig == "" {
+		return serverMinimums{}, nil
+	}
+	return parseServerMinimums(s.serverConfig)
+}
+
+// Ping always succeeds, since the data is already in memory.
+func (s *memoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// UserCount counts the users that haven't been deactivated.  Bots can't be told apart from people without the Bots
+// table, so they're included.
+func (s *memoryStore) UserCount(ctx context.Context) (int, error) {
+	count := 0
+	for _, user := range s.users {
+		if user.DeleteAt == 0 {
+			count++
+		}
+	}
+	return count, nil
+}
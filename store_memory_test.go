@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// Props for the kinds of client the tests use.
+const (
+	desktopProps = `{"browser": "Desktop App/%s", "os": "Windows"}`
+	mobileProps  = `{"browser": "Mobile App/%s", "os": "iOS", "isMobile": "true"}`
+)
+
+func desktopSession(id, userID, version string) SessionRecord {
+	return SessionRecord{ID: id, UserID: userID, Props: fmt.Sprintf(desktopProps, version)}
+}
+
+func mobileSession(id, userID, version, deviceID string) SessionRecord {
+	return SessionRecord{ID: id, UserID: userID, Props: fmt.Sprintf(mobileProps, version), DeviceID: deviceID}
+}
+
+// testStore is a small installation: ann has two desktop sessions and a phone, bob has one old desktop session, and
+// the user behind the last session has been deleted.  The sessions are deliberately out of user order.
+func testStore() *memoryStore {
+	return &memoryStore{
+		sessions: []SessionRecord{
+			desktopSession("s1", "u2", "4.0.0"),
+			desktopSession("s2", "u1", "5.3.0"),
+			mobileSession("s3", "u1", "2.12.0", "dev-a"),
+			desktopSession("s4", "u9", "3.0.0"),
+			desktopSession("s5", "u1", "5.1.0"),
+		},
+		users: map[string]UserRecord{
+			"u1": {ID: "u1", Username: "ann", Email: "ann@example.com", Locale: "fr", AuthService: sql.NullString{String: "ldap", Valid: true},
+				Roles: sql.NullString{String: "system_user system_admin", Valid: true}},
+			"u2": {ID: "u2", Username: "bob", Email: "bob@example.com", Locale: "en", AuthService: sql.NullString{Valid: true},
+				Roles: sql.NullString{String: "system_user", Valid: true}},
+		},
+		teams: map[string][]string{"u1": {"Red"}, "u2": {"Blue", "Green"}},
+	}
+}
+
+func TestEachSessionUser(t *testing.T) {
+	store := testStore()
+
+	var ids, users []string
+	err := store.EachSessionUser(context.Background(), func(session SessionRecord, user *UserRecord) error {
+		ids = append(ids, session.ID)
+		if user == nil {
+			users = append(users, "")
+		} else {
+			users = append(users, user.Username)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachSessionUser: %v", err)
+	}
+	// In order of user ID, keeping the order of each user's sessions, with no user for the deleted one
+	if want := []string{"s2", "s3", "s5", "s1", "s4"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("sessions = %v, want %v", ids, want)
+	}
+	if want := []string{"ann", "ann", "ann", "bob", ""}; !reflect.DeepEqual(users, want) {
+		t.Errorf("users = %v, want %v", users, want)
+	}
+	if store.sessions[0].ID != "s1" {
+		t.Errorf("EachSessionUser reordered the store's own sessions")
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = store.EachSessionUser(context.Background(), func(session SessionRecord, user *UserRecord) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("an error from fn gave %v after %d calls, want %v after 1", err, calls, stop)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name     string
+		session  SessionRecord
+		kind     mmversions.ClientKind
+		version  string
+		platform string
+		devBuild bool
+	}{
+		{"desktop", desktopSession("s", "u", "5.3.0"), mmversions.Desktop, "5.3.0", "Windows", false},
+		{"nightly desktop", desktopSession("s", "u", "5.9.0-nightly.20240601"), mmversions.Desktop, "5.9.0-nightly.20240601", "Windows", true},
+		{"mobile", mobileSession("s", "u", "2.13.0", "d"), mmversions.Mobile, "2.13.0", "iOS", false},
+		{"mobile by device ID", SessionRecord{Props: `{"browser": "Mobile App/2.13.0", "os": "Android"}`, DeviceID: "d"}, mmversions.Mobile, "2.13.0", "Android", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := mmversions.Classify(test.session)
+			if err != nil {
+				t.Fatalf("Classify: %v", err)
+			}
+			if client.Kind != test.kind || client.Version != test.version || client.OS != test.platform || client.DevBuild != test.devBuild {
+				t.Errorf("Classify = %s %s on %s (dev build %t), want %s %s on %s (dev build %t)", client.Kind, client.Version,
+					client.OS, client.DevBuild, test.kind, test.version, test.platform, test.devBuild)
+			}
+		})
+	}
+
+	if _, err := mmversions.Classify(SessionRecord{Props: "not json"}); err == nil {
+		t.Error("Classify accepted props that aren't JSON")
+	}
+}
+
+func TestProcessSessions(t *testing.T) {
+	summary, err := processSessions(context.Background(), testStore())
+	if err != nil {
+		t.Fatalf("processSessions: %v", err)
+	}
+	want := map[string][]mmversions.VersionEntry{
+		"desktop": {{Version: "5.3.0", OS: "Windows", Count: 1}, {Version: "5.1.0", OS: "Windows", Count: 1},
+			{Version: "4.0.0", OS: "Windows", Count: 1}, {Version: "3.0.0", OS: "Windows", Count: 1}},
+		"mobile": {{Version: "2.12.0", OS: "iOS", Count: 1}},
+	}
+	got := map[string][]mmversions.VersionEntry{
+		"desktop": mmversions.Entries(summary.Desktop),
+		"mobile":  mmversions.Entries(summary.Mobile),
+	}
+	for kind := range want {
+		if !sameEntries(got[kind], want[kind]) {
+			t.Errorf("%s counts = %v, want %v", kind, got[kind], want[kind])
+		}
+	}
+}
+
+// sameEntries compares version counts regardless of their order.
+func sameEntries(got, want []mmversions.VersionEntry) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	counts := make(map[mmversions.VersionEntry]int)
+	for _, entry := range got {
+		counts[entry]++
+	}
+	for _, entry := range want {
+		counts[entry]--
+	}
+	for _, n := range counts {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTallyUsers(t *testing.T) {
+	store := testStore()
+	sessions, err := store.Sessions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := sessionUsers(context.Background(), store)
+	if err != nil {
+		t.Fatalf("sessionUsers: %v", err)
+	}
+	if _, ok := users["u9"]; ok || len(users) != 2 {
+		t.Fatalf("sessionUsers = %v, want ann and bob only", users)
+	}
+
+	if got, want := tallyLocales(sessions, users), map[string]int{"fr": 3, "en": 1, "": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tallyLocales = %v, want %v", got, want)
+	}
+
+	ann := groupCount{desktop: map[string]int{"5.3.0": 1, "5.1.0": 1}, mobile: map[string]int{"2.12.0": 1}}
+	bob := groupCount{desktop: map[string]int{"4.0.0": 1}, mobile: map[string]int{}}
+	deleted := groupCount{desktop: map[string]int{"3.0.0": 1}, mobile: map[string]int{}}
+	tests := []struct {
+		name string
+		got  map[string]groupCount
+		want map[string]groupCount
+	}{
+		{"auth services", tallyAuthServices(sessions, users), map[string]groupCount{"ldap": ann, "email": bob, "": deleted}},
+		{"teams", tallyTeams(sessions, store.teams), map[string]groupCount{"Red": ann, "Blue": bob, "Green": bob, "": deleted}},
+		{"roles", tallyRoles(sessions, users, "", "").counts, map[string]groupCount{msgRoleAdmin: ann, msgRoleMember: bob, "": deleted}},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.got, test.want) {
+			t.Errorf("%s = %v, want %v", test.name, test.got, test.want)
+		}
+	}
+
+	roles := tallyRoles(sessions, users, "5.0.0", "3.0.0")
+	if want := map[string]int{msgRoleMember: 1, "": 1}; !reflect.DeepEqual(roles.outdatedDesktop, want) {
+		t.Errorf("outdated desktop users = %v, want %v", roles.outdatedDesktop, want)
+	}
+	if want := map[string]int{msgRoleAdmin: 1}; !reflect.DeepEqual(roles.outdatedMobile, want) {
+		t.Errorf("outdated mobile users = %v, want %v", roles.outdatedMobile, want)
+	}
+}
+
+func TestDoLookup(t *testing.T) {
+	// The columns checked in each row: Version, Username, Active Sessions and Outdated Sessions, followed by the
+	// extra columns that the options add
+	tests := []struct {
+		name     string
+		versions lookupVersions
+		options  lookupOptions
+		header   []string
+		rows     [][]string
+	}{
+		{
+			name:     "desktop",
+			versions: desktopOnly("5.1.0"),
+			rows:     [][]string{{"5.1.0", "ann", "3", "1"}, {"4.0.0", "bob", "1", "1"}},
+		},
+		{
+			name:     "dedupe users",
+			versions: desktopOnly("9.0.0"),
+			options:  lookupOptions{dedupeUsers: true},
+			header:   []string{"Newest Version"},
+			rows:     [][]string{{"5.1.0", "ann", "3", "2", "5.3.0"}, {"4.0.0", "bob", "1", "1", "4.0.0"}},
+		},
+		{
+			name:     "limit",
+			versions: desktopOnly("9.0.0"),
+			options:  lookupOptions{limit: 1},
+			rows:     [][]string{{"4.0.0", "bob", "1", "1"}},
+		},
+		{
+			name:     "mobile and teams",
+			versions: lookupVersions{mmversions.Desktop: "4.0.0", mmversions.Mobile: "2.12.0"},
+			options:  lookupOptions{includeTeams: true},
+			header:   []string{"Client", "Device ID", "Teams"},
+			rows:     [][]string{{"2.12.0", "ann", "3", "1", "mobile", "dev-a", "Red"}, {"4.0.0", "bob", "1", "1", "desktop", "", "Blue, Green"}},
+		},
+		{
+			name:     "redacted mobile",
+			versions: lookupVersions{mmversions.Desktop: "4.0.0", mmversions.Mobile: "2.12.0"},
+			options:  lookupOptions{redact: true, pseudonymKey: "k"},
+			header:   []string{"Email", "Device ID"},
+			rows: [][]string{{"2.12.0", pseudonym("u1", "k"), "3", "1", pseudonym("u1", "k"), devicePseudonym("dev-a", "k")},
+				{"4.0.0", pseudonym("u2", "k"), "1", "1", pseudonym("u2", "k"), ""}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "lookup.csv")
+			test.options.format = "csv"
+			test.options.config = &Config{}
+			if err := doLookup(context.Background(), testStore(), filename, test.versions, test.options); err != nil {
+				t.Fatalf("doLookup: %v", err)
+			}
+
+			records := readCSV(t, filename)
+			header := records[0]
+			columns := []string{"Version", "Username", "Active Sessions", "Outdated Sessions"}
+			columns = append(columns, test.header...)
+			var rows [][]string
+			for _, record := range records[1:] {
+				var row []string
+				for _, column := range columns {
+					row = append(row, record[indexOf(header, column)])
+				}
+				rows = append(rows, row)
+			}
+			if !reflect.DeepEqual(rows, test.rows) {
+				t.Errorf("rows = %v, want %v", rows, test.rows)
+			}
+		})
+	}
+}
+
+func readCSV(t *testing.T, filename string) [][]string {
+	t.Helper()
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func indexOf(header []string, column string) int {
+	for i, name := range header {
+		if name == column {
+			return i
+		}
+	}
+	return -1
+}
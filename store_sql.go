@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sqlStore reads sessions and users from a live database, using its dialect for anything database-specific.
+type sqlStore struct {
+	db      *sql.DB
+	dialect dialect
+	// userColumns are the extra Users columns to read for the lookup CSV
+	userColumns []string
+	// active decides which sessions are read
+	active activeRule
+	// where is the extra condition from sessions.where, if there is one
+	where *customWhere
+}
+
+// newSQLStore returns a store reading from the database described by the config.
+func newSQLStore(db *sql.DB, config *Config) (*sqlStore, error) {
+	d, err := dialectFor(config.DB.Type)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStore{db: db, dialect: d, userColumns: userTableColumns(config.Lookup.UserColumns)}, nil
+}
+
+// sessionFields and userFields are the columns read into each record, in Mattermost's case.  They're passed through
+// the dialect before use.
+var (
+	sessionFields = []string{"Id", "UserId", "Props", "DeviceId", "ExpiresAt", "LastActivityAt", "CreateAt", "IsOAuth"}
+	userFields    = []string{"Id", "Username", "Email", "FirstName", "LastName", "MfaActive", "AuthService", "AuthData", "Locale", "Roles", "DeleteAt"}
+)
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlStore) Sessions(ctx context.Context) ([]SessionRecord, error) {
+	d := s.dialect
+	query, args := s.sessionsQuery()
+
+	DebugPrint("Executing query: " + query)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error executing query: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []SessionRecord
+	for rows.Next() {
+		var session SessionRecord
+		if err := rows.Scan(sessionDest(&session)...); err != nil {
+			errMsg := fmt.Sprintf("Error scanning %s row: %v", d.driver(), err)
+			LogMessage(errorLevel, errMsg)
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		errMsg := fmt.Sprintf("Error iterating over rows: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// sessionsQuery builds the query for the active sessions, and its arguments.
+func (s *sqlStore) sessionsQuery() (string, []interface{}) {
+	// We need the current epoch to ensure we only retrieve sessions that are still active
+	expires, used := s.active.cutoffs(time.Now())
+
+	d := s.dialect
+	conditions := []string{d.hasProps(d.identifier("Props"))}
+	if s.active.byExpiry() {
+		expiresAt := d.identifier("ExpiresAt")
+		conditions = append(conditions, fmt.Sprintf("(%s > %d OR %s = 0)", expiresAt, expires, expiresAt))
+	}
+	if s.active.byActivity() {
+		conditions = append(conditions, fmt.Sprintf("%s > %d", d.identifier("LastActivityAt"), used))
+	}
+	var args []interface{}
+	if s.where != nil {
+		conditions = append(conditions, "("+s.where.sql(d, 1)+")")
+		args = s.where.params
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s",
+		strings.Join(identifiers(d, sessionFields...), ", "), d.identifier("Sessions"), strings.Join(conditions, " AND "))
+	return query, args
+}
+
+// sessionDest returns where each of the sessionFields is scanned to.
+func sessionDest(session *SessionRecord) []interface{} {
+	return []interface{}{&session.ID, &session.UserID, &session.Props, &session.DeviceID, &session.ExpiresAt, &session.LastActivityAt, &session.CreateAt, &session.IsOAuth}
+}
+
+// EachSessionUser reads the active sessions and their users in a single query, joining the Users table to the same
+// query as Sessions, rather than looking each user up separately, and passes on each row as it's read.  The sessions
+// query is used as a subquery, so that the conditions from sessions.where can't be confused with columns of the Users
+// table.  It's a left join, so that sessions whose user no longer exists are still counted, as they are offline.
+func (s *sqlStore) EachSessionUser(ctx context.Context, fn func(session SessionRecord, user *UserRecord) error) error {
+	d := s.dialect
+	sessionsQuery, args := s.sessionsQuery()
+	var columns []string
+	for _, field := range sessionFields {
+		columns = append(columns, "s."+d.identifier(field))
+	}
+	for _, field := range append(append([]string{}, userFields...), s.userColumns...) {
+		columns = append(columns, "u."+d.identifier(field))
+	}
+	query := fmt.Sprintf("SELECT %s FROM (%s) s LEFT JOIN %s u ON u.%s = s.%s ORDER BY s.%s", strings.Join(columns, ", "),
+		sessionsQuery, d.identifier("Users"), d.identifier("Id"), d.identifier("UserId"), d.identifier("UserId"))
+
+	DebugPrint("Executing query: " + query)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error executing query: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var session SessionRecord
+		var user UserRecord
+		userValues, finishUser := s.userDest(&user)
+		if err := rows.Scan(append(sessionDest(&session), userValues...)...); err != nil {
+			errMsg := fmt.Sprintf("Error scanning %s row: %v", d.driver(), err)
+			LogMessage(errorLevel, errMsg)
+			return err
+		}
+		var found *UserRecord
+		if finishUser() {
+			found = &user
+		}
+		if err := fn(session, found); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		errMsg := fmt.Sprintf("Error iterating over rows: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return err
+	}
+
+	return nil
+}
+
+func (s *sqlStore) User(ctx context.Context, userID string) ([]UserRecord, error) {
+	d := s.dialect
+	return s.queryUsers(ctx, fmt.Sprintf("%s = %s", d.identifier("Id"), d.placeholder(1)), userID)
+}
+
+// FindUser relies on Mattermost storing usernames and emails in lower case.
+func (s *sqlStore) FindUser(ctx context.Context, name string) ([]UserRecord, error) {
+	d := s.dialect
+	name = strings.ToLower(strings.TrimPrefix(name, "@"))
+	return s.queryUsers(ctx, fmt.Sprintf("%s = %s OR %s = %s", d.identifier("Username"), d.placeholder(1), d.identifier("Email"), d.placeholder(2)), name, name)
+}
+
+// queryUsers reads the users matching a condition, with the extra columns for the lookup CSV.
+func (s *sqlStore) queryUsers(ctx context.Context, condition string, args ...interface{}) ([]UserRecord, error) {
+	d := s.dialect
+	columns := identifiers(d, append(append([]string{}, userFields...), s.userColumns...)...)
+	userQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(columns, ", "), d.identifier("Users"), condition)
+
+	TracePrint(fmt.Sprintf("Executing query: %s with: %v", userQuery, args))
+	userRows, err := s.db.QueryContext(ctx, userQuery, args...)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error executing query: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return nil, err
+	}
+	defer userRows.Close()
+
+	var users []UserRecord
+	for userRows.Next() {
+		var user UserRecord
+		dest, finishUser := s.userDest(&user)
+		if err := userRows.Scan(dest...); err != nil {
+			errMsg := fmt.Sprintf("Error scanning %s row: %v", d.driver(), err)
+			LogMessage(errorLevel, errMsg)
+			return nil, err
+		}
+		finishUser()
+		users = append(users, user)
+	}
+
+	return users, userRows.Err()
+}
+
+// userDest returns where each of the userFields, and the extra columns for the lookup CSV, is scanned to, and a
+// function that copies them into the user once the row has been scanned.  The function reports whether there was a
+// user, since the columns are all null when a left join doesn't find one.
+func (s *sqlStore) userDest(user *UserRecord) ([]interface{}, func() bool) {
+	var id, username, email, firstName, lastName, locale sql.NullString
+	var deleteAt sql.NullInt64
+	extraValues := make([]sql.NullString, len(s.userColumns))
+	dest := []interface{}{&id, &username, &email, &firstName, &lastName, &user.MfaActive, &user.AuthService, &user.AuthData, &locale, &user.Roles, &deleteAt}
+	for i := range extraValues {
+		dest = append(dest, &extraValues[i])
+	}
+	return dest, func() bool {
+		user.ID, user.Username, user.Email = id.String, username.String, email.String
+		user.FirstName, user.LastName, user.Locale = firstName.String, lastName.String, locale.String
+		user.DeleteAt = deleteAt.Int64
+		user.Extra = make(map[string]string, len(s.userColumns))
+		for i, column := range s.userColumns {
+			user.Extra[strings.ToLower(column)] = extraValues[i].String
+		}
+		return id.Valid
+	}
+}
+
+func (s *sqlStore) UserCount(ctx context.Context) (int, error) {
+	d := s.dialect
+	id := func(name string) string { return d.identifier(name) }
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s u WHERE u.%s = 0 AND NOT EXISTS (SELECT 1 FROM %s b WHERE b.%s = u.%s)",
+		id("Users"), id("DeleteAt"), id("Bots"), id("UserId"), id("Id"))
+
+	DebugPrint("Executing query: " + countQuery)
+	var count int
+	if err := s.db.QueryRowContext(ctx, countQuery).Scan(&count); err != nil {
+		errMsg := fmt.Sprintf("Error executing query: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *sqlStore) License(ctx context.Context) (*licenseInfo, error) {
+	d := s.dialect
+	id := func(name string) string { return d.identifier(name) }
+	licenseQuery := fmt.Sprintf("SELECT l.%s FROM %s s JOIN %s l ON l.%s = s.%s WHERE s.%s = 'ActiveLicenseId'",
+		id("Bytes"), id("Systems"), id("Licenses"), id("Id"), id("Value"), id("Name"))
+
+	DebugPrint("Executing query: " + licenseQuery)
+	var encoded string
+	err := s.db.QueryRowContext(ctx, licenseQuery).Scan(&encoded)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		errMsg := fmt.Sprintf("Error executing query: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return nil, err
+	}
+
+	license, err := parseLicense(encoded)
+	if err != nil {
+		errMsg := fmt.Sprintf("Unable to read the active license: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return nil, err
+	}
+	return license, nil
+}
+
+// ServerMinimums reads the active configuration from the Configurations table, where the server keeps it when its
+// configuration is in the database.  A server configured from a file has nothing there.
+func (s *sqlStore) ServerMinimums(ctx context.Context) (serverMinimums, error) {
+	d := s.dialect
+	configQuery := fmt.Sprintf("SELECT %s FROM %s WHERE %s = TRUE", d.identifier("Value"), d.identifier("Configurations"), d.identifier("Active"))
+
+	DebugPrint("Executing query: " + configQuery)
+	var value string
+	err := s.db.QueryRowContext(ctx, configQuery).Scan(&value)
+	if err == sql.ErrNoRows {
+		return serverMinimums{}, nil
+	}
+	if err != nil {
+		errMsg := fmt.Sprintf("Error executing query: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return serverMinimums{}, err
+	}
+
+	minimums, err := parseServerMinimums(value)
+	if err != nil {
+		errMsg := fmt.Sprintf("Unable to read the server's configuration: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return serverMinimums{}, err
+	}
+	return minimums, nil
+}
+
+// AllTeams reads every user's teams in a single query, for the lookup CSV, rather than a query for each user.
+func (s *sqlStore) AllTeams(ctx context.Context) (map[string][]string, error) {
+	d := s.dialect
+	id := func(name string) string { return d.identifier(name) }
+	teamQuery := fmt.Sprintf("SELECT tm.%s, t.%s FROM %s tm JOIN %s t ON t.%s = tm.%s WHERE tm.%s = 0 AND t.%s = 0 ORDER BY t.%s",
+		id("UserId"), id("DisplayName"), id("TeamMembers"), id("Teams"), id("Id"), id("TeamId"), id("DeleteAt"),
+		id("DeleteAt"), id("DisplayName"))
+
+	DebugPrint("Executing query: " + teamQuery)
+	teamRows, err := s.db.QueryContext(ctx, teamQuery)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error executing query: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return nil, err
+	}
+	defer teamRows.Close()
+
+	teams := make(map[string][]string)
+	for teamRows.Next() {
+		var userID, team string
+		if err := teamRows.Scan(&userID, &team); err != nil {
+			errMsg := fmt.Sprintf("Error scanning %s row: %v", d.driver(), err)
+			LogMessage(errorLevel, errMsg)
+			return nil, err
+		}
+		teams[userID] = append(teams[userID], team)
+	}
+
+	return teams, teamRows.Err()
+}
+
+func (s *sqlStore) Teams(ctx context.Context, userID string) ([]string, error) {
+	d := s.dialect
+	id := func(name string) string { return d.identifier(name) }
+	teamQuery := fmt.Sprintf("SELECT t.%s FROM %s tm JOIN %s t ON t.%s = tm.%s WHERE tm.%s = %s AND tm.%s = 0 AND t.%s = 0 ORDER BY t.%s",
+		id("DisplayName"), id("TeamMembers"), id("Teams"), id("Id"), id("TeamId"), id("UserId"), d.placeholder(1),
+		id("DeleteAt"), id("DeleteAt"), id("DisplayName"))
+
+	TracePrint("Executing query: " + teamQuery + " with UserId: " + userID)
+	teamRows, err := s.db.QueryContext(ctx, teamQuery, userID)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error executing query: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return nil, err
+	}
+	defer teamRows.Close()
+
+	var teams []string
+	for teamRows.Next() {
+		var team string
+		if err := teamRows.Scan(&team); err != nil {
+			errMsg := fmt.Sprintf("Error scanning %s row: %v", d.driver(), err)
+			LogMessage(errorLevel, errMsg)
+			return nil, err
+		}
+		teams = append(teams, team)
+	}
+
+	return teams, teamRows.Err()
+}
@@ -0,0 +1,49 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"log/syslog"
+	"net/url"
+)
+
+// syslogLogger sends log messages to the local syslog daemon, or to a remote one.
+type syslogLogger struct {
+	writer *syslog.Writer
+}
+
+// openSystemLogger connects to syslog.  An empty address means the local daemon, otherwise it should be a URL such as
+// udp://loghost:514 or tcp://loghost:514.
+func openSystemLogger(address string) (systemLogger, error) {
+	network, raddr := "", ""
+	if address != "" {
+		u, err := url.Parse(address)
+		if err != nil {
+			return nil, err
+		}
+		network, raddr = u.Scheme, u.Host
+	}
+
+	writer, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, appName)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogLogger{writer: writer}, nil
+}
+
+func (l *syslogLogger) Log(level LogLevel, message string) error {
+	switch level {
+	case errorLevel:
+		return l.writer.Err(message)
+	case warningLevel:
+		return l.writer.Warning(message)
+	case infoLevel:
+		return l.writer.Info(message)
+	default:
+		return l.writer.Debug(message)
+	}
+}
+
+func (l *syslogLogger) Close() error {
+	return l.writer.Close()
+}
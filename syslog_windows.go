@@ -0,0 +1,47 @@
+//go:build windows
+
+package main
+
+import (
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// Event IDs are arbitrary, but keeping one per level makes it easy to filter in Event Viewer.
+const (
+	eventIDInfo    = 1
+	eventIDWarning = 2
+	eventIDError   = 3
+)
+
+// eventLogger sends log messages to the Windows Event Log.
+type eventLogger struct {
+	log *eventlog.Log
+}
+
+// openSystemLogger opens the Windows Event Log.  The address is only used for syslog, so it's ignored here.
+func openSystemLogger(address string) (systemLogger, error) {
+	// Registering the event source needs admin rights, and only has to be done once.  If it fails, the messages are
+	// still logged, but Event Viewer will complain that it can't find the event descriptions.
+	_ = eventlog.InstallAsEventCreate(appName, eventlog.Error|eventlog.Warning|eventlog.Info)
+
+	log, err := eventlog.Open(appName)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLogger{log: log}, nil
+}
+
+func (l *eventLogger) Log(level LogLevel, message string) error {
+	switch level {
+	case errorLevel:
+		return l.log.Error(eventIDError, message)
+	case warningLevel:
+		return l.log.Warning(eventIDWarning, message)
+	default:
+		return l.log.Info(eventIDInfo, message)
+	}
+}
+
+func (l *eventLogger) Close() error {
+	return l.log.Close()
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+	"golang.org/x/term"
+)
+
+// ANSI colour codes used to highlight the summary tables.
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+)
+
+// reportStyle controls how the summary tables are highlighted.
+type reportStyle struct {
+	color             bool
+	minDesktopVersion string
+	minMobileVersion  string
+}
+
+// useColor decides whether to colour the output.  Colour is only used when writing to a terminal, and never when
+// the user has asked us not to, either with the flag or the NO_COLOR convention (https://no-color.org).
+func useColor(noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// versionColor picks the highlight for a version.  Anything below the minimum version is red.  When there's no
+// minimum, anything older than the newest version in use is yellow.  The newest version is always green.
+func versionColor(version string, minVersion string, newest string) string {
+	if minVersion != "" {
+		if older, err := mmversions.OlderOrEqual(version, minVersion); err == nil && older && version != minVersion {
+			return colorRed
+		}
+	}
+	if version == newest {
+		return colorGreen
+	}
+	if minVersion == "" {
+		return colorYellow
+	}
+	return ""
+}
+
+// printVersionTable writes an aligned table of versions, OS and counts, in version order.
+func printVersionTable(w io.Writer, versionCount VersionCount, minVersion string, style reportStyle) {
+	entries := mmversions.Entries(versionCount)
+	newest := ""
+	for _, entry := range entries {
+		if _, _, _, err := mmversions.ParseVersion(entry.Version); err == nil {
+			newest = entry.Version
+		}
+	}
+
+	// Colour codes would upset the column widths, so the table is laid out first and then coloured line by line
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\n", tr(msgColumnVersion), tr(msgColumnOS), tr(msgColumnCount))
+	for _, entry := range entries {
+		fmt.Fprintf(tw, "  %s\t%s\t%d\n", entry.Version, entry.OS, entry.Count)
+	}
+	tw.Flush()
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	fmt.Fprintln(w, lines[0])
+	for i, line := range lines[1:] {
+		color := ""
+		if style.color {
+			color = versionColor(entries[i].Version, minVersion, newest)
+		}
+		if color != "" {
+			fmt.Fprintln(w, color+line+colorReset)
+		} else {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+// printAPITable writes an aligned table of API clients by type and OS.
+func printAPITable(w io.Writer, apiCount VersionCount) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\n", tr(msgColumnType), tr(msgColumnOS), tr(msgColumnCount))
+	for _, entry := range mmversions.Entries(apiCount) {
+		fmt.Fprintf(tw, "  %s\t%s\t%d\n", entry.Version, entry.OS, entry.Count)
+	}
+	tw.Flush()
+}
+
+// printBuildTable writes an aligned table of mobile app versions, broken down by build number and OS.
+func printBuildTable(w io.Writer, buildCount VersionCount) {
+	fmt.Fprintln(w, "\n"+tr(msgBuildsFound))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", tr(msgColumnVersion), tr(msgColumnBuild), tr(msgColumnOS), tr(msgColumnCount))
+	for _, entry := range mmversions.BuildEntries(buildCount) {
+		build := entry.Build
+		if build == "" {
+			build = "-"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%d\n", entry.Version, build, entry.OS, entry.Count)
+	}
+	tw.Flush()
+}
+
+// printOSVersionTable writes an aligned table of desktop app versions broken down by OS and OS version, for OS
+// strings that include a version, such as "Windows 11".  OS strings without a version are listed with a "-".
+func printOSVersionTable(w io.Writer, versionCount VersionCount) {
+	type row struct {
+		os, osVersion string
+		entry         VersionEntry
+	}
+	var rows []row
+	for _, entry := range mmversions.Entries(versionCount) {
+		name, version := mmversions.SplitOS(entry.OS)
+		rows = append(rows, row{os: name, osVersion: version, entry: entry})
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].os != rows[j].os {
+			return rows[i].os < rows[j].os
+		}
+		if rows[i].osVersion != rows[j].osVersion {
+			return lessNumeric(rows[i].osVersion, rows[j].osVersion)
+		}
+		return false
+	})
+
+	fmt.Fprintln(w, "\n"+tr(msgOSVersionsFound))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", tr(msgColumnOS), tr(msgColumnOSVersion), tr(msgColumnAppVersion), tr(msgColumnCount))
+	for _, r := range rows {
+		osVersion := r.osVersion
+		if osVersion == "" {
+			osVersion = "-"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%d\n", r.os, osVersion, r.entry.Version, r.entry.Count)
+	}
+	tw.Flush()
+}
+
+// lessNumeric compares dotted version numbers of any length, such as 10.15.7 and 11, part by part.  Parts that
+// aren't numbers are compared as strings.
+func lessNumeric(a, b string) bool {
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		if aParts[i] == bParts[i] {
+			continue
+		}
+		aNum, aErr := strconv.Atoi(aParts[i])
+		bNum, bErr := strconv.Atoi(bParts[i])
+		if aErr == nil && bErr == nil {
+			return aNum < bNum
+		}
+		return aParts[i] < bParts[i]
+	}
+	return len(aParts) < len(bParts)
+}
+
+// printExpiryTable writes an aligned table of desktop and mobile clients by how soon their sessions expire.
+func printExpiryTable(w io.Writer, counts []expiryCount) {
+	fmt.Fprintln(w, "\n"+tr(msgExpiryFound))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\n", tr(msgColumnExpires), tr(msgColumnDesktop), tr(msgColumnMobile))
+	for _, count := range counts {
+		fmt.Fprintf(tw, "  %s\t%d\t%d\n", tr(count.label), count.desktop, count.mobile)
+	}
+	tw.Flush()
+}
+
+// printAdoptionTable writes the number of users with each kind of client, as a percentage of the enabled users.
+func printAdoptionTable(w io.Writer, a adoption) {
+	percent := func(n int) string {
+		if a.users == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", float64(n)*100/float64(a.users))
+	}
+
+	fmt.Fprintln(w, "\n"+tr(msgAdoptionFound))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\n", tr(msgColumnClient), tr(msgColumnUsers), tr(msgColumnAdoption))
+	fmt.Fprintf(tw, "  %s\t%d\t%s\n", tr(msgCardDesktopClients), a.desktop, percent(a.desktop))
+	fmt.Fprintf(tw, "  %s\t%d\t%s\n", tr(msgCardMobileClients), a.mobile, percent(a.mobile))
+	fmt.Fprintf(tw, "  %s\t%d\t%s\n", tr(msgAdoptionEither), a.either, percent(a.either))
+	tw.Flush()
+	fmt.Fprintln(w, "\n"+trf(msgEnabledUsers, a.users))
+}
+
+// printPerUserTable writes the sessions per user breakdown from tallySessionsPerUser.
+func printPerUserTable(w io.Writer, counts []perUserCount, most int) {
+	fmt.Fprintln(w, "\n"+tr(msgPerUserFound))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\n", tr(msgColumnPerUser), tr(msgColumnUsers), tr(msgColumnSessions))
+	for _, count := range counts {
+		fmt.Fprintf(tw, "  %s\t%d\t%d\n", count.label, count.users, count.sessions)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\n"+trf(msgMostSessions, most))
+}
+
+// printLicenseHeader writes the server's edition and license, which starts the report, so that the versions in use
+// and the license are in the same document for auditors.  An expired license is shown in red.
+func printLicenseHeader(w io.Writer, license *licenseInfo, now time.Time, style reportStyle) {
+	fmt.Fprintln(w, tr(msgServerLicense))
+	if license == nil {
+		fmt.Fprintln(w, "  "+trf(msgEdition, tr(msgEditionFree)))
+		fmt.Fprintln(w)
+		return
+	}
+
+	edition := license.Edition
+	if edition == "" {
+		edition = "Enterprise"
+	}
+	if license.Trial {
+		edition = trf(msgEditionTrial, edition)
+	}
+	fmt.Fprintln(w, "  "+trf(msgEdition, edition))
+	if license.Customer != "" {
+		fmt.Fprintln(w, "  "+trf(msgLicensedTo, license.Customer))
+	}
+	fmt.Fprintln(w, "  "+trf(msgLicensedSeats, license.Seats))
+	if license.ExpiresAt != 0 {
+		expires := time.UnixMilli(license.ExpiresAt)
+		days := int(math.Round(expires.Sub(now).Hours() / 24))
+		if expires.After(now) {
+			fmt.Fprintln(w, "  "+trf(msgLicenseExpires, formatMillis(license.ExpiresAt), days))
+		} else {
+			expired := trf(msgLicenseExpired, formatMillis(license.ExpiresAt), -days)
+			if style.color {
+				expired = colorRed + expired + colorReset
+			}
+			fmt.Fprintln(w, "  "+expired)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// printLicenseUsage writes the active clients and distinct active users as a percentage of the licensed seats,
+// with a warning if there are more active users than seats.
+func printLicenseUsage(w io.Writer, seats int, clients int, users int, style reportStyle) {
+	fmt.Fprintln(w, "\n"+tr(msgLicenseFound))
+	if seats == 0 {
+		fmt.Fprintln(w, "  "+tr(msgNoLicense))
+		return
+	}
+	percent := func(n int) float64 { return float64(n) * 100 / float64(seats) }
+	fmt.Fprintln(w, "  "+trf(msgLicensedSeats, seats))
+	fmt.Fprintln(w, "  "+trf(msgLicenseClients, clients, percent(clients)))
+	fmt.Fprintln(w, "  "+trf(msgLicenseUsers, users, percent(users)))
+	if users > seats {
+		warning := "\n" + trf(msgLicenseExceeded, users, seats)
+		if style.color {
+			warning = colorRed + warning + colorReset
+		}
+		fmt.Fprintln(w, warning)
+	}
+}
+
+// printGroupTable writes the version counts for each group of users, such as teams, in order of name, with the
+// users without a group last, under the noGroup label.
+func printGroupTable(w io.Writer, heading, column, noGroup string, groupCounts map[string]groupCount) {
+	groups := make([]string, 0, len(groupCounts))
+	for group := range groupCounts {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if (groups[i] == "") != (groups[j] == "") {
+			return groups[j] == ""
+		}
+		return strings.ToLower(groups[i]) < strings.ToLower(groups[j])
+	})
+
+	fmt.Fprintln(w, "\n"+tr(heading))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", tr(column), tr(msgColumnClient), tr(msgColumnVersion), tr(msgColumnCount))
+	for _, group := range groups {
+		name := group
+		if name == "" {
+			name = tr(noGroup)
+		}
+		for _, client := range []struct {
+			label  string
+			counts map[string]int
+		}{
+			{msgPlatformDesktop, groupCounts[group].desktop},
+			{msgPlatformMobile, groupCounts[group].mobile},
+		} {
+			versions := make([]string, 0, len(client.counts))
+			for version := range client.counts {
+				versions = append(versions, version)
+			}
+			sort.Slice(versions, func(i, j int) bool { return mmversions.Less(versions[i], versions[j]) })
+			for _, version := range versions {
+				fmt.Fprintf(tw, "  %s\t%s\t%s\t%d\n", name, tr(client.label), version, client.counts[version])
+			}
+		}
+	}
+	tw.Flush()
+}
+
+// printRoleTable writes the version counts for each role, followed by the number of users in each role on outdated
+// versions, if there's a minimum version to compare with.
+func printRoleTable(w io.Writer, tally roleTally, style reportStyle) {
+	translated := make(map[string]groupCount, len(tally.counts))
+	for role, count := range tally.counts {
+		if role != "" {
+			role = tr(role)
+		}
+		translated[role] = count
+	}
+	printGroupTable(w, msgRolesFound, msgColumnRole, msgUnknownRole, translated)
+
+	for _, platform := range []struct {
+		label    string
+		minimum  string
+		outdated map[string]int
+	}{
+		{msgPlatformDesktop, style.minDesktopVersion, tally.outdatedDesktop},
+		{msgPlatformMobile, style.minMobileVersion, tally.outdatedMobile},
+	} {
+		if platform.minimum == "" {
+			continue
+		}
+		var counts []string
+		for _, role := range []string{msgRoleAdmin, msgRoleGuest, msgRoleMember, ""} {
+			name := tr(msgUnknownRole)
+			if role != "" {
+				name = tr(role)
+			}
+			if n := platform.outdated[role]; n > 0 || role != "" {
+				counts = append(counts, fmt.Sprintf("%s %d", name, n))
+			}
+		}
+		fmt.Fprintln(w, "\n"+trf(msgOutdatedByRole, tr(platform.label), platform.minimum, strings.Join(counts, ", ")))
+	}
+}
+
+// printLocaleTable writes an aligned table of client counts by user locale, most common first.
+func printLocaleTable(w io.Writer, localeCount map[string]int) {
+	locales := make([]string, 0, len(localeCount))
+	for locale := range localeCount {
+		locales = append(locales, locale)
+	}
+	sort.Slice(locales, func(i, j int) bool {
+		if localeCount[locales[i]] != localeCount[locales[j]] {
+			return localeCount[locales[i]] > localeCount[locales[j]]
+		}
+		return locales[i] < locales[j]
+	})
+
+	fmt.Fprintln(w, "\n"+tr(msgLocalesFound))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\n", tr(msgColumnLocale), tr(msgColumnCount))
+	for _, locale := range locales {
+		name := locale
+		if name == "" {
+			name = tr(msgUnknownLocale)
+		}
+		fmt.Fprintf(tw, "  %s\t%d\n", name, localeCount[locale])
+	}
+	tw.Flush()
+}
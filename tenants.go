@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// defaultTenantsDir is where the tenants command writes each customer's report bundle, in a directory named after
+// the tenant, along with the overview across all of them.
+const defaultTenantsDir = "tenants"
+
+// The files in each tenant's report bundle, and the overview alongside them.
+const (
+	tenantSummaryFile  = "summary.txt"
+	tenantReportFile   = "report.csv"
+	tenantSnapshotFile = "snapshot.json"
+	tenantOverviewFile = "overview.csv"
+)
+
+// tenantResult is the outcome of the report for one tenant.  err is set if the tenant's database couldn't be read,
+// in which case there's no summary.
+type tenantResult struct {
+	name       string
+	summary    *mmversions.Summary
+	compliance []complianceResult
+	// outdated and total are the desktop and mobile clients older than the tenant's minimum versions, or the newest
+	// version in use without them, and all of its desktop and mobile clients
+	outdated int
+	total    int
+	err      error
+}
+
+// newTenantResult measures the tenant's clients against its own minimum versions and compliance thresholds.
+func newTenantResult(name string, summary *mmversions.Summary, config *Config) tenantResult {
+	desktop, _ := outdatedEntries(mmversions.Entries(summary.Desktop), config.Report.MinDesktopVersion)
+	mobile, _ := outdatedEntries(mmversions.Entries(summary.Mobile), config.Report.MinMobileVersion)
+	return tenantResult{
+		name:       name,
+		summary:    summary,
+		compliance: checkCompliance(summary, config),
+		outdated:   sumEntries(desktop) + sumEntries(mobile),
+		total:      mmversions.Total(summary.Desktop) + mmversions.Total(summary.Mobile),
+	}
+}
+
+// breaches lists the platforms below their compliance threshold.
+func (r tenantResult) breaches() []string {
+	var platforms []string
+	for _, result := range r.compliance {
+		if result.breached() {
+			platforms = append(platforms, result.platform)
+		}
+	}
+	return platforms
+}
+
+// status is the tenant's compliance for the overview: failed if it couldn't be read, the breached platforms, OK, or
+// a dash if it has no compliance thresholds.
+func (r tenantResult) status() string {
+	switch {
+	case r.err != nil:
+		return tr(msgTenantFailed)
+	case len(r.breaches()) > 0:
+		return tr(msgComplianceBreach) + " (" + strings.Join(r.breaches(), ", ") + ")"
+	case len(r.compliance) > 0:
+		return tr(msgComplianceOK)
+	default:
+		return "-"
+	}
+}
+
+// writeTenantBundle writes the tenant's report bundle to dir: a plain-language summary, the version counts as CSV,
+// and a snapshot.  The summary's trend comes from the snapshot left by the previous run, before it's replaced.
+func writeTenantBundle(ctx context.Context, dir string, summary *mmversions.Summary, config *Config) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	bundleConfig := *config
+	bundleConfig.Output.SnapshotFile = filepath.Join(dir, tenantSnapshotFile)
+	for _, file := range []struct{ name, format string }{
+		{tenantSummaryFile, "exec-summary"},
+		{tenantReportFile, "csv"},
+	} {
+		if err := writeSummaryFile(ctx, filepath.Join(dir, file.name), file.format, summary, &bundleConfig); err != nil {
+			return err
+		}
+	}
+	return writeSnapshot(bundleConfig.Output.SnapshotFile, newSnapshot(summary))
+}
+
+// writeSummaryFile writes the version counts to a file in one of the output formats.
+func writeSummaryFile(ctx context.Context, filename, format string, summary *mmversions.Summary, config *Config) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer, err := newOutputWriter(format, file, config)
+	if err != nil {
+		return err
+	}
+	if err := writer.Write(ctx, summary); err != nil {
+		return fmt.Errorf("unable to write %s: %w", filename, err)
+	}
+	return file.Close()
+}
+
+// tenantOverviewRows lists each tenant's clients and compliance, for the overview file.  The first row is the
+// header.
+func tenantOverviewRows(results []tenantResult) [][]string {
+	rows := [][]string{{"Tenant", "Desktop", "Mobile", "Outdated", "Outdated %", "Status"}}
+	for _, r := range results {
+		if r.err != nil {
+			rows = append(rows, []string{r.name, "", "", "", "", "failed"})
+			continue
+		}
+		status := "ok"
+		switch {
+		case len(r.breaches()) > 0:
+			status = "breached: " + strings.Join(r.breaches(), ", ")
+		case len(r.compliance) == 0:
+			status = ""
+		}
+		rows = append(rows, []string{r.name, fmt.Sprint(mmversions.Total(r.summary.Desktop)), fmt.Sprint(mmversions.Total(r.summary.Mobile)),
+			fmt.Sprint(r.outdated), fmt.Sprintf("%.1f", percentOf(r.outdated, r.total)), status})
+	}
+	return rows
+}
+
+// printTenantOverview writes a table of each tenant's clients and compliance, with the tenants that breach their
+// thresholds or couldn't be read highlighted.
+func printTenantOverview(w io.Writer, results []tenantResult, style reportStyle) {
+	fmt.Fprintln(w, tr(msgTenantsFound))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", tr(msgColumnTenant), tr(msgColumnDesktop), tr(msgColumnMobile), tr(msgColumnOutdated), tr(msgColumnStatus))
+	for _, r := range results {
+		status := r.status()
+		if style.color && (r.err != nil || len(r.breaches()) > 0) {
+			status = colorRed + status + colorReset
+		}
+		if r.err != nil {
+			fmt.Fprintf(tw, "  %s\t-\t-\t-\t%s\n", r.name, status)
+			continue
+		}
+		fmt.Fprintf(tw, "  %s\t%d\t%d\t%.1f%%\t%s\n", r.name, mmversions.Total(r.summary.Desktop), mmversions.Total(r.summary.Mobile),
+			percentOf(r.outdated, r.total), status)
+	}
+	tw.Flush()
+
+	compliant, breached, failed := 0, 0, 0
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			failed++
+		case len(r.breaches()) > 0:
+			breached++
+		default:
+			compliant++
+		}
+	}
+	fmt.Fprintln(w, "\n"+trf(msgTenantsTotal, len(results), compliant, breached, failed))
+}
+
+// tenantsFailed returns the error for the first tenant that couldn't be read or, failing that, a compliance error
+// with every platform breached by any tenant in the exit code.  It returns nil if all the tenants are compliant.
+func tenantsFailed(results []tenantResult) error {
+	breaches := 0
+	var details []string
+	for _, r := range results {
+		if r.err != nil {
+			return r.err
+		}
+		for _, result := range r.compliance {
+			if result.breached() {
+				breaches |= result.bit
+				details = append(details, fmt.Sprintf("%s %s %.1f%% < %g%%", r.name, result.platform, result.percent(), result.threshold))
+			}
+		}
+	}
+	if breaches == 0 {
+		return nil
+	}
+	return complianceError(breaches, "Compliance thresholds breached: %s", strings.Join(details, ", "))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	// Embed the time zone database, since it isn't always available on Windows or in minimal containers
+	_ "time/tzdata"
+)
+
+// outputLocation is the time zone used for timestamps in reports and other output, set with '-tz'.  It defaults to
+// UTC so that output from servers in different time zones can be compared directly.
+var outputLocation = time.UTC
+
+// setOutputLocation sets the output time zone from an IANA name such as Europe/Berlin, or "Local" for the time zone
+// of the machine running the utility.
+func setOutputLocation(name string) error {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("unknown time zone %q", name)
+	}
+	outputLocation = location
+	return nil
+}
+
+// formatTimestamp renders a time as ISO-8601 in the output time zone.
+func formatTimestamp(t time.Time) string {
+	return t.In(outputLocation).Format(time.RFC3339)
+}
+
+// formatMillis renders a Mattermost timestamp, which is in milliseconds since the epoch.  Zero means that the
+// timestamp isn't set, so it's left empty rather than showing 1970.
+func formatMillis(millis int64) string {
+	if millis == 0 {
+		return ""
+	}
+	return formatTimestamp(time.UnixMilli(millis))
+}
+
+// ageInDays returns the number of whole days since a Mattermost timestamp, or an empty string if it isn't set.
+func ageInDays(millis int64, now time.Time) string {
+	if millis == 0 {
+		return ""
+	}
+	return strconv.Itoa(int(now.Sub(time.UnixMilli(millis)).Hours() / 24))
+}
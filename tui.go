@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// dashboardRow is a single line of one of the dashboard tables.
+type dashboardRow struct {
+	Category string
+	Version  string
+	OS       string
+	Count    int
+}
+
+// dashboardData is the result of a single collection for the dashboard.
+type dashboardData struct {
+	rows      []dashboardRow
+	collected time.Time
+	err       error
+}
+
+type dashboardTick struct{}
+
+// dashboard is the bubbletea model for the interactive terminal dashboard.
+type dashboard struct {
+	// ctx is cancelled when the utility is stopped, abandoning any refresh in progress
+	ctx      context.Context
+	source   Store
+	interval time.Duration
+
+	data       dashboardData
+	loading    bool
+	filter     string
+	editFilter bool
+	osFilter   string
+	offset     int
+	height     int
+	status     string
+}
+
+func newDashboard(ctx context.Context, source Store, interval time.Duration) *dashboard {
+	return &dashboard{ctx: ctx, source: source, interval: interval, loading: true}
+}
+
+// collect reads the sessions and tallies the desktop, mobile and web versions.
+func (d *dashboard) collect() tea.Cmd {
+	return func() tea.Msg {
+		sessions, err := d.source.Sessions(d.ctx)
+		if err != nil {
+			return dashboardData{err: err, collected: time.Now()}
+		}
+
+		summary := tallySessions(sessions)
+		var rows []dashboardRow
+		for _, category := range []struct {
+			name   string
+			counts VersionCount
+		}{
+			{"Desktop", summary.Desktop},
+			{"Desktop (dev)", summary.DesktopDev},
+			{"Desktop (legacy)", summary.DesktopLegacy},
+			{"Mobile", summary.Mobile},
+			{"Web", summary.Web},
+			{"API", summary.API},
+		} {
+			for _, entry := range mmversions.Entries(category.counts) {
+				rows = append(rows, dashboardRow{Category: category.name, Version: entry.Version, OS: entry.OS, Count: entry.Count})
+			}
+		}
+
+		return dashboardData{rows: rows, collected: time.Now()}
+	}
+}
+
+func (d *dashboard) tick() tea.Cmd {
+	return tea.Tick(d.interval, func(time.Time) tea.Msg { return dashboardTick{} })
+}
+
+func (d *dashboard) Init() tea.Cmd {
+	return tea.Batch(d.collect(), d.tick())
+}
+
+func (d *dashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		d.height = msg.Height
+
+	case dashboardTick:
+		if d.loading {
+			return d, d.tick()
+		}
+		d.loading = true
+		return d, tea.Batch(d.collect(), d.tick())
+
+	case dashboardData:
+		d.loading = false
+		d.data = msg
+		if msg.err != nil {
+			d.status = "Refresh failed: " + msg.err.Error()
+		}
+
+	case tea.KeyMsg:
+		if d.editFilter {
+			return d, d.updateFilter(msg)
+		}
+		return d, d.handleKey(msg)
+	}
+
+	return d, nil
+}
+
+// updateFilter handles key presses while the user is typing a filter.
+func (d *dashboard) updateFilter(msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyEnter:
+		d.editFilter = false
+	case tea.KeyEsc:
+		d.editFilter = false
+		d.filter = ""
+	case tea.KeyBackspace:
+		if len(d.filter) > 0 {
+			d.filter = d.filter[:len(d.filter)-1]
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		d.filter += string(msg.Runes)
+	case tea.KeyCtrlC:
+		return tea.Quit
+	}
+	d.offset = 0
+	return nil
+}
+
+func (d *dashboard) handleKey(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "q", "ctrl+c":
+		return tea.Quit
+	case "/":
+		d.editFilter = true
+	case "o":
+		d.osFilter = d.nextOS()
+		d.offset = 0
+	case "c":
+		d.filter = ""
+		d.osFilter = ""
+		d.offset = 0
+	case "r":
+		if !d.loading {
+			d.loading = true
+			return d.collect()
+		}
+	case "e":
+		d.status = d.export()
+	case "up", "k":
+		if d.offset > 0 {
+			d.offset--
+		}
+	case "down", "j":
+		d.offset++
+	}
+	return nil
+}
+
+// nextOS cycles the OS filter through each OS in the current data, then back to showing everything.
+func (d *dashboard) nextOS() string {
+	seen := make(map[string]bool)
+	var systems []string
+	for _, row := range d.data.rows {
+		if !seen[row.OS] {
+			seen[row.OS] = true
+			systems = append(systems, row.OS)
+		}
+	}
+	sort.Strings(systems)
+
+	if d.osFilter == "" {
+		if len(systems) > 0 {
+			return systems[0]
+		}
+		return ""
+	}
+	for i, system := range systems {
+		if system == d.osFilter && i+1 < len(systems) {
+			return systems[i+1]
+		}
+	}
+	return ""
+}
+
+// visibleRows returns the rows that match the current filters.
+func (d *dashboard) visibleRows() []dashboardRow {
+	filter := strings.ToLower(d.filter)
+	var rows []dashboardRow
+	for _, row := range d.data.rows {
+		if d.osFilter != "" && row.OS != d.osFilter {
+			continue
+		}
+		if filter != "" && !strings.Contains(strings.ToLower(row.Version), filter) && !strings.Contains(strings.ToLower(row.OS), filter) {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// export writes the rows in the current view to a CSV file, returning a status message.
+func (d *dashboard) export() string {
+	filename := fmt.Sprintf("mm-versions-%s.csv", time.Now().Format("20060102-150405"))
+	file, err := os.Create(filename)
+	if err != nil {
+		return "Export failed: " + err.Error()
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	_ = writer.Write([]string{"Category", "Version", "OS", "Count"})
+	for _, row := range d.visibleRows() {
+		_ = writer.Write([]string{row.Category, row.Version, row.OS, fmt.Sprint(row.Count)})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return "Export failed: " + err.Error()
+	}
+
+	return "Exported current view to " + filename
+}
+
+func (d *dashboard) View() string {
+	var header strings.Builder
+	fmt.Fprintf(&header, "Mattermost Client Versions - refreshing every %s", d.interval)
+	if !d.data.collected.IsZero() {
+		fmt.Fprintf(&header, ", last updated %s", formatTimestamp(d.data.collected))
+	}
+	if d.loading {
+		header.WriteString(" (refreshing...)")
+	}
+	header.WriteString("\n")
+
+	filterLine := "Filter: " + d.filter
+	if d.editFilter {
+		filterLine += "_"
+	}
+	if d.osFilter != "" {
+		filterLine += "   OS: " + d.osFilter
+	}
+	header.WriteString(filterLine + "\n\n")
+
+	var body strings.Builder
+	rows := d.visibleRows()
+	for _, category := range []string{"Desktop", "Desktop (dev)", "Mobile", "Web", "API"} {
+		total := 0
+		var table strings.Builder
+		tw := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  VERSION\tOS\tCOUNT")
+		for _, row := range rows {
+			if row.Category == category {
+				fmt.Fprintf(tw, "  %s\t%s\t%d\n", row.Version, row.OS, row.Count)
+				total += row.Count
+			}
+		}
+		tw.Flush()
+		fmt.Fprintf(&body, "%s (%d)\n%s\n", category, total, table.String())
+	}
+
+	footer := "[/] filter  [o] cycle OS  [c] clear  [r] refresh  [e] export CSV  [↑/↓] scroll  [q] quit\n" + d.status
+
+	// Scroll the body so the header and footer always fit on screen
+	lines := strings.Split(strings.TrimRight(body.String(), "\n"), "\n")
+	space := d.height - strings.Count(header.String(), "\n") - strings.Count(footer, "\n") - 2
+	if space > 0 && len(lines) > space {
+		if d.offset > len(lines)-space {
+			d.offset = len(lines) - space
+		}
+		lines = lines[d.offset : d.offset+space]
+	}
+
+	return header.String() + strings.Join(lines, "\n") + "\n\n" + footer
+}
+
+// runDashboard runs the terminal dashboard until the user quits.
+func runDashboard(ctx context.Context, source Store, interval time.Duration) error {
+	program := tea.NewProgram(newDashboard(ctx, source, interval), tea.WithAltScreen(), tea.WithContext(ctx))
+	_, err := program.Run()
+	return err
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// uploadTimeout limits how long a single upload can take, so a scheduled run can't hang on an unreachable endpoint.
+var uploadTimeout = 5 * time.Minute
+
+// uploader delivers output files somewhere other than the local disk, once they've been written.  Each kind of
+// destination lives in its own file and registers itself by URL scheme with registerUploader, in the same way as the
+// output formats.
+type uploader interface {
+	// Upload stores the contents of r under name, relative to the destination in the URL.
+	Upload(ctx context.Context, name string, r io.Reader) error
+	Close() error
+}
+
+// uploaderFactory opens the destination in an upload URL, taking any credentials it needs from the config.
+type uploaderFactory func(ctx context.Context, destination *url.URL, config *Config) (uploader, error)
+
+var uploaders = make(map[string]uploaderFactory)
+
+// registerUploader makes a destination available by URL scheme.  It's called from init functions, so a duplicate
+// scheme is a programming error.
+func registerUploader(scheme string, factory uploaderFactory) {
+	if _, ok := uploaders[scheme]; ok {
+		panic("upload scheme registered twice: " + scheme)
+	}
+	uploaders[scheme] = factory
+}
+
+// uploadSchemes lists the registered URL schemes, for help and error messages.
+func uploadSchemes() string {
+	schemes := make([]string, 0, len(uploaders))
+	for scheme := range uploaders {
+		schemes = append(schemes, scheme+"://")
+	}
+	sort.Strings(schemes)
+	return strings.Join(schemes, ", ")
+}
+
+// parseUploadURL checks that an upload URL has a registered scheme and a bucket or host.
+func parseUploadURL(destination string) (*url.URL, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := uploaders[strings.ToLower(u.Scheme)]; !ok {
+		return nil, fmt.Errorf("unsupported upload destination %q.  Supported schemes are: %s", u.Redacted(), uploadSchemes())
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("the upload destination %q has no bucket or host", u.Redacted())
+	}
+	return u, nil
+}
+
+// uploadOutput uploads output files to the destination in the config, if there is one.  Each file keeps its base
+// name, under any path in the URL.
+func uploadOutput(ctx context.Context, config *Config, filenames ...string) error {
+	if config.Upload.URL == "" {
+		return nil
+	}
+	destination, err := parseUploadURL(config.Upload.URL)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout)
+	defer cancel()
+
+	target, err := uploaders[strings.ToLower(destination.Scheme)](ctx, destination, config)
+	if err != nil {
+		errMsg := fmt.Sprintf("Unable to open upload destination %s: %v", destination.Redacted(), err)
+		LogMessage(errorLevel, errMsg)
+		return err
+	}
+	defer target.Close()
+
+	for _, filename := range filenames {
+		if err := uploadFile(ctx, target, destination, filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadFile uploads a single file to an open destination.
+func uploadFile(ctx context.Context, target uploader, destination *url.URL, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		errMsg := fmt.Sprintf("Unable to open %s for upload: %v", filename, err)
+		LogMessage(errorLevel, errMsg)
+		return err
+	}
+	defer file.Close()
+
+	name := filepath.Base(filename)
+	if err := target.Upload(ctx, name, file); err != nil {
+		errMsg := fmt.Sprintf("Unable to upload %s to %s: %v", name, destination.Redacted(), err)
+		LogMessage(errorLevel, errMsg)
+		return err
+	}
+
+	LogMessage(infoLevel, "Uploaded "+name+" to: "+destination.Redacted())
+	return nil
+}
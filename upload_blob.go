@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+
+	"gocloud.dev/blob"
+	_ "gocloud.dev/blob/azureblob"
+	_ "gocloud.dev/blob/gcsblob"
+	_ "gocloud.dev/blob/s3blob"
+)
+
+func init() {
+	for _, scheme := range []string{"s3", "gs", "azblob"} {
+		registerUploader(scheme, openBlobUploader)
+	}
+}
+
+// blobUploader uploads to S3, Google Cloud Storage or Azure Blob Storage.  Credentials come from each provider's
+// standard chain, e.g. the AWS_* environment variables, a shared config file or an instance role for S3, so they
+// never need to be in the config file.
+type blobUploader struct {
+	bucket *blob.Bucket
+}
+
+// openBlobUploader opens the bucket in a URL such as s3://bucket/prefix?region=eu-west-1.  The path is used as a
+// prefix for the uploaded files, and the query holds the provider's own settings.
+func openBlobUploader(ctx context.Context, destination *url.URL, config *Config) (uploader, error) {
+	bucketURL := *destination
+	prefix := strings.Trim(bucketURL.Path, "/")
+	bucketURL.Path = ""
+	bucket, err := blob.OpenBucket(ctx, bucketURL.String())
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" {
+		bucket = blob.PrefixedBucket(bucket, prefix+"/")
+	}
+	return &blobUploader{bucket: bucket}, nil
+}
+
+func (u *blobUploader) Upload(ctx context.Context, name string, r io.Reader) error {
+	writer, err := u.bucket.NewWriter(ctx, name, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, r); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+func (u *blobUploader) Close() error {
+	return u.bucket.Close()
+}
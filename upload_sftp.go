@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func init() {
+	registerUploader("sftp", openSFTPUploader)
+}
+
+// sftpUploader copies files to a directory on an SFTP server.
+type sftpUploader struct {
+	conn   *ssh.Client
+	client *sftp.Client
+	dir    string
+}
+
+// openSFTPUploader connects to the server in a URL such as sftp://reports@files.example.com/incoming.  The user
+// authenticates with the key in upload.ssh_key, upload.password, or both, and the server's host key must be in the
+// known hosts file, which is ~/.ssh/known_hosts unless upload.known_hosts says otherwise.
+func openSFTPUploader(ctx context.Context, destination *url.URL, config *Config) (uploader, error) {
+	settings := config.Upload
+
+	knownHostsFile := settings.KnownHosts
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("unable to find the known hosts file: %w", err)
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the known hosts file: %w", err)
+	}
+
+	var auth []ssh.AuthMethod
+	if settings.SSHKey != "" {
+		key, err := os.ReadFile(settings.SSHKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the SSH key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the SSH key %s: %w", settings.SSHKey, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	password := settings.Password
+	if urlPassword, ok := destination.User.Password(); ok {
+		password = urlPassword
+	}
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("no SSH key or password.  Set upload.ssh_key or upload.password in the config file")
+	}
+
+	username := destination.User.Username()
+	if username == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("no user in the URL: %w", err)
+		}
+		username = current.Username
+	}
+	address := destination.Host
+	if destination.Port() == "" {
+		address = net.JoinHostPort(destination.Hostname(), "22")
+	}
+
+	DebugPrint("Connecting to SFTP server: " + address + " as " + username)
+	dialer := &net.Dialer{Timeout: connectionTestTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	sshConn, channels, requests, err := ssh.NewClientConn(netConn, address, &ssh.ClientConfig{
+		User:            username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         connectionTestTimeout,
+	})
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	conn := ssh.NewClient(sshConn, channels, requests)
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	dir := destination.Path
+	if dir == "" {
+		dir = "."
+	} else if err := client.MkdirAll(dir); err != nil {
+		client.Close()
+		conn.Close()
+		return nil, fmt.Errorf("unable to create %s: %w", dir, err)
+	}
+	return &sftpUploader{conn: conn, client: client, dir: dir}, nil
+}
+
+func (u *sftpUploader) Upload(ctx context.Context, name string, r io.Reader) error {
+	file, err := u.client.Create(path.Join(u.dir, name))
+	if err != nil {
+		return err
+	}
+	if _, err := file.ReadFrom(r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Close drops the SSH connection first, since closing the SFTP session waits for the server to close its end, which
+// some servers never do.  Each file has been written in full by then.
+func (u *sftpUploader) Close() error {
+	err := u.conn.Close()
+	u.client.Close()
+	return err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func init() {
+	registerUploader("webdav", openWebDAVUploader)
+	registerUploader("webdav+http", openWebDAVUploader)
+}
+
+// webdavUploader copies files to a collection on a WebDAV server, such as Nextcloud or SharePoint, with HTTP PUT.
+type webdavUploader struct {
+	client   *http.Client
+	base     *url.URL
+	username string
+	password string
+}
+
+// openWebDAVUploader prepares uploads to a URL such as webdav://reports@files.example.com/remote.php/dav/files/reports,
+// which is reached over HTTPS.  webdav+http:// is plain HTTP, for servers on a trusted network.  The password is
+// taken from upload.password, if it isn't in the URL.  The collection in the path is created if it doesn't exist.
+func openWebDAVUploader(ctx context.Context, destination *url.URL, config *Config) (uploader, error) {
+	base := *destination
+	base.Scheme = "https"
+	if destination.Scheme == "webdav+http" {
+		base.Scheme = "http"
+	}
+	base.User = nil
+	base.Path = strings.TrimSuffix(base.Path, "/")
+
+	u := &webdavUploader{
+		client:   &http.Client{Timeout: webhookTimeout},
+		base:     &base,
+		username: destination.User.Username(),
+		password: config.Upload.Password,
+	}
+	if password, ok := destination.User.Password(); ok {
+		u.password = password
+	}
+
+	// MKCOL only creates one level at a time, and fails with 405 Method Not Allowed when the collection is there already
+	collection := base
+	collection.Path = ""
+	for _, segment := range strings.Split(strings.Trim(base.Path, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		collection.Path += "/" + segment
+		status, err := u.do(ctx, "MKCOL", collection.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusCreated && status != http.StatusMethodNotAllowed && status != http.StatusOK {
+			return nil, fmt.Errorf("unable to create collection %s: %s", collection.Path, http.StatusText(status))
+		}
+	}
+	return u, nil
+}
+
+func (u *webdavUploader) Upload(ctx context.Context, name string, r io.Reader) error {
+	target := *u.base
+	target.Path += "/" + name
+	status, err := u.do(ctx, http.MethodPut, target.String(), r)
+	if err != nil {
+		return err
+	}
+	if status < 200 || status > 299 {
+		return fmt.Errorf("the server returned %d %s", status, http.StatusText(status))
+	}
+	return nil
+}
+
+// do sends a request with the credentials, returning the status code.
+func (u *webdavUploader) do(ctx context.Context, method, target string, body io.Reader) (int, error) {
+	request, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("User-Agent", "mm-desktop-versions/"+Version)
+	if u.username != "" {
+		request.SetBasicAuth(u.username, u.password)
+	}
+	response, err := u.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	return response.StatusCode, nil
+}
+
+func (u *webdavUploader) Close() error {
+	u.client.CloseIdleConnections()
+	return nil
+}
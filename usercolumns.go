@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Extra Users columns for the lookup CSV are either a column of the Users table, such as Position or Nickname, or
+// "props." followed by a key in the user's Props, which is where custom profile attributes are often kept.  Column
+// names can't be passed to the database as parameters, so they're checked against these patterns instead.
+var (
+	userColumnPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+	userPropPattern   = regexp.MustCompile(`^(?i:props)\.[A-Za-z0-9_.\-]+$`)
+)
+
+// validateUserColumns checks the extra Users columns from the config file.
+func validateUserColumns(columns []string) error {
+	for _, column := range columns {
+		if !userColumnPattern.MatchString(column) && !userPropPattern.MatchString(column) {
+			return fmt.Errorf("invalid user column %q.  This must be a column of the Users table, or props.<key>", column)
+		}
+	}
+	return nil
+}
+
+// userTableColumns returns the Users table columns needed for the extra columns, which includes Props if any of them
+// come from the user's Props.
+func userTableColumns(columns []string) []string {
+	var tableColumns []string
+	seen := make(map[string]bool)
+	for _, column := range columns {
+		if userPropPattern.MatchString(column) {
+			column = "Props"
+		}
+		if !seen[strings.ToLower(column)] {
+			seen[strings.ToLower(column)] = true
+			tableColumns = append(tableColumns, column)
+		}
+	}
+	return tableColumns
+}
+
+// userColumnValue returns the value of an extra column for a user.
+func userColumnValue(user UserRecord, column string) string {
+	if !userPropPattern.MatchString(column) {
+		return user.Extra[strings.ToLower(column)]
+	}
+
+	var props map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(user.Extra["props"]), &props); err != nil {
+		return ""
+	}
+	_, key, _ := strings.Cut(column, ".")
+	return jsonValueString(props[key])
+}
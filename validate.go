@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+	"github.com/spf13/viper"
+)
+
+// configProblem is a single issue found when validating a config file.
+type configProblem struct {
+	Level   LogLevel
+	Key     string
+	Message string
+}
+
+func (p configProblem) String() string {
+	if p.Key == "" {
+		return fmt.Sprintf("[%s] %s", p.Level, p.Message)
+	}
+	return fmt.Sprintf("[%s] %s: %s", p.Level, p.Key, p.Message)
+}
+
+// configKeys lists every key that the config file can contain, in viper's dotted, lower-case form.
+func configKeys(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("mapstructure")
+		if name == "" {
+			name = field.Tag.Get("json")
+		}
+		name, _, _ = strings.Cut(name, ",")
+		if name == "" || name == "-" {
+			name = field.Name
+		}
+		key := strings.ToLower(prefix + name)
+
+		if field.Type.Kind() == reflect.Struct {
+			keys = append(keys, configKeys(field.Type, key+".")...)
+		} else {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// validateConfig checks a config file for anything that would stop the utility from running, returning the
+// problems found.  If a profile or tenant is given, the settings are checked with it applied.  An error is only
+// returned if the file couldn't be read at all, or the profile or tenant doesn't exist.
+func validateConfig(configFile string, profile string, tenant string) ([]configProblem, error) {
+	if _, err := os.Stat(configFile); err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", configFile, err)
+	}
+
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("%s is not a valid config file: %w", configFile, err)
+	}
+	if profile != "" {
+		if err := selectProfile(v, profile); err != nil {
+			return nil, err
+		}
+	}
+	if tenant != "" {
+		if err := selectTenant(v, tenant); err != nil {
+			return nil, err
+		}
+	}
+
+	var problems []configProblem
+	addError := func(key, format string, args ...interface{}) {
+		problems = append(problems, configProblem{Level: errorLevel, Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+	addWarning := func(key, format string, args ...interface{}) {
+		problems = append(problems, configProblem{Level: warningLevel, Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+
+	known := make(map[string]bool)
+	for _, key := range configKeys(reflect.TypeOf(Config{}), "") {
+		known[key] = true
+	}
+	unknown := []string{}
+	for _, key := range v.AllKeys() {
+		// Profiles and tenants can contain any of the top-level settings, apart from more profiles or tenants
+		if section, rest, ok := strings.Cut(key, "."); ok && (section == "profiles" || section == "tenants") {
+			_, setting, _ := strings.Cut(rest, ".")
+			if !nestedSection(setting) && known[setting] {
+				continue
+			}
+		}
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		addWarning(key, "unknown setting, which will be ignored.  Check the spelling against the README")
+	}
+
+	var config Config
+	if err := v.Unmarshal(&config); err != nil {
+		addError("", "the config file has a value of the wrong type: %v", err)
+		return problems, nil
+	}
+
+	switch {
+	case config.DB.Type == "":
+		addError("db.type", "missing.  This must be either \"postgresql\" or \"mysql\"")
+	case config.DB.Type != "postgresql" && config.DB.Type != "mysql":
+		addError("db.type", "unsupported database type %q.  This must be either \"postgresql\" or \"mysql\"", config.DB.Type)
+	}
+	if config.DB.Host == "" {
+		addError("db.host", "missing.  This should be the hostname or IP address of the database server")
+	}
+	if !v.IsSet("db.port") {
+		addError("db.port", "missing.  This is normally 5432 for PostgreSQL or 3306 for MySQL")
+	} else if config.DB.Port < 1 || config.DB.Port > 65535 {
+		addError("db.port", "%d is not a valid port number", config.DB.Port)
+	}
+	if config.DB.Name == "" {
+		addError("db.name", "missing.  This should be the name of the Mattermost database")
+	}
+	if config.DB.User == "" {
+		addError("db.user", "missing.  This should be a database user with read access to the Sessions and Users tables")
+	}
+	if config.DB.Password == "" {
+		addWarning("db.password", "empty.  This is only correct if the database doesn't require a password")
+	}
+
+	if config.Webhook.URL != "" {
+		if u, err := url.Parse(config.Webhook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			addError("webhook.url", "%q is not a valid http(s) URL", config.Webhook.URL)
+		}
+	}
+	if config.Bot.URL != "" && !validBotURL(config.Bot.URL) {
+		addError("bot.url", "%q is not a valid http(s) URL", config.Bot.URL)
+	}
+	if config.Bot.URL != "" && config.Bot.Token == "" {
+		addError("bot.token", "missing.  This should be a personal access token for the bot account")
+	}
+	switch config.Webhook.Format {
+	case "", webhookMattermost, webhookSlack, webhookTeams:
+	default:
+		addError("webhook.format", "unsupported format %q.  This must be one of \"mattermost\", \"slack\" or \"teams\"", config.Webhook.Format)
+	}
+	if config.LDAP.URL != "" {
+		if u, err := url.Parse(config.LDAP.URL); err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+			addError("ldap.url", "%q is not a valid ldap:// or ldaps:// URL", config.LDAP.URL)
+		}
+		if config.LDAP.BaseDN == "" {
+			addError("ldap.base_dn", "missing.  This should be the DN to search for users under")
+		}
+		if config.LDAP.IDAttribute == "" {
+			addError("ldap.id_attribute", "missing.  This must match the ID Attribute in Mattermost's AD/LDAP settings")
+		}
+		if len(config.LDAP.Attributes) == 0 {
+			addWarning("ldap.attributes", "empty, so no directory attributes will be added to the lookup CSV")
+		}
+		if config.LDAP.InsecureSkipVerify {
+			addWarning("ldap.insecure_skip_verify", "the LDAP server's certificate won't be checked")
+		}
+	}
+	if config.Lookup.EmailHMACKey != "" && len(config.Lookup.EmailHMACKey) < minHMACKeyLength {
+		addWarning("lookup.email_hmac_key", "shorter than %d characters, which makes the hashed emails easier to reverse", minHMACKeyLength)
+	}
+	if config.Lookup.PseudonymKey != "" && len(config.Lookup.PseudonymKey) < minHMACKeyLength {
+		addWarning("lookup.pseudonym_key", "shorter than %d characters, which makes the pseudonyms easier to reverse", minHMACKeyLength)
+	}
+	if _, err := loadEncryption(&config); err != nil {
+		addError("encryption", "%v", err)
+	}
+	if err := validateUserColumns(config.Lookup.UserColumns); err != nil {
+		addError("lookup.user_columns", "%v", err)
+	}
+	for _, setting := range []struct{ key, version string }{
+		{"report.min_desktop_version", config.Report.MinDesktopVersion},
+		{"report.min_mobile_version", config.Report.MinMobileVersion},
+	} {
+		if _, _, _, err := mmversions.ParseVersion(setting.version); setting.version != "" && err != nil {
+			addError(setting.key, "%q is not a valid version.  This should be three numbers, such as 5.5.0", setting.version)
+		}
+	}
+	for i, release := range config.Upgrade.Releases {
+		key := fmt.Sprintf("upgrade.releases[%d]", i)
+		if _, _, _, err := mmversions.ParseVersion(release.Server); err != nil {
+			addError(key+".server", "%q is not a valid server version.  This should be three numbers, such as 10.5.0", release.Server)
+		}
+		for _, setting := range []struct{ key, version string }{
+			{key + ".min_desktop_version", release.MinDesktopVersion},
+			{key + ".min_mobile_version", release.MinMobileVersion},
+		} {
+			if _, _, _, err := mmversions.ParseVersion(setting.version); setting.version != "" && err != nil {
+				addError(setting.key, "%q is not a valid version.  This should be three numbers, such as 5.5.0", setting.version)
+			}
+		}
+	}
+	for _, setting := range []struct {
+		key       string
+		threshold float64
+		minimum   bool
+	}{
+		{"report.desktop_threshold", config.Report.DesktopThreshold, config.Report.MinDesktopVersion != ""},
+		{"report.mobile_threshold", config.Report.MobileThreshold, config.Report.MinMobileVersion != ""},
+		{"report.web_threshold", config.Report.WebThreshold, len(config.Report.MinBrowserVersions) > 0},
+	} {
+		if setting.threshold < 0 || setting.threshold > 100 {
+			addError(setting.key, "%g is not a percentage.  This should be between 0 and 100", setting.threshold)
+		} else if setting.threshold > 0 && !setting.minimum {
+			addError(setting.key, "set without a minimum version, so there's nothing to compare the clients with")
+		}
+	}
+	if _, err := newActiveRule(&config, 0); err != nil {
+		addError("sessions.active", "%v", err)
+	}
+	if _, err := parseCustomWhere(config.Sessions.Where, config.Sessions.Params); err != nil {
+		addError("sessions.where", "%v", err)
+	}
+	if _, err := mmversions.CompilePatterns(config.Sessions.LegacyDesktopPatterns); err != nil {
+		addError("sessions.legacy_desktop_patterns", "%v", err)
+	}
+	if len(config.Sessions.NodeProps) > 0 {
+		if _, err := newNodeAttributor(&config); err != nil {
+			addError("sessions.node_pools", "%v", err)
+		}
+	} else if len(config.Sessions.NodePools) > 0 {
+		addError("sessions.node_props", "missing.  The node pools are matched against the addresses in these session props")
+	}
+	if config.Sessions.ActiveDays < 0 {
+		addError("sessions.active_days", "must be a positive number of days")
+	}
+	if config.Report.MaxSessionDays < 0 {
+		addError("report.max_session_days", "must be a positive number of days")
+	}
+	for browser, major := range config.Report.MinBrowserVersions {
+		if major <= 0 {
+			addError("report.min_browser_versions", "the minimum version of %s must be a major version, such as 120", browser)
+		}
+	}
+	if config.Upload.URL != "" {
+		if _, err := parseUploadURL(config.Upload.URL); err != nil {
+			addError("upload.url", "%v", err)
+		}
+	}
+	for _, setting := range []struct{ key, file string }{
+		{"signing.minisign_key", config.Signing.MinisignKey},
+		{"signing.gpg_key", config.Signing.GPGKey},
+	} {
+		if _, err := os.Stat(setting.file); setting.file != "" && err != nil {
+			addError(setting.key, "unable to read the key: %v", err)
+		}
+	}
+	if config.Output.Lang != "" {
+		if _, ok := translations[strings.ToLower(config.Output.Lang)]; !ok {
+			addError("output.lang", "unsupported language %q.  This must be one of: %s", config.Output.Lang, supportedLanguages())
+		}
+	}
+
+	return problems, nil
+}
+
+// nestedSection reports whether a setting within a profile or tenant is itself a profile or tenant, which isn't
+// supported.
+func nestedSection(setting string) bool {
+	for _, section := range []string{"profiles", "tenants"} {
+		if setting == section || strings.HasPrefix(setting, section+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// maskedValue replaces secrets when the configuration is shown.
+const maskedValue = "********"
+
+// printConfig writes the effective configuration as JSON.  The password and the path of the webhook URL, which
+// contains its token, are masked, since the output is likely to end up in support tickets.
+func printConfig(w io.Writer, config *Config) error {
+	shown := *config
+	shown.Profiles = nil
+	shown.Tenants = nil
+	if shown.DB.Password != "" {
+		shown.DB.Password = maskedValue
+	}
+	if shown.Bot.Token != "" {
+		shown.Bot.Token = maskedValue
+	}
+	if shown.LDAP.BindPassword != "" {
+		shown.LDAP.BindPassword = maskedValue
+	}
+	if shown.Signing.Passphrase != "" {
+		shown.Signing.Passphrase = maskedValue
+	}
+	if shown.Upload.Password != "" {
+		shown.Upload.Password = maskedValue
+	}
+	if shown.Lookup.EmailHMACKey != "" {
+		shown.Lookup.EmailHMACKey = maskedValue
+	}
+	if shown.Lookup.PseudonymKey != "" {
+		shown.Lookup.PseudonymKey = maskedValue
+	}
+	if shown.Webhook.URL != "" {
+		if u, err := url.Parse(shown.Webhook.URL); err == nil && u.Host != "" {
+			shown.Webhook.URL = u.Scheme + "://" + u.Host + "/" + maskedValue
+		} else {
+			shown.Webhook.URL = maskedValue
+		}
+	}
+
+	if u, err := url.Parse(shown.Upload.URL); err == nil && shown.Upload.URL != "" {
+		shown.Upload.URL = u.Redacted()
+	}
+
+	data, err := json.MarshalIndent(shown, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// Supported webhook formats.  Mattermost and Slack share the same basic payload shape, but Slack gets Block Kit
+// so the card renders properly there, and Teams needs an Adaptive Card.
+const (
+	webhookMattermost = "mattermost"
+	webhookSlack      = "slack"
+	webhookTeams      = "teams"
+)
+
+var webhookTimeout = 30 * time.Second
+
+func init() {
+	registerOutputWriter("webhook", func(w io.Writer, config *Config) OutputWriter {
+		return &webhookOutput{url: config.Webhook.URL, format: config.Webhook.Format}
+	})
+}
+
+// webhookOutput posts the version counts to the configured webhook as a summary card.
+type webhookOutput struct {
+	url    string
+	format string
+}
+
+func (o *webhookOutput) Write(ctx context.Context, summary *mmversions.Summary) error {
+	return postWebhook(ctx, o.url, o.format, buildSummaryCard(summary))
+}
+
+func (o *webhookOutput) WriteUsers(ctx context.Context, rows [][]string) error {
+	return errUnsupportedOutput
+}
+
+// SummaryCard is the platform-neutral content of the summary that we post to a webhook.
+type SummaryCard struct {
+	Title        string
+	DesktopTotal int
+	MobileTotal  int
+	Total        int
+	DesktopLines []string
+	MobileLines  []string
+	// DevTotal and DevLines are the nightly and developer desktop builds, and APITotal and APILines are the
+	// integrations, neither of which are included in Total
+	DevTotal    int
+	DevLines    []string
+	APITotal    int
+	APILines    []string
+	GeneratedAt string
+}
+
+// buildSummaryCard converts the version counts into the content for the webhook summary card.
+func buildSummaryCard(summary *mmversions.Summary) SummaryCard {
+	card := SummaryCard{
+		Title:        tr(msgCardTitle),
+		DesktopTotal: mmversions.Total(summary.Desktop),
+		MobileTotal:  mmversions.Total(summary.Mobile),
+		DesktopLines: versionLines(summary.Desktop),
+		MobileLines:  versionLines(summary.Mobile),
+		DevTotal:     mmversions.Total(summary.DesktopDev),
+		DevLines:     versionLines(summary.DesktopDev),
+		APITotal:     mmversions.Total(summary.API),
+		APILines:     versionLines(summary.API),
+		GeneratedAt:  formatTimestamp(time.Now()),
+	}
+	card.Total = card.DesktopTotal + card.MobileTotal
+
+	return card
+}
+
+// versionLines returns one "version (OS) - count" line per entry, in version order.
+func versionLines(versionCount VersionCount) []string {
+	var lines []string
+	for _, entry := range mmversions.Entries(versionCount) {
+		lines = append(lines, fmt.Sprintf("%s (%s) - %d", entry.Version, entry.OS, entry.Count))
+	}
+	return lines
+}
+
+// markdownBody renders the card as Markdown, which is what Mattermost and Slack both expect in their text fields.
+func (c SummaryCard) markdownBody() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**%s:** %d\n", tr(msgCardDesktopClients), c.DesktopTotal)
+	fmt.Fprintf(&sb, "**%s:** %d\n", tr(msgCardMobileClients), c.MobileTotal)
+	fmt.Fprintf(&sb, "**%s:** %d\n", tr(msgCardTotalClients), c.Total)
+	if c.DevTotal > 0 {
+		fmt.Fprintf(&sb, "**%s:** %d\n", tr(msgCardDevClients), c.DevTotal)
+	}
+	if c.APITotal > 0 {
+		fmt.Fprintf(&sb, "**%s:** %d\n", tr(msgCardAPIClients), c.APITotal)
+	}
+	if len(c.DesktopLines) > 0 {
+		sb.WriteString("\n**" + tr(msgCardDesktopHeading) + "**\n")
+		sb.WriteString(bulletList(c.DesktopLines))
+	}
+	if len(c.MobileLines) > 0 {
+		sb.WriteString("\n**" + tr(msgCardMobileHeading) + "**\n")
+		sb.WriteString(bulletList(c.MobileLines))
+	}
+	if len(c.DevLines) > 0 {
+		sb.WriteString("\n**" + tr(msgCardDevHeading) + "**\n")
+		sb.WriteString(bulletList(c.DevLines))
+	}
+	if len(c.APILines) > 0 {
+		sb.WriteString("\n**" + tr(msgCardAPIHeading) + "**\n")
+		sb.WriteString(bulletList(c.APILines))
+	}
+	return sb.String()
+}
+
+func bulletList(lines []string) string {
+	var sb strings.Builder
+	for _, line := range lines {
+		sb.WriteString("- " + line + "\n")
+	}
+	return sb.String()
+}
+
+// mattermostPayload builds an incoming webhook payload using a message attachment.
+func (c SummaryCard) mattermostPayload() map[string]interface{} {
+	return map[string]interface{}{
+		"username": "mm-desktop-versions",
+		"attachments": []map[string]interface{}{
+			{
+				"fallback": trf(msgCardFallback, c.Title, c.Total),
+				"title":    c.Title,
+				"text":     c.markdownBody(),
+				"footer":   tr(msgCardGenerated) + " " + c.GeneratedAt,
+			},
+		},
+	}
+}
+
+// slackPayload builds a Block Kit payload.  Slack uses its own "mrkdwn" dialect, so bold is a single asterisk.
+func (c SummaryCard) slackPayload() map[string]interface{} {
+	text := strings.ReplaceAll(c.markdownBody(), "**", "*")
+	return map[string]interface{}{
+		"text": trf(msgCardFallback, c.Title, c.Total),
+		"blocks": []map[string]interface{}{
+			{
+				"type": "header",
+				"text": map[string]string{"type": "plain_text", "text": c.Title},
+			},
+			{
+				"type": "section",
+				"text": map[string]string{"type": "mrkdwn", "text": text},
+			},
+			{
+				"type":     "context",
+				"elements": []map[string]string{{"type": "mrkdwn", "text": tr(msgCardGenerated) + " " + c.GeneratedAt}},
+			},
+		},
+	}
+}
+
+// teamsPayload builds an Adaptive Card, wrapped the way Teams incoming webhooks and workflows expect.
+func (c SummaryCard) teamsPayload() map[string]interface{} {
+	facts := []map[string]string{
+		{"title": tr(msgCardDesktopClients), "value": fmt.Sprint(c.DesktopTotal)},
+		{"title": tr(msgCardMobileClients), "value": fmt.Sprint(c.MobileTotal)},
+		{"title": tr(msgCardTotalClients), "value": fmt.Sprint(c.Total)},
+	}
+	if c.DevTotal > 0 {
+		facts = append(facts, map[string]string{"title": tr(msgCardDevClients), "value": fmt.Sprint(c.DevTotal)})
+	}
+	if c.APITotal > 0 {
+		facts = append(facts, map[string]string{"title": tr(msgCardAPIClients), "value": fmt.Sprint(c.APITotal)})
+	}
+
+	body := []map[string]interface{}{
+		{"type": "TextBlock", "text": c.Title, "weight": "Bolder", "size": "Medium"},
+		{"type": "FactSet", "facts": facts},
+	}
+	if len(c.DesktopLines) > 0 {
+		body = append(body,
+			map[string]interface{}{"type": "TextBlock", "text": tr(msgCardDesktopHeading), "weight": "Bolder"},
+			map[string]interface{}{"type": "TextBlock", "text": bulletList(c.DesktopLines), "wrap": true})
+	}
+	if len(c.MobileLines) > 0 {
+		body = append(body,
+			map[string]interface{}{"type": "TextBlock", "text": tr(msgCardMobileHeading), "weight": "Bolder"},
+			map[string]interface{}{"type": "TextBlock", "text": bulletList(c.MobileLines), "wrap": true})
+	}
+	if len(c.DevLines) > 0 {
+		body = append(body,
+			map[string]interface{}{"type": "TextBlock", "text": tr(msgCardDevHeading), "weight": "Bolder"},
+			map[string]interface{}{"type": "TextBlock", "text": bulletList(c.DevLines), "wrap": true})
+	}
+	if len(c.APILines) > 0 {
+		body = append(body,
+			map[string]interface{}{"type": "TextBlock", "text": tr(msgCardAPIHeading), "weight": "Bolder"},
+			map[string]interface{}{"type": "TextBlock", "text": bulletList(c.APILines), "wrap": true})
+	}
+	body = append(body, map[string]interface{}{"type": "TextBlock", "text": tr(msgCardGenerated) + " " + c.GeneratedAt, "isSubtle": true, "size": "Small"})
+
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{
+			{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]interface{}{
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"body":    body,
+				},
+			},
+		},
+	}
+}
+
+// webhookPayload returns the JSON body for the requested webhook format.
+func webhookPayload(format string, card SummaryCard) ([]byte, error) {
+	var payload map[string]interface{}
+	switch format {
+	case webhookMattermost, "":
+		payload = card.mattermostPayload()
+	case webhookSlack:
+		payload = card.slackPayload()
+	case webhookTeams:
+		payload = card.teamsPayload()
+	default:
+		return nil, fmt.Errorf("unsupported webhook format: %s", format)
+	}
+
+	return json.Marshal(payload)
+}
+
+// postWebhook sends the summary card to the configured webhook URL.
+func postWebhook(ctx context.Context, url string, format string, card SummaryCard) error {
+	DebugPrint("Posting summary to " + format + " webhook")
+
+	body, err := webhookPayload(format, card)
+	if err != nil {
+		LogMessage(errorLevel, err.Error())
+		return err
+	}
+	return sendWebhook(ctx, url, body)
+}
+
+// postWebhookMessage sends a titled Markdown message, rather than the summary card, to the configured webhook URL.
+func postWebhookMessage(ctx context.Context, url string, format string, title string, text string) error {
+	DebugPrint("Posting message to " + format + " webhook")
+
+	var payload map[string]interface{}
+	switch format {
+	case webhookMattermost, "":
+		payload = map[string]interface{}{"username": "mm-desktop-versions", "text": "#### " + title + "\n" + text}
+	case webhookSlack:
+		payload = map[string]interface{}{
+			"text": title,
+			"blocks": []map[string]interface{}{
+				{"type": "header", "text": map[string]string{"type": "plain_text", "text": title}},
+				{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": strings.ReplaceAll(text, "**", "*")}},
+			},
+		}
+	case webhookTeams:
+		payload = map[string]interface{}{
+			"type": "message",
+			"attachments": []map[string]interface{}{
+				{
+					"contentType": "application/vnd.microsoft.card.adaptive",
+					"content": map[string]interface{}{
+						"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+						"type":    "AdaptiveCard",
+						"version": "1.4",
+						"body": []map[string]interface{}{
+							{"type": "TextBlock", "text": title, "weight": "Bolder", "size": "Medium"},
+							{"type": "TextBlock", "text": text, "wrap": true},
+						},
+					},
+				},
+			},
+		}
+	default:
+		err := fmt.Errorf("unsupported webhook format: %s", format)
+		LogMessage(errorLevel, err.Error())
+		return err
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return sendWebhook(ctx, url, body)
+}
+
+// sendWebhook posts a JSON payload to a webhook URL.
+func sendWebhook(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		errMsg := fmt.Sprintf("Invalid webhook URL: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		errMsg := fmt.Sprintf("Error posting to webhook: %v", err)
+		LogMessage(errorLevel, errMsg)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+		LogMessage(errorLevel, err.Error())
+		return err
+	}
+
+	return nil
+}
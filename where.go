@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// customWhere is a condition from the config file that's added to the Sessions query, e.g. to only count the users
+// in a table of pilot users, with its parameters bound rather than written into the SQL.
+type customWhere struct {
+	condition string
+	params    []interface{}
+}
+
+// forbiddenWords are the keywords that have no place in a condition on the Sessions table, because they change data,
+// run statements or code, or read and write files.  The database user should only be able to read anyway, but the
+// condition is checked as well, so that a mistake in the config file can't do any harm.
+var forbiddenWords = map[string]bool{
+	"insert": true, "update": true, "delete": true, "drop": true, "alter": true, "create": true, "truncate": true,
+	"grant": true, "revoke": true, "replace": true, "merge": true, "call": true, "exec": true, "execute": true,
+	"into": true, "load": true, "outfile": true, "dumpfile": true, "copy": true, "lock": true, "union": true,
+	"set": true, "handler": true, "do": true, "sleep": true, "benchmark": true, "pg_sleep": true,
+}
+
+// parseCustomWhere checks a condition from the config file, and that it has a ? placeholder for each parameter.  The
+// condition can't contain more than one statement, comments, or any of the forbiddenWords outside of quotes, and
+// its parentheses and quotes must be balanced, so that it can't escape the brackets it's put in.
+func parseCustomWhere(condition string, params []interface{}) (*customWhere, error) {
+	condition = strings.TrimSpace(condition)
+	if condition == "" {
+		if len(params) > 0 {
+			return nil, errors.New("there are parameters, but no condition to use them")
+		}
+		return nil, nil
+	}
+
+	placeholders := 0
+	depth := 0
+	var word strings.Builder
+	checkWord := func() error {
+		if w := strings.ToLower(word.String()); forbiddenWords[w] {
+			return fmt.Errorf("%q isn't allowed in the condition", w)
+		}
+		word.Reset()
+		return nil
+	}
+
+	runes := []rune(condition)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		if unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' {
+			word.WriteRune(c)
+			continue
+		}
+		if err := checkWord(); err != nil {
+			return nil, err
+		}
+
+		switch c {
+		case '\'', '"', '`':
+			// Skip to the closing quote.  A doubled quote is an escaped one; backslashes are refused, since MySQL and
+			// PostgreSQL treat them differently.
+			closed := false
+			for i++; i < len(runes); i++ {
+				if runes[i] == '\\' {
+					return nil, errors.New("backslashes aren't allowed in quotes")
+				}
+				if runes[i] == c {
+					if i+1 < len(runes) && runes[i+1] == c {
+						i++
+						continue
+					}
+					closed = true
+					break
+				}
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated %c quote", c)
+			}
+		case ';':
+			return nil, errors.New("only a single condition is allowed, without semicolons")
+		case '#':
+			return nil, errors.New("comments aren't allowed")
+		case '-', '/':
+			if i+1 < len(runes) && (runes[i+1] == '-' && c == '-' || runes[i+1] == '*' && c == '/') {
+				return nil, errors.New("comments aren't allowed")
+			}
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth < 0 {
+				return nil, errors.New("unbalanced parentheses")
+			}
+		case '?':
+			placeholders++
+		}
+	}
+	if err := checkWord(); err != nil {
+		return nil, err
+	}
+	if depth != 0 {
+		return nil, errors.New("unbalanced parentheses")
+	}
+	if placeholders != len(params) {
+		return nil, fmt.Errorf("the condition has %d ? placeholders, but there are %d parameters", placeholders, len(params))
+	}
+	bound := make([]interface{}, len(params))
+	for i, param := range params {
+		switch value := param.(type) {
+		case float64:
+			// JSON numbers are read as floats, but they're usually millisecond timestamps, which should stay whole
+			if value == float64(int64(value)) {
+				param = int64(value)
+			}
+		case string, bool, int, int64:
+		default:
+			return nil, fmt.Errorf("parameter %v isn't a string, number or boolean", param)
+		}
+		bound[i] = param
+	}
+	return &customWhere{condition: condition, params: bound}, nil
+}
+
+// sql returns the condition with the placeholders in the dialect's form, numbered from first, for adding to a query
+// that already has first-1 parameters.
+func (w *customWhere) sql(d dialect, first int) string {
+	var b strings.Builder
+	n := first
+	var quote rune
+	for _, c := range w.condition {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?':
+			b.WriteString(d.placeholder(n))
+			n++
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jlandells/mm-desktop-version/pkg/mmversions"
+)
+
+// clientKindLabels are the names of each kind of client in the whois listing.
+var clientKindLabels = map[mmversions.ClientKind]string{
+	mmversions.Desktop: msgPlatformDesktop,
+	mmversions.Mobile:  msgPlatformMobile,
+	mmversions.Web:     msgPlatformWeb,
+	mmversions.API:     msgPlatformAPI,
+}
+
+// printWhois writes a user's details, followed by every one of their active sessions, most recently used first,
+// which answers the support question of what someone is signed in with.
+func printWhois(w io.Writer, user UserRecord, sessions []SessionRecord) {
+	fmt.Fprintf(w, "%s <%s>\n", user.Username, user.Email)
+	if name := strings.TrimSpace(user.FirstName + " " + user.LastName); name != "" {
+		fmt.Fprintln(w, "  "+name)
+	}
+	fmt.Fprintln(w, "  "+user.ID)
+	if user.DeleteAt != 0 {
+		fmt.Fprintln(w, "  "+tr(msgWhoisDeactivated))
+	}
+
+	var own []SessionRecord
+	for _, session := range sessions {
+		if session.UserID == user.ID {
+			own = append(own, session)
+		}
+	}
+	sort.SliceStable(own, func(i, j int) bool { return own[i].LastActivityAt > own[j].LastActivityAt })
+
+	fmt.Fprintln(w, "\n"+trf(msgWhoisSessions, len(own)))
+	if len(own) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\t%s\t%s\n", tr(msgColumnClient), tr(msgColumnVersion), tr(msgColumnOS),
+		tr(msgColumnDeviceID), tr(msgColumnLastUsed), tr(msgColumnCreated), tr(msgColumnExpires))
+	for _, session := range own {
+		kind, version, os := "-", "-", "-"
+		if client, err := mmversions.Classify(session); err == nil {
+			if label, ok := clientKindLabels[client.Kind]; ok {
+				kind = tr(label)
+			}
+			version, os = orDash(mmversions.Bucket(client)), orDash(client.OS)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\t%s\t%s\n", kind, version, os, orDash(session.DeviceID),
+			orDash(formatMillis(session.LastActivityAt)), orDash(formatMillis(session.CreateAt)), orDash(formatMillis(session.ExpiresAt)))
+	}
+	tw.Flush()
+}
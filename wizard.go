@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+var connectionTestTimeout = 10 * time.Second
+
+// wizard asks the questions needed to build a config file.
+type wizard struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask prompts for a value, returning the default if nothing is entered.
+func (w *wizard) ask(prompt string, defaultValue string) (string, error) {
+	if defaultValue != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", prompt, defaultValue)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", prompt)
+	}
+
+	line, err := w.in.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return defaultValue, nil
+	}
+	return line, nil
+}
+
+// askChoice prompts until one of the allowed values is entered.
+func (w *wizard) askChoice(prompt string, defaultValue string, choices ...string) (string, error) {
+	for {
+		answer, err := w.ask(fmt.Sprintf("%s (%s)", prompt, strings.Join(choices, "/")), defaultValue)
+		if err != nil {
+			return "", err
+		}
+		for _, choice := range choices {
+			if strings.EqualFold(answer, choice) {
+				return choice, nil
+			}
+		}
+		fmt.Fprintf(w.out, "Please enter one of: %s\n", strings.Join(choices, ", "))
+	}
+}
+
+// askPort prompts until a valid port number is entered.
+func (w *wizard) askPort(prompt string, defaultValue int) (int, error) {
+	for {
+		answer, err := w.ask(prompt, strconv.Itoa(defaultValue))
+		if err != nil {
+			return 0, err
+		}
+		port, err := strconv.Atoi(answer)
+		if err == nil && port > 0 && port < 65536 {
+			return port, nil
+		}
+		fmt.Fprintln(w.out, "Please enter a port number between 1 and 65535")
+	}
+}
+
+// askPassword prompts for a password without echoing it, when we're attached to a terminal.
+func (w *wizard) askPassword(prompt string, current string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return w.ask(prompt, current)
+	}
+
+	if current != "" {
+		fmt.Fprintf(w.out, "%s [leave blank to keep current]: ", prompt)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", prompt)
+	}
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(w.out)
+	if err != nil {
+		return "", err
+	}
+	if len(password) == 0 {
+		return current, nil
+	}
+	return string(password), nil
+}
+
+// askDatabase prompts for all of the database settings, using the previous answers as defaults.
+func (w *wizard) askDatabase(config *Config) error {
+	var err error
+
+	previousType := config.DB.Type
+	if config.DB.Type, err = w.askChoice("Database type", config.DB.Type, "postgresql", "mysql"); err != nil {
+		return err
+	}
+	if config.DB.Port == 0 || config.DB.Type != previousType {
+		config.DB.Port = 5432
+		if config.DB.Type == "mysql" {
+			config.DB.Port = 3306
+		}
+	}
+
+	if config.DB.Host, err = w.ask("Database host", config.DB.Host); err != nil {
+		return err
+	}
+	if config.DB.Port, err = w.askPort("Database port", config.DB.Port); err != nil {
+		return err
+	}
+	if config.DB.Name, err = w.ask("Database name", config.DB.Name); err != nil {
+		return err
+	}
+	if config.DB.User, err = w.ask("Database user", config.DB.User); err != nil {
+		return err
+	}
+	if config.DB.Password, err = w.askPassword("Database password", config.DB.Password); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// testConnection opens the database and checks that we can talk to it.
+func testConnection(ctx context.Context, config *Config) error {
+	db, err := openDatabase(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, connectionTestTimeout)
+	defer cancel()
+	return db.PingContext(ctx)
+}
+
+// writeConfigFile saves the database settings in the same layout as the example config.
+func writeConfigFile(filename string, config *Config) error {
+	data, err := json.MarshalIndent(map[string]interface{}{"db": config.DB}, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	// The file contains the database password, so keep it private
+	return os.WriteFile(filename, append(data, '\n'), 0600)
+}
+
+// runWizard interactively builds a config file.
+func runWizard(ctx context.Context, filename string, in io.Reader, out io.Writer) error {
+	w := &wizard{in: bufio.NewReader(in), out: out}
+
+	if _, err := os.Stat(filename); err == nil {
+		overwrite, err := w.askChoice(filename+" already exists.  Overwrite it?", "no", "yes", "no")
+		if err != nil {
+			return err
+		}
+		if overwrite != "yes" {
+			return errors.New("config file not written")
+		}
+	}
+
+	config := &Config{}
+	config.DB.Type = "postgresql"
+	config.DB.Host = "localhost"
+	config.DB.Name = "mattermost"
+	config.DB.User = "mmuser"
+
+	fmt.Fprintln(out, "Please enter the details of your Mattermost database.  Press Enter to accept the default shown in brackets.")
+	for {
+		if err := w.askDatabase(config); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(out, "Testing the connection...")
+		err := testConnection(ctx, config)
+		if err == nil {
+			fmt.Fprintln(out, "Connection successful.")
+			break
+		}
+
+		fmt.Fprintf(out, "Connection failed: %v\n", err)
+		next, err := w.askChoice("Re-enter the details, save anyway, or quit?", "retry", "retry", "save", "quit")
+		if err != nil {
+			return err
+		}
+		if next == "save" {
+			break
+		}
+		if next == "quit" {
+			return errors.New("config file not written")
+		}
+	}
+
+	if err := writeConfigFile(filename, config); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Config written to %s\n", filename)
+	return nil
+}